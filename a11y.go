@@ -0,0 +1,29 @@
+package main
+
+// #cgo pkg-config: gtk+-3.0
+// #include <stdlib.h>
+// #include <gtk/gtk.h>
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+type accessibleRole C.AtkRole
+
+const (
+	roleList     accessibleRole = C.ATK_ROLE_LIST
+	roleListItem accessibleRole = C.ATK_ROLE_LIST_ITEM
+)
+
+// setAccessible sets the role and the name announced by assistive
+// technologies for the given widget.
+func setAccessible(w gtk.IWidget, role accessibleRole, name string) {
+	a := C.gtk_widget_get_accessible((*C.GtkWidget)(unsafe.Pointer(w.ToWidget().Native())))
+	C.atk_object_set_role(a, C.AtkRole(role))
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	C.atk_object_set_name(a, cname)
+}
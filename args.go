@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// verbose is whether what's being done is logged, as set by --verbose.
+var verbose bool
+
+// guiArgs are the arguments the GUI is started with.
+type guiArgs struct {
+	// files are the paths or URIs of the documents to open.
+	files []string
+	// page is the 1-based page of the first file to annotate once it's open,
+	// or 0.
+	page int
+}
+
+// parseGUIArgs parses the arguments the GUI is started with. Asking for help
+// or the version prints it and returns flag.ErrHelp.
+func parseGUIArgs(args []string) (guiArgs, error) {
+	fs := flag.NewFlagSet("pdfrankenstein", flag.ContinueOnError)
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	fs.BoolVar(&verbose, "verbose", false, "log what's being done, also to a file in the XDG state directory")
+	page := fs.Int("page", 0, "annotate the given page of the first file once it's open")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Usage: pdfrankenstein [--page N] [--verbose] [file.pdf...]")
+		fmt.Fprintln(out, "       pdfrankenstein COMMAND [--help] ...")
+		fmt.Fprintln(out, "\nFiles are opened in the running instance if there's one.")
+		fs.PrintDefaults()
+		fmt.Fprintln(out, "  --config FILE\n    \tread settings from FILE")
+		fmt.Fprintln(out, "  --profile NAME\n    \tuse the settings of the profile NAME of the config")
+		fmt.Fprintln(out, "  --set KEY=VALUE\n    \toverride a setting, for any command")
+		fmt.Fprintln(out, "  --low-resource\n    \tuse less memory and CPU, like --set session.low-resource=on")
+		fmt.Fprintln(out, "\n"+strings.TrimSuffix(configUsage(), "\n"))
+		fmt.Fprintln(out, "\nCommands:")
+		var names []string
+		for name, c := range commands {
+			if !c.hidden {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  %-14s %s\n", name, commands[name].summary)
+		}
+		fmt.Fprintf(out, "  %-14s %s\n", "--no-gui", noGUICmd.summary)
+		fmt.Fprintf(out, "  %-14s %s\n", "--automation", automationCmd.summary)
+	}
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return guiArgs{}, err
+	}
+	if *showVersion {
+		v := version
+		if commit != "" {
+			v += " (" + commit + ")"
+		}
+		fmt.Println(progName, v)
+		return guiArgs{}, flag.ErrHelp
+	}
+	if *page < 0 {
+		return guiArgs{}, fmt.Errorf("invalid page %d", *page)
+	}
+	if *page > 0 && len(pos) == 0 {
+		return guiArgs{}, errors.New("--page needs a file to open")
+	}
+	return guiArgs{files: pos, page: *page}, nil
+}
+
+// pageHint is the prefix of the hint of the application's "open" signal
+// carrying the page to annotate, so it reaches the running instance.
+const pageHint = "page="
+
+// hintPage returns the page carried by the given hint, or 0.
+func hintPage(hint string) int {
+	if !strings.HasPrefix(hint, pageHint) {
+		return 0
+	}
+	page, err := strconv.Atoi(strings.TrimPrefix(hint, pageHint))
+	if err != nil || page < 0 {
+		return 0
+	}
+	return page
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"sync"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Decoding the SVG assets takes a while on slow machines, so none of it is
+// done before the main window shows. Each is decoded once first needed, or
+// ahead of that on a worker by warmAssets. GdkPixbuf is safe to use off the
+// GTK thread, unlike the CSS providers which are made on it when first used.
+var (
+	//go:embed splash.svg
+	splash []byte
+	//go:embed icon.svg
+	appIcon []byte
+	//go:embed loading.svg
+	loadingImgBytes []byte
+	//go:embed nothumb.svg
+	noThumbImgBytes []byte
+
+	splashPix  = &lazyPixbuf{name: "splash", data: splash}
+	loadingPix = &lazyPixbuf{name: "loading", data: loadingImgBytes}
+	noThumbPix = &lazyPixbuf{name: "no thumb", data: noThumbImgBytes}
+
+	cleanCSS = &lazyCSS{css: `label{border-radius:3px;padding:2px 6px;background:@theme_bg_color;opacity:0.8}`}
+	badgeCSS = &lazyCSS{css: `box{color:white;background:orange;border-radius:0 0 0 8px;padding:4px}`}
+)
+
+// lazyPixbuf is an embedded image decoded on first use.
+type lazyPixbuf struct {
+	name string
+	data []byte
+	once sync.Once
+	pix  *gdk.Pixbuf
+}
+
+// get returns the decoded image. It may be called from any thread.
+func (l *lazyPixbuf) get() *gdk.Pixbuf {
+	l.once.Do(func() {
+		var err error
+		if l.pix, err = gdk.PixbufNewFromBytesOnly(l.data); err != nil {
+			log.Fatalf("failed to create %s pixbuf: %s", l.name, err)
+		}
+	})
+	return l.pix
+}
+
+// lazyCSS is a CSS provider loaded on first use. It must only be used on the
+// GTK thread.
+type lazyCSS struct {
+	css      string
+	provider *gtk.CssProvider
+}
+
+func (l *lazyCSS) get() *gtk.CssProvider {
+	if l.provider == nil {
+		p, err := gtk.CssProviderNew()
+		if err != nil {
+			log.Fatalf("failed to create css provider: %s", err)
+		}
+		if err := p.LoadFromData(l.css); err != nil {
+			log.Fatalf("failed to load css: %s", err)
+		}
+		l.provider = p
+	}
+	return l.provider
+}
+
+// warmAssets decodes the images needed once a document opens, and then
+// loads the CSS providers, while the UI waits for the user.
+func warmAssets() {
+	workQueue.submit(func() {
+		loadingPix.get()
+		noThumbPix.get()
+	}, func() {
+		cleanCSS.get()
+		badgeCSS.get()
+	})
+}
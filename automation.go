@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Automation mode speaks JSON-RPC 2.0 over standard input and output, one
+// message per line, so programs can drive sessions without the GUI or a
+// gRPC client. Requests are handled one at a time in the order received,
+// and events about them are sent as "event" notifications before their
+// response, making runs deterministic. It ends once standard input does.
+//
+// Methods, with pages numbered from 0:
+//
+//	open     {"path"} -> {"session", "pages"}
+//	pages    {"session"} -> [{"page", "width", "height", "rotation", "annotated"}]
+//	annotate {"session", "page", "svg"} -> {"changed"}
+//	save     {"session", "path"} -> {}
+//	close    {"session"} -> {}
+//
+// annotate sets the annotation SVG of the page to svg, or opens the page in
+// the editor if svg is left out, waiting for it to be closed.
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcNoMethod       = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+)
+
+// automationCmd runs automation mode. It's run when --automation is given
+// rather than by name.
+var automationCmd = command{summary: "drive sessions with JSON-RPC over stdio", setup: automateCmd}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// automationEvent is the params of an "event" notification.
+type automationEvent struct {
+	Session string `json:"session"`
+	// Type is one of "opened", "editing", "annotated", "saved" and "closed".
+	Type string `json:"type"`
+	Page *int   `json:"page,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type automationPage struct {
+	Page      int     `json:"page"`
+	Width     float64 `json:"width"`
+	Height    float64 `json:"height"`
+	Rotation  int     `json:"rotation"`
+	Annotated bool    `json:"annotated"`
+}
+
+// automatedSession is a session opened by the driving program.
+type automatedSession struct {
+	sess   *session.Session
+	unlock func()
+}
+
+// automation is the state of automation mode.
+type automation struct {
+	ctx      context.Context
+	out      *json.Encoder
+	sessions map[string]*automatedSession
+	// lastID is the ID of the last session opened. IDs count up from 1.
+	lastID int
+}
+
+func automateCmd(fs *flag.FlagSet) func(pos []string) error {
+	fs.Bool("automation", true, "drive sessions with JSON-RPC over stdio")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein --automation")
+		fmt.Fprintln(fs.Output(), "\nJSON-RPC 2.0 requests are read from standard input, one per line, and")
+		fmt.Fprintln(fs.Output(), "responses written to standard output. The methods are open, pages,")
+		fmt.Fprintln(fs.Output(), "annotate, save and close, as described in automation.go of the source.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 0 {
+			fs.Usage()
+			return errors.New("unexpected arguments")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		a := &automation{ctx: ctx, out: json.NewEncoder(os.Stdout), sessions: map[string]*automatedSession{}}
+		defer a.closeAll()
+		return a.serve(os.Stdin)
+	}
+}
+
+// serve handles the requests read from r until it ends.
+func (a *automation) serve(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20) // annotation SVGs come inline
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			a.send(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{rpcParseError, err.Error()}})
+			continue
+		}
+		res, err := a.handle(req)
+		if req.ID == nil {
+			continue // Notifications get no response
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: res}
+		if err != nil {
+			var rerr *rpcError
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{rpcFailed, err.Error()}
+			}
+			resp.Result, resp.Error = nil, rerr
+		} else if res == nil {
+			resp.Result = struct{}{}
+		}
+		a.send(resp)
+	}
+	return sc.Err()
+}
+
+func (a *automation) send(msg any) {
+	if err := a.out.Encode(msg); err != nil {
+		logVerbose(logApp, "cannot write automation message: %s", err)
+	}
+}
+
+func (a *automation) event(e automationEvent) {
+	a.send(rpcNotification{JSONRPC: "2.0", Method: "event", Params: e})
+}
+
+// handle runs the method of the request and returns its result.
+func (a *automation) handle(req rpcRequest) (any, error) {
+	if req.JSONRPC != "2.0" {
+		return nil, &rpcError{rpcInvalidRequest, "jsonrpc must be \"2.0\""}
+	}
+	var p struct {
+		Session string  `json:"session"`
+		Path    string  `json:"path"`
+		Page    *int    `json:"page"`
+		SVG     *string `json:"svg"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+	}
+
+	if req.Method == "open" {
+		if p.Path == "" {
+			return nil, &rpcError{rpcInvalidParams, "path is required"}
+		}
+		return a.open(p.Path)
+	}
+
+	s, ok := a.sessions[p.Session]
+	switch {
+	case req.Method != "pages" && req.Method != "annotate" && req.Method != "save" && req.Method != "close":
+		return nil, &rpcError{rpcNoMethod, fmt.Sprintf("no method '%s'", req.Method)}
+	case !ok:
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("no session '%s'", p.Session)}
+	}
+
+	switch req.Method {
+	case "pages":
+		pages := make([]automationPage, s.sess.PageCount())
+		for i := range pages {
+			info, err := s.sess.PageInfo(i)
+			if err != nil {
+				return nil, err
+			}
+			pages[i] = automationPage{i, info.Width, info.Height, info.Rotation, info.Annotated}
+		}
+		return pages, nil
+
+	case "annotate":
+		if p.Page == nil || *p.Page < 0 || *p.Page >= s.sess.PageCount() {
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("page must be within 0-%d", s.sess.PageCount()-1)}
+		}
+		var changed bool
+		if p.SVG != nil {
+			if err := s.sess.PutAnnotation(*p.Page, []byte(*p.SVG)); err != nil {
+				return nil, err
+			}
+			changed = true
+		} else {
+			a.event(automationEvent{Session: p.Session, Type: "editing", Page: p.Page})
+			var err error
+			if changed, err = s.sess.Annotate(a.ctx, *p.Page); err != nil {
+				return nil, err
+			}
+		}
+		if changed {
+			a.event(automationEvent{Session: p.Session, Type: "annotated", Page: p.Page})
+		}
+		return map[string]bool{"changed": changed}, nil
+
+	case "save":
+		if p.Path == "" {
+			return nil, &rpcError{rpcInvalidParams, "path is required"}
+		}
+		if err := s.sess.SaveContext(a.ctx, p.Path); err != nil {
+			return nil, err
+		}
+		a.event(automationEvent{Session: p.Session, Type: "saved", Path: p.Path})
+		return nil, nil
+
+	default: // close
+		s.close()
+		delete(a.sessions, p.Session)
+		a.event(automationEvent{Session: p.Session, Type: "closed"})
+		return nil, nil
+	}
+}
+
+// open starts a session on the PDF at path, locking it like the GUI does.
+func (a *automation) open(path string) (any, error) {
+	unlock, holder := lockDoc(path)
+	if holder != nil {
+		return nil, fmt.Errorf("'%s' is open by %s on %s", path, holder.User, holder.Host)
+	}
+	sess, err := session.New(path)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to open '%s': %s", path, err)
+	}
+	sess.SetEditor(cliEditor())
+	a.lastID++
+	id := strconv.Itoa(a.lastID)
+	a.sessions[id] = &automatedSession{sess: sess, unlock: unlock}
+	a.event(automationEvent{Session: id, Type: "opened", Path: path})
+	return map[string]any{"session": id, "pages": sess.PageCount()}, nil
+}
+
+func (s *automatedSession) close() {
+	s.sess.Close()
+	s.unlock()
+}
+
+// closeAll closes the sessions left open by the driving program.
+func (a *automation) closeAll() {
+	for id, s := range a.sessions {
+		s.close()
+		delete(a.sessions, id)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gotk3/gotk3/glib"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// autosaveTimer periodically snapshots the sessions of modified documents,
+// if enabled.
+var autosaveTimer glib.SourceHandle
+
+// autosaveRoot returns the directory holding a snapshot directory for each
+// document with unsaved changes.
+func autosaveRoot() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "autosave"), nil
+}
+
+// startAutosave (re)starts the auto-save timer according to the
+// preferences.
+func startAutosave() {
+	if autosaveTimer != 0 {
+		glib.SourceRemove(autosaveTimer)
+		autosaveTimer = 0
+	}
+	if state.AutosaveMinutes <= 0 {
+		return
+	}
+	autosaveTimer = glib.TimeoutAdd(uint(state.AutosaveMinutes)*60*1000, func() bool {
+		for _, d := range docs {
+			d.autosave()
+		}
+		return true
+	})
+}
+
+// autosave snapshots the session of the document if it has unsaved changes.
+func (d *document) autosave() {
+	if !d.modified || d.saving {
+		return
+	}
+	if d.autosaveDir == "" {
+		root, err := autosaveRoot()
+		if err == nil {
+			err = os.MkdirAll(root, 0755)
+		}
+		if err == nil {
+			d.autosaveDir, err = ioutil.TempDir(root, "session-*")
+		}
+		if err != nil {
+			log.Printf("cannot create auto-save directory: %s", err)
+			return
+		}
+	}
+	dir := d.autosaveDir
+	var err error
+	workQueue.submit(func() {
+		d.sessMu.Lock()
+		defer d.sessMu.Unlock()
+		if !d.sess.IsClosed() {
+			err = d.sess.Snapshot(dir)
+		}
+	}, func() {
+		if err != nil {
+			log.Printf("failed to auto-save '%s': %s", d.path, err)
+		}
+	})
+}
+
+// dropAutosave removes the auto-saved snapshot of the document, for once
+// its changes are saved or discarded.
+func (d *document) dropAutosave() {
+	if d.autosaveDir == "" {
+		return
+	}
+	if err := os.RemoveAll(d.autosaveDir); err != nil {
+		log.Printf("cannot remove auto-save directory: %s", err)
+	}
+	d.autosaveDir = ""
+}
+
+// recoverAutosaves offers to recover the documents auto-saved before the app
+// last quit unexpectedly or was terminated. Declined ones are removed.
+func recoverAutosaves() {
+	root, err := autosaveRoot()
+	if err != nil {
+		return
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		dir := filepath.Join(root, e.Name())
+		origin, err := session.SnapshotOrigin(dir)
+		if err != nil {
+			log.Printf("removing broken auto-save '%s': %s", dir, err)
+			_ = os.RemoveAll(dir)
+			continue
+		}
+		if !confirm(fmt.Sprintf("Recover unsaved changes to %s?", filepath.Base(origin)),
+			fmt.Sprintf("%s was not saved when PDFrankenstein last quit.", shrinkHome(origin)),
+			"Recover", "Discard", false) {
+			_ = os.RemoveAll(dir)
+			continue
+		}
+		recoverAutosave(dir, origin)
+	}
+}
+
+// recoverAutosave opens the document auto-saved to dir in a new tab.
+func recoverAutosave(dir, origin string) {
+	mainWin.SetSensitive(false)
+	opening++
+
+	var sess *session.Session
+	var err error
+	fileQueue.submit(func() {
+		sess, err = session.Resume(dir)
+		if err == nil {
+			_, _ = sess.PageInfo(0)
+		}
+	}, func() {
+		if opening--; opening == 0 {
+			mainWin.SetSensitive(true)
+		}
+		if err != nil {
+			log.Printf("failed to recover '%s': %s", dir, err)
+			showErrMsg("Cannot recover unsaved changes", err.Error())
+			return
+		}
+		d := newDocument(origin, sess)
+		d.autosaveDir = dir
+		addDoc(d)
+		d.setModified(true)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotk3/gotk3/gdk"
+)
+
+// A region of the screen can be captured and placed on a page as a stamp,
+// e.g. to put a screenshot of an error into a bug report. Capturing is left
+// to whichever screenshot tool is installed.
+
+// captureTool is a screenshot tool which lets the user select a region of
+// the screen and saves it as PNG.
+type captureTool struct {
+	// needs are the executables which must be installed. The first one is
+	// run with args, where {file} is replaced by the path to save to.
+	needs []string
+	args  []string
+	// wayland is whether the tool works on Wayland, x11 whether it works on
+	// X11.
+	wayland, x11 bool
+}
+
+// captureTools are tried in order.
+var captureTools = []captureTool{
+	{[]string{"spectacle"}, []string{"-r", "-b", "-n", "-o", "{file}"}, true, true},
+	{[]string{"gnome-screenshot"}, []string{"-a", "-f", "{file}"}, true, true},
+	{[]string{"grim", "slurp"}, []string{"-g", "{region}", "{file}"}, true, false},
+	{[]string{"maim"}, []string{"-s", "{file}"}, false, true},
+	{[]string{"scrot"}, []string{"-s", "{file}"}, false, true},
+	{[]string{"xfce4-screenshooter"}, []string{"-r", "-s", "{file}"}, false, true},
+}
+
+// findCaptureTool returns the first capture tool which is installed and
+// works in the current session, or false if there's none.
+func findCaptureTool() (captureTool, bool) {
+	wayland := os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("XDG_SESSION_TYPE") == "wayland"
+next:
+	for _, t := range captureTools {
+		if wayland && !t.wayland || !wayland && !t.x11 {
+			continue
+		}
+		for _, exe := range t.needs {
+			if _, err := exec.LookPath(exe); err != nil {
+				continue next
+			}
+		}
+		return t, true
+	}
+	return captureTool{}, false
+}
+
+// capture runs the tool to save a region of the screen to path. It returns
+// false if the user cancelled the selection.
+func (t captureTool) capture(path string) (bool, error) {
+	args := make([]string, len(t.args))
+	for i, a := range t.args {
+		switch a {
+		case "{file}":
+			a = path
+		case "{region}":
+
+			// grim takes the region picked with slurp
+
+			out, err := exec.Command("slurp").Output()
+			if err != nil {
+				return false, nil
+			}
+			a = strings.TrimSpace(string(out))
+		}
+		args[i] = a
+	}
+	if _, err := exec.Command(t.needs[0], args...).Output(); err != nil {
+
+		// Most tools fail without saving anything when the selection is
+		// cancelled
+
+		var exitErr *exec.ExitError
+		if _, serr := os.Stat(path); errors.As(err, &exitErr) && serr != nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s failed: %s", t.needs[0], err)
+	}
+
+	// Others succeed without saving anything
+
+	if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// captureRegion lets the user select a region of the screen and places it on
+// the given page, framed like a stamp. The window is minimized meanwhile so
+// it doesn't cover what's being captured.
+func (d *document) captureRegion(page int) {
+	if !d.changeable() {
+		return
+	}
+	tool, ok := findCaptureTool()
+	if !ok {
+		showErrMsg("Cannot capture screen",
+			"None of the supported screenshot tools were found. Install Spectacle, GNOME Screenshot, "+
+				"grim and slurp, maim, scrot or Xfce Screenshooter.")
+		return
+	}
+	dir, err := ioutil.TempDir("", "pdfrankenstein-capture-*")
+	if err != nil {
+		showErrMsg("Cannot capture screen", err.Error())
+		return
+	}
+	path := filepath.Join(dir, "capture.png")
+
+	mainWin.Iconify()
+	var captured bool
+	editQueue.submit(func() {
+		captured, err = tool.capture(path)
+	}, func() {
+		defer os.RemoveAll(dir)
+		mainWin.Present()
+		if err != nil {
+			showErrMsg("Cannot capture screen", err.Error())
+			return
+		}
+		if !captured {
+			return
+		}
+
+		// The document may have changed while the window was minimized
+
+		d.sessMu.Lock()
+		closed := d.sess.IsClosed()
+		d.sessMu.Unlock()
+		if closed || page >= len(d.pageCells) || !d.changeable() {
+			return
+		}
+
+		png, err := ioutil.ReadFile(path)
+		if err != nil {
+			showErrMsg("Cannot capture screen", err.Error())
+			return
+		}
+		pix, err := gdk.PixbufNewFromFile(path)
+		if err != nil {
+			showErrMsg("Cannot capture screen", err.Error())
+			return
+		}
+		p, ok := askPlacement(fmt.Sprintf("Place Capture on Page %d", page+1), pix, true)
+		if !ok {
+			return
+		}
+		d.placeImage(page, png, p)
+	})
+}
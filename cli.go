@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc"
+
+	"github.com/oxplot/pdfrankenstein/rpc"
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// command is a command run without the GUI. setup defines its flags on the
+// given flag set and returns the function running it with the positional
+// arguments, so that flags can be listed without running anything.
+type command struct {
+	summary string
+	setup   func(fs *flag.FlagSet) func(pos []string) error
+	// words are what the positional arguments are chosen from, rather than
+	// being PDF files, when completing.
+	words []string
+	// hidden commands are for internal use and left out of completions.
+	hidden bool
+}
+
+// commands are the subcommands run without the GUI, by name. They're set in
+// init since completion lists them.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"flatten": {summary: "overlay annotation SVGs on a PDF", setup: flattenCmd},
+		"thumbs":  {summary: "render pages to PNG files", setup: thumbsCmd},
+		"export-images": {
+			summary: "render annotated pages to PNG or JPEG files",
+			setup:   exportImagesCmd,
+		},
+		"merge": {summary: "concatenate PDF files", setup: mergeCmd},
+		"split": {summary: "write page ranges of a PDF to separate files", setup: splitCmd},
+		"serve": {summary: "serve the session API over gRPC", setup: serveCmd},
+		"completion": {
+			summary: "print a shell completion script",
+			setup:   completionCmd,
+			words:   []string{"bash", "zsh", "fish"},
+		},
+		"__pages": {summary: "list the page numbers of a PDF", setup: pagesCmd, hidden: true},
+		"__search-provider": {
+			summary: "serve the GNOME Shell search provider",
+			setup:   searchProviderCmd,
+			hidden:  true,
+		},
+	}
+}
+
+// noGUICmd annotates pages of a PDF in the editor without the GUI. It's run
+// when --no-gui is given rather than by name.
+var noGUICmd = command{summary: "annotate pages without the GUI", setup: annotateCmd}
+
+// run parses the arguments of the command and runs it.
+func (c command) run(name string, args []string) error {
+	fs := flag.NewFlagSet("pdfrankenstein "+name, flag.ContinueOnError)
+	run := c.setup(fs)
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	return run(pos)
+}
+
+// runCLI runs the command line interface if the arguments ask for it rather
+// than the GUI, and reports whether they did.
+func runCLI(args []string) (bool, error) {
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			return true, cliErr(cmd.run(args[0], args[1:]))
+		}
+	}
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == "--no-gui" || a == "-no-gui" {
+			return true, cliErr(noGUICmd.run("--no-gui", args))
+		}
+		if a == "--automation" || a == "-automation" {
+			return true, cliErr(automationCmd.run("--automation", args))
+		}
+	}
+	return false, nil
+}
+
+// cliErr returns the error of a command, leaving out asking for help which
+// isn't a failure.
+func cliErr(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// parseInterspersed parses flags mixed with positional arguments, which the
+// flag package stops at, and returns the positional ones. Everything after a
+// "--" terminator is positional, even if it looks like a flag.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	if i := terminator(fs, args); i >= 0 {
+		args, rest = args[:i], args[i+1:]
+	}
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return append(pos, rest...), nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// terminator returns the index of the "--" ending the flags in args, or -1
+// if there's none. A "--" given as the value of a flag, e.g. "-o --", isn't
+// one.
+func terminator(fs *flag.FlagSet, args []string) int {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return i
+		}
+		if len(a) < 2 || a[0] != '-' || strings.Contains(a, "=") {
+			continue
+		}
+		f := fs.Lookup(strings.TrimLeft(a, "-"))
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			i++
+		}
+	}
+	return -1
+}
+
+// parseRanges parses a comma separated list of 1-based page numbers and
+// ranges such as "1-3", "9-" (to the last page) or "-3" (from the first).
+func parseRanges(spec string, count int) ([]session.PageRange, error) {
+	var ranges []session.PageRange
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		from, to := 1, count
+		var err error
+		if a, b, ok := strings.Cut(r, "-"); ok {
+			if a != "" {
+				if from, err = strconv.Atoi(a); err != nil {
+					return nil, fmt.Errorf("invalid page range '%s'", r)
+				}
+			}
+			if b != "" {
+				if to, err = strconv.Atoi(b); err != nil {
+					return nil, fmt.Errorf("invalid page range '%s'", r)
+				}
+			}
+		} else {
+			if from, err = strconv.Atoi(r); err != nil {
+				return nil, fmt.Errorf("invalid page number '%s'", r)
+			}
+			to = from
+		}
+		if from < 1 || to > count || from > to {
+			return nil, fmt.Errorf("page range '%s' is outside of 1-%d", r, count)
+		}
+		ranges = append(ranges, session.PageRange{From: from, To: to})
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no pages given")
+	}
+	return ranges, nil
+}
+
+// parsePages parses page ranges like parseRanges and returns the 0-based
+// pages in the order given.
+func parsePages(spec string, count int) ([]int, error) {
+	ranges, err := parseRanges(spec, count)
+	if err != nil {
+		return nil, err
+	}
+	var pages []int
+	for _, r := range ranges {
+		for p := r.From; p <= r.To; p++ {
+			pages = append(pages, p-1)
+		}
+	}
+	return pages, nil
+}
+
+// cliEditor returns the editor settings from the preferences for use without
+// the GUI. Placing on a given monitor needs GDK and is left out.
+func cliEditor() session.Editor {
+	loadState()
+	e := session.Editor{Command: editorCommand()}
+	switch state.EditorPlacement {
+	case "maximized":
+		e.Placement = session.PlaceMaximized
+	case "fullscreen":
+		e.Placement = session.PlaceFullscreen
+	}
+	e.Env = editorEnv()
+	return e
+}
+
+// annotateCmd opens the given pages of a PDF in the editor one after the
+// other and saves the result, all without the GUI.
+func annotateCmd(fs *flag.FlagSet) func(pos []string) error {
+	fs.Bool("no-gui", true, "annotate without the GUI")
+	pagesFlag := fs.String("pages", "", "pages to annotate, e.g. 3,7 or 1-3 (required)")
+	out := fs.String("out", "", "path to save the annotated PDF to, or - for standard output (required)")
+	flattenForms := fs.Bool("flatten-forms", false, "flatten form fields into the pages rather than keep them fillable")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein --no-gui in.pdf --pages 3,7 --out out.pdf")
+		fmt.Fprintln(fs.Output(), "\nThe input PDF is read from standard input if given as -.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 1 || *pagesFlag == "" || *out == "" {
+			fs.Usage()
+			return errors.New("expected one input PDF along with --pages and --out")
+		}
+
+		if pos[0] != stdio {
+			unlock, holder := lockDoc(pos[0])
+			if holder != nil {
+				return fmt.Errorf("'%s' is open by %s on %s since %s", pos[0], holder.User, holder.Host,
+					holder.Since.Local().Format("Jan 2 15:04"))
+			}
+			defer unlock()
+		}
+		sess, done, err := openInput(pos[0])
+		if err != nil {
+			return err
+		}
+		defer done()
+		pages, err := parsePages(*pagesFlag, sess.PageCount())
+		if err != nil {
+			return err
+		}
+		sess.SetEditor(cliEditor())
+		sess.SetFlattenForm(*flattenForms)
+
+		// Interrupting closes the editor. Changes it saved by then are kept.
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		for _, p := range pages {
+			fmt.Fprintf(os.Stderr, "Annotating page %d. Save and close the editor to continue.\n", p+1)
+			changed, err := sess.Annotate(ctx, p)
+			if errors.Is(err, context.Canceled) {
+				return errors.New("interrupted")
+			}
+			if err != nil {
+				return err
+			}
+			if !changed {
+				fmt.Fprintf(os.Stderr, "Page %d unchanged.\n", p+1)
+			}
+		}
+
+		save := func(path string) error { return sess.SaveContext(ctx, path) }
+		if err := writeOutput(*out, save); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return errors.New("interrupted")
+			}
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved to %s\n", outputName(*out))
+		return nil
+	}
+}
+
+// flattenCmd overlays annotation SVGs made beforehand on a PDF.
+func flattenCmd(fs *flag.FlagSet) func(pos []string) error {
+	out := fs.String("o", "", "path to write the flattened PDF to, or - for standard output")
+	flattenForms := fs.Bool("flatten-forms", false, "flatten form fields into the pages too rather than keep them fillable")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein flatten src.pdf annots-dir/ out.pdf")
+		fmt.Fprintln(fs.Output(), "       pdfrankenstein flatten - annots-dir/ -o -")
+		fmt.Fprintln(fs.Output(), "\nAnnotations of page N are read from annot-<N-1>.svg in annots-dir.")
+		fmt.Fprintln(fs.Output(), "The source PDF is read from standard input if given as -.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if *out == "" && len(pos) == 3 {
+			*out, pos = pos[2], pos[:2]
+		}
+		if len(pos) != 2 || *out == "" {
+			fs.Usage()
+			return errors.New("expected a source PDF, an annotations directory and an output path")
+		}
+
+		sess, done, err := openInput(pos[0])
+		if err != nil {
+			return err
+		}
+		defer done()
+		sess.SetFlattenForm(*flattenForms)
+		n, err := sess.ImportAnnotations(pos[1])
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			fmt.Fprintf(os.Stderr, "No annotations found in %s\n", pos[1])
+		}
+		if err := writeOutput(*out, sess.Save); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved %d annotated pages to %s\n", n, outputName(*out))
+		return nil
+	}
+}
+
+// thumbsCmd renders pages the way the GUI renders thumbnails.
+func thumbsCmd(fs *flag.FlagSet) func(pos []string) error {
+	size := fs.Int("size", session.ThumbnailSize, "size in pixels of the square the pages are fit in")
+	out := fs.String("out", "", "directory to write page-N.png files to (required)")
+	pagesFlag := fs.String("pages", "", "pages to render, e.g. 3,7 or 1-3 (default all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein thumbs in.pdf --size 400 --out dir/")
+		fmt.Fprintln(fs.Output(), "\nThe input PDF is read from standard input if given as -.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 1 || *out == "" {
+			fs.Usage()
+			return errors.New("expected one input PDF along with --out")
+		}
+		if *size < 1 {
+			return fmt.Errorf("invalid size %d", *size)
+		}
+
+		sess, done, err := openInput(pos[0])
+		if err != nil {
+			return err
+		}
+		defer done()
+		spec := *pagesFlag
+		if spec == "" {
+			spec = "1-"
+		}
+		pages, err := parsePages(spec, sess.PageCount())
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			return err
+		}
+
+		// Numbers are padded to the width of the last page like image export
+
+		digits := len(strconv.Itoa(sess.PageCount()))
+		paths, err := sess.RenderPages(pages, *size)
+		if err != nil {
+			return err
+		}
+		for i, p := range pages {
+			dst := filepath.Join(*out, fmt.Sprintf("page-%0*d.png", digits, p+1))
+			if err := copyFile(paths[i], dst); err != nil {
+				return fmt.Errorf("failed to write '%s': %s", dst, err)
+			}
+			fmt.Println(dst)
+		}
+		return nil
+	}
+}
+
+// exportImagesCmd renders pages with their annotations through the save
+// pipeline, e.g. for publishing snapshots of a review.
+func exportImagesCmd(fs *flag.FlagSet) func(pos []string) error {
+	format := fs.String("format", "png", "image format, png or jpeg")
+	dpi := fs.Int("dpi", defaultDPI(150), "resolution in dots per inch")
+	annots := fs.String("annotations", "", "directory to read annot-<N-1>.svg files from (default NAME.pdfrann beside in.pdf if any)")
+	out := fs.String("o", "", "directory to write page-N images to (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein export-images in.pdf --format png --dpi 150 -o dir/")
+		fmt.Fprintln(fs.Output(), "\nThe input PDF is read from standard input if given as -.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 1 || *out == "" {
+			fs.Usage()
+			return errors.New("expected one input PDF along with -o")
+		}
+		if *format == "jpg" {
+			*format = "jpeg"
+		}
+		if *format != "png" && *format != "jpeg" {
+			return fmt.Errorf("unsupported image format '%s'", *format)
+		}
+		if *dpi < 1 {
+			return fmt.Errorf("invalid resolution %d", *dpi)
+		}
+		dir := *annots
+		if dir == "" && pos[0] != stdio {
+			if fi, err := os.Stat(session.SidecarDir(pos[0])); err == nil && fi.IsDir() {
+				dir = session.SidecarDir(pos[0])
+			}
+		}
+
+		sess, done, err := openInput(pos[0])
+		if err != nil {
+			return err
+		}
+		defer done()
+		if dir != "" {
+			n, err := sess.ImportAnnotations(dir)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Rendering %d annotated pages from %s\n", n, dir)
+		}
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			return err
+		}
+		paths, err := sess.ExportImages(*out, *format, *dpi)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		return nil
+	}
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0644)
+}
+
+// stdio is the path standing for standard input or output.
+const stdio = "-"
+
+// readStdin copies standard input to a temporary file and returns its path
+// along with a function removing it.
+func readStdin() (string, func(), error) {
+	f, err := ioutil.TempFile("", "pdfrankenstein-stdin-*.pdf")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { _ = os.Remove(f.Name()) }
+	_, err = io.Copy(f, os.Stdin)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		remove()
+		return "", nil, fmt.Errorf("failed to read standard input: %s", err)
+	}
+	return f.Name(), remove, nil
+}
+
+// openInput starts a session on the PDF at path, or on standard input if
+// path is "-". done closes the session and removes what it was opened on.
+func openInput(path string) (sess *session.Session, done func(), err error) {
+	name, remove := path, func() {}
+	if path == stdio {
+		if path, remove, err = readStdin(); err != nil {
+			return nil, nil, err
+		}
+		name = "standard input"
+	}
+	if sess, err = session.New(path); err != nil {
+		remove()
+		return nil, nil, fmt.Errorf("failed to open '%s': %s", name, err)
+	}
+	return sess, func() {
+		sess.Close()
+		remove()
+	}, nil
+}
+
+// writeOutput calls write with the path to write to, which is a temporary
+// file copied to standard output if path is "-".
+func writeOutput(path string, write func(path string) error) error {
+	if path != stdio {
+		return write(path)
+	}
+	dir, err := ioutil.TempDir("", "pdfrankenstein-stdout-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "out.pdf")
+	if err := write(tmp); err != nil {
+		return err
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		return fmt.Errorf("failed to write to standard output: %s", err)
+	}
+	return nil
+}
+
+// outputName returns how to refer to the output path in messages.
+func outputName(path string) string {
+	if path == stdio {
+		return "standard output"
+	}
+	return path
+}
+
+// mergeCmd concatenates PDF files.
+func mergeCmd(fs *flag.FlagSet) func(pos []string) error {
+	out := fs.String("o", "", "path to write the merged PDF to, or - for standard output (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein merge a.pdf b.pdf... -o out.pdf")
+		fmt.Fprintln(fs.Output(), "\nOne of the inputs can be - to read it from standard input.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) == 0 || *out == "" {
+			fs.Usage()
+			return errors.New("expected input PDFs along with -o")
+		}
+		inputs := append([]string(nil), pos...)
+		stdin := false
+		for i, in := range inputs {
+			if in != stdio {
+				continue
+			}
+			if stdin {
+				return errors.New("standard input can only be given once")
+			}
+			stdin = true
+			path, remove, err := readStdin()
+			if err != nil {
+				return err
+			}
+			defer remove()
+			inputs[i] = path
+		}
+		return writeOutput(*out, func(out string) error {
+			return session.Merge(out, inputs...)
+		})
+	}
+}
+
+// splitCmd writes page ranges of a PDF file to separate files.
+func splitCmd(fs *flag.FlagSet) func(pos []string) error {
+	rangesFlag := fs.String("ranges", "", "page ranges, one per output file, e.g. 1-3,9- (default one file per page)")
+	prefix := fs.String("o", "", "prefix of the output files, which are named PREFIX-N.pdf (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein split in.pdf --ranges 1-3,9- -o prefix")
+		fmt.Fprintln(fs.Output(), "\nThe input PDF is read from standard input if given as -.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 1 || *prefix == "" {
+			fs.Usage()
+			return errors.New("expected one input PDF along with -o")
+		}
+
+		in := pos[0]
+		if in == stdio {
+			path, remove, err := readStdin()
+			if err != nil {
+				return err
+			}
+			defer remove()
+			in = path
+		}
+		count, err := session.CountPages(in)
+		if err != nil {
+			return fmt.Errorf("failed to open '%s': %s", pos[0], err)
+		}
+		var ranges []session.PageRange
+		if *rangesFlag == "" {
+			for p := 1; p <= count; p++ {
+				ranges = append(ranges, session.PageRange{From: p, To: p})
+			}
+		} else if ranges, err = parseRanges(*rangesFlag, count); err != nil {
+			return err
+		}
+		paths, err := session.Split(in, ranges, *prefix)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		return nil
+	}
+}
+
+// serveCmd serves the session API over gRPC until interrupted. Clients can
+// make the server read any file its user can through the SVGs they put, so
+// it listens on a Unix socket only its user can connect to unless told to
+// listen on TCP.
+func serveCmd(fs *flag.FlagSet) func(pos []string) error {
+	listen := fs.String("listen", "unix:"+defaultServeSocket(),
+		"address to listen on, a Unix socket as unix:PATH or host:port for TCP, which is unauthenticated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein serve [--listen unix:PATH | --listen HOST:PORT]")
+		fmt.Fprintln(fs.Output(), "\nThe service is defined in rpc/session.proto of the source. It's served")
+		fmt.Fprintln(fs.Output(), "on a Unix socket only the current user can connect to by default. On TCP,")
+		fmt.Fprintln(fs.Output(), "anyone who can connect can read the files of the current user.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 0 {
+			fs.Usage()
+			return errors.New("unexpected arguments")
+		}
+		l, err := serveListen(*listen)
+		if err != nil {
+			return err
+		}
+		srv := rpc.NewServer()
+		defer srv.Shutdown()
+		gs := grpc.NewServer(rpc.ServerOptions()...)
+		rpc.RegisterSessionServer(gs, srv)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			gs.GracefulStop()
+		}()
+		fmt.Fprintf(os.Stderr, "Serving on %s\n", l.Addr())
+		return gs.Serve(l)
+	}
+}
+
+// defaultServeSocket returns the path of the Unix socket the session API is
+// served on by default, in the user's runtime directory if there's one.
+func defaultServeSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pdfrankenstein-rpc.sock")
+}
+
+// serveListen listens on addr, a Unix socket path prefixed with "unix:" or a
+// TCP host:port. Unix sockets are created accessible to the current user
+// only, replacing stale ones left by servers which didn't exit cleanly.
+func serveListen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		fmt.Fprintf(os.Stderr, "Warning: anyone who can connect to %s can use the service unauthenticated\n", addr)
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("already serving on '%s'", path)
+		}
+		_ = os.Remove(path)
+	}
+	umask := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	return l, err
+}
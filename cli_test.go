@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		out  string
+		pos  []string
+	}{
+		{[]string{"a.pdf", "-o", "b.pdf", "c.pdf"}, "b.pdf", []string{"a.pdf", "c.pdf"}},
+		{[]string{"a.pdf", "--", "-o.pdf", "-x"}, "", []string{"a.pdf", "-o.pdf", "-x"}},
+		{[]string{"-o", "b.pdf", "--", "--"}, "b.pdf", []string{"--"}},
+		{[]string{"a.pdf", "--"}, "", []string{"a.pdf"}},
+		{[]string{"-o", "--", "a.pdf", "-o", "b.pdf"}, "b.pdf", []string{"a.pdf"}},
+		{[]string{"-v", "--", "-o", "b.pdf"}, "", []string{"-o", "b.pdf"}},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		out := fs.String("o", "", "output")
+		fs.Bool("v", false, "verbose")
+		pos, err := parseInterspersed(fs, c.args)
+		if err != nil {
+			t.Errorf("%q: %s", c.args, err)
+			continue
+		}
+		if *out != c.out || !reflect.DeepEqual(pos, c.pos) {
+			t.Errorf("%q: got -o %q and %q, want -o %q and %q", c.args, *out, pos, c.out, c.pos)
+		}
+	}
+
+	// "--" as the value of -o doesn't end the flags, so -x is still taken
+	// as one
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.String("o", "", "output")
+	if pos, err := parseInterspersed(fs, []string{"-o", "--", "a.pdf", "-x"}); err == nil {
+		t.Errorf("undefined -x after -o -- taken as positional in %q", pos)
+	}
+}
+
+func TestServeListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	l, err := serveListen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket created with mode %o, want 600", perm)
+	}
+	if l2, err := serveListen("unix:" + path); err == nil {
+		l2.Close()
+		t.Error("listened on a socket already served on")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Completion scripts are generated from the flags the commands define, so
+// they don't go stale as flags are added.
+
+// valueKind is what the value of a flag is completed as.
+type valueKind int
+
+const (
+	valueNone  valueKind = iota // flag takes no value
+	valueAny                    // value isn't completed
+	valuePages                  // page numbers of the input PDF
+	valueFile
+	valueDir
+)
+
+// completionFlag is a flag as needed to complete it.
+type completionFlag struct {
+	name, usage string
+	kind        valueKind
+}
+
+// option returns the flag as typed on the command line. Single letter flags
+// take one dash as in the usage messages.
+func (f completionFlag) option() string {
+	if len(f.name) == 1 {
+		return "-" + f.name
+	}
+	return "--" + f.name
+}
+
+// completionCommand is a command as needed to complete it. The --no-gui
+// command has an empty name.
+type completionCommand struct {
+	name, summary string
+	words         []string
+	flags         []completionFlag
+}
+
+// flagKind guesses what the value of a flag is from its name and usage.
+func flagKind(f *flag.Flag) valueKind {
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return valueNone
+	}
+	switch f.Name {
+	case "pages", "ranges":
+		return valuePages
+	case "annotations":
+		return valueDir
+	case "out", "o":
+		if strings.Contains(f.Usage, "directory") {
+			return valueDir
+		}
+		return valueFile
+	}
+	return valueAny
+}
+
+// completionCommands returns the commands to complete, sorted by name, with
+// the --no-gui one first.
+func completionCommands() []completionCommand {
+	describe := func(name string, c command) completionCommand {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		c.setup(fs)
+		cc := completionCommand{name: name, summary: c.summary, words: c.words}
+		fs.VisitAll(func(f *flag.Flag) {
+			cc.flags = append(cc.flags, completionFlag{f.Name, f.Usage, flagKind(f)})
+		})
+		return cc
+	}
+	var names []string
+	for name, c := range commands {
+		if !c.hidden {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	cmds := []completionCommand{describe("", noGUICmd)}
+	for _, name := range names {
+		cmds = append(cmds, describe(name, commands[name]))
+	}
+	return cmds
+}
+
+// completionCmd prints the completion script for a shell.
+func completionCmd(fs *flag.FlagSet) func(pos []string) error {
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein completion bash|zsh|fish")
+		fmt.Fprintln(fs.Output(), "\nFor bash and zsh, source the output in the shell's startup file.")
+		fmt.Fprintln(fs.Output(), "For fish, save it to ~/.config/fish/completions/pdfrankenstein.fish.")
+		fs.PrintDefaults()
+	}
+	return func(pos []string) error {
+		if len(pos) != 1 {
+			fs.Usage()
+			return errors.New("expected a shell")
+		}
+		cmds := completionCommands()
+		switch pos[0] {
+		case "bash":
+			fmt.Print(bashCompletion(cmds))
+		case "zsh":
+			fmt.Print("autoload -U +X bashcompinit && bashcompinit\n\n" + bashCompletion(cmds))
+		case "fish":
+			fmt.Print(fishCompletion(cmds))
+		default:
+			return fmt.Errorf("unsupported shell '%s'", pos[0])
+		}
+		return nil
+	}
+}
+
+// pagesCmd lists the page numbers of a PDF, for completing --pages.
+func pagesCmd(fs *flag.FlagSet) func(pos []string) error {
+	return func(pos []string) error {
+		if len(pos) != 1 {
+			return errors.New("expected one input PDF")
+		}
+		n, err := session.CountPages(pos[0])
+		if err != nil {
+			return err
+		}
+		for p := 1; p <= n; p++ {
+			fmt.Println(p)
+		}
+		return nil
+	}
+}
+
+// bashCompletion returns the bash completion script, which zsh runs too
+// through bashcompinit.
+func bashCompletion(cmds []completionCommand) string {
+	var b strings.Builder
+	b.WriteString(`# bash completion for pdfrankenstein
+
+# _pdfrankenstein_pages completes a comma separated list of page numbers of
+# the first PDF on the command line.
+_pdfrankenstein_pages() {
+	local w pdf
+	for w in "${COMP_WORDS[@]}"; do
+		if [[ $w == *.[pP][dD][fF] ]]; then
+			pdf=$w
+			break
+		fi
+	done
+	[[ -n $pdf ]] || return
+	local pre=
+	[[ $cur == *,* ]] && pre=${cur%,*},
+	COMPREPLY=($(compgen -P "$pre" -W "$(pdfrankenstein __pages "$pdf" 2>/dev/null)" -- "${cur##*,}"))
+	type compopt &>/dev/null && compopt -o nospace
+}
+
+# Words are indexed only through loops and the arguments since zsh indexes
+# arrays from 1.
+_pdfrankenstein() {
+	local cur=$2 prev=$3 cmd= flags= words= w i=0
+	for w in "${COMP_WORDS[@]}"; do
+		(( i++ == 1 )) && cmd=$w
+	done
+`)
+	b.WriteString("\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n")
+	var names []string
+	for _, c := range cmds {
+		if c.name != "" {
+			names = append(names, c.name)
+		}
+	}
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\") $(compgen -o plusdirs -f -X '!*.[pP][dD][fF]' -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString("\t\treturn\n\tfi\n\tcase $cmd in\n")
+
+	// The --no-gui command goes last as the catch-all.
+
+	for _, c := range append(cmds[1:], cmds[0]) {
+		if c.name == "" {
+			b.WriteString("\t*)\n")
+		} else {
+			fmt.Fprintf(&b, "\t%s)\n", c.name)
+		}
+		var opts []string
+		for _, f := range c.flags {
+			opts = append(opts, f.option())
+		}
+		fmt.Fprintf(&b, "\t\tflags=%q\n", strings.Join(opts, " "))
+		if len(c.words) > 0 {
+			fmt.Fprintf(&b, "\t\twords=%q\n", strings.Join(c.words, " "))
+		}
+		var cases strings.Builder
+		for _, f := range c.flags {
+			var action string
+			switch f.kind {
+			case valueNone:
+				continue
+			case valueAny:
+				action = "return"
+			case valuePages:
+				action = "_pdfrankenstein_pages; return"
+			case valueFile:
+				action = `COMPREPLY=($(compgen -f -- "$cur")); return`
+			case valueDir:
+				action = `COMPREPLY=($(compgen -d -- "$cur")); return`
+			}
+			fmt.Fprintf(&cases, "\t\t-%s|--%s) %s ;;\n", f.name, f.name, action)
+		}
+		if cases.Len() > 0 {
+			b.WriteString("\t\tcase $prev in\n" + cases.String() + "\t\tesac\n")
+		}
+		b.WriteString("\t\t;;\n")
+	}
+	b.WriteString(`	esac
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+	elif [[ -n $words ]]; then
+		COMPREPLY=($(compgen -W "$words" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -o plusdirs -f -X '!*.[pP][dD][fF]' -- "$cur"))
+	fi
+}
+
+complete -o filenames -F _pdfrankenstein pdfrankenstein
+`)
+	return b.String()
+}
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// fishCompletion returns the fish completion script.
+func fishCompletion(cmds []completionCommand) string {
+	var b strings.Builder
+	b.WriteString(`# fish completion for pdfrankenstein
+
+# __pdfrankenstein_pages completes a comma separated list of page numbers of
+# the first PDF on the command line.
+function __pdfrankenstein_pages
+	set -l pre (string match -r '^.*,' -- (commandline -ct))
+	for w in (commandline -opc)
+		if string match -qi '*.pdf' -- $w
+			pdfrankenstein __pages $w 2>/dev/null | string replace -r '^' -- "$pre"
+			return
+		end
+	end
+end
+
+complete -c pdfrankenstein -f
+`)
+	for _, c := range cmds {
+		if c.name != "" {
+			fmt.Fprintf(&b, "complete -c pdfrankenstein -n __fish_use_subcommand -a %s -d %s\n",
+				c.name, fishQuote(c.summary))
+		}
+	}
+	for _, c := range cmds {
+		cond := fishQuote("__fish_seen_subcommand_from " + c.name)
+		if c.name == "" {
+			cond = fishQuote("__fish_contains_opt no-gui")
+		}
+		b.WriteString("\n")
+		if len(c.words) > 0 {
+			fmt.Fprintf(&b, "complete -c pdfrankenstein -n %s -a %s\n", cond, fishQuote(strings.Join(c.words, " ")))
+		} else {
+			fmt.Fprintf(&b, "complete -c pdfrankenstein -n %s -k -a '(__fish_complete_suffix .pdf)'\n", cond)
+		}
+		for _, f := range c.flags {
+			opt := "-l " + f.name
+			if len(f.name) == 1 {
+				opt = "-o " + f.name
+			}
+			fcond := cond
+			if c.name == "" && f.name == "no-gui" {
+				fcond = "__fish_use_subcommand"
+			}
+			var action string
+			switch f.kind {
+			case valueAny:
+				action = " -x"
+			case valuePages:
+				action = " -x -a '(__pdfrankenstein_pages)'"
+			case valueFile:
+				action = " -r -F"
+			case valueDir:
+				action = " -x -a '(__fish_complete_directories (commandline -ct))'"
+			}
+			fmt.Fprintf(&b, "complete -c pdfrankenstein -n %s %s%s -d %s\n", fcond, opt, action, fishQuote(f.usage))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Settings for setups out of the ordinary, such as tools installed outside
+// PATH, are read at startup from an INI file in the XDG config directory:
+//
+//	[tools]
+//	inkscape = /opt/inkscape/bin/inkscape
+//
+//	[render]
+//	dpi = 200
+//
+//	[profile grading]
+//	render.dpi = 150
+//	editor.profile-dir = ~/.config/inkscape-grading
+//
+// Where new tool versions need other arguments, the templates they're made
+// from can be overridden, e.g. templates.qpdf-overlay, with placeholders as
+// described in the session package.
+//
+// Each setting may be overridden by an environment variable named after it,
+// e.g. PDFRANKENSTEIN_RENDER_DPI for render.dpi, which in turn is overridden
+// by --set render.dpi=200 on the command line. --config reads another file
+// instead. Profiles hold settings used instead while they're chosen with
+// --profile or in the header bar. Unlike the preferences, these are never
+// changed by the app.
+
+// appConfig is the configuration read at startup.
+type appConfig struct {
+	// tools are the executables of tools by name, where not found in PATH.
+	tools map[string]string
+	// dpi is the resolution pages are rasterized at for printing and by
+	// default for export-images, or 0 for their own defaults.
+	dpi int
+	// thumbnailSize is the size in pixels of the square thumbnails fit in,
+	// or 0 for the default.
+	thumbnailSize int
+	// tempDir is where temporary files are kept, if set.
+	tempDir string
+	// editor overrides the editor command of the preferences, if set.
+	editor string
+	// editorProfile is the preferences directory Inkscape is run with for
+	// annotating, if set.
+	editorProfile string
+	// initials and signature are the paths of the images used for signing,
+	// overriding the ones chosen in the preferences, if set.
+	initials, signature string
+	// workers is how many external programs are run at a time, or 0 for
+	// as many as there are CPUs.
+	workers int
+	// lowResource is "on", "off" or "auto" for low-resource mode, see
+	// lowres.go.
+	lowResource string
+	// templates are the argument templates of tools by name, where set.
+	templates map[string]string
+}
+
+// defaultConfig returns the configuration with nothing set.
+func defaultConfig() appConfig {
+	return appConfig{tools: map[string]string{}, lowResource: "auto", templates: map[string]string{}}
+}
+
+var config = defaultConfig()
+
+// configSetting is a setting of the config file.
+type configSetting struct {
+	// key is the setting as "section.name".
+	key, usage string
+	set        func(v string) error
+}
+
+// configSettings returns the settings of the config file, sorted by key.
+func configSettings() []configSetting {
+	positive := func(v string, dst *int) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("'%s' is not a positive number", v)
+		}
+		*dst = n
+		return nil
+	}
+	// Paths may start with ~ for the home directory
+	path := func(v string, dst *string) error {
+		if v == "~" || strings.HasPrefix(v, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			v = home + v[1:]
+		}
+		*dst = v
+		return nil
+	}
+	settings := []configSetting{
+		{"render.dpi", "resolution pages are rasterized at for printing and exporting images",
+			func(v string) error { return positive(v, &config.dpi) }},
+		{"render.thumbnail-size", "size in pixels of the square thumbnails are fit in",
+			func(v string) error { return positive(v, &config.thumbnailSize) }},
+		{"session.temp-dir", "directory for temporary files",
+			func(v string) error { return path(v, &config.tempDir) }},
+		{"session.workers", "number of external programs run at a time",
+			func(v string) error { return positive(v, &config.workers) }},
+		{"session.low-resource", "on, off or auto to use less memory and CPU where there's little",
+			func(v string) error {
+				if v != "on" && v != "off" && v != "auto" {
+					return fmt.Errorf("'%s' is not on, off or auto", v)
+				}
+				config.lowResource = v
+				return nil
+			}},
+		{"editor.command", "editor command, overriding the preferences",
+			func(v string) error { config.editor = v; return nil }},
+		{"editor.profile-dir", "Inkscape preferences directory, e.g. with other default pen styles",
+			func(v string) error { return path(v, &config.editorProfile) }},
+		{"sign.initials", "PNG image of the initials, overriding the preferences",
+			func(v string) error { return path(v, &config.initials) }},
+		{"sign.signature", "PNG image of the signature, overriding the preferences",
+			func(v string) error { return path(v, &config.signature) }},
+	}
+	for _, name := range session.ToolNames() {
+		name := name
+		settings = append(settings, configSetting{"tools." + name, "executable of " + name,
+			func(v string) error {
+				var p string
+				err := path(v, &p)
+				config.tools[name] = p
+				return err
+			}})
+	}
+	for _, name := range session.ArgTemplateNames() {
+		name := name
+		settings = append(settings, configSetting{"templates." + name, "arguments of " + name,
+			func(v string) error {
+				config.templates[name] = v
+				return session.CheckArgTemplate(name, v)
+			}})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].key < settings[j].key })
+	return settings
+}
+
+// configEnv returns the environment variable overriding the given setting.
+func configEnv(key string) string {
+	return "PDFRANKENSTEIN_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// configPath returns the path of the config file.
+func configPath() (string, error) {
+	dir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pdfrankenstein", "config.ini"), nil
+}
+
+// profileSection is the prefix of the sections of the config file holding
+// profiles, e.g. [profile grading]. Their settings are named in full, e.g.
+// "render.dpi = 150", and take precedence over the others while the profile
+// is in use.
+const profileSection = "profile "
+
+// configFile is the contents of a config file.
+type configFile struct {
+	path string
+	// values are the settings outside of profiles and profiles those of each
+	// profile by name, both keyed as "section.name".
+	values   map[string]string
+	profiles map[string]map[string]string
+}
+
+// readConfigFile reads the INI file at path. A missing file is empty, unless
+// required.
+func readConfigFile(path string, required bool) (configFile, error) {
+	cf := configFile{path: path, values: map[string]string{}, profiles: map[string]map[string]string{}}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return cf, nil
+	}
+	if err != nil {
+		return cf, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	section, values := "", cf.values
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section, values = strings.TrimSpace(line[1:len(line)-1]), cf.values
+			if strings.HasPrefix(section, profileSection) {
+				name := strings.TrimSpace(strings.TrimPrefix(section, profileSection))
+				if cf.profiles[name] == nil {
+					cf.profiles[name] = map[string]string{}
+				}
+				section, values = "", cf.profiles[name]
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return cf, fmt.Errorf("%s:%d: expected name = value", path, n)
+		}
+		key := strings.TrimSpace(name)
+		if section != "" {
+			key = section + "." + key
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return cf, sc.Err()
+}
+
+// configLayers are where settings come from, from the config file up to
+// --set, kept so the configuration can be worked out again for another
+// profile.
+var configLayers struct {
+	file configFile
+	sets map[string]string
+	// profile is the profile in use, or "".
+	profile string
+}
+
+// loadConfig reads the config file and the environment, and takes --config,
+// --profile, --set and --low-resource out of args, applying them all. It
+// returns the remaining arguments. Without --profile, the profile last chosen in the GUI
+// is used if it still exists.
+func loadConfig(args []string) ([]string, error) {
+	var rest []string
+	path, required := "", false
+	profile, hasProfile := "", false
+	sets := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if a == "--low-resource" || a == "-low-resource" {
+			sets["session.low-resource"] = "on"
+			continue
+		}
+		if !strings.HasPrefix(a, "-") || (name != "config" && name != "set" && name != "profile") {
+			rest = append(rest, a)
+			continue
+		}
+		if !hasValue {
+			if i++; i == len(args) {
+				return nil, fmt.Errorf("flag needs an argument: -%s", name)
+			}
+			value = args[i]
+		}
+		switch name {
+		case "config":
+			path, required = value, true
+			continue
+		case "profile":
+			profile, hasProfile = value, true
+			continue
+		}
+		key, v, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --set '%s', expected key=value", value)
+		}
+		sets[key] = v
+	}
+
+	if path == "" {
+		var err error
+		if path, err = configPath(); err != nil {
+			return nil, err
+		}
+	}
+	cf, err := readConfigFile(path, required)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %s", err)
+	}
+	configLayers.file, configLayers.sets = cf, sets
+	if !hasProfile {
+		loadState()
+		if _, ok := cf.profiles[state.Profile]; ok {
+			profile = state.Profile
+		}
+	}
+	if err := useProfile(profile); err != nil {
+		return nil, err
+	}
+
+	if config.thumbnailSize > 0 {
+		session.ThumbnailSize = config.thumbnailSize
+	}
+	startLowResource()
+	if config.workers == 0 {
+		config.workers = runtime.NumCPU()
+	}
+	if config.tempDir == "" && session.Sandboxed() {
+		// /tmp of the sandbox isn't shared with the tools run on the host,
+		// unlike the cache directory of the app
+		if dir, err := xdgDir("XDG_CACHE_HOME", ".cache"); err == nil {
+			config.tempDir = filepath.Join(dir, "tmp")
+			if err := os.MkdirAll(config.tempDir, 0700); err != nil {
+				return nil, fmt.Errorf("cannot create temporary directory: %s", err)
+			}
+		}
+	}
+	if config.tempDir != "" {
+		// Covers the external programs run too
+		os.Setenv("TMPDIR", config.tempDir)
+	}
+	session.SetConcurrency(config.workers)
+	startQueues(config.workers)
+	return rest, nil
+}
+
+// configProfiles returns the names of the profiles in the config file,
+// sorted.
+func configProfiles() []string {
+	var names []string
+	for name := range configLayers.file.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// useProfile works out the configuration with the given profile, or none if
+// "", and applies what can be changed while running: tool paths, the
+// resolution, editor and signing images. The temporary directory, workers,
+// thumbnail size and low-resource mode only take effect at startup.
+func useProfile(name string) error {
+	cf := configLayers.file
+	values := map[string]string{}
+	for key, v := range cf.values {
+		values[key] = v
+	}
+	if name != "" {
+		p, ok := cf.profiles[name]
+		if !ok {
+			return fmt.Errorf("no profile '%s' in '%s'", name, cf.path)
+		}
+		for key, v := range p {
+			values[key] = v
+		}
+	}
+	known := map[string]bool{}
+	for _, s := range configSettings() {
+		known[s.key] = true
+		if v, ok := os.LookupEnv(configEnv(s.key)); ok {
+			values[s.key] = v
+		}
+		if v, ok := configLayers.sets[s.key]; ok {
+			values[s.key] = v
+		}
+	}
+	for key := range configLayers.sets {
+		if !known[key] {
+			return fmt.Errorf("unknown setting '%s' given to --set", key)
+		}
+	}
+
+	prev := config
+	config = defaultConfig()
+	for _, s := range configSettings() {
+		v, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		delete(values, s.key)
+		if err := s.set(v); err != nil {
+			config = prev
+			return fmt.Errorf("invalid %s: %s", s.key, err)
+		}
+	}
+	if len(values) > 0 {
+		var unknown []string
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		config = prev
+		return fmt.Errorf("unknown setting '%s' in '%s'", unknown[0], cf.path)
+	}
+
+	for _, t := range session.ToolNames() {
+		session.SetToolPath(t, config.tools[t])
+	}
+	for _, name := range session.ArgTemplateNames() {
+		_ = session.SetArgTemplate(name, config.templates[name]) // Checked already
+	}
+	configLayers.profile = name
+	return nil
+}
+
+// editorCommand returns the editor command from the config if set, or else
+// from the preferences.
+func editorCommand() string {
+	if config.editor != "" {
+		return config.editor
+	}
+	return state.EditorCommand
+}
+
+// editorEnv returns the environment variables the editor is run with.
+func editorEnv() []string {
+	if config.editorProfile == "" {
+		return nil
+	}
+	return []string{"INKSCAPE_PROFILE_DIR=" + config.editorProfile}
+}
+
+// configUsage describes the config file and settings for usage messages.
+func configUsage() string {
+	var b strings.Builder
+	path, err := configPath()
+	if err != nil {
+		path = "~/.config/pdfrankenstein/config.ini"
+	}
+	fmt.Fprintf(&b, "Settings are read from %s, overridden by PDFRANKENSTEIN_SECTION_NAME\n", shrinkHome(path))
+	fmt.Fprintln(&b, "environment variables and --set section.name=value:")
+	for _, s := range configSettings() {
+		fmt.Fprintf(&b, "  %-24s %s\n", s.key, s.usage)
+	}
+	return b.String()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// When the app panics, a crash report is written to the state directory
+// with the stack, the recent log entries and where the temporary files of
+// open documents are. Documents with unsaved changes are auto-saved first
+// where it's safe to, i.e. when crashing on the GTK thread, so they're
+// offered for recovery on next launch along with the report. Crashes off
+// the GTK thread leave the temporary directories for the report to point at
+// instead. Termination by a signal, e.g. on logging out, isn't a crash: it
+// only auto-saves.
+
+// crashReportsKept is how many crash reports are kept, newest first.
+const crashReportsKept = 10
+
+// crashOnce makes sure only the first crash is reported, should handling it
+// crash as well.
+var crashOnce sync.Once
+
+// crashSessions are the sessions of the open documents, for crash reports
+// written off the GTK thread where docs can't be used.
+var crashSessions struct {
+	mu       sync.Mutex
+	sessions map[*session.Session]struct{}
+}
+
+// trackSession adds the session of a newly opened document to those told
+// about in crash reports.
+func trackSession(s *session.Session) {
+	crashSessions.mu.Lock()
+	defer crashSessions.mu.Unlock()
+	if crashSessions.sessions == nil {
+		crashSessions.sessions = map[*session.Session]struct{}{}
+	}
+	crashSessions.sessions[s] = struct{}{}
+}
+
+// untrackSession removes the session of a closed document from those told
+// about in crash reports.
+func untrackSession(s *session.Session) {
+	crashSessions.mu.Lock()
+	delete(crashSessions.sessions, s)
+	crashSessions.mu.Unlock()
+}
+
+// crashDir returns the directory crash reports are written to.
+func crashDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "crashes"), nil
+}
+
+// crashGuard reports a panic, and lets it carry on crashing the app. It must
+// be deferred, by the main goroutine, which runs the GTK main loop, with
+// onGTK true, and by other goroutines with onGTK false.
+func crashGuard(onGTK bool) {
+	if r := recover(); r != nil {
+		reportCrash(fmt.Sprintf("panic: %v", r), debug.Stack(), onGTK)
+		panic(r)
+	}
+}
+
+// handleSignals exits on SIGTERM or SIGHUP, e.g. on logging out with
+// documents open. Unsaved changes are auto-saved on the GTK main loop first
+// if it gets to it in time, to be offered for recovery on next launch.
+func handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-c
+		code := 128
+		if n, ok := sig.(syscall.Signal); ok {
+			code += int(n)
+		}
+		crashSessions.mu.Lock()
+		open := len(crashSessions.sessions) > 0
+		crashSessions.mu.Unlock()
+		if !open {
+			os.Exit(code)
+		}
+		done := make(chan struct{})
+		glib.IdleAdd(func() {
+			if report := autosaveUnsaved(); report != "" {
+				log.Printf("terminated by %s:\n%s", sig, strings.TrimSpace(report))
+			}
+			close(done)
+		})
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Printf("terminated by %s before unsaved changes could be auto-saved", sig)
+		}
+		os.Exit(code)
+	}()
+}
+
+// reportCrash auto-saves the documents with unsaved changes if on the GTK
+// thread, and writes a crash report.
+func reportCrash(reason string, stack []byte, onGTK bool) {
+	crashOnce.Do(func() {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s", progName, version)
+		if commit != "" {
+			fmt.Fprintf(&b, " (%s)", commit)
+		}
+		fmt.Fprintf(&b, " crashed at %s\n\n%s\n\n", time.Now().Format(time.RFC3339), reason)
+		if onGTK {
+			b.WriteString(autosaveUnsaved())
+		}
+		b.WriteString(crashSessionsReport())
+		fmt.Fprintf(&b, "Stack:\n\n%s\n", stack)
+		fmt.Fprintf(&b, "Recent log entries:\n\n%s", recentLogEntries())
+
+		dir, err := crashDir()
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		path := filepath.Join(dir, "crash-"+time.Now().Format("20060102-150405")+".txt")
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(b.String()), 0600)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%scannot write crash report: %s\n", log.Prefix(), err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%scrash report written to %s\n", log.Prefix(), path)
+	})
+}
+
+// autosaveUnsaved auto-saves the documents with unsaved changes whose
+// sessions aren't in use, on crashing or being terminated, and returns what
+// was done for the crash report or log. It must be called on the GTK thread.
+func autosaveUnsaved() (report string) {
+	defer func() {
+		if r := recover(); r != nil {
+			report += fmt.Sprintf("Auto-saving panicked: %v\n\n", r)
+		}
+	}()
+	var b strings.Builder
+	for _, d := range docs {
+		if !d.modified {
+			continue
+		}
+		if !d.sessMu.TryLock() {
+			fmt.Fprintf(&b, "Not auto-saved since in use: %s\n", d.path)
+			continue
+		}
+		err := func() error {
+			defer d.sessMu.Unlock()
+			if d.autosaveDir == "" {
+				root, err := autosaveRoot()
+				if err != nil {
+					return err
+				}
+				if err := os.MkdirAll(root, 0755); err != nil {
+					return err
+				}
+				if d.autosaveDir, err = ioutil.TempDir(root, "session-*"); err != nil {
+					return err
+				}
+			}
+			return d.sess.Snapshot(d.autosaveDir)
+		}()
+		if err != nil {
+			fmt.Fprintf(&b, "Failed to auto-save %s: %s\n", d.path, err)
+		} else {
+			fmt.Fprintf(&b, "Auto-saved %s to %s\n", d.path, d.autosaveDir)
+		}
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// crashSessionsReport returns where the temporary files of the open
+// documents are, for the crash report.
+func crashSessionsReport() string {
+	crashSessions.mu.Lock()
+	defer crashSessions.mu.Unlock()
+	if len(crashSessions.sessions) == 0 {
+		return ""
+	}
+	var lines []string
+	for s := range crashSessions.sessions {
+		lines = append(lines, fmt.Sprintf("%s\n    temporary files in %s", s.Origin(), s.TmpDir()))
+	}
+	sort.Strings(lines)
+	return "Open documents, whose annotations are kept as annot-N.svg in their\n" +
+		"temporary files, N being the page number in the PDF counting from 0:\n\n" +
+		strings.Join(lines, "\n") + "\n\n"
+}
+
+// crashReports returns the paths of the crash reports, newest first.
+func crashReports() []string {
+	dir, err := crashDir()
+	if err != nil {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "crash-*.txt"))
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths
+}
+
+// checkLastCrash offers to open the report of a crash since the app was last
+// started, and removes all but the newest crash reports. Auto-saved changes
+// are offered for recovery separately.
+func checkLastCrash() {
+	reports := crashReports()
+	if len(reports) > crashReportsKept {
+		for _, p := range reports[crashReportsKept:] {
+			_ = os.Remove(p)
+		}
+	}
+	if len(reports) == 0 || filepath.Base(reports[0]) <= state.LastCrashSeen {
+		return
+	}
+	path := reports[0]
+	state.LastCrashSeen = filepath.Base(path)
+	saveState()
+
+	dlg := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, gtk.MESSAGE_WARNING, gtk.BUTTONS_NONE,
+		"%s quit unexpectedly", progName)
+	defer dlg.Destroy()
+	dlg.FormatSecondaryText("%s", "A crash report was written to "+shrinkHome(path)+
+		". Please attach it when filing an issue. Unsaved changes which could be "+
+		"auto-saved are offered for recovery next.")
+	if _, err := dlg.AddButton("Close", gtk.RESPONSE_CLOSE); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Open Report", gtk.RESPONSE_OK); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+	if dlg.Run() == gtk.RESPONSE_OK {
+		xdgOpen(path)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Today's date is stamped on a page in one go, in the format and at the
+// position chosen in the preferences, as text in the annotations which can
+// be edited later.
+const (
+	defaultDateFormat   = "%Y-%m-%d"
+	defaultDatePosition = "top-right"
+	// dateStampSize is the font size of date stamps as a fraction of the
+	// page width.
+	dateStampSize = 0.02
+)
+
+// dateFormats are the formats offered for date stamps, besides any typed in.
+var dateFormats = []string{
+	"%Y-%m-%d",
+	"%d/%m/%Y",
+	"%m/%d/%Y",
+	"%e %B %Y",
+	"%B %e, %Y",
+	"%Y-%m-%d %H:%M",
+}
+
+// formatDate formats t following format, in which %Y, %y, %m, %d, %e, %B,
+// %b, %A, %a, %H, %I, %M, %S and %p are replaced as by strftime, and %% by
+// a percent sign. Anything else is kept as is.
+func formatDate(format string, t time.Time) string {
+	pad := func(n int) string {
+		if n < 10 {
+			return "0" + strconv.Itoa(n)
+		}
+		return strconv.Itoa(n)
+	}
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			b.WriteString(strconv.Itoa(t.Year()))
+		case 'y':
+			b.WriteString(pad(t.Year() % 100))
+		case 'm':
+			b.WriteString(pad(int(t.Month())))
+		case 'd':
+			b.WriteString(pad(t.Day()))
+		case 'e':
+			b.WriteString(strconv.Itoa(t.Day()))
+		case 'B':
+			b.WriteString(t.Month().String())
+		case 'b':
+			b.WriteString(t.Month().String()[:3])
+		case 'A':
+			b.WriteString(t.Weekday().String())
+		case 'a':
+			b.WriteString(t.Weekday().String()[:3])
+		case 'H':
+			b.WriteString(pad(t.Hour()))
+		case 'I':
+			b.WriteString(pad((t.Hour()+11)%12 + 1))
+		case 'M':
+			b.WriteString(pad(t.Minute()))
+		case 'S':
+			b.WriteString(pad(t.Second()))
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
+
+// dateFormat returns the format of date stamps chosen in the preferences.
+func dateFormat() string {
+	if state.DateFormat == "" {
+		return defaultDateFormat
+	}
+	return state.DateFormat
+}
+
+// stampDate puts today's date on the given page.
+func (d *document) stampDate(page int) {
+	if !d.changeable() {
+		return
+	}
+	pos := state.DatePosition
+	if pos == "" {
+		pos = defaultDatePosition
+	}
+	p := session.TextPlacement{Size: dateStampSize, Margin: pasteMargin}
+	p.AnchorX, p.AnchorY = imageAnchor(pos)
+	text := formatDate(dateFormat(), time.Now())
+	d.place(page, "Cannot stamp date", func() error {
+		return d.sess.PlaceText(context.Background(), page, text, p)
+	}, nil)
+}
+
+// newDateStampRow creates the preferences row choosing the format and
+// position of date stamps, and returns it along with what stores the
+// choices.
+func newDateStampRow() (*gtk.Box, func()) {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 10)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	formatCombo, err := gtk.ComboBoxTextNewWithEntry()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	for _, f := range dateFormats {
+		formatCombo.Append(f, f)
+	}
+	formatEntry, err := formatCombo.GetEntry()
+	if err != nil {
+		log.Fatalf("unable to get combo box entry: %s", err)
+	}
+	formatEntry.SetText(dateFormat())
+	example := func() {
+		f, _ := formatEntry.GetText()
+		formatCombo.SetTooltipText("Looks like: " + formatDate(f, time.Now()) + "\n\n" +
+			"%Y year, %y short year, %m month, %d day, %e day without leading zero, " +
+			"%B month name, %b short month name, %A weekday, %a short weekday, " +
+			"%H hour, %I hour of 12, %M minute, %S second, %p AM or PM")
+	}
+	formatEntry.Connect("changed", example)
+	example()
+
+	posCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	for _, p := range imagePositions {
+		posCombo.Append(p.id, p.label)
+	}
+	if !posCombo.SetActiveID(state.DatePosition) {
+		posCombo.SetActiveID(defaultDatePosition)
+	}
+	box.PackStart(formatCombo, false, false, 0)
+	box.PackStart(posCombo, false, false, 0)
+
+	return box, func() {
+		f, _ := formatEntry.GetText()
+		if f = strings.TrimSpace(f); f == defaultDateFormat {
+			f = ""
+		}
+		state.DateFormat = f
+		state.DatePosition = posCombo.GetActiveID()
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/gotk3/gotk3/glib"
+)
+
+// The D-Bus API lets other programs drive annotation. It's served under its
+// own name, separate from the GApplication's, by the first instance only.
+const (
+	dbusName  = "org.oxplot.PDFrankenstein"
+	dbusPath  = dbus.ObjectPath("/org/oxplot/PDFrankenstein")
+	dbusIface = "org.oxplot.PDFrankenstein"
+)
+
+// dbusIntro is the introspection data of the interface. Pages are 1-based.
+const dbusIntro = `<interface name="` + dbusIface + `">
+	<method name="Open">
+		<arg name="path" type="s" direction="in"/>
+	</method>
+	<method name="AnnotatePage">
+		<arg name="path" type="s" direction="in"/>
+		<arg name="page" type="i" direction="in"/>
+	</method>
+	<method name="Save">
+		<arg name="path" type="s" direction="in"/>
+		<arg name="out" type="s" direction="in"/>
+	</method>
+	<signal name="Status">
+		<arg name="path" type="s"/>
+		<arg name="status" type="s"/>
+	</signal>
+</interface>`
+
+// dbusConn is the session bus connection the API is served on, or nil if
+// it's not being served.
+var dbusConn *dbus.Conn
+
+// dbusAPI implements the D-Bus interface. Its methods are called on the
+// D-Bus connection's goroutines and hand over to the GTK main loop. Methods
+// return once the request is started and report the outcome through the
+// Status signal.
+type dbusAPI struct{}
+
+// onGTK runs f on the GTK main loop and waits for it to return.
+func onGTK(f func() error) *dbus.Error {
+	res := make(chan error, 1)
+	glib.IdleAdd(func() { res <- f() })
+	if err := <-res; err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// findDoc returns the open document with the given path, or nil.
+func findDoc(path string) *document {
+	for _, d := range docs {
+		if d.path == path {
+			return d
+		}
+	}
+	return nil
+}
+
+// Open opens the PDF file at path in a new tab, or brings it to front if
+// already open. Status reports "opened" once it's loaded.
+func (dbusAPI) Open(path string) *dbus.Error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return onGTK(func() error {
+		if d := findDoc(path); d != nil {
+			notebook.SetCurrentPage(notebook.PageNum(d.root))
+			emitStatus(path, "opened")
+			return nil
+		}
+		open(path)
+		return nil
+	})
+}
+
+// AnnotatePage opens the given 1-based page of an open document in the
+// editor. Status reports the outcome once the editor is closed.
+func (dbusAPI) AnnotatePage(path string, page int32) *dbus.Error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return onGTK(func() error {
+		d := findDoc(path)
+		if d == nil {
+			return fmt.Errorf("'%s' is not open", path)
+		}
+		if page < 1 || int(page) > len(d.pageCells) {
+			return fmt.Errorf("page %d is outside of 1-%d", page, len(d.pageCells))
+		}
+		if d.readOnly {
+			return errors.New("document is open read-only")
+		}
+		if d.busy() {
+			return errors.New("document is busy annotating or saving")
+		}
+		d.annotate(int(page) - 1)
+		return nil
+	})
+}
+
+// Save saves an open document to out, or to where it was last saved if out
+// is empty. Status reports "saved" once done.
+func (dbusAPI) Save(path, out string) *dbus.Error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	if out != "" {
+		if out, err = filepath.Abs(out); err != nil {
+			return dbus.MakeFailedError(err)
+		}
+	}
+	return onGTK(func() error {
+		d := findDoc(path)
+		if d == nil {
+			return fmt.Errorf("'%s' is not open", path)
+		}
+		if d.readOnly {
+			return errors.New("document is open read-only")
+		}
+		if d.busy() {
+			return errors.New("document is busy annotating or saving")
+		}
+		if out == "" {
+			out = d.savePath
+		}
+		if out == "" {
+			return errors.New("document has not been saved before and no output path is given")
+		}
+		d.saveTo(out)
+		return nil
+	})
+}
+
+// serveDBus serves the D-Bus API on the session bus. Failing to do so only
+// logs since the app works fine without.
+func serveDBus() {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		log.Printf("cannot serve D-Bus API: %s", err)
+		return
+	}
+	if err := conn.Export(dbusAPI{}, dbusPath, dbusIface); err != nil {
+		log.Printf("cannot serve D-Bus API: %s", err)
+		conn.Close()
+		return
+	}
+	intro := introspect.Introspectable("<node>" + dbusIntro + introspect.IntrospectDataString + "</node>")
+	if err := conn.Export(intro, dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		log.Printf("cannot serve D-Bus API: %s", err)
+		conn.Close()
+		return
+	}
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err == nil && reply != dbus.RequestNameReplyPrimaryOwner {
+		err = fmt.Errorf("name %s is taken", dbusName)
+	}
+	if err != nil {
+		log.Printf("cannot serve D-Bus API: %s", err)
+		conn.Close()
+		return
+	}
+	dbusConn = conn
+}
+
+// emitStatus sends the Status signal about the document at path, if the
+// D-Bus API is being served. The status is logged with --verbose.
+func emitStatus(path, status string) {
+	logVerbose(logUI, "%s: %s", shrinkHome(path), status)
+	if dbusConn == nil {
+		return
+	}
+	if err := dbusConn.Emit(dbusPath, dbusIface+".Status", path, status); err != nil {
+		log.Printf("cannot emit D-Bus status: %s", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// distro describes how to install packages on a family of Linux
+// distributions.
+type distro struct {
+	ids      []string
+	install  string
+	packages map[string]string
+}
+
+var distros = []distro{
+	{
+		ids:     []string{"arch", "manjaro", "endeavouros"},
+		install: "sudo pacman -S",
+		packages: map[string]string{
+			"inkscape": "inkscape", "qpdf": "qpdf", "pdftocairo": "poppler", "pdftoppm": "poppler",
+			"pdfinfo": "poppler",
+		},
+	},
+	{
+		ids:     []string{"debian", "ubuntu", "linuxmint", "pop"},
+		install: "sudo apt install",
+		packages: map[string]string{
+			"inkscape": "inkscape", "qpdf": "qpdf", "pdftocairo": "poppler-utils", "pdftoppm": "poppler-utils",
+			"pdfinfo": "poppler-utils",
+		},
+	},
+	{
+		ids:     []string{"fedora", "rhel", "centos"},
+		install: "sudo dnf install",
+		packages: map[string]string{
+			"inkscape": "inkscape", "qpdf": "qpdf", "pdftocairo": "poppler-utils", "pdftoppm": "poppler-utils",
+			"pdfinfo": "poppler-utils",
+		},
+	},
+	{
+		ids:     []string{"opensuse", "suse"},
+		install: "sudo zypper install",
+		packages: map[string]string{
+			"inkscape": "inkscape", "qpdf": "qpdf", "pdftocairo": "poppler-tools", "pdftoppm": "poppler-tools",
+			"pdfinfo": "poppler-tools",
+		},
+	},
+	{
+		ids:     []string{"alpine"},
+		install: "sudo apk add",
+		packages: map[string]string{
+			"inkscape": "inkscape", "qpdf": "qpdf", "pdftocairo": "poppler-utils", "pdftoppm": "poppler-utils",
+			"pdfinfo": "poppler-utils",
+		},
+	},
+}
+
+// detectDistro returns the install instructions for the running distro based
+// on /etc/os-release, or nil if it's not a known one. In a Flatpak sandbox
+// that of the host is used, where the tools are run.
+func detectDistro() *distro {
+	path := "/etc/os-release"
+	if session.Sandboxed() {
+		path = "/run/host/os-release"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), "=")
+		if !ok || (k != "ID" && k != "ID_LIKE") {
+			continue
+		}
+		ids = append(ids, strings.Fields(strings.Trim(v, `"'`))...)
+	}
+	for _, id := range ids {
+		for i, d := range distros {
+			for _, did := range d.ids {
+				if id == did {
+					return &distros[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// installHint returns instructions on installing the given missing tools.
+func installHint(missing []string) string {
+	hint := installCommand(missing)
+	if session.Sandboxed() {
+		hint += "\n\nAs " + progName + " runs in a Flatpak sandbox, the tools are run on the host" +
+			" system, which needs the permission to talk to org.freedesktop.Flatpak."
+	}
+	return hint
+}
+
+// installCommand returns how to install the given missing tools.
+func installCommand(missing []string) string {
+	d := detectDistro()
+	if d == nil {
+		return "Install " + strings.Join(missing, ", ") +
+			" using your distribution's package manager. pdftocairo, pdftoppm and pdfinfo are" +
+			" part of poppler (often packaged as poppler-utils)."
+	}
+	var pkgs []string
+	seen := map[string]bool{}
+	for _, t := range missing {
+		if p := d.packages[t]; p != "" && !seen[p] {
+			seen[p] = true
+			pkgs = append(pkgs, p)
+		}
+	}
+	return "Install them by running:\n\n" + d.install + " " + strings.Join(pkgs, " ")
+}
+
+// checkDeps probes for the external tools in the background and guides the
+// user through installing any missing ones. Once all are found, the check is
+// skipped on subsequent launches.
+func checkDeps() {
+	if state.DepsFound {
+		return
+	}
+	var missing []string
+	workQueue.submit(func() {
+		for _, t := range session.Tools() {
+			if t.Err != nil {
+				log.Print(t.Err)
+				missing = append(missing, t.Name)
+			}
+		}
+	}, func() {
+		if len(missing) == 0 {
+			state.DepsFound = true
+			saveState()
+			return
+		}
+		showMissingDeps(missing)
+	})
+}
+
+func showMissingDeps(missing []string) {
+	d := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, gtk.MESSAGE_WARNING, gtk.BUTTONS_CLOSE,
+		"Some required programs are missing")
+	defer d.Destroy()
+
+	// The hint is selectable so the install command can be copied.
+
+	l, err := gtk.LabelNew(fmt.Sprintf(
+		"%s uses the following programs which could not be found: %s.\n\n%s\n\n"+
+			"Until they're installed, opening, annotating or saving files will fail.",
+		progName, strings.Join(missing, ", "), installHint(missing)))
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	l.SetSelectable(true)
+	l.SetLineWrap(true)
+	l.SetMaxWidthChars(60)
+	area, err := d.GetMessageArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog message area: %s", err)
+	}
+	area.PackStart(l, false, false, 0)
+	l.Show()
+
+	d.SetDefaultResponse(gtk.RESPONSE_CLOSE)
+	_ = d.Run()
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Diagnostics check the external tools and then run a one page PDF through
+// the whole pipeline, from opening to saving it annotated, so what fails can
+// be told apart from what's wrong with a particular document.
+
+//go:embed diagnose.pdf
+var diagnosePDF []byte
+
+// diagnoseSVG is the annotation put on the page of the test PDF, which is
+// 200×100pt.
+const diagnoseSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="200pt" height="100pt" viewBox="0 0 200 100">
+<rect x="10" y="10" width="180" height="80" fill="none" stroke="red" stroke-width="2"/>
+</svg>
+`
+
+// errSkipped is the error of steps not run since an earlier one failed.
+var errSkipped = errors.New("skipped since an earlier step failed")
+
+// diagStep is a step of the diagnostics and how it went.
+type diagStep struct {
+	name string
+	// err is why the step failed, or nil if it passed.
+	err error
+	// output is what the step found, or the output of the tool which failed.
+	output string
+	took   time.Duration
+}
+
+// runDiagnostics runs the diagnostics and returns their steps in order. It
+// doesn't touch GTK.
+func runDiagnostics() []diagStep {
+	var steps []diagStep
+	for _, t := range session.Tools() {
+		s := diagStep{name: t.Name + " version", err: t.Err, output: strings.TrimSpace(t.Output)}
+		if t.Err == nil {
+			s.output = t.Version
+		}
+		steps = append(steps, s)
+	}
+
+	dir, err := ioutil.TempDir("", "pdfrankenstein-diagnose-*")
+	if err != nil {
+		return append(steps, diagStep{name: "create temporary directory", err: err})
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "test.pdf")
+	out := filepath.Join(dir, "annotated.pdf")
+
+	// Steps of the pipeline, each run only if the ones before passed
+
+	var sess *session.Session
+	defer func() {
+		if sess != nil {
+			sess.Close()
+		}
+	}()
+	failed := false
+	step := func(name string, run func() (string, error)) {
+		s := diagStep{name: name, err: errSkipped}
+		if !failed {
+			start := time.Now()
+			s.output, s.err = run()
+			s.took = time.Since(start)
+			failed = s.err != nil
+		}
+		steps = append(steps, s)
+	}
+	step("open test PDF", func() (string, error) {
+		if err := ioutil.WriteFile(in, diagnosePDF, 0644); err != nil {
+			return "", err
+		}
+		sess, err = session.New(in)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d page", sess.PageCount()), nil
+	})
+	step("read page size", func() (string, error) {
+		info, err := sess.PageInfo(0)
+		return fmt.Sprintf("%g×%gpt", info.Width, info.Height), err
+	})
+	step("render thumbnail", func() (string, error) {
+		return sess.Thumbnail(0)
+	})
+	step("render pages in batch", func() (string, error) {
+		paths, err := sess.RenderPages([]int{0}, 100)
+		return strings.Join(paths, "\n"), err
+	})
+	step("export page to SVG", func() (string, error) {
+		return "", sess.Prefetch(context.Background(), 0)
+	})
+	step("annotate page", func() (string, error) {
+		return "", sess.PutAnnotation(0, []byte(diagnoseSVG))
+	})
+	step("save annotated PDF", func() (string, error) {
+		return out, sess.Save(out)
+	})
+	step("check saved PDF", func() (string, error) {
+		n, err := session.CountPages(out)
+		if err == nil && n != 1 {
+			err = fmt.Errorf("saved PDF has %d pages rather than 1", n)
+		}
+		return fmt.Sprintf("%d page", n), err
+	})
+	return steps
+}
+
+// diagReport returns the steps as plain text, for bug reports.
+func diagReport(steps []diagStep) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", progName, version)
+	if lowResource {
+		b.WriteString("Low-resource mode is on\n")
+	}
+	b.WriteString("\n")
+	for _, s := range steps {
+		status := "PASS"
+		switch {
+		case s.err == errSkipped:
+			status = "SKIP"
+		case s.err != nil:
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%s %s", status, s.name)
+		if s.took > 0 {
+			fmt.Fprintf(&b, " (%s)", s.took.Round(time.Millisecond))
+		}
+		b.WriteString("\n")
+		for _, text := range []string{s.output, errString(s.err)} {
+			if text = strings.TrimSpace(text); text != "" && s.err != errSkipped {
+				b.WriteString("    " + strings.ReplaceAll(text, "\n", "\n    ") + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// showDiagnostics runs the diagnostics and shows how each step went, with
+// the report ready to copy into a bug report.
+func showDiagnostics() {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Diagnostics")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	dlg.SetDefaultSize(520, 440)
+	copyBut, err := dlg.AddButton("Copy Report", gtk.RESPONSE_APPLY)
+	if err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	copyBut.SetSensitive(false)
+	if _, err := dlg.AddButton("Close", gtk.RESPONSE_CLOSE); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_CLOSE)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.SetSpacing(10)
+	con.SetMarginTop(10)
+	con.SetMarginBottom(10)
+	con.SetMarginStart(10)
+	con.SetMarginEnd(10)
+
+	summary, err := gtk.LabelNew("Checking the tools and annotating a test PDF…")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	summary.SetLineWrap(true)
+	summary.SetHAlign(gtk.ALIGN_START)
+	con.PackStart(summary, false, false, 0)
+	scr, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatalf("unable to create scrolled window: %s", err)
+	}
+	con.PackStart(scr, true, true, 0)
+	spinner, err := gtk.SpinnerNew()
+	if err != nil {
+		log.Fatalf("unable to create spinner: %s", err)
+	}
+	spinner.Start()
+	scr.Add(spinner)
+
+	var steps []diagStep
+	workQueue.submit(func() {
+		steps = runDiagnostics()
+	}, func() {
+		scr.Remove(spinner)
+		list, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 4)
+		if err != nil {
+			log.Fatalf("unable to create box: %s", err)
+		}
+		failures := 0
+		for _, s := range steps {
+			list.PackStart(newDiagRow(s), false, false, 0)
+			if s.err != nil && s.err != errSkipped {
+				failures++
+			}
+		}
+		scr.Add(list)
+		list.ShowAll()
+		if failures == 0 {
+			summary.SetText("All steps passed.")
+		} else {
+			summary.SetText(fmt.Sprintf("%d steps failed. Expand them for what went wrong, "+
+				"and include the copied report when filing an issue.", failures))
+		}
+		copyBut.SetSensitive(true)
+	})
+
+	dlg.ShowAll()
+	for dlg.Run() == gtk.RESPONSE_APPLY {
+		cb, err := gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+		if err != nil {
+			showErrMsg("Cannot copy report", err.Error())
+			continue
+		}
+		cb.SetText(diagReport(steps))
+	}
+}
+
+// newDiagRow creates the row showing how a step of the diagnostics went,
+// with its output expandable.
+func newDiagRow(s diagStep) gtk.IWidget {
+	icon, title := "emblem-ok-symbolic", s.name
+	switch {
+	case s.err == errSkipped:
+		icon = "action-unavailable-symbolic"
+	case s.err != nil:
+		icon = "dialog-error-symbolic"
+	}
+	if s.took > 0 {
+		title += fmt.Sprintf(" (%s)", s.took.Round(time.Millisecond))
+	}
+	img, err := gtk.ImageNewFromIconName(icon, gtk.ICON_SIZE_BUTTON)
+	if err != nil {
+		log.Fatalf("unable to create image: %s", err)
+	}
+	text := strings.TrimSpace(strings.Join([]string{s.output, errString(s.err)}, "\n"))
+	if text == "" {
+		box, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
+		if err != nil {
+			log.Fatalf("unable to create box: %s", err)
+		}
+		l, err := gtk.LabelNew(title)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		box.PackStart(img, false, false, 0)
+		box.PackStart(l, false, false, 0)
+		return box
+	}
+
+	exp, err := gtk.ExpanderNew("")
+	if err != nil {
+		log.Fatalf("unable to create expander: %s", err)
+	}
+	head, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	l, err := gtk.LabelNew(title)
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	head.PackStart(img, false, false, 0)
+	head.PackStart(l, false, false, 0)
+	exp.SetLabelWidget(head)
+	out, err := gtk.LabelNew(text)
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	out.SetSelectable(true)
+	out.SetLineWrap(true)
+	out.SetXAlign(0)
+	out.SetMarginStart(24)
+	if ctx, err := out.GetStyleContext(); err == nil {
+		ctx.AddClass("monospace")
+	}
+	exp.Add(out)
+	exp.SetExpanded(s.err != nil && s.err != errSkipped)
+	return exp
+}
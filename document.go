@@ -0,0 +1,1512 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/gotk3/gotk3/pango"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// document is a PDF open in its own tab, along with its annotation session
+// and page grid.
+type document struct {
+	// id identifies the document in page drag and drop data.
+	id       int
+	path     string
+	savePath string
+	modified bool
+
+	sessMu     sync.Mutex
+	sess       *session.Session
+	loadCtx    context.Context
+	cancelLoad func()
+	// autosaveDir is where the session is auto-saved to while there are
+	// unsaved changes.
+	autosaveDir string
+	// readOnly is set when the PDF was locked by someone else and opened for
+	// browsing only. unlock releases the lock on the PDF, if held.
+	readOnly bool
+	unlock   func()
+	// localCopy is the temporary directory holding the copy of a remote
+	// document the session works on, or "" for local ones.
+	localCopy string
+	// grading is set while the document is the submission being graded.
+	grading bool
+	// extracted is set for documents of pages extracted from another, whose
+	// path is a temporary file kept out of the recent files.
+	extracted bool
+	// selAnchor is the page a selection is extended from with Shift+click.
+	selAnchor int
+
+	// Background work shown in the status bar
+	thumbsLeft int
+	saving     bool
+	placing    bool
+	// savedBytes is how much of the PDF being saved is written so far.
+	savedBytes     int64
+	annotatingPage int
+	// cancelAnnotate kills Inkscape while a page is being annotated.
+	cancelAnnotate func()
+	// hoverTimer starts exporting the page the pointer dwells on, and
+	// cancelHover stops that export once the pointer leaves.
+	hoverTimer  glib.SourceHandle
+	cancelHover func()
+
+	undoStack []func()
+
+	root        *gtk.Box
+	tabLabel    *gtk.Label
+	flow        *gtk.FlowBox
+	inkscapeBar *gtk.InfoBar
+	inkscapeLbl *gtk.Label
+	savedBar    *gtk.InfoBar
+	savedLbl    *gtk.Label
+	gradeBar    *gtk.InfoBar
+	gradeLbl    *gtk.Label
+
+	// Notes sidebar, showing the notes of the focused page
+	notesPanel  *gtk.Box
+	notesLbl    *gtk.Label
+	notesBuf    *gtk.TextBuffer
+	notesCell   *gtk.FlowBoxChild
+	loadingNote bool
+
+	pageCells  []*gtk.FlowBoxChild
+	pageSlots  []*gtk.EventBox
+	pageImages []*gtk.Image
+	pageLabels []*gtk.Label
+	pageBadges []*gtk.Box
+	pageCounts []*gtk.Label
+	pageEdits  []*gtk.Label
+	// Whether annotated pages are shown with their annotations rather than
+	// as the original page
+	pageAfter []bool
+	// Reasons thumbnails failed to render, shown in page tooltips
+	thumbErrs []error
+}
+
+var lastDocID int
+
+// newDocument creates the page grid for the given PDF opened as sess.
+func newDocument(path string, sess *session.Session) *document {
+	var err error
+	lastDocID++
+	d := &document{
+		id:             lastDocID,
+		path:           path,
+		sess:           sess,
+		annotatingPage: -1,
+		unlock:         func() {},
+	}
+	sess.SetCompositor(compositeThumb)
+	sess.SetDiskCap(tempCap())
+	sess.SetSaveProgress(func(written int64) {
+		glib.IdleAdd(func() {
+			if d.saving {
+				d.savedBytes = written
+				updateStatus()
+			}
+		})
+	})
+
+	d.root, err = gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+
+	d.inkscapeBar, err = gtk.InfoBarNew()
+	if err != nil {
+		log.Fatalf("unable to create info bar: %s", err)
+	}
+	d.inkscapeBar.SetMessageType(gtk.MESSAGE_INFO)
+	d.inkscapeLbl, err = gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	d.inkscapeLbl.Show()
+	barContent, err := d.inkscapeBar.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get info bar content area: %s", err)
+	}
+	barContent.Add(d.inkscapeLbl)
+	d.inkscapeBar.AddButton("Cancel", gtk.RESPONSE_CANCEL)
+	d.inkscapeBar.Connect("response", func(_ *gtk.InfoBar, resp int) {
+		if gtk.ResponseType(resp) == gtk.RESPONSE_CANCEL {
+			d.confirmCancelAnnotate()
+		}
+	})
+	d.inkscapeBar.SetNoShowAll(true)
+	d.root.PackStart(d.inkscapeBar, false, false, 0)
+
+	d.savedBar = d.newSavedBar()
+	d.root.PackStart(d.savedBar, false, false, 0)
+
+	d.flow, err = gtk.FlowBoxNew()
+	if err != nil {
+		log.Fatalf("unable to create flowbox: %s", err)
+	}
+	// Pages are selected with Ctrl+click, Shift+click or Ctrl+Space, while
+	// activating a page annotates it alone.
+	d.flow.SetSelectionMode(gtk.SELECTION_MULTIPLE)
+	d.flow.Connect("child-activated", func(_ *gtk.FlowBox, c *gtk.FlowBoxChild) {
+		d.flow.UnselectAll()
+		d.annotate(c.GetIndex())
+	})
+	d.flow.Connect("selected-children-changed", func() { updateActions() })
+	setAccessible(d.flow, roleList, "Pages")
+	d.flow.SetMarginTop(10)
+	d.flow.SetMarginBottom(10)
+	d.flow.SetMarginStart(10)
+	d.flow.SetMarginEnd(10)
+
+	scr, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatalf("unable to create scrolled window: %s", err)
+	}
+	scr.Add(d.flow)
+
+	paned, err := gtk.PanedNew(gtk.ORIENTATION_HORIZONTAL)
+	if err != nil {
+		log.Fatalf("unable to create paned: %s", err)
+	}
+	paned.Pack1(scr, true, false)
+	d.notesPanel = d.newNotesPanel()
+	d.notesPanel.SetNoShowAll(true)
+	paned.Pack2(d.notesPanel, false, false)
+	d.root.PackStart(paned, true, true, 0)
+
+	// Populate the grid with pages
+
+	d.pageCells = make([]*gtk.FlowBoxChild, sess.PageCount())
+	d.pageSlots = make([]*gtk.EventBox, sess.PageCount())
+	d.pageImages = make([]*gtk.Image, sess.PageCount())
+	d.pageLabels = make([]*gtk.Label, sess.PageCount())
+	d.pageBadges = make([]*gtk.Box, sess.PageCount())
+	d.pageCounts = make([]*gtk.Label, sess.PageCount())
+	d.pageEdits = make([]*gtk.Label, sess.PageCount())
+	d.pageAfter = make([]bool, sess.PageCount())
+	d.thumbErrs = make([]error, sess.PageCount())
+	for i := range d.pageCells {
+		d.pageCells[i] = d.newPageCell(i)
+		d.flow.Add(d.pageCells[i])
+		d.updatePage(i)
+	}
+
+	d.root.ShowAll()
+	d.notesPanel.SetVisible(showNotes)
+	d.applyFilter()
+	d.applyView()
+	return d
+}
+
+// newTab creates the notebook tab label of the document, with a button to
+// close it.
+func (d *document) newTab() *gtk.Box {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 4)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	tip := shrinkHome(d.path)
+	if dir := d.sess.Sidecar(); dir != "" {
+		tip += "\nAnnotations kept in " + shrinkHome(dir)
+	}
+	box.SetTooltipText(tip)
+	d.tabLabel, err = gtk.LabelNew(baseName(d.path))
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	d.tabLabel.SetEllipsize(pango.ELLIPSIZE_MIDDLE)
+	d.tabLabel.SetMaxWidthChars(24)
+	box.PackStart(d.tabLabel, true, true, 0)
+	b, err := gtk.ButtonNewFromIconName("window-close-symbolic", gtk.ICON_SIZE_MENU)
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	b.SetRelief(gtk.RELIEF_NONE)
+	b.SetTooltipText("Close")
+	b.Connect("clicked", func() { closeDoc(d) })
+	box.PackStart(b, false, false, 0)
+	box.ShowAll()
+	return box
+}
+
+// loadThumb renders the given page without holding sessMu, which the GTK
+// thread takes often, since rendering can take seconds.
+func (d *document) loadThumb(page int, preview, after bool) (string, error) {
+	d.sessMu.Lock()
+	sess := d.sess
+	closed := sess.IsClosed()
+	d.sessMu.Unlock()
+	if closed {
+		return "", session.ErrClosed
+	}
+	switch {
+	case preview && after:
+		return sess.AnnotatedPreview(page)
+	case preview:
+		return sess.Preview(page)
+	case after:
+		return sess.AnnotatedThumbnail(page)
+	}
+	return sess.Thumbnail(page)
+}
+
+// compositeThumb draws the annotations in the SVG at overlay over the page
+// render at base, scaled to fit, and saves the result as PNG to out. It's
+// run on workers rather than the GTK thread, which is fine since GdkPixbuf,
+// rendering the SVG with librsvg, is thread safe unlike GTK itself.
+func compositeThumb(base, overlay, out string) error {
+	pix, err := gdk.PixbufNewFromFile(base)
+	if err != nil {
+		return err
+	}
+	w, h := pix.GetWidth(), pix.GetHeight()
+	over, err := gdk.PixbufNewFromFileAtScale(overlay, w, h, false)
+	if err != nil {
+		return err
+	}
+	over.Composite(pix, 0, 0, w, h, 0, 0, 1, 1, gdk.INTERP_BILINEAR, 255)
+	return pix.SavePNG(out, 1)
+}
+
+// startLoadingThumbs cancels any ongoing thumbnail loading and starts afresh.
+// Thumbnails are cached by the session so reloading is cheap. Once
+// cancelled, no more thumbnails are loaded nor set on the page images.
+func (d *document) startLoadingThumbs() {
+	if d.cancelLoad != nil {
+		d.cancelLoad()
+	}
+	d.thumbsLeft = len(d.pageImages)
+	updateStatus()
+	d.loadCtx, d.cancelLoad = context.WithCancel(context.Background())
+
+	// Pages shown as they are, rather than with annotations, are rendered in
+	// batches, each with a single run of pdftoppm
+
+	var batch []int
+	for page := range d.pageImages {
+		if d.pageAfter[page] && d.isAnnotated(page) {
+			d.submitThumb(page)
+			continue
+		}
+		if batch = append(batch, page); len(batch) == thumbBatch {
+			d.submitThumbs(batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		d.submitThumbs(batch)
+	}
+}
+
+// thumbBatch is the number of thumbnails rendered at once, kept small
+// enough for the first ones to show up soon.
+const thumbBatch = 24
+
+// submitThumbs queues loading the thumbnails of the given pages, as they are
+// rather than with annotations, all at once. If that fails, they're loaded
+// one by one so each page gets its own error.
+func (d *document) submitThumbs(pages []int) {
+	ctx := d.loadCtx
+	preview := stripView
+	var paths []string
+	var err error
+	workQueue.submit(func() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The session is only locked to look up the pages so the GTK thread
+		// isn't held up while they're rendered
+
+		d.sessMu.Lock()
+		sess := d.sess
+		if sess.IsClosed() {
+			d.sessMu.Unlock()
+			err = session.ErrClosed
+			return
+		}
+		orig := sess.OriginalPages(pages)
+		d.sessMu.Unlock()
+		size := session.ThumbnailSize
+		if preview {
+			size = session.PreviewSize
+		}
+		paths, err = sess.RenderOriginalPages(orig, size)
+	}, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logVerbose(session.LogSession, "failed to load thumbnails: %s", err)
+			for _, page := range pages {
+				d.submitThumb(page)
+			}
+			return
+		}
+		for i, page := range pages {
+			d.thumbErrs[page] = nil
+			d.pageImages[page].SetFromFile(paths[i])
+		}
+		d.thumbsLeft -= len(pages)
+		updateStatus()
+	})
+}
+
+// reloadThumb renders the thumbnail of the given page afresh, for when the
+// page has changed while thumbnails are shown with annotations.
+func (d *document) reloadThumb(page int) {
+	d.thumbsLeft++
+	updateStatus()
+	d.pageImages[page].SetFromPixbuf(loadingPix.get())
+	d.submitThumb(page)
+}
+
+// submitThumb queues loading the thumbnail of the given page, showing it
+// with or without annotations as toggled.
+func (d *document) submitThumb(page int) {
+	ctx := d.loadCtx
+	preview := stripView
+	after := d.pageAfter[page] && d.isAnnotated(page)
+	var path string
+	var err error
+	workQueue.submit(func() {
+		if ctx.Err() == nil {
+			path, err = d.loadThumb(page, preview, after)
+		}
+	}, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("failed to load thumbnail: %s", err)
+		}
+		d.thumbErrs[page] = err
+		if err != nil {
+			d.pageImages[page].SetFromPixbuf(noThumbPix.get())
+		} else {
+			d.pageImages[page].SetFromFile(path)
+		}
+		d.thumbsLeft--
+		updateStatus()
+	})
+}
+
+// focusedPage returns the position of the page with keyboard focus, or -1 if
+// none has it.
+func (d *document) focusedPage() int {
+	for p, c := range d.pageCells {
+		if c.IsFocus() {
+			return p
+		}
+	}
+	return -1
+}
+
+// currentPage returns the position of the page which last had keyboard
+// focus, or of the first page if none had.
+func (d *document) currentPage() int {
+	if d.notesCell == nil {
+		return 0
+	}
+	return d.notesCell.GetIndex()
+}
+
+// toggleAfter switches the given annotated page between showing the original
+// page and the page with its annotations.
+func (d *document) toggleAfter(page int) {
+	d.pageAfter[page] = !d.pageAfter[page]
+	d.reloadThumb(page)
+}
+
+// status returns the session stats and the state of background work for the
+// status bar.
+func (d *document) status() (string, string) {
+	d.sessMu.Lock()
+	if d.sess.IsClosed() {
+		d.sessMu.Unlock()
+		return "", ""
+	}
+	pages, annotated := d.sess.PageCount(), d.sess.AnnotatedCount()
+	usage, err := d.sess.DiskUsage()
+	stamps, serr := d.sess.Stamps()
+	watermarked := d.sess.Watermark() != nil
+	numbered := d.sess.PageNumbering() != nil
+	d.sessMu.Unlock()
+
+	status := fmt.Sprintf("%d pages · %d annotated", pages, annotated)
+	if serr == nil && len(stamps) > 0 {
+		var score float64
+		for _, s := range stamps {
+			score += s.Points
+		}
+		status += " · score " + formatScore(score)
+	}
+	if watermarked {
+		status += " · watermarked"
+	}
+	if numbered {
+		status += " · numbered"
+	}
+	if err == nil {
+		status += " · " + formatSize(usage) + " temporary files"
+	}
+
+	var work []string
+	if d.saving && d.savedBytes > 0 {
+		work = append(work, "Saving… "+formatSize(d.savedBytes)+" written")
+	} else if d.saving {
+		work = append(work, "Saving…")
+	}
+	if d.placing {
+		work = append(work, "Placing on page…")
+	}
+	if d.thumbsLeft > 0 {
+		total := len(d.pageImages)
+		work = append(work, fmt.Sprintf("Rendering pages %d/%d", total-d.thumbsLeft, total))
+	}
+	return status, strings.Join(work, " · ")
+}
+
+func (d *document) isAnnotated(page int) bool {
+	d.sessMu.Lock()
+	defer d.sessMu.Unlock()
+	return d.sess.IsAnnotated(page)
+}
+
+// updatePage refreshes the labels and accessible name of the given page to
+// reflect its annotation state.
+func (d *document) updatePage(page int) {
+	d.sessMu.Lock()
+	edited := d.sess.LastEdited(page)
+	objects, err := d.sess.ObjectCount(page)
+	d.sessMu.Unlock()
+	annotated := !edited.IsZero()
+
+	name := fmt.Sprintf("Page %d", page+1)
+	if annotated {
+		name += ", annotated"
+	}
+	d.pageLabels[page].SetText(strconv.Itoa(page + 1))
+	d.pageBadges[page].SetVisible(annotated)
+	if err != nil {
+		log.Printf("cannot count objects on page %d: %s", page+1, err)
+		d.pageCounts[page].SetText("?")
+	} else {
+		d.pageCounts[page].SetText(strconv.Itoa(objects))
+	}
+	d.pageEdits[page].SetText("edited " + formatEditTime(edited))
+	d.pageEdits[page].SetVisible(annotated)
+	d.pageCells[page].SetVisible(annotated || !annotatedOnly)
+	setAccessible(d.pageCells[page], roleListItem, name)
+}
+
+// applyFilter shows or hides unannotated pages according to the annotated
+// only toggle.
+func (d *document) applyFilter() {
+	for p := range d.pageCells {
+		d.pageCells[p].SetVisible(!annotatedOnly || d.isAnnotated(p))
+	}
+}
+
+// applyView lays the pages out as a grid of thumbnails or, in the strip
+// view, as a single column of larger previews, and renders them afresh.
+func (d *document) applyView() {
+	size, perLine := session.ThumbnailSize, uint(7) // GTK's default
+	if stripView {
+		size, perLine = session.PreviewSize, 1
+	}
+	d.flow.SetMaxChildrenPerLine(perLine)
+	for _, eb := range d.pageSlots {
+		eb.SetSizeRequest(size, size)
+	}
+	for _, img := range d.pageImages {
+		img.SetFromPixbuf(loadingPix.get())
+	}
+	d.startLoadingThumbs()
+}
+
+// formatEditTime formats the time a page was edited, leaving out the date
+// for today.
+func formatEditTime(t time.Time) string {
+	now := time.Now()
+	if t.Year() == now.Year() && t.YearDay() == now.YearDay() {
+		return t.Format("15:04")
+	}
+	return t.Format("Jan 2")
+}
+
+// movePage moves the page at position from to position to.
+func (d *document) movePage(from, to int) {
+	d.sessMu.Lock()
+	d.sess.Move(from, to)
+	d.sessMu.Unlock()
+
+	c := d.pageCells[from]
+	d.flow.Remove(c)
+	d.flow.Insert(c, to)
+	moveItem(d.pageCells, from, to)
+	moveItem(d.pageSlots, from, to)
+	moveItem(d.pageImages, from, to)
+	moveItem(d.pageLabels, from, to)
+	moveItem(d.pageBadges, from, to)
+	moveItem(d.pageCounts, from, to)
+	moveItem(d.pageEdits, from, to)
+	moveItem(d.pageAfter, from, to)
+	moveItem(d.thumbErrs, from, to)
+
+	lo, hi := from, to
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	for p := lo; p <= hi; p++ {
+		d.updatePage(p)
+	}
+	d.setModified(true)
+	d.startLoadingThumbs()
+	d.showNote(d.notesCell)
+}
+
+// pushUndo records a function which reverts the last change.
+func (d *document) pushUndo(f func()) {
+	d.undoStack = append(d.undoStack, f)
+	updateActions()
+}
+
+func (d *document) undo() {
+	if len(d.undoStack) == 0 || d.busy() {
+		return
+	}
+	f := d.undoStack[len(d.undoStack)-1]
+	d.undoStack = d.undoStack[:len(d.undoStack)-1]
+	updateActions()
+	f()
+}
+
+func (d *document) clearAnnotation(page int) {
+	if !d.changeable() {
+		return
+	}
+	if !confirm(fmt.Sprintf("Clear the annotations of page %d?", page+1), "", "Clear", "Keep", true) {
+		return
+	}
+	d.sessMu.Lock()
+	err := d.sess.Clear(page)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot clear annotations", err.Error())
+		return
+	}
+	d.updatePage(page)
+	if d.pageAfter[page] {
+		d.reloadThumb(page)
+	}
+	d.setModified(true)
+	updateStatus()
+	d.pushUndo(func() { d.restoreAnnotation(page) })
+}
+
+// revertAnnotation puts the annotations of the given page back to how they
+// were when last saved, after confirming.
+func (d *document) revertAnnotation(page int) {
+	if !d.changeable() {
+		return
+	}
+	if !confirm(fmt.Sprintf("Revert page %d to how it was last saved?", page+1),
+		"Changes made since can be brought back with Undo.", "Revert", "Keep", true) {
+		return
+	}
+	wasAnnotated := d.isAnnotated(page)
+	d.sessMu.Lock()
+	err := d.sess.Revert(page)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot revert annotations", err.Error())
+		return
+	}
+	d.updatePage(page)
+	if d.pageAfter[page] {
+		d.reloadThumb(page)
+	}
+	d.setModified(true)
+	updateStatus()
+
+	// Reverting a page which had its annotations cleared puts annotations
+	// back without anything going to the trash, so undoing clears them again.
+
+	if wasAnnotated {
+		d.pushUndo(func() { d.restoreAnnotation(page) })
+	} else {
+		d.pushUndo(func() { d.unrevertAnnotation(page) })
+	}
+}
+
+// unrevertAnnotation undoes reverting a page whose annotations were cleared.
+func (d *document) unrevertAnnotation(page int) {
+	d.sessMu.Lock()
+	err := d.sess.Clear(page)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot undo revert", err.Error())
+		return
+	}
+	d.updatePage(page)
+	if d.pageAfter[page] {
+		d.reloadThumb(page)
+	}
+	d.setModified(true)
+	updateStatus()
+}
+
+// restoreAnnotation brings back the last cleared annotations of the given
+// page.
+func (d *document) restoreAnnotation(page int) {
+	d.sessMu.Lock()
+	err := d.sess.Restore(page)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot restore annotations", err.Error())
+		return
+	}
+	d.updatePage(page)
+	if d.pageAfter[page] {
+		d.reloadThumb(page)
+	}
+	d.setModified(true)
+	updateStatus()
+}
+
+// newPageCell creates the flow box cell showing the thumbnail of the given
+// page, along with its number and annotated badge.
+//
+// The cell handles keyboard navigation, context menu and reordering.
+// Activation (click, Enter) annotates, Delete clears the page, B toggles
+// between the page before and after annotating and Ctrl+V pastes an image.
+// Since pages move around, handlers look up the page by the cell's current
+// position.
+func (d *document) newPageCell(page int) *gtk.FlowBoxChild {
+	c, err := gtk.FlowBoxChildNew()
+	if err != nil {
+		log.Fatalf("unable to create flow box child: %s", err)
+	}
+	c.Connect("key-press-event", func(c *gtk.FlowBoxChild, ev *gdk.Event) bool {
+		key := gdk.EventKeyNewFromEvent(ev)
+		if gdk.ModifierType(key.State())&gdk.CONTROL_MASK != 0 {
+			if key.KeyVal() == gdk.KEY_v {
+				d.pasteImage(c.GetIndex())
+				return true
+			}
+			return false
+		}
+		switch key.KeyVal() {
+		case gdk.KEY_Delete, gdk.KEY_KP_Delete:
+			page := c.GetIndex()
+			if d.isAnnotated(page) {
+				d.clearAnnotation(page)
+			}
+			return true
+		case gdk.KEY_b:
+			page := c.GetIndex()
+			if d.isAnnotated(page) {
+				d.toggleAfter(page)
+			}
+			return true
+		case gdk.KEY_Escape:
+			if len(d.selectedPages()) == 0 {
+				return false
+			}
+			d.flow.UnselectAll()
+			return true
+		}
+		return false
+	})
+	c.Connect("popup-menu", func(c *gtk.FlowBoxChild) bool {
+		d.showPageMenu(c, nil)
+		return true
+	})
+	c.Connect("focus-in-event", func(c *gtk.FlowBoxChild) bool {
+		if c != d.notesCell {
+			d.showNote(c)
+		}
+		return false
+	})
+
+	// Drag data is the document ID and page position, so pages can't be
+	// dropped into other documents.
+
+	c.DragDestSet(gtk.DEST_DEFAULT_ALL, []gtk.TargetEntry{*pageTarget}, gdk.ACTION_MOVE)
+	c.Connect("drag-data-received", func(c *gtk.FlowBoxChild, _ *gdk.DragContext, x, y int, data *gtk.SelectionData) {
+		id, pos, _ := strings.Cut(string(data.GetData()), ":")
+		if id != strconv.Itoa(d.id) {
+			return
+		}
+		from, err := strconv.Atoi(pos)
+		if err != nil {
+			return
+		}
+		to := c.GetIndex()
+		if from == to || !d.changeable() {
+			return
+		}
+		d.movePage(from, to)
+		d.pushUndo(func() { d.movePage(to, from) })
+	})
+
+	// The cell has no window of its own, so pointer events (other than the
+	// flow box's activation) are handled by an event box.
+
+	eb, err := gtk.EventBoxNew()
+	if err != nil {
+		log.Fatalf("unable to create event box: %s", err)
+	}
+	eb.Connect("button-press-event", func(_ *gtk.EventBox, ev *gdk.Event) bool {
+		btn := gdk.EventButtonNewFromEvent(ev)
+		if btn.Type() != gdk.EVENT_BUTTON_PRESS {
+			return false
+		}
+		if btn.Button() == gdk.BUTTON_PRIMARY {
+			return d.selectClicked(c, gdk.ModifierType(btn.State()))
+		}
+		if btn.Button() != gdk.BUTTON_SECONDARY {
+			return false
+		}
+		c.GrabFocus()
+		d.showPageMenu(c, ev)
+		return true
+	})
+	_ = eb.SetProperty("has-tooltip", true)
+	eb.Connect("query-tooltip", func(_ *gtk.EventBox, x, y int, keyboard bool, tip *gtk.Tooltip) bool {
+		tip.SetText(d.pageTooltip(c.GetIndex()))
+		return true
+	})
+	eb.AddEvents(int(gdk.ENTER_NOTIFY_MASK | gdk.LEAVE_NOTIFY_MASK))
+	eb.Connect("enter-notify-event", func() bool {
+		d.hoverPage(c.GetIndex())
+		return false
+	})
+	eb.Connect("leave-notify-event", func() bool {
+		d.unhoverPage()
+		return false
+	})
+	// Pages come in all sizes and orientations, so thumbnails are centered in
+	// a square slot, sized by applyView, to keep the grid aligned.
+	d.pageSlots[page] = eb
+	eb.DragSourceSet(gdk.ModifierType(gdk.BUTTON1_MASK), []gtk.TargetEntry{*pageTarget}, gdk.ACTION_MOVE)
+	eb.Connect("drag-data-get", func(_ *gtk.EventBox, _ *gdk.DragContext, data *gtk.SelectionData) {
+		data.SetData(pageAtom, []byte(fmt.Sprintf("%d:%d", d.id, c.GetIndex())))
+	})
+
+	o, err := gtk.OverlayNew()
+	if err != nil {
+		log.Fatal("Unable to create overlay")
+	}
+	o.SetHAlign(gtk.ALIGN_CENTER)
+	o.SetVAlign(gtk.ALIGN_CENTER)
+	eb.Add(o)
+
+	// Page thumb
+
+	img, err := gtk.ImageNewFromPixbuf(loadingPix.get())
+	if err != nil {
+		log.Fatalf("failed to create image asset: %s", err)
+	}
+	d.pageImages[page] = img
+	o.Add(img)
+
+	// Page Label
+
+	l, err := gtk.LabelNew(strconv.Itoa(page + 1))
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	addCSS(l, cleanCSS.get())
+	l.SetHAlign(gtk.ALIGN_START)
+	l.SetVAlign(gtk.ALIGN_END)
+	l.SetMarginBottom(3)
+	l.SetMarginStart(3)
+	d.pageLabels[page] = l
+	o.AddOverlay(l)
+
+	// Annotated badge, with the number of objects drawn so empty
+	// annotations stand out
+
+	badge, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 3)
+	if err != nil {
+		log.Fatalf("unable to create badge: %s", err)
+	}
+	addCSS(badge, badgeCSS.get())
+	badge.SetHAlign(gtk.ALIGN_END)
+	badge.SetVAlign(gtk.ALIGN_START)
+	badge.SetNoShowAll(true)
+	badgeIcon, err := gtk.ImageNewFromIconName("document-edit-symbolic", gtk.ICON_SIZE_BUTTON)
+	if err != nil {
+		log.Fatalf("unable to create badge: %s", err)
+	}
+	badgeIcon.Show()
+	badge.PackStart(badgeIcon, false, false, 0)
+	count, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	count.Show()
+	badge.PackStart(count, false, false, 0)
+	d.pageBadges[page] = badge
+	d.pageCounts[page] = count
+	o.AddOverlay(badge)
+
+	// Last edited caption
+
+	edited, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	addCSS(edited, cleanCSS.get())
+	if ctx, err := edited.GetStyleContext(); err == nil {
+		ctx.AddClass("dim-label")
+	}
+	edited.SetHAlign(gtk.ALIGN_END)
+	edited.SetVAlign(gtk.ALIGN_END)
+	edited.SetMarginBottom(3)
+	edited.SetMarginEnd(3)
+	edited.SetNoShowAll(true)
+	d.pageEdits[page] = edited
+	o.AddOverlay(edited)
+
+	c.Add(eb)
+	c.ShowAll()
+	return c
+}
+
+// selectClicked toggles the selection of the page in the given cell if
+// clicked with Ctrl held, or selects the pages from the last one clicked to
+// it with Shift held. It returns false for plain clicks, left to activate the
+// page.
+func (d *document) selectClicked(c *gtk.FlowBoxChild, state gdk.ModifierType) bool {
+	page := c.GetIndex()
+	switch {
+	case state&gdk.SHIFT_MASK != 0:
+		from, to := d.selAnchor, page
+		if from > to {
+			from, to = to, from
+		}
+		for p := from; p <= to && p < len(d.pageCells); p++ {
+			d.flow.SelectChild(d.flow.GetChildAtIndex(p))
+		}
+	case state&gdk.CONTROL_MASK != 0:
+		if c.IsSelected() {
+			d.flow.UnselectChild(c)
+		} else {
+			d.flow.SelectChild(c)
+		}
+		d.selAnchor = page
+	default:
+		d.selAnchor = page
+		return false
+	}
+	c.GrabFocus()
+	return true
+}
+
+// selectedPages returns the positions of the selected pages in order.
+func (d *document) selectedPages() []int {
+	var pages []int
+	for _, c := range d.flow.GetSelectedChildren() {
+		pages = append(pages, c.GetIndex())
+	}
+	sort.Ints(pages)
+	return pages
+}
+
+// pageTooltip returns the tooltip text describing the given page.
+func (d *document) pageTooltip(page int) string {
+	d.sessMu.Lock()
+	if d.sess.IsClosed() {
+		d.sessMu.Unlock()
+		return ""
+	}
+	info, err := d.sess.PageInfo(page)
+	objects, countErr := d.sess.ObjectCount(page)
+	d.sessMu.Unlock()
+	if err != nil {
+		return fmt.Sprintf("Page %d", page+1)
+	}
+
+	// Points to millimeters
+	const mm = 25.4 / 72
+	w, h := info.DisplaySize()
+	orient := "portrait"
+	if info.Landscape() {
+		orient = "landscape"
+	}
+	lines := []string{
+		fmt.Sprintf("Page %d", page+1),
+		fmt.Sprintf("%.0f × %.0f mm, %s", w*mm, h*mm, orient),
+	}
+	if info.Rotation != 0 {
+		lines = append(lines, fmt.Sprintf("Rotated %d°", info.Rotation))
+	}
+	if info.Annotated {
+		lines = append(lines, "Annotated, last edited "+info.LastEdited.Format("Jan 2 15:04"))
+		switch {
+		case countErr != nil:
+			lines = append(lines, "Cannot count objects: "+countErr.Error())
+		case objects == 0:
+			lines = append(lines, "Nothing drawn")
+		case objects == 1:
+			lines = append(lines, "1 object drawn")
+		default:
+			lines = append(lines, fmt.Sprintf("%d objects drawn", objects))
+		}
+	} else {
+		lines = append(lines, "Not annotated")
+	}
+	if err := d.thumbErrs[page]; err != nil {
+		lines = append(lines, "Thumbnail failed: "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showPageMenu shows the context menu of the page in the given cell, either
+// at the pointer if triggered by a click or at the cell otherwise.
+func (d *document) showPageMenu(c *gtk.FlowBoxChild, ev *gdk.Event) {
+	page := c.GetIndex()
+	annotated := d.isAnnotated(page)
+
+	m, err := gtk.MenuNew()
+	if err != nil {
+		log.Fatalf("unable to create menu: %s", err)
+	}
+	annotItem, err := gtk.MenuItemNewWithLabel("Annotate in Inkscape")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	annotItem.Connect("activate", func() { d.annotate(page) })
+	annotItem.SetSensitive(d.changeable())
+	m.Append(annotItem)
+	pasteItem, err := gtk.MenuItemNewWithLabel("Paste Image…")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	pasteItem.Connect("activate", func() { d.pasteImage(page) })
+	pasteItem.SetSensitive(d.changeable() && clipboardHasImage())
+	m.Append(pasteItem)
+	qrItem, err := gtk.MenuItemNewWithLabel("QR Code…")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	qrItem.Connect("activate", func() { d.placeQRCode(page) })
+	qrItem.SetSensitive(d.changeable())
+	m.Append(qrItem)
+	dateItem, err := gtk.MenuItemNewWithLabel("Stamp Today's Date")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	dateItem.Connect("activate", func() { d.stampDate(page) })
+	dateItem.SetSensitive(d.changeable())
+	m.Append(dateItem)
+	whiteoutItem, err := gtk.MenuItemNewWithLabel("Cover with Whiteout…")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	whiteoutItem.Connect("activate", func() { d.whiteout(page) })
+	whiteoutItem.SetSensitive(d.changeable())
+	m.Append(whiteoutItem)
+	stampItem, err := gtk.MenuItemNewWithLabel("Rubric Stamp")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	stampItem.SetSubmenu(d.newStampMenu(page))
+	m.Append(stampItem)
+	clearItem, err := gtk.MenuItemNewWithLabel("Clear Annotations…")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	clearItem.Connect("activate", func() { d.clearAnnotation(page) })
+	clearItem.SetSensitive(annotated && d.changeable())
+	m.Append(clearItem)
+	revertItem, err := gtk.MenuItemNewWithLabel("Revert to Last Saved…")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	revertItem.Connect("activate", func() { d.revertAnnotation(page) })
+	d.sessMu.Lock()
+	changed := d.sess.IsChangedSinceSave(page)
+	d.sessMu.Unlock()
+	revertItem.SetSensitive(changed && d.changeable())
+	m.Append(revertItem)
+	afterItem, err := gtk.CheckMenuItemNewWithLabel("Show Annotations")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	afterItem.SetActive(annotated && d.pageAfter[page])
+	afterItem.SetSensitive(annotated)
+	afterItem.Connect("toggled", func() { d.toggleAfter(page) })
+	m.Append(afterItem)
+
+	// The selection is opened if the page is part of it, or else the page
+	// alone
+
+	pages, label := []int{page}, "Open Page as New Document"
+	if c.IsSelected() {
+		pages, label = d.selectedPages(), "Open Selection as New Document"
+	}
+	openItem, err := gtk.MenuItemNewWithLabel(label)
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	openItem.Connect("activate", func() { d.openPages(pages) })
+	openItem.SetSensitive(!d.busy())
+	m.Append(openItem)
+	m.ShowAll()
+
+	if ev != nil {
+		m.PopupAtPointer(ev)
+	} else {
+		m.PopupAtWidget(c, gdk.GDK_GRAVITY_CENTER, gdk.GDK_GRAVITY_NORTH_WEST, nil)
+	}
+}
+
+// notifyAnnotated sends a desktop notification about the outcome of
+// annotating a page in Inkscape.
+func (d *document) notifyAnnotated(page int, changed bool, err error) {
+	var n *glib.Notification
+	switch {
+	case err != nil:
+		n = glib.NotificationNew(fmt.Sprintf("Annotating page %d failed", page+1))
+		n.SetBody(err.Error())
+	case changed:
+		n = glib.NotificationNew(fmt.Sprintf("Page %d annotated", page+1))
+		n.SetBody(baseName(d.path))
+	default:
+		n = glib.NotificationNew(fmt.Sprintf("Page %d unchanged", page+1))
+		n.SetBody("Inkscape was closed without saving any changes.")
+	}
+	app.SendNotification("annotation", n)
+}
+
+// busy returns true while the document can't be changed, i.e. when a page is
+// being annotated, an image is being pasted or the document is being saved.
+func (d *document) busy() bool {
+	return d.annotatingPage >= 0 || d.placing || d.saving
+}
+
+// changeable returns true if the document can be changed now, i.e. it's
+// neither busy nor open read-only.
+func (d *document) changeable() bool {
+	return !d.readOnly && !d.busy()
+}
+
+// annotate opens the given page in Inkscape. The rest of the UI stays usable
+// for browsing while Inkscape is open but changes to the document are
+// blocked until it's closed.
+func (d *document) annotate(page int) {
+	if !d.changeable() {
+		return
+	}
+	d.annotatingPage = page
+	d.inkscapeLbl.SetText(fmt.Sprintf(
+		"Page %d is open in Inkscape. Once done, save, close and return here.", page+1))
+	d.inkscapeBar.Show()
+	updateActions()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancelAnnotate = cancel
+	d.sess.SetEditor(currentEditor())
+
+	// An export started by hovering the page is left to finish, rather than
+	// cancelled as the pointer leaves for Inkscape, since annotating waits
+	// on it.
+
+	d.cancelHover = nil
+
+	var changed bool
+	var err error
+	editQueue.submit(func() {
+		changed, err = d.sess.Annotate(ctx, page)
+	}, func() {
+		cancel()
+		d.cancelAnnotate = nil
+		d.annotatingPage = -1
+		d.inkscapeBar.Hide()
+		updateActions()
+
+		// Changes saved in Inkscape before it was killed are kept
+
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		} else if !mainWin.IsActive() {
+			d.notifyAnnotated(page, changed, err)
+		}
+		switch {
+		case err != nil:
+			emitStatus(d.path, fmt.Sprintf("page %d failed: %s", page+1, err))
+		case changed:
+			emitStatus(d.path, fmt.Sprintf("page %d annotated", page+1))
+		default:
+			emitStatus(d.path, fmt.Sprintf("page %d unchanged", page+1))
+		}
+		if err != nil {
+			showErrMsg("Cannot annotate file", err.Error())
+			return
+		}
+		if changed {
+			d.setModified(true)
+			d.updatePage(page)
+			if d.pageAfter[page] {
+				d.reloadThumb(page)
+			}
+			updateStatus()
+		}
+		d.pageCells[page].GrabFocus()
+	})
+	d.prefetch(page+1, page-1)
+}
+
+// prefetch exports the given pages to SVG in the background while a page is
+// open in Inkscape, so annotating one of them next doesn't wait on the
+// export. Pages outside of the document are skipped, and all of them in
+// low-resource mode.
+func (d *document) prefetch(pages ...int) {
+	if lowResource {
+		return
+	}
+	for _, p := range pages {
+		if p < 0 || p >= len(d.pageCells) {
+			continue
+		}
+		p := p
+		var err error
+		workQueue.submit(func() {
+			d.sessMu.Lock()
+			sess := d.sess
+			closed := sess.IsClosed()
+			d.sessMu.Unlock()
+			if !closed {
+				err = sess.Prefetch(context.Background(), p)
+			}
+		}, func() {
+			if err != nil && !errors.Is(err, session.ErrClosed) {
+				logVerbose(session.LogSession, "cannot prefetch page %d of '%s': %s", p+1, d.path, err)
+			}
+		})
+	}
+}
+
+// hoverDwell is how long in milliseconds the pointer dwells on a page before
+// it's exported.
+const hoverDwell = 400
+
+// hoverPage exports the given page to SVG in the background once the
+// pointer dwells on it, hiding the export behind deciding what to annotate.
+func (d *document) hoverPage(page int) {
+	d.unhoverPage()
+	if !d.changeable() || lowResource {
+		return
+	}
+	d.hoverTimer = glib.TimeoutAdd(hoverDwell, func() bool {
+		d.hoverTimer = 0
+		ctx, cancel := context.WithCancel(context.Background())
+		d.cancelHover = cancel
+		var err error
+		workQueue.submit(func() {
+			d.sessMu.Lock()
+			sess := d.sess
+			closed := sess.IsClosed()
+			d.sessMu.Unlock()
+			if !closed {
+				err = sess.Prefetch(ctx, page)
+			}
+		}, func() {
+			cancel()
+			if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, session.ErrClosed) {
+				logVerbose(session.LogSession, "cannot prefetch page %d of '%s': %s", page+1, d.path, err)
+			}
+		})
+		return false
+	})
+}
+
+// unhoverPage stops exporting the page the pointer dwelled on, if not done
+// yet.
+func (d *document) unhoverPage() {
+	if d.hoverTimer != 0 {
+		glib.SourceRemove(d.hoverTimer)
+		d.hoverTimer = 0
+	}
+	if d.cancelHover != nil {
+		d.cancelHover()
+		d.cancelHover = nil
+	}
+}
+
+// confirmCancelAnnotate kills Inkscape after confirming, for when it hangs or
+// the wrong page was opened.
+func (d *document) confirmCancelAnnotate() {
+	if d.cancelAnnotate == nil {
+		return
+	}
+	if confirm("Close Inkscape?", "Any changes not yet saved in Inkscape will be lost.",
+		"Close Inkscape", "Keep Editing", true) && d.cancelAnnotate != nil {
+		d.cancelAnnotate()
+	}
+}
+
+// close closes the session of the document, asking to confirm if there are
+// unsaved changes. Returns false if the document was kept open.
+func (d *document) close() bool {
+	if d.annotatingPage >= 0 {
+		showErrMsg("Cannot close file",
+			fmt.Sprintf("Page %d of %s is still open in Inkscape. Close Inkscape first.",
+				d.annotatingPage+1, baseName(d.path)))
+		return false
+	}
+	if d.saving || d.placing {
+		return false
+	}
+
+	if d.modified && !confirm(fmt.Sprintf("Close %s without saving?", baseName(d.path)),
+		"Your changes will be lost.", "Close Anyway", "Keep Editing", true) {
+		return false
+	}
+
+	d.cancelLoad()
+	d.unhoverPage()
+	d.sessMu.Lock()
+	d.sess.Close()
+	d.sessMu.Unlock()
+	d.dropAutosave()
+	d.unlock()
+	if d.localCopy != "" {
+		_ = os.RemoveAll(d.localCopy)
+	}
+	return true
+}
+
+// setModified records whether there are unsaved changes and marks the tab
+// and title accordingly.
+func (d *document) setModified(modified bool) {
+	d.modified = modified
+	title := baseName(d.path)
+	if modified {
+		title = "• " + title
+	}
+	if d.readOnly {
+		title += " (read-only)"
+	}
+	d.tabLabel.SetText(title)
+	updateHeader()
+}
+
+// save writes the annotated PDF to the path it was last saved to, asking for
+// one if it hasn't been saved yet.
+func (d *document) save() {
+	if !d.changeable() {
+		return
+	}
+	if d.savePath == "" {
+		d.saveAs()
+		return
+	}
+	d.saveTo(d.savePath)
+}
+
+// saveAs asks for a path and writes the annotated PDF to it.
+func (d *document) saveAs() {
+	if !d.changeable() {
+		return
+	}
+	ofd, err := gtk.FileChooserNativeDialogNew(
+		"Save As",
+		mainWin,
+		gtk.FILE_CHOOSER_ACTION_SAVE,
+		"_Save",
+		"_Cancel",
+	)
+	if err != nil {
+		log.Fatalf("failed to open file chooser: %s", err)
+	}
+	defer ofd.Destroy()
+
+	filter, err := gtk.FileFilterNew()
+	if err != nil {
+		log.Fatalf("failed to create file filter: %s", err)
+	}
+	filter.SetName("PDF documents")
+	filter.AddPattern("*.pdf")
+	filter.AddPattern("*.PDF")
+	ofd.AddFilter(filter)
+
+	ofd.SetDoOverwriteConfirmation(true)
+	ofd.SetLocalOnly(false)
+	switch {
+	case d.savePath != "" && !isRemote(d.savePath):
+		ofd.SetFilename(d.savePath)
+	case d.extracted:
+		// Its path is temporary, but named after the pages it has
+		if state.SaveDir != "" {
+			ofd.SetCurrentFolder(state.SaveDir)
+		}
+		ofd.SetCurrentName(baseName(d.path))
+	case state.SaveDir != "":
+		ofd.SetCurrentFolder(state.SaveDir)
+		ofd.SetCurrentName(suggestedSaveName(d.path))
+	case !isRemote(d.path):
+		ofd.SetCurrentFolder(filepath.Dir(d.path))
+		ofd.SetCurrentName(suggestedSaveName(d.path))
+	default:
+		ofd.SetCurrentName(suggestedSaveName(d.path))
+	}
+
+	if ofd.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	path := ofd.GetFilename()
+	if path == "" {
+		path = ofd.GetURI()
+	}
+
+	if !strings.HasSuffix(strings.ToLower(path), ".pdf") {
+		path += ".pdf"
+	}
+	if !isRemote(path) {
+		state.SaveDir = filepath.Dir(path)
+		saveState()
+	}
+	d.saveTo(path)
+}
+
+// saveTo writes the annotated PDF to the given path or remote URI in the
+// background.
+func (d *document) saveTo(path string) {
+	d.savedBar.Hide()
+	mainWin.SetSensitive(false)
+	d.saving = true
+	d.savedBytes = 0
+	updateStatus()
+
+	var err, manifestErr error
+	withManifest := state.ArchivalManifest && !isRemote(path)
+	fileQueue.submit(func() {
+		if isRemote(path) {
+			err = saveRemote(path, d.sess.Save)
+		} else {
+			err = d.sess.Save(path)
+		}
+		if err == nil && withManifest {
+			manifestErr = writeManifest(d.path, d.sess.Source(), path)
+		}
+	}, func() {
+		mainWin.SetSensitive(true)
+		d.saving = false
+		updateStatus()
+		if errors.Is(err, errDAVUnauthorized) {
+			if uri, ok := askDAVLocation("Save to WebDAV Location", path, err.Error()+"."); ok {
+				d.saveTo(uri)
+			}
+			return
+		}
+		if err != nil {
+			emitStatus(d.path, "failed: "+err.Error())
+			showErrMsg("Cannot save file", err.Error())
+			return
+		}
+		emitStatus(d.path, "saved")
+		if manifestErr != nil {
+			showErrMsg("Cannot write archival manifest", manifestErr.Error())
+		}
+		d.savePath = path
+		d.setModified(false)
+		d.dropAutosave()
+		d.savedLbl.SetText("Saved to " + shrinkHome(path))
+		d.savedBar.Show()
+		updateActions()
+		if d.grading && path == gradedPath(d.path) {
+			d.gradedSaved()
+		}
+	})
+}
+
+// Responses of the saved bar buttons.
+const (
+	responseOpenFolder gtk.ResponseType = 1
+	responseOpenViewer gtk.ResponseType = 2
+	responseSendEmail  gtk.ResponseType = 3
+)
+
+// newSavedBar creates the info bar shown after a successful save, offering to
+// open the saved file or its folder, or email it.
+func (d *document) newSavedBar() *gtk.InfoBar {
+	bar, err := gtk.InfoBarNew()
+	if err != nil {
+		log.Fatalf("unable to create info bar: %s", err)
+	}
+	bar.SetMessageType(gtk.MESSAGE_INFO)
+	bar.SetShowCloseButton(true)
+	bar.AddButton("Open Containing Folder", responseOpenFolder)
+	bar.AddButton("Open in PDF Viewer", responseOpenViewer)
+	bar.AddButton("Send by Email…", responseSendEmail)
+	d.savedLbl, err = gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	d.savedLbl.SetEllipsize(pango.ELLIPSIZE_MIDDLE)
+	d.savedLbl.Show()
+	content, err := bar.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get info bar content area: %s", err)
+	}
+	content.Add(d.savedLbl)
+	bar.SetNoShowAll(true)
+	bar.Connect("response", func(_ *gtk.InfoBar, resp int) {
+		bar.Hide()
+		switch gtk.ResponseType(resp) {
+		case responseOpenFolder:
+			xdgOpen(dirName(d.savePath))
+		case responseOpenViewer:
+			xdgOpen(d.savePath)
+		case responseSendEmail:
+			xdgEmail(d.savePath)
+		}
+	})
+	return bar
+}
+
+// xdgOpen opens the given path with the user's preferred application.
+func xdgOpen(path string) {
+	launch(exec.Command("xdg-open", path), func(err error) {
+		if err != nil {
+			showErrMsg("Cannot open "+shrinkHome(path), err.Error())
+		}
+	})
+}
+
+// xdgEmail opens a new message in the user's email client with the given
+// file attached.
+func xdgEmail(path string) {
+	if isRemote(path) {
+		showErrMsg("Cannot send by email", "Only local files can be attached. Save a copy locally to send it.")
+		return
+	}
+	launch(exec.Command("xdg-email", "--subject", filepath.Base(path), "--attach", path), func(err error) {
+		if errors.Is(err, exec.ErrNotFound) {
+			showErrMsg("Cannot send by email", "xdg-email was not found. Install xdg-utils to send files by email.")
+		} else if err != nil {
+			showErrMsg("Cannot send by email", err.Error())
+		}
+	})
+}
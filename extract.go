@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// openPages opens the pages of the document at the given positions, along
+// with their annotations, as a new document in a tab of its own, e.g. to
+// hand an appendix off separately. The new document has no file until it's
+// saved.
+func (d *document) openPages(pages []int) {
+	if d.busy() || len(pages) == 0 {
+		return
+	}
+	dir, err := ioutil.TempDir("", "pdfrankenstein-extract-*")
+	if err != nil {
+		showErrMsg("Cannot open pages", err.Error())
+		return
+	}
+	path := filepath.Join(dir, extractName(d.path, pages))
+	logVerbose(logUI, "opening pages %v of '%s' as '%s'", pages, d.path, path)
+
+	mainWin.SetSensitive(false)
+	opening++
+
+	var sess *session.Session
+	fileQueue.submit(func() {
+		sess, err = d.sess.Extract(context.Background(), pages, path)
+	}, func() {
+		if opening--; opening == 0 {
+			mainWin.SetSensitive(true)
+		}
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			showErrMsg("Cannot open pages", err.Error())
+			return
+		}
+		nd := newDocument(path, sess)
+		nd.localCopy = dir
+		nd.extracted = true
+		addDoc(nd)
+		nd.setModified(true)
+		emitStatus(path, "extracted from "+d.path)
+	})
+}
+
+// extractName returns the file name of the document of the given pages
+// extracted from the one at path, e.g. "report (pages 3-5, 9).pdf".
+func extractName(path string, pages []int) string {
+	what := "page"
+	if len(pages) > 1 {
+		what = "pages"
+	}
+	name := baseName(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return fmt.Sprintf("%s (%s %s).pdf", name, what, formatPages(pages))
+}
+
+// formatPages returns the given sorted 0-based pages as a list of 1-based
+// page numbers and ranges as parsed by parseRanges, e.g. "3-5, 9".
+func formatPages(pages []int) string {
+	var ranges []string
+	for i := 0; i < len(pages); {
+		j := i + 1
+		for j < len(pages) && pages[j] == pages[j-1]+1 {
+			j++
+		}
+		ranges = append(ranges, session.PageRange{From: pages[i] + 1, To: pages[j-1] + 1}.String())
+		i = j
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// File manager integration adds "Annotate with PDFrankenstein" to the
+// context menu of PDFs in the file managers found, each in its own way.
+
+const contextMenuLabel = "Annotate with " + progName
+
+// xdgDir returns the XDG base directory in the given environment variable,
+// or fallback under the home directory if it's not set.
+func xdgDir(env, fallback string) (string, error) {
+	if dir := os.Getenv(env); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, fallback), nil
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// desktopQuote quotes s as an argument of the Exec key of desktop entries.
+func desktopQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`).Replace(s) + `"`
+}
+
+// fileManager is a file manager whose context menu can be extended.
+type fileManager struct {
+	name, exe string
+	install   func(bin string) (string, error)
+}
+
+var fileManagers = []fileManager{
+	{"Nautilus", "nautilus", installNautilus},
+	{"Dolphin", "dolphin", installDolphin},
+	{"Thunar", "thunar", installThunar},
+}
+
+// installNautilus adds a Nautilus script, which shows under Scripts in the
+// context menu of any file.
+func installNautilus(bin string) (string, error) {
+	dir, err := xdgDir("XDG_DATA_HOME", ".local/share")
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "nautilus", "scripts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, contextMenuLabel)
+	script := "#!/bin/sh\n" +
+		"# Installed by " + progName + "\n" +
+		"IFS='\n'\n" +
+		"exec " + shellQuote(bin) + " $NAUTILUS_SCRIPT_SELECTED_FILE_PATHS\n"
+	return path, ioutil.WriteFile(path, []byte(script), 0755)
+}
+
+// installDolphin adds a KDE service menu for PDFs. It's made executable as
+// KDE Frameworks 6 requires.
+func installDolphin(bin string) (string, error) {
+	dir, err := xdgDir("XDG_DATA_HOME", ".local/share")
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "kio", "servicemenus")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "pdfrankenstein.desktop")
+	entry := "[Desktop Entry]\n" +
+		"Type=Service\n" +
+		"MimeType=application/pdf;\n" +
+		"Actions=annotate;\n" +
+		"X-KDE-ServiceTypes=KonqPopupMenu/Plugin\n" +
+		"\n" +
+		"[Desktop Action annotate]\n" +
+		"Name=" + contextMenuLabel + "\n" +
+		"Icon=pdfrankenstein\n" +
+		"Exec=" + desktopQuote(bin) + " %F\n"
+	return path, ioutil.WriteFile(path, []byte(entry), 0755)
+}
+
+// thunarActionID identifies our custom action in Thunar's uca.xml.
+const thunarActionID = "pdfrankenstein-annotate"
+
+// installThunar adds a custom action for PDFs to Thunar's uca.xml, keeping
+// the user's own actions. An earlier installed one is left as is.
+func installThunar(bin string) (string, error) {
+	dir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "Thunar")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "uca.xml")
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		b, err = []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<actions>\n</actions>\n"), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	uca := string(b)
+	if strings.Contains(uca, "<unique-id>"+thunarActionID+"</unique-id>") {
+		return path, nil
+	}
+	end := strings.LastIndex(uca, "</actions>")
+	if end < 0 {
+		return "", fmt.Errorf("'%s' has no actions element", path)
+	}
+	action := "<action>\n" +
+		"\t<icon>pdfrankenstein</icon>\n" +
+		"\t<name>" + xmlEscape(contextMenuLabel) + "</name>\n" +
+		"\t<unique-id>" + thunarActionID + "</unique-id>\n" +
+		"\t<command>" + xmlEscape(shellQuote(bin)) + " %F</command>\n" +
+		"\t<description>Open the selected PDFs in " + progName + "</description>\n" +
+		"\t<patterns>*.pdf;*.PDF</patterns>\n" +
+		"\t<other-files/>\n" +
+		"</action>\n"
+	uca = uca[:end] + action + uca[end:]
+	if err := ioutil.WriteFile(path+".tmp", []byte(uca), 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(path+".tmp", path)
+}
+
+// installContextMenus adds the context menu entry to the file managers
+// found and returns a line about each.
+func installContextMenus() ([]string, error) {
+	bin, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot locate the %s executable: %s", progName, err)
+	}
+	var report []string
+	for _, fm := range fileManagers {
+		if _, err := exec.LookPath(fm.exe); err != nil {
+			continue
+		}
+		path, err := fm.install(bin)
+		if err != nil {
+			report = append(report, fmt.Sprintf("%s: failed: %s", fm.name, err))
+		} else {
+			report = append(report, fmt.Sprintf("%s: added to %s", fm.name, shrinkHome(path)))
+		}
+	}
+	if len(report) == 0 {
+		return nil, errors.New("none of the supported file managers (Nautilus, Dolphin and Thunar) were found")
+	}
+	return report, nil
+}
+
+// addToContextMenus installs the context menu entries and reports the
+// outcome.
+func addToContextMenus() {
+	report, err := installContextMenus()
+	if err != nil {
+		showErrMsg("Cannot add to context menus", err.Error())
+		return
+	}
+	d := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, gtk.MESSAGE_INFO, gtk.BUTTONS_CLOSE,
+		"%s", "\""+contextMenuLabel+"\" added to context menus")
+	defer d.Destroy()
+	d.FormatSecondaryText("%s", strings.Join(report, "\n")+
+		"\n\nFile managers which are running may need to be restarted to show it.")
+	_ = d.Run()
+}
@@ -0,0 +1,38 @@
+package main
+
+// #cgo pkg-config: gio-2.0
+// #include <stdlib.h>
+// #include <gio/gio.h>
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// openWithHint registers the application and opens the given files, as
+// paths or URIs relative to the working directory, with the given hint to
+// the "open" signal. Files are opened by the running instance if there's
+// one. gotk3 has no way of passing a hint, which GApplication leaves empty
+// when handling the command line itself.
+func openWithHint(files []string, hint string) error {
+	gapp := (*C.GApplication)(unsafe.Pointer(app.Native()))
+	var gerr *C.GError
+	if C.g_application_register(gapp, nil, &gerr) == 0 {
+		defer C.g_error_free(gerr)
+		return errors.New(C.GoString(gerr.message))
+	}
+	gfiles := make([]*C.GFile, len(files))
+	for i, f := range files {
+		cf := C.CString(f)
+		gfiles[i] = C.g_file_new_for_commandline_arg(cf)
+		C.free(unsafe.Pointer(cf))
+	}
+	ch := C.CString(hint)
+	defer C.free(unsafe.Pointer(ch))
+	C.g_application_open(gapp, &gfiles[0], C.gint(len(gfiles)), ch)
+	for _, f := range gfiles {
+		C.g_object_unref(C.gpointer(f))
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// A folder of submissions can be graded one after another. Each is opened in
+// turn and, once annotated, saved to the graded folder beside them under a
+// suffixed name, closed and followed by the next, with no dialogs in between.
+const (
+	gradedDirName = "graded"
+	gradedSuffix  = "-graded"
+)
+
+// Responses of the grading bar buttons.
+const (
+	responseGradeNext gtk.ResponseType = 1
+	responseGradeSkip gtk.ResponseType = 2
+	responseGradeStop gtk.ResponseType = 3
+)
+
+// gradingBatch is a folder of submissions being graded.
+type gradingBatch struct {
+	dir string
+	// current is the submission being graded and files the ones left after
+	// it, in order.
+	current string
+	files   []string
+	// total is the number of submissions in the folder and graded the number
+	// saved to the graded folder, including before grading started.
+	total, graded int
+}
+
+// grading is the batch being graded, or nil.
+var grading *gradingBatch
+
+// gradedPath returns where the graded version of the submission at path is
+// saved, e.g. "graded/essay-graded.pdf" beside "essay.pdf".
+func gradedPath(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return filepath.Join(filepath.Dir(path), gradedDirName, name+gradedSuffix+".pdf")
+}
+
+// submissions returns the PDF files in dir, sorted by name.
+func submissions(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".pdf") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// startGrading asks for a folder of submissions and opens the first one
+// which hasn't been graded yet, so grading a folder can be picked up where
+// it was left.
+func startGrading() {
+	if grading != nil {
+		if d := findDoc(grading.current); d != nil && d.grading {
+			notebook.SetCurrentPage(notebook.PageNum(d.root))
+			showErrMsg("Already grading", fmt.Sprintf("Finish grading %s first, or stop grading it.",
+				shrinkHome(grading.dir)))
+			return
+		}
+	}
+
+	ofd, err := gtk.FileChooserNativeDialogNew(
+		"Grade Folder of Submissions",
+		mainWin,
+		gtk.FILE_CHOOSER_ACTION_SELECT_FOLDER,
+		"_Grade",
+		"_Cancel",
+	)
+	if err != nil {
+		log.Fatalf("failed to open file chooser: %s", err)
+	}
+	defer ofd.Destroy()
+	if state.OpenDir != "" {
+		ofd.SetCurrentFolder(state.OpenDir)
+	}
+	if ofd.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	dir := ofd.GetFilename()
+	if dir == "" {
+		showErrMsg("Cannot grade folder", noLocalPathMsg(ofd.GetURI()))
+		return
+	}
+
+	files, err := submissions(dir)
+	if err != nil {
+		showErrMsg("Cannot grade folder", err.Error())
+		return
+	}
+	var left []string
+	for _, f := range files {
+		if _, err := os.Stat(gradedPath(f)); err != nil {
+			left = append(left, f)
+		}
+	}
+	switch {
+	case len(files) == 0:
+		showErrMsg("Nothing to grade", fmt.Sprintf("There are no PDF files in %s.", shrinkHome(dir)))
+		return
+	case len(left) == 0:
+		showErrMsg("Nothing to grade", fmt.Sprintf("All %d PDF files in %s are graded already.",
+			len(files), shrinkHome(dir)))
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(dir, gradedDirName), 0755); err != nil {
+		showErrMsg("Cannot grade folder", err.Error())
+		return
+	}
+	grading = &gradingBatch{dir: dir, files: left, total: len(files), graded: len(files) - len(left)}
+	gradeNext()
+}
+
+// gradeNext opens the next submission, or reports the batch as done if
+// there are none left.
+func gradeNext() {
+	if len(grading.files) == 0 {
+		finishGrading()
+		return
+	}
+	grading.current, grading.files = grading.files[0], grading.files[1:]
+	open(grading.current)
+}
+
+// finishGrading ends the batch and offers to open the graded folder.
+func finishGrading() {
+	b := grading
+	grading = nil
+	updateActions()
+	out := filepath.Join(b.dir, gradedDirName)
+	msg := fmt.Sprintf("%d of %d submissions are graded in %s.", b.graded, b.total, shrinkHome(out))
+	if _, err := os.Stat(filepath.Join(out, scoresFileName)); err == nil {
+		msg += " Their scores are in " + scoresFileName + "."
+	}
+	if confirm("Grading finished", msg, "Open Folder", "Close", false) {
+		xdgOpen(out)
+	}
+}
+
+// gradeAdopt marks d as being graded if it's the submission the batch
+// opened, showing the grading bar.
+func gradeAdopt(d *document) {
+	if grading == nil || d.path != grading.current || d.grading {
+		return
+	}
+	d.grading = true
+	if d.gradeBar == nil {
+		d.gradeBar = d.newGradeBar()
+		d.root.PackStart(d.gradeBar, false, false, 0)
+		d.root.ReorderChild(d.gradeBar, 0)
+	}
+	n := grading.total - len(grading.files)
+	d.gradeLbl.SetText(fmt.Sprintf("Submission %d of %d. Once annotated, it's saved as %s/%s.",
+		n, grading.total, gradedDirName, filepath.Base(gradedPath(d.path))))
+	d.gradeBar.Show()
+	updateActions()
+}
+
+// newGradeBar creates the info bar shown on the submission being graded,
+// offering to save it and go on to the next one.
+func (d *document) newGradeBar() *gtk.InfoBar {
+	bar, err := gtk.InfoBarNew()
+	if err != nil {
+		log.Fatalf("unable to create info bar: %s", err)
+	}
+	bar.SetMessageType(gtk.MESSAGE_INFO)
+	bar.AddButton("Stop Grading", responseGradeStop)
+	bar.AddButton("Skip", responseGradeSkip)
+	bar.AddButton("Save and Next", responseGradeNext)
+	d.gradeLbl, err = gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	d.gradeLbl.SetLineWrap(true)
+	d.gradeLbl.Show()
+	content, err := bar.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get info bar content area: %s", err)
+	}
+	content.Add(d.gradeLbl)
+	bar.SetNoShowAll(true)
+	bar.Connect("response", func(_ *gtk.InfoBar, resp int) {
+		switch gtk.ResponseType(resp) {
+		case responseGradeNext:
+			d.saveGraded()
+		case responseGradeSkip:
+			d.skipGrading()
+		case responseGradeStop:
+			d.stopGrading()
+		}
+	})
+	return bar
+}
+
+// saveGraded saves the submission to the graded folder, with the score
+// stamped on its first page if the rubric asks for it. Once saved, it's
+// closed and the next one opened.
+func (d *document) saveGraded() {
+	if !d.grading || !d.changeable() {
+		if d.readOnly {
+			showErrMsg("Cannot save graded submission", baseName(d.path)+" is open read-only. Skip it instead.")
+		}
+		return
+	}
+	save := func() { d.saveTo(gradedPath(d.path)) }
+	if _, n, err := d.score(); state.StampScore && err == nil && n > 0 {
+		d.stampScore(save)
+		return
+	}
+	save()
+}
+
+// gradedSaved records the score of the submission once saved, closes it and
+// goes on to the next.
+func (d *document) gradedSaved() {
+	if score, n, err := d.score(); err != nil {
+		showErrMsg("Cannot record score", err.Error())
+	} else if n > 0 {
+		if err := recordScore(d.path, score, n); err != nil {
+			showErrMsg("Cannot record score", err.Error())
+		}
+	}
+	grading.graded++
+	d.grading = false
+	if !closeDoc(d) {
+		d.grading = true
+		return
+	}
+	gradeNext()
+}
+
+// skipGrading closes the submission without saving it and goes on to the
+// next.
+func (d *document) skipGrading() {
+	if !d.grading || d.busy() {
+		return
+	}
+	d.grading = false
+	if !closeDoc(d) {
+		d.grading = true
+		return
+	}
+	gradeNext()
+}
+
+// stopGrading ends the batch, leaving the submission open.
+func (d *document) stopGrading() {
+	d.grading = false
+	d.gradeBar.Hide()
+	grading = nil
+	updateActions()
+}
+
+// gradingClosed ends the batch if d is the submission being graded, since
+// closing it otherwise than through the grading bar means leaving off.
+func gradingClosed(d *document) {
+	if d.grading {
+		d.grading = false
+		grading = nil
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os/exec"
+	"sync"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+// Blocking work, most notably running external programs, is never done on
+// the GTK thread nor on ad hoc goroutines. Instead it's submitted to one of
+// the job queues below whose workers never touch GTK, or for applications
+// which run until the user closes them, launched. Outcomes are handed back
+// to the GTK main loop via glib.IdleAdd.
+var (
+	// workQueue runs background work such as rendering.
+	workQueue *jobQueue
+	// fileQueue runs opening and saving documents, so they don't wait
+	// behind whole documents of thumbnails being rendered.
+	fileQueue *jobQueue
+	// editQueue runs Inkscape for annotating, which blocks until the user is
+	// done. Annotations beyond its number of workers wait their turn.
+	editQueue *jobQueue
+)
+
+// startQueues starts the job queues, with workQueue running the given
+// number of jobs at a time.
+func startQueues(workers int) {
+	workQueue = newJobQueue(workers)
+	fileQueue = newJobQueue(2)
+	editQueue = newJobQueue(4)
+}
+
+// launch starts an application such as a viewer and calls done on the GTK
+// main loop with its error once it exits. Some run in the foreground until
+// the user closes them, so they're waited for on a goroutine of their own
+// rather than holding up a worker.
+func launch(cmd *exec.Cmd, done func(err error)) {
+	if err := cmd.Start(); err != nil {
+		done(err)
+		return
+	}
+	go func() {
+		defer crashGuard(false)
+		err := cmd.Wait()
+		glib.IdleAdd(func() { done(err) })
+	}()
+}
+
+// jobQueue runs jobs in order of submission on a fixed pool of workers.
+type jobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []func()
+}
+
+func newJobQueue(workers int) *jobQueue {
+	q := &jobQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *jobQueue) work() {
+	defer crashGuard(false)
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		job()
+	}
+}
+
+// submit queues run to be called on a worker and done to be called on the
+// GTK main loop once run returns. run must not touch GTK. Results are passed
+// from run to done through variables captured by both.
+func (q *jobQueue) submit(run func(), done func()) {
+	q.mu.Lock()
+	q.pending = append(q.pending, func() {
+		run()
+		glib.IdleAdd(done)
+	})
+	q.cond.Signal()
+	q.mu.Unlock()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Open PDFs are marked with a lock file beside them so that a second
+// instance, possibly on another machine sharing the file, can warn before
+// two people annotate the same document and one's work is lost on save.
+// Locks are advisory: they're only honored by PDFrankenstein.
+
+// lockInfo identifies who holds a lock. It's the content of the lock file.
+type lockInfo struct {
+	User  string    `json:"user"`
+	Host  string    `json:"host"`
+	PID   int       `json:"pid"`
+	Since time.Time `json:"since"`
+}
+
+// lockPath returns the path of the lock file of the PDF at path.
+func lockPath(path string) string {
+	return filepath.Join(filepath.Dir(path), ".~lock."+filepath.Base(path)+".pdfrankenstein")
+}
+
+// ownLock returns the lock info of this process.
+func ownLock() lockInfo {
+	l := lockInfo{PID: os.Getpid(), Since: time.Now()}
+	if u, err := user.Current(); err == nil {
+		l.User = u.Username
+	}
+	l.Host, _ = os.Hostname()
+	return l
+}
+
+// stale returns true if the lock was left behind by a process which is gone.
+// Only locks of the same host can be checked, and not from within a sandbox,
+// which has processes of its own.
+func (l lockInfo) stale() bool {
+	host, _ := os.Hostname()
+	if l.Host != host || session.Sandboxed() {
+		return false
+	}
+	return syscall.Kill(l.PID, 0) == syscall.ESRCH
+}
+
+// lockDoc locks the PDF at path. If someone else holds the lock, it returns
+// who, and release does nothing. Failing to create the lock file, e.g. in a
+// read-only directory, only logs since the document can still be edited.
+func lockDoc(path string) (release func(), holder *lockInfo) {
+	release = func() {}
+	lp := lockPath(path)
+	own := ownLock()
+	b, err := json.Marshal(own)
+	if err != nil {
+		log.Printf("cannot encode lock: %s", err)
+		return release, nil
+	}
+
+	// A stale lock is taken over once
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(lp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(b)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				log.Printf("cannot write lock file '%s': %s", lp, err)
+				_ = os.Remove(lp)
+				return release, nil
+			}
+			return func() {
+				if err := os.Remove(lp); err != nil && !os.IsNotExist(err) {
+					log.Printf("cannot remove lock file '%s': %s", lp, err)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			log.Printf("cannot create lock file '%s': %s", lp, err)
+			return release, nil
+		}
+		var l lockInfo
+		if b, err := ioutil.ReadFile(lp); err != nil || json.Unmarshal(b, &l) != nil {
+			log.Printf("ignoring unreadable lock file '%s'", lp)
+			return release, nil
+		}
+		if !l.stale() {
+			return release, &l
+		}
+		log.Printf("removing stale lock file '%s'", lp)
+		_ = os.Remove(lp)
+	}
+	return release, nil
+}
+
+// Responses of askLocked.
+const (
+	lockedReadOnly gtk.ResponseType = 1
+	lockedAnyway   gtk.ResponseType = 2
+)
+
+// askLocked asks what to do about the PDF at path being locked by holder.
+// It returns lockedReadOnly, lockedAnyway or gtk.RESPONSE_CANCEL.
+func askLocked(path string, holder *lockInfo) gtk.ResponseType {
+	who := holder.User
+	if holder.Host != "" {
+		who += " on " + holder.Host
+	}
+	dlg := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, gtk.MESSAGE_WARNING, gtk.BUTTONS_NONE,
+		"%s", filepath.Base(path)+" is already open")
+	defer dlg.Destroy()
+	dlg.FormatSecondaryText("%s", fmt.Sprintf("It was opened by %s on %s. If you both annotate and save it, "+
+		"one of you will lose their changes.", who, holder.Since.Local().Format("Jan 2 15:04")))
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Open Anyway", lockedAnyway); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Open Read-Only", lockedReadOnly); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(lockedReadOnly)
+	switch r := dlg.Run(); r {
+	case lockedReadOnly, lockedAnyway:
+		return r
+	default:
+		return gtk.RESPONSE_CANCEL
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotk3/gotk3/glib"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// With --verbose, what's being done is logged to a file in the state
+// directory besides stderr, one entry per line of space separated key=value
+// fields: the time, the kind of what's logged and the message. Everything
+// else logged ends up there as well, of kind "app". The file is rotated once
+// it grows past logMaxSize, keeping logKeep older ones. The last
+// recentLogSize entries are kept in memory for crash reports regardless.
+
+const (
+	logMaxSize    = 1 << 20
+	logKeep       = 3
+	recentLogSize = 200
+)
+
+// Log kinds besides those of sessions.
+const (
+	logApp = "app"
+	logUI  = "ui"
+)
+
+// logFile is the log file being written, or nil if not logging to one.
+var logFile *rotatingLog
+
+// stderrLog logs to stderr only, for entries written to the log file
+// separately.
+var stderrLog = log.New(os.Stderr, "", 0)
+
+// recentLog is the last entries logged, oldest first.
+var recentLog struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+// logVerbose logs what's being done, of the given kind, if --verbose is
+// given.
+func logVerbose(kind, format string, v ...any) {
+	if !verbose {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	stderrLog.Print(log.Prefix() + msg)
+	logEntry(kind, msg)
+}
+
+// logEntry writes an entry of the given kind to the log file, if any, and
+// keeps it among the recent ones.
+func logEntry(kind, msg string) {
+	line := fmt.Sprintf("time=%s kind=%s msg=%s\n",
+		time.Now().Format("2006-01-02T15:04:05.000Z07:00"), kind, strconv.Quote(msg))
+	recentLog.mu.Lock()
+	recentLog.entries = append(recentLog.entries, line)
+	if n := len(recentLog.entries) - recentLogSize; n > 0 {
+		recentLog.entries = append(recentLog.entries[:0], recentLog.entries[n:]...)
+	}
+	recentLog.mu.Unlock()
+	logFile.write(line)
+}
+
+// recentLogEntries returns the last entries logged, oldest first.
+func recentLogEntries() string {
+	recentLog.mu.Lock()
+	defer recentLog.mu.Unlock()
+	return strings.Join(recentLog.entries, "")
+}
+
+// logPath returns the path of the log file.
+func logPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pdfrankenstein.log"), nil
+}
+
+// startLogFile starts logging to the log file what's logged with
+// logVerbose, by the log package and by sessions.
+func startLogFile() {
+	path, err := logPath()
+	if err != nil {
+		log.Printf("cannot locate log file: %s", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("cannot create log directory: %s", err)
+		return
+	}
+	l := &rotatingLog{path: path}
+	if err := l.open(); err != nil {
+		log.Printf("cannot open log file: %s", err)
+		return
+	}
+	logFile = l
+	session.SetLogger(func(kind, msg string) { logVerbose(kind, "%s", msg) })
+	logVerbose(logApp, "%s %s started with pid %d", progName, version, os.Getpid())
+}
+
+// logTee writes what's logged by the log package to stderr and as entries
+// to the log file.
+type logTee struct{}
+
+func (logTee) Write(p []byte) (int, error) {
+	logEntry(logApp, strings.TrimPrefix(strings.TrimSuffix(string(p), "\n"), log.Prefix()))
+	return os.Stderr.Write(p)
+}
+
+// rotatingLog is a log file renamed to path.1 and so on once it grows past
+// logMaxSize.
+type rotatingLog struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames the log files to the next number, dropping the oldest, and
+// opens a new one.
+func (l *rotatingLog) rotate() error {
+	l.f.Close()
+	for i := logKeep - 1; i >= 0; i-- {
+		from := l.path
+		if i > 0 {
+			from += "." + strconv.Itoa(i)
+		}
+		if err := os.Rename(from, l.path+"."+strconv.Itoa(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// write writes a line to the log. It does nothing on a nil log.
+func (l *rotatingLog) write(line string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.size+int64(len(line)) > logMaxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			l.f = nil
+			fmt.Fprintf(os.Stderr, "%scannot rotate log file: %s\n", log.Prefix(), err)
+			return
+		}
+	}
+	n, _ := io.WriteString(l.f, line)
+	l.size += int64(n)
+}
+
+// addAction adds an action to the app, logging its activations with
+// --verbose.
+func addAction(a *glib.SimpleAction) {
+	name := a.GetName()
+	a.Connect("activate", func() { logVerbose(logUI, "activated %s", name) })
+	app.AddAction(a)
+}
+
+// openLogFile opens the log file with the user's preferred application.
+func openLogFile() {
+	path, err := logPath()
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		showErrMsg("No log file", "Nothing has been logged yet. Start "+progName+
+			" with --verbose to log what it does.")
+		return
+	}
+	if err != nil {
+		showErrMsg("Cannot open log file", err.Error())
+		return
+	}
+	xdgOpen(path)
+}
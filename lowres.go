@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Low-resource mode keeps the app usable on old laptops and single board
+// computers by rendering at lower resolutions, not exporting pages to SVG
+// ahead of annotating them and running one external program at a time.
+// Settings given explicitly still win. It's on by default where there's
+// little memory or few CPUs, and can be forced either way with the
+// session.low-resource setting, or on with --low-resource.
+
+const (
+	// lowResourceMemory and lowResourceCPUs are the total memory in bytes
+	// and the number of CPUs at or below which low-resource mode is on by
+	// default.
+	lowResourceMemory = 2 << 30
+	lowResourceCPUs   = 2
+	// lowResourceDPI is the resolution pages are rasterized at for printing
+	// and exporting images, unless set in the config.
+	lowResourceDPI = 100
+	// Sizes of thumbnails and previews, unless set in the config.
+	lowResourceThumbnailSize = 128
+	lowResourcePreviewSize   = 480
+)
+
+// lowResource is whether low-resource mode is on.
+var lowResource bool
+
+// detectLowResource returns whether the machine has little enough memory or
+// few enough CPUs for low-resource mode.
+func detectLowResource() bool {
+	if runtime.NumCPU() <= lowResourceCPUs {
+		return true
+	}
+	mem := totalMemory()
+	return mem > 0 && mem <= lowResourceMemory
+}
+
+// totalMemory returns the total memory in bytes as told by /proc/meminfo, or
+// 0 if unknown.
+func totalMemory() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) == 3 && f[0] == "MemTotal:" && f[2] == "kB" {
+			kb, _ := strconv.ParseInt(f[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// startLowResource turns low-resource mode on or off as configured, and
+// applies the sizes and number of workers it implies.
+func startLowResource() {
+	switch config.lowResource {
+	case "on":
+		lowResource = true
+	case "off":
+		lowResource = false
+	default:
+		lowResource = detectLowResource()
+	}
+	if !lowResource {
+		return
+	}
+	if config.thumbnailSize == 0 {
+		session.ThumbnailSize = lowResourceThumbnailSize
+	}
+	session.PreviewSize = lowResourcePreviewSize
+	if config.workers == 0 {
+		config.workers = 1
+	}
+}
+
+// defaultDPI returns the resolution pages are rasterized at, def unless set
+// in the config or in low-resource mode.
+func defaultDPI(def int) int {
+	switch {
+	case config.dpi > 0:
+		return config.dpi
+	case lowResource:
+		return lowResourceDPI
+	}
+	return def
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"context"
-	_ "embed"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
+	"unsafe"
 
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
@@ -21,21 +21,14 @@ import (
 
 const (
 	progName = "PDFrankenstein"
+	appID    = "com.github.oxplot.pdfrankenstein"
 )
 
+// Set at build time
 var (
-	cleanCSS *gtk.CssProvider
-	dirtyCSS *gtk.CssProvider
-	//go:embed splash.svg
-	splash []byte
-	//go:embed icon.svg
-	appIcon []byte
-	//go:embed loading.svg
-	loadingImgBytes []byte
-	loadingPix      *gdk.Pixbuf
-	//go:embed nothumb.svg
-	noThumbImgBytes []byte
-	noThumbPix      *gdk.Pixbuf
+	version = "dev"
+	commit  = ""
+	date    = ""
 )
 
 func shrinkHome(path string) string {
@@ -50,25 +43,89 @@ func shrinkHome(path string) string {
 	return path
 }
 
-var (
-	annotSinceLastSave bool
-
-	sessMu     sync.Mutex
-	sess       *session.Session
-	cancelLoad func()
+// uriPath returns the local path of a file:// URI as sent by file managers
+// on drag and drop. URIs with a hostname are only accepted if the hostname
+// refers to this machine.
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI scheme '%s'", u.Scheme)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		if h, err := os.Hostname(); err != nil || u.Host != h {
+			return "", fmt.Errorf("'%s' is not a local file", uri)
+		}
+	}
+	if u.Path == "" {
+		return "", fmt.Errorf("'%s' has no path", uri)
+	}
+	return u.Path, nil
+}
 
+var (
+	app       *gtk.Application
 	mainWin   *gtk.Window
 	mainStack *gtk.Stack
+	notebook  *gtk.Notebook
 	openBut   *gtk.Button
 	saveBut   *gtk.Button
+	saveAsBut *gtk.Button
+	printBut  *gtk.Button
 	closeBut  *gtk.Button
+	undoBut   *gtk.Button
 	hdrBar    *gtk.HeaderBar
-	pageFlow  *gtk.FlowBox
-
-	pageImages []*gtk.Image
-	pageLabels []*gtk.Label
-
-	openFilePath string
+	statusLbl *gtk.Label
+	workLbl   *gtk.Label
+
+	recentHdr   *gtk.Label
+	recentList  *gtk.ListBox
+	recentPaths []string
+
+	// Open documents, one per notebook tab
+	docs []*document
+	// Number of files being opened
+	opening int
+
+	undoAction      *glib.SimpleAction
+	saveAction      *glib.SimpleAction
+	saveAsAction    *glib.SimpleAction
+	printAction     *glib.SimpleAction
+	emailAction     *glib.SimpleAction
+	captureAction   *glib.SimpleAction
+	dateAction      *glib.SimpleAction
+	whiteoutAction  *glib.SimpleAction
+	saveDAVAction   *glib.SimpleAction
+	migrateAction   *glib.SimpleAction
+	extractAction   *glib.SimpleAction
+	watermarkAction *glib.SimpleAction
+	numbersAction   *glib.SimpleAction
+	gradeAction     *glib.SimpleAction
+	gradeNextAction *glib.SimpleAction
+	rubricAction    *glib.SimpleAction
+	scoreAction     *glib.SimpleAction
+	signAction      *glib.SimpleAction
+	infoAction      *glib.SimpleAction
+	closeAction     *glib.SimpleAction
+	filterAction    *glib.SimpleAction
+
+	// Whether only annotated pages are shown
+	annotatedOnly bool
+	filterBut     *gtk.ToggleButton
+
+	// Whether pages are shown as a continuous strip rather than a grid
+	stripView   bool
+	stripAction *glib.SimpleAction
+	stripBut    *gtk.ToggleButton
+
+	notesAction *glib.SimpleAction
+	notesBut    *gtk.ToggleButton
+
+	// Drag and drop target for reordering pages
+	pageTarget *gtk.TargetEntry
+	pageAtom   gdk.Atom
 )
 
 func showErrMsg(title string, msg string) {
@@ -104,81 +161,159 @@ func showErrMsg(title string, msg string) {
 	}
 	con.Add(l)
 
+	d.SetDefaultResponse(gtk.RESPONSE_OK)
 	d.ShowAll()
 	_ = d.Run()
 	d.Close()
 }
 
-func loadThumbs(ctx context.Context, loadThumb func(p int) (string, error)) {
-	cnt := len(pageImages)
-	for i := 0; i < cnt; i++ {
-		select {
-		case <-ctx.Done():
-			return
-		default:
+// confirm asks the user to confirm an action and returns true if they did.
+// Enter confirms and Escape cancels. Destructive actions are styled as such.
+func confirm(title, msg, okLabel, cancelLabel string, destructive bool) bool {
+	d := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, gtk.MESSAGE_QUESTION, gtk.BUTTONS_NONE, "%s", title)
+	defer d.Destroy()
+	if msg != "" {
+		d.FormatSecondaryText("%s", msg)
+	}
+	if _, err := d.AddButton(cancelLabel, gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	ok, err := d.AddButton(okLabel, gtk.RESPONSE_OK)
+	if err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if destructive {
+		if ctx, err := ok.GetStyleContext(); err == nil {
+			ctx.AddClass("destructive-action")
 		}
-		path, err := loadThumb(i)
-		if err != nil {
-			log.Printf("failed to load thumbnail: %s", err)
-			func(img *gtk.Image, path string) {
-				glib.IdleAdd(func() {
-					img.SetFromPixbuf(noThumbPix)
-				})
-			}(pageImages[i], path)
-		} else {
-			func(img *gtk.Image, path string) {
-				glib.IdleAdd(func() {
-					img.SetFromFile(path)
-				})
-			}(pageImages[i], path)
+	}
+	d.SetDefaultResponse(gtk.RESPONSE_OK)
+	return d.Run() == gtk.RESPONSE_OK
+}
+
+// formatSize formats a byte count in human readable form.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// updateStatus refreshes the status bar with the stats of the current
+// document and the state of its background work.
+func updateStatus() {
+	d := curDoc()
+	if d == nil {
+		statusLbl.SetText("")
+		workLbl.SetText("")
+		return
+	}
+	status, work := d.status()
+	statusLbl.SetText(status)
+	workLbl.SetText(work)
+}
+
+// curDoc returns the document in the current tab or nil if none is open.
+func curDoc() *document {
+	cur := notebook.GetCurrentPage()
+	for _, d := range docs {
+		if notebook.PageNum(d.root) == cur {
+			return d
 		}
 	}
+	return nil
 }
 
-func addCSS(w gtk.IWidget, css *gtk.CssProvider) {
-	ctx, err := w.ToWidget().GetStyleContext()
-	if err != nil {
-		log.Fatalf("unable to get style context: %s", err)
+// updateActions enables the actions which apply to the current document
+// based on its state.
+func updateActions() {
+	d := curDoc()
+	editable := d != nil && !d.busy()
+	saveAction.SetEnabled(editable && !d.readOnly)
+	saveAsAction.SetEnabled(editable && !d.readOnly)
+	saveDAVAction.SetEnabled(editable && !d.readOnly)
+	printAction.SetEnabled(editable)
+	emailAction.SetEnabled(editable && d.savePath != "" && !isRemote(d.savePath))
+	captureAction.SetEnabled(editable && !d.readOnly)
+	dateAction.SetEnabled(editable && !d.readOnly)
+	whiteoutAction.SetEnabled(editable && !d.readOnly)
+	signAction.SetEnabled(editable && !d.readOnly)
+	migrateAction.SetEnabled(editable)
+	extractAction.SetEnabled(editable && len(d.selectedPages()) > 0)
+	watermarkAction.SetEnabled(editable && !d.readOnly)
+	numbersAction.SetEnabled(editable && !d.readOnly)
+	gradeNextAction.SetEnabled(editable && d.grading && !d.readOnly)
+	scoreAction.SetEnabled(editable && !d.readOnly)
+	infoAction.SetEnabled(d != nil)
+	undoAction.SetEnabled(editable && len(d.undoStack) > 0)
+	closeAction.SetEnabled(editable)
+}
+
+// updateHeader refreshes the header bar to reflect the current document.
+func updateHeader() {
+	d := curDoc()
+	for _, b := range []*gtk.Button{saveBut, saveAsBut, printBut, undoBut, &filterBut.Button, &stripBut.Button, &notesBut.Button, closeBut} {
+		b.SetVisible(d != nil)
 	}
-	ctx.AddProvider(css, gtk.STYLE_PROVIDER_PRIORITY_APPLICATION)
+	if d == nil {
+		hdrBar.SetTitle("")
+		hdrBar.SetSubtitle("")
+		return
+	}
+	title := baseName(d.path)
+	if d.modified {
+		title = "• " + title
+	}
+	hdrBar.SetTitle(title)
+	hdrBar.SetSubtitle(dirName(shrinkHome(d.path)))
+	if d.savePath != "" {
+		saveBut.SetTooltipText("Save to " + shrinkHome(d.savePath))
+	} else {
+		saveBut.SetTooltipText("")
+	}
+}
+
+// updateUI refreshes everything which depends on the current document.
+func updateUI() {
+	updateHeader()
+	updateActions()
+	updateStatus()
 }
 
-func removeCSS(w gtk.IWidget, css *gtk.CssProvider) {
+func addCSS(w gtk.IWidget, css *gtk.CssProvider) {
 	ctx, err := w.ToWidget().GetStyleContext()
 	if err != nil {
 		log.Fatalf("unable to get style context: %s", err)
 	}
-	ctx.RemoveProvider(css)
+	ctx.AddProvider(css, gtk.STYLE_PROVIDER_PRIORITY_APPLICATION)
 }
 
-func clearAnnotation(page int) {
-	d, err := gtk.DialogNewWithButtons("Clear page annotations?", mainWin, gtk.DIALOG_MODAL,
-		[]any{"Clear", gtk.RESPONSE_OK},
-		[]any{"Keep", gtk.RESPONSE_CANCEL})
-	if err != nil {
-		log.Fatalf("unable to create confirmation dialog: %s", err)
-	}
-	if d.Run() == gtk.RESPONSE_OK {
-		sessMu.Lock()
-		sess.Clear(page)
-		sessMu.Unlock()
-		pageLabels[page].SetText(strconv.Itoa(page + 1))
-		removeCSS(pageLabels[page], dirtyCSS)
+// moveItem moves the item at index from to index to, shifting the items in
+// between.
+func moveItem[T any](items []T, from, to int) {
+	item := items[from]
+	if from < to {
+		copy(items[from:to], items[from+1:to+1])
+	} else {
+		copy(items[to+1:from+1], items[to:from])
 	}
-	d.Close()
-	d.Destroy()
+	items[to] = item
 }
 
 func open(path string) {
-	var err error
-
 	if path == "" {
-		ofd, err := gtk.FileChooserDialogNewWith1Button(
+		ofd, err := gtk.FileChooserNativeDialogNew(
 			"Open PDF File",
 			mainWin,
 			gtk.FILE_CHOOSER_ACTION_OPEN,
-			"Open",
-			gtk.RESPONSE_OK,
+			"_Open",
+			"_Cancel",
 		)
 		if err != nil {
 			log.Fatalf("failed to open file chooser: %s", err)
@@ -190,267 +325,497 @@ func open(path string) {
 		}
 		filter.AddMimeType("application/pdf")
 		filter.SetName("PDF Document")
-		ofd.SetLocalOnly(true)
 		ofd.AddFilter(filter)
-		if ofd.Run() != gtk.RESPONSE_OK {
+		ofd.SetLocalOnly(false)
+		if state.OpenDir != "" {
+			ofd.SetCurrentFolder(state.OpenDir)
+		}
+		if ofd.Run() != int(gtk.RESPONSE_ACCEPT) {
 			return
 		}
-		path = ofd.GetFilename()
-		ofd.Close()
+		if path = ofd.GetFilename(); path == "" {
+			path = ofd.GetURI()
+		} else {
+			state.OpenDir = filepath.Dir(path)
+			saveState()
+		}
 	}
 
-	mainWin.SetSensitive(false)
-	defer mainWin.SetSensitive(true)
+	// Files which are already open are brought to front
 
-	sessMu.Lock()
-	sess, err = session.New(path)
-	sessMu.Unlock()
-	if err != nil {
-		log.Printf("failed to open '%s': %s", path, err)
-		glib.IdleAdd(func() { showErrMsg("Cannot load file", err.Error()) })
-		return
+	for _, d := range docs {
+		if d.path == path {
+			notebook.SetCurrentPage(notebook.PageNum(d.root))
+			annotateAsked(d)
+			gradeAdopt(d)
+			return
+		}
 	}
 
-	openFilePath = path
-	dir, file := filepath.Split(shrinkHome(path))
-	hdrBar.SetTitle(file)
-	hdrBar.SetSubtitle(dir)
-	openBut.Hide()
-	saveBut.Show()
-	closeBut.Show()
-
-	// Populate the UI with pages
+	// Someone else having the file open is warned about. Remote documents
+	// are copied so they can't be locked, nor have sidecar annotations.
 
-	pageImages = make([]*gtk.Image, sess.PageCount())
-	pageLabels = make([]*gtk.Label, sess.PageCount())
-	for i := range pageImages {
-		o, err := gtk.OverlayNew()
-		if err != nil {
-			log.Fatal("Unable to create overlay")
+	remote := isRemote(path)
+	unlock, holder := func() {}, (*lockInfo)(nil)
+	if !remote {
+		unlock, holder = lockDoc(path)
+	}
+	readOnly := false
+	if holder != nil {
+		switch askLocked(path, holder) {
+		case lockedReadOnly:
+			readOnly = true
+		case lockedAnyway:
+		default:
+			return
 		}
+	}
 
-		// Page thumb
-
-		img, err := gtk.ImageNewFromPixbuf(loadingPix)
-		if err != nil {
-			log.Fatalf("failed to create image asset: %s", err)
+	logVerbose(logUI, "opening '%s'", path)
+	mainWin.SetSensitive(false)
+	opening++
+
+	sidecar := state.SidecarAnnotations && !readOnly && !remote
+	// Remote documents are fetched to a copy of their own already
+	inPlace := state.OpenInPlace || remote
+	var sess *session.Session
+	var local string
+	var form session.Form
+	var err error
+	fileQueue.submit(func() {
+		local = path
+		if remote {
+			if local, err = fetchRemote(path); err != nil {
+				return
+			}
 		}
-		img.Show()
-		pageImages[i] = img
-		eb, err := gtk.EventBoxNew()
-		if err != nil {
-			log.Fatalf("unable to create event box: %s", err)
-		}
-		eb.SetHAlign(gtk.ALIGN_START)
-		eb.Add(img)
-		eb.AddEvents(int(gdk.BUTTON_PRESS_MASK))
-		func(page int) {
-			eb.Connect("button-press-event", func() {
-				annotate(page)
-			})
-		}(i)
-		eb.Show()
-		o.Add(eb)
-
-		// Page Label
-
-		l, err := gtk.LabelNew(strconv.Itoa(i + 1))
-		if err != nil {
-			log.Fatalf("unable to create label: %s", err)
+		if inPlace || session.OnReadOnlyMedium(local) {
+			sess, err = session.NewInPlace(local)
+		} else {
+			sess, err = session.New(local)
+		}
+		if err != nil && remote {
+			_ = os.RemoveAll(filepath.Dir(local))
+		}
+		if err == nil && sidecar {
+			if _, err = sess.UseSidecar(session.SidecarDir(path)); err != nil {
+				sess.Close()
+			}
+		}
+		if err == nil {
+			// Warm up the page geometry cache so tooltips don't run pdfinfo
+			_, _ = sess.PageInfo(0)
+			form, _ = sess.Form()
+		}
+	}, func() {
+		if opening--; opening == 0 {
+			mainWin.SetSensitive(true)
+		}
+		if errors.Is(err, errDAVUnauthorized) {
+			if uri, ok := askDAVLocation("Open WebDAV Location", path, err.Error()+"."); ok {
+				open(uri)
+			}
+			return
 		}
-		addCSS(l, cleanCSS)
-		pageLabels[i] = l
-		l.Show()
-
-		eb, err = gtk.EventBoxNew()
 		if err != nil {
-			log.Fatalf("unable to create event box: %s", err)
+			unlock()
+			log.Printf("failed to open '%s': %s", path, err)
+			emitStatus(path, "failed: "+err.Error())
+			showErrMsg("Cannot load file", err.Error())
+			return
+		}
+		if form != session.FormNone {
+			sess.SetFlattenForm(askFlattenForm(path, form))
+		}
+		d := newDocument(path, sess)
+		d.unlock = unlock
+		if remote {
+			d.localCopy = filepath.Dir(local)
+		}
+		if readOnly {
+			d.readOnly = true
+			d.setModified(false)
 		}
-		eb.Show()
-		eb.SetHAlign(gtk.ALIGN_START)
-		eb.SetVAlign(gtk.ALIGN_END)
-		eb.SetMarginBottom(3)
-		eb.SetMarginStart(3)
-		eb.Add(l)
-		eb.AddEvents(int(gdk.BUTTON_PRESS_MASK))
-		func(page int) {
-			eb.Connect("button-press-event", func() {
-				sessMu.Lock()
-				annotated := sess.IsAnnotated(page)
-				sessMu.Unlock()
-				if annotated {
-					clearAnnotation(page)
-				}
-			})
-		}(i)
+		addDoc(d)
+	})
+}
 
-		o.AddOverlay(eb)
+// noLocalPathMsg explains that the given file chosen in a file chooser can't
+// be used since it has no local path, e.g. remote locations without a GVFS
+// FUSE mount.
+func noLocalPathMsg(uri string) string {
+	return fmt.Sprintf("'%s' is not accessible as a local file. Make sure GVFS FUSE "+
+		"support (gvfsd-fuse) is running for remote locations.", uri)
+}
 
-		o.Show()
-		pageFlow.Add(o)
+// askFlattenForm asks whether the form fields of the document at path are
+// flattened into the pages on saving, rather than kept fillable.
+func askFlattenForm(path string, form session.Form) bool {
+	msg := fmt.Sprintf("%s has fillable form fields. They can be kept fillable in the saved "+
+		"document, or flattened so their current values become part of the pages and can't be "+
+		"changed. Flattening also flattens any comments in it.", baseName(path))
+	if form == session.FormXFA {
+		msg += " It's an XFA form, so flattening only keeps what its regular form fields show."
 	}
+	return confirm("Keep form fields fillable?", msg, "Flatten", "Keep Fillable", false)
+}
 
-	var ctx context.Context
-	ctx, cancelLoad = context.WithCancel(context.Background())
-	go loadThumbs(ctx, func(p int) (string, error) {
-		sessMu.Lock()
-		defer sessMu.Unlock()
-		if sess == nil || sess.IsClosed() {
-			return "", errors.New("session is nil/closed")
+// addDoc adds a tab for the given newly opened document and switches to it.
+func addDoc(d *document) {
+	path := d.path
+	if !d.extracted {
+		addRecentFile(path)
+		if rm, err := gtk.RecentManagerGetDefault(); err == nil {
+			if isRemote(path) {
+				rm.AddItem(path)
+			} else {
+				rm.AddItem("file://" + (&url.URL{Path: path}).EscapedPath())
+			}
 		}
-		return sess.Thumbnail(p)
-	})
+	}
 
-	mainStack.SetVisibleChildName("pages")
+	docs = append(docs, d)
+	trackSession(d.sess)
+	i := notebook.AppendPage(d.root, d.newTab())
+	notebook.SetTabReorderable(d.root, true)
+	notebook.SetShowTabs(len(docs) > 1)
+	mainStack.SetVisibleChildName("docs")
+	notebook.SetCurrentPage(i)
+	updateUI()
+	emitStatus(path, "opened")
+	focus := 0
+	if path == resumePath {
+		focus, resumePath = resumePage, ""
+	}
+	if focus >= 0 && focus < len(d.pageCells) {
+		d.pageCells[focus].GrabFocus()
+	}
+	annotateAsked(d)
+	gradeAdopt(d)
 }
 
-func annotate(page int) {
-	mainWin.SetSensitive(false)
-	mainStack.SetVisibleChildName("continue-in-inkscape")
+// resumePath and resumePage are the document and page to return to once the
+// last document is reopened on startup.
+var (
+	resumePath string
+	resumePage int
+)
 
-	go func() {
-		sessMu.Lock()
-		changed, err := sess.Annotate(page)
-		sessMu.Unlock()
+// annotatePath and annotatePage are the document and page to annotate once
+// it's open, as asked for with --page.
+var (
+	annotatePath string
+	annotatePage int
+)
 
-		glib.IdleAdd(func() {
-			mainWin.SetSensitive(true)
-			mainStack.SetVisibleChildName("pages")
-			if err != nil {
-				showErrMsg("Cannot annotate file", err.Error())
-				return
-			}
-			if changed {
-				annotSinceLastSave = true
-				pageLabels[page].SetText(fmt.Sprintf("%d : clear", page+1))
-				addCSS(pageLabels[page], dirtyCSS)
-			}
-		})
-	}()
+// annotateAsked opens the page asked for with --page in the editor if d is
+// the document it was asked for.
+func annotateAsked(d *document) {
+	if d.path != annotatePath {
+		return
+	}
+	page := annotatePage
+	annotatePath = ""
+	if page >= len(d.pageCells) {
+		showErrMsg("Cannot annotate page", fmt.Sprintf("%s has only %d pages.", baseName(d.path), len(d.pageCells)))
+		return
+	}
+	if !d.changeable() {
+		return
+	}
+	d.pageCells[page].GrabFocus()
+	d.annotate(page)
 }
 
-func closeFile() bool {
-	sessMu.Lock()
-	if sess == nil || sess.IsClosed() {
-		sessMu.Unlock()
-		return true
+// reopenLast reopens the document which was open when the app was last
+// quit, if enabled in the preferences and the file is still around.
+func reopenLast() {
+	if !state.ReopenLast || state.LastDocument == "" {
+		return
 	}
-	sessMu.Unlock()
-
-	if annotSinceLastSave {
-		d, err := gtk.DialogNewWithButtons("Your changes will be lost!", mainWin, gtk.DIALOG_MODAL,
-			[]any{"Close anyway", gtk.RESPONSE_OK},
-			[]any{"Keep editing", gtk.RESPONSE_CANCEL})
-		if err != nil {
-			log.Fatalf("unable to create confirmation dialog: %s", err)
-		}
-		defer d.Destroy()
-		defer d.Close()
-		if d.Run() != gtk.RESPONSE_OK {
-			return false
+	// Recovered documents take precedence
+	if opening > 0 || len(docs) > 0 {
+		return
+	}
+	if !isRemote(state.LastDocument) {
+		if _, err := os.Stat(state.LastDocument); err != nil {
+			log.Printf("cannot reopen last document: %s", err)
+			return
 		}
 	}
+	resumePath, resumePage = state.LastDocument, state.LastPage
+	open(state.LastDocument)
+}
 
-	pageFlow.GetChildren().Foreach(func(i any) {
-		if c, ok := i.(gtk.IWidget); ok {
-			pageFlow.Remove(c)
+// closeDoc closes the given document and removes its tab. Returns false if
+// the document was kept open.
+func closeDoc(d *document) bool {
+	notebook.SetCurrentPage(notebook.PageNum(d.root))
+	if !d.close() {
+		return false
+	}
+	gradingClosed(d)
+	for i, o := range docs {
+		if o == d {
+			docs = append(docs[:i], docs[i+1:]...)
+			untrackSession(d.sess)
+			break
 		}
-	})
-
-	annotSinceLastSave = false
-	resetUIToStart()
-	sessMu.Lock()
-	if sess != nil {
-		cancelLoad()
-		sess.Close()
 	}
-	sessMu.Unlock()
+	notebook.RemovePage(notebook.PageNum(d.root))
+	emitStatus(d.path, "closed")
+	notebook.SetShowTabs(len(docs) > 1)
+	if len(docs) == 0 {
+		resetUIToStart()
+	}
+	updateUI()
+	return true
+}
 
-	openFilePath = ""
+// closeAll closes all documents, stopping at the first one which is kept
+// open. Returns true if all were closed.
+func closeAll() bool {
+	for len(docs) > 0 {
+		if !closeDoc(docs[0]) {
+			return false
+		}
+	}
 	return true
 }
 
+// openFiles opens each of the given files in its own tab.
+func openFiles(paths []string) {
+	for _, path := range paths {
+		open(path)
+	}
+}
+
 func resetUIToStart() {
-	hdrBar.SetTitle("")
-	hdrBar.SetSubtitle("")
-	openBut.Show()
-	saveBut.Hide()
-	closeBut.Hide()
+	updateRecentList()
 	mainStack.SetVisibleChildName("splash")
 }
 
-func save() {
-	ofd, err := gtk.FileChooserDialogNewWith1Button(
-		"Save",
-		mainWin,
-		gtk.FILE_CHOOSER_ACTION_SAVE,
-		"Save",
-		gtk.RESPONSE_OK,
-	)
+// newStartPage creates the page shown when no file is open, offering to open
+// a file or one of the recently opened ones.
+func newStartPage() (*gtk.Box, error) {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 10)
 	if err != nil {
-		log.Fatalf("failed to open file chooser: %s", err)
+		return nil, err
 	}
-	defer ofd.Destroy()
-	ofd.SetLocalOnly(true)
+	box.SetHAlign(gtk.ALIGN_CENTER)
+	box.SetVAlign(gtk.ALIGN_CENTER)
+	box.SetMarginTop(20)
+	box.SetMarginBottom(20)
 
-	filter, err := gtk.FileFilterNew()
+	// The splash fills in once decoded, so the window shows without it
+	splashImg, err := gtk.ImageNew()
 	if err != nil {
-		log.Fatalf("failed to create file filter: %s", err)
+		return nil, fmt.Errorf("failed to create image for splash: %s", err)
 	}
-	filter.SetName("PDF documents")
-	filter.AddPattern("*.pdf")
-	filter.AddPattern("*.PDF")
-	ofd.AddFilter(filter)
+	workQueue.submit(func() { splashPix.get() }, func() { splashImg.SetFromPixbuf(splashPix.get()) })
+	box.PackStart(splashImg, false, false, 0)
 
-	ofd.SetFilename(openFilePath)
+	b, err := gtk.ButtonNewWithLabel("Open PDF File…")
+	if err != nil {
+		return nil, err
+	}
+	b.SetHAlign(gtk.ALIGN_CENTER)
+	if ctx, err := b.GetStyleContext(); err == nil {
+		ctx.AddClass("suggested-action")
+	}
+	b.Connect("clicked", func() { open("") })
+	box.PackStart(b, false, false, 0)
 
-	if ofd.Run() != gtk.RESPONSE_OK {
-		return
+	hint, err := gtk.LabelNew("or drop PDF files anywhere in this window")
+	if err != nil {
+		return nil, err
 	}
-	path := ofd.GetFilename()
-	ofd.Close()
+	if ctx, err := hint.GetStyleContext(); err == nil {
+		ctx.AddClass("dim-label")
+	}
+	box.PackStart(hint, false, false, 0)
 
-	if !strings.HasSuffix(strings.ToLower(path), ".pdf") {
-		path += ".pdf"
+	recentHdr, err = gtk.LabelNew("")
+	if err != nil {
+		return nil, err
 	}
+	recentHdr.SetMarkup("<b>Recent Files</b>")
+	recentHdr.SetHAlign(gtk.ALIGN_START)
+	recentHdr.SetMarginTop(10)
+	box.PackStart(recentHdr, false, false, 0)
 
-	sessMu.Lock()
-	err = sess.Save(path)
-	sessMu.Unlock()
+	recentList, err = gtk.ListBoxNew()
 	if err != nil {
-		glib.IdleAdd(func() { showErrMsg("Cannot save file", err.Error()) })
-		return
+		return nil, err
 	}
-	annotSinceLastSave = false
+	recentList.Connect("row-activated", func(_ *gtk.ListBox, row *gtk.ListBoxRow) {
+		if i := row.GetIndex(); i < len(recentPaths) {
+			open(recentPaths[i])
+		}
+	})
+	box.PackStart(recentList, false, false, 0)
+
+	box.ShowAll()
+	return box, nil
 }
 
-func initUI() error {
-	var err error
+// updateRecentList refreshes the recent files on the start page, leaving out
+// the local ones which no longer exist.
+func updateRecentList() {
+	recentList.GetChildren().Foreach(func(i any) {
+		if c, ok := i.(gtk.IWidget); ok {
+			recentList.Remove(c)
+		}
+	})
+	recentPaths = nil
+	for _, path := range state.RecentFiles {
+		if _, err := os.Stat(path); err != nil && !isRemote(path) {
+			continue
+		}
+		l, err := gtk.LabelNew(shrinkHome(path))
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_START)
+		l.SetMarginTop(5)
+		l.SetMarginBottom(5)
+		l.SetMarginStart(10)
+		l.SetMarginEnd(10)
+		l.Show()
+		recentList.Add(l)
+		recentPaths = append(recentPaths, path)
+	}
+	recentHdr.SetVisible(len(recentPaths) > 0)
+	recentList.SetVisible(len(recentPaths) > 0)
+}
 
-	gtk.Init(nil)
+// suggestedSaveName returns the default file name for saving the annotated
+// version of the given PDF, e.g. "report-annotated.pdf" for "report.pdf".
+func suggestedSaveName(path string) string {
+	name := baseName(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return name + "-annotated.pdf"
+}
 
-	// Assets
+// showAbout shows the about dialog along with the versions of the external
+// tools found, to help with bug reports.
+func showAbout() {
+	var tools []session.Tool
+	workQueue.submit(func() {
+		tools = session.Tools()
+	}, func() {
+		showAboutWith(tools)
+	})
+}
 
-	loadingPix, err = gdk.PixbufNewFromBytesOnly(loadingImgBytes)
+func showAboutWith(tools []session.Tool) {
+	d, err := gtk.AboutDialogNew()
 	if err != nil {
-		return fmt.Errorf("failed to create loading pixbuf: %s", err)
+		log.Fatalf("unable to create about dialog: %s", err)
 	}
-	noThumbPix, err = gdk.PixbufNewFromBytesOnly(noThumbImgBytes)
-	if err != nil {
-		return fmt.Errorf("failed to create no thumb pixbuf: %s", err)
+	defer d.Destroy()
+	d.SetTransientFor(mainWin)
+	d.SetModal(true)
+	d.SetProgramName(progName)
+	v := version
+	if commit != "" {
+		v += "\n" + commit
+	}
+	if date != "" {
+		v += "\nbuilt " + date
+	}
+	d.SetVersion(v)
+	if logo, err := gdk.PixbufNewFromBytesOnly(appIcon); err == nil {
+		d.SetLogo(logo)
 	}
+	d.SetWebsite("https://github.com/oxplot/pdfrankenstein")
+	d.SetLicenseType(gtk.LICENSE_BSD)
 
-	cleanCSS, err = gtk.CssProviderNew()
-	if err != nil {
-		return fmt.Errorf("failed to create css provider: %s", err)
+	deps := []string{}
+	for _, t := range tools {
+		if t.Err != nil {
+			deps = append(deps, t.Name+": not found")
+		} else {
+			deps = append(deps, t.Name+": "+t.Version)
+		}
 	}
-	cleanCSS.LoadFromData(
-		`label{border-radius:3px;padding:2px 6px;background:@theme_bg_color;opacity:0.8}`)
-	dirtyCSS, err = gtk.CssProviderNew()
+	d.SetComments("PDF annotator which uses Inkscape for editing.\n\n" +
+		strings.Join(deps, "\n"))
+
+	_ = d.Run()
+}
+
+// newPrimaryMenu creates the menu of the header bar's menu button.
+func newPrimaryMenu() *glib.Menu {
+	dav := glib.MenuNew()
+	dav.Append("Open WebDAV Location…", "app.open-webdav")
+	dav.Append("Save to WebDAV Location…", "app.save-webdav")
+	grade := glib.MenuNew()
+	grade.Append("Grade Folder of Submissions…", "app.grade-folder")
+	grade.Append("Save Graded and Open Next", "app.grade-next")
+	grade.Append("Edit Rubric…", "app.rubric")
+	grade.Append("Stamp Score on First Page", "app.stamp-score")
+	doc := glib.MenuNew()
+	doc.Append("Capture Screen Region…", "app.capture")
+	doc.Append("Initial Every Page and Sign…", "app.sign")
+	doc.Append("Carry Annotations to Revision…", "app.migrate")
+	doc.Append("Open Selection as New Document", "app.extract")
+	doc.Append("Watermark…", "app.watermark")
+	doc.Append("Page Numbers…", "app.page-numbers")
+	doc.Append("Send Saved PDF by Email…", "app.email")
+	prefs := glib.MenuNew()
+	prefs.Append("Preferences", "app.preferences")
+	help := glib.MenuNew()
+	help.Append("Session Info", "app.session-info")
+	help.Append("Keyboard Shortcuts", "app.shortcuts")
+	help.Append("Run Diagnostics", "app.diagnostics")
+	help.Append("Open Log File", "app.open-log")
+	help.Append("About "+progName, "app.about")
+	quit := glib.MenuNew()
+	quit.Append("Quit", "app.quit")
+
+	m := glib.MenuNew()
+	m.AppendSectionWithoutLabel(&dav.MenuModel)
+	m.AppendSectionWithoutLabel(&grade.MenuModel)
+	m.AppendSectionWithoutLabel(&doc.MenuModel)
+	m.AppendSectionWithoutLabel(&prefs.MenuModel)
+	m.AppendSectionWithoutLabel(&help.MenuModel)
+	m.AppendSectionWithoutLabel(&quit.MenuModel)
+	return m
+}
+
+// newProfileCombo creates the chooser of the profile of the config in use,
+// which is remembered for the next start.
+func newProfileCombo(profiles []string) (*gtk.ComboBoxText, error) {
+	c, err := gtk.ComboBoxTextNew()
 	if err != nil {
-		return fmt.Errorf("failed to create css provider: %s", err)
+		return nil, err
 	}
-	dirtyCSS.LoadFromData(`label{color:white;background:orange;opacity:1}`)
+	c.Append("", "No Profile")
+	for _, p := range profiles {
+		c.Append(p, p)
+	}
+	c.SetActiveID(configLayers.profile)
+	c.SetTooltipText("Settings Profile")
+	c.Connect("changed", func() {
+		p := c.GetActiveID()
+		if p == configLayers.profile {
+			return
+		}
+		if err := useProfile(p); err != nil {
+			showErrMsg("Cannot use profile", err.Error())
+			c.SetActiveID(configLayers.profile)
+			return
+		}
+		logVerbose(logUI, "using profile '%s'", p)
+		state.Profile = p
+		saveState()
+	})
+	return c, nil
+}
+
+func initUI() error {
+	var err error
 
 	// Main window
 
@@ -459,11 +824,22 @@ func initUI() error {
 		return fmt.Errorf("failed to create main window: %s", err)
 	}
 	mainWin.Connect("delete-event", func() bool {
-		return !closeFile()
+		lastDoc, lastPage := "", 0
+		if d := curDoc(); d != nil && !d.extracted {
+			lastDoc, lastPage = d.path, d.focusedPage()
+		}
+		if !closeAll() {
+			return true
+		}
+		state.LastDocument, state.LastPage = lastDoc, lastPage
+		saveState()
+		return false
 	})
-	mainWin.Connect("destroy", func() {
-		gtk.MainQuit()
+	mainWin.Connect("focus-in-event", func() bool {
+		app.WithdrawNotification("annotation")
+		return false
 	})
+	app.AddWindow(mainWin)
 
 	dragTarget, err := gtk.TargetEntryNew("text/uri-list", gtk.TARGET_OTHER_APP, 0)
 	if err != nil {
@@ -471,13 +847,20 @@ func initUI() error {
 	}
 	mainWin.DragDestSet(gtk.DEST_DEFAULT_ALL, []gtk.TargetEntry{*dragTarget}, gdk.ACTION_COPY)
 	mainWin.Connect("drag-data-received", func(_ *gtk.Window, _ *gdk.DragContext, x, y int, s *gtk.SelectionData, m int, t uint) {
-		uri := strings.SplitN(string(s.GetData()), "\r", 2)[0]
-		if !strings.HasPrefix(uri, "file://") {
-			return
-		}
-		if closeFile() {
-			open(strings.TrimPrefix(uri, "file://"))
+		var paths []string
+		for _, uri := range strings.Split(string(s.GetData()), "\n") {
+			uri = strings.TrimSpace(uri)
+			if uri == "" || strings.HasPrefix(uri, "#") {
+				continue
+			}
+			path, err := uriPath(uri)
+			if err != nil {
+				log.Printf("ignoring dropped URI: %s", err)
+				continue
+			}
+			paths = append(paths, path)
 		}
+		openFiles(paths)
 	})
 
 	iconPix, err := gdk.PixbufNewFromBytesOnly(appIcon)
@@ -500,7 +883,266 @@ func initUI() error {
 	if err != nil {
 		return fmt.Errorf("failed to create main stack: %s", err)
 	}
-	mainWin.Add(mainStack)
+	statusLbl, err = gtk.LabelNew("")
+	if err != nil {
+		return fmt.Errorf("failed to create status label: %s", err)
+	}
+	workLbl, err = gtk.LabelNew("")
+	if err != nil {
+		return fmt.Errorf("failed to create status label: %s", err)
+	}
+	statusBar, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 10)
+	if err != nil {
+		return fmt.Errorf("failed to create status bar: %s", err)
+	}
+	statusBar.SetMarginTop(3)
+	statusBar.SetMarginBottom(3)
+	statusBar.SetMarginStart(10)
+	statusBar.SetMarginEnd(10)
+	infoBut, err := gtk.ButtonNewFromIconName("drive-harddisk-symbolic", gtk.ICON_SIZE_MENU)
+	if err != nil {
+		return fmt.Errorf("failed to create session info button: %s", err)
+	}
+	infoBut.SetRelief(gtk.RELIEF_NONE)
+	infoBut.SetTooltipText("Session Info")
+	infoBut.SetActionName("app.session-info")
+	statusBar.PackStart(statusLbl, false, false, 0)
+	statusBar.PackEnd(infoBut, false, false, 0)
+	statusBar.PackEnd(workLbl, false, false, 0)
+
+	mainBox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create main box: %s", err)
+	}
+	mainBox.PackStart(mainStack, true, true, 0)
+	mainBox.PackEnd(statusBar, false, false, 0)
+	mainWin.Add(mainBox)
+
+	// Actions
+
+	undoAction = glib.SimpleActionNew("undo", nil)
+	undoAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.undo()
+		}
+	})
+	undoAction.SetEnabled(false)
+	addAction(undoAction)
+
+	saveAction = glib.SimpleActionNew("save", nil)
+	saveAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.save()
+		}
+	})
+	addAction(saveAction)
+
+	saveAsAction = glib.SimpleActionNew("save-as", nil)
+	saveAsAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.saveAs()
+		}
+	})
+	addAction(saveAsAction)
+
+	saveDAVAction = glib.SimpleActionNew("save-webdav", nil)
+	saveDAVAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.saveDAV()
+		}
+	})
+	addAction(saveDAVAction)
+
+	printAction = glib.SimpleActionNew("print", nil)
+	printAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.print()
+		}
+	})
+	addAction(printAction)
+
+	emailAction = glib.SimpleActionNew("email", nil)
+	emailAction.Connect("activate", func() {
+		if d := curDoc(); d != nil && d.savePath != "" {
+			xdgEmail(d.savePath)
+		}
+	})
+	addAction(emailAction)
+
+	captureAction = glib.SimpleActionNew("capture", nil)
+	captureAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.captureRegion(d.currentPage())
+		}
+	})
+	addAction(captureAction)
+
+	dateAction = glib.SimpleActionNew("stamp-date", nil)
+	dateAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.stampDate(d.currentPage())
+		}
+	})
+	addAction(dateAction)
+
+	whiteoutAction = glib.SimpleActionNew("whiteout", nil)
+	whiteoutAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.whiteout(d.currentPage())
+		}
+	})
+	addAction(whiteoutAction)
+
+	signAction = glib.SimpleActionNew("sign", nil)
+	signAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.initialAndSign()
+		}
+	})
+	addAction(signAction)
+
+	migrateAction = glib.SimpleActionNew("migrate", nil)
+	migrateAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.migrateAnnotations()
+		}
+	})
+	addAction(migrateAction)
+
+	extractAction = glib.SimpleActionNew("extract", nil)
+	extractAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.openPages(d.selectedPages())
+		}
+	})
+	addAction(extractAction)
+
+	watermarkAction = glib.SimpleActionNew("watermark", nil)
+	watermarkAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.watermark()
+		}
+	})
+	addAction(watermarkAction)
+
+	numbersAction = glib.SimpleActionNew("page-numbers", nil)
+	numbersAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.pageNumbers()
+		}
+	})
+	addAction(numbersAction)
+
+	gradeAction = glib.SimpleActionNew("grade-folder", nil)
+	gradeAction.Connect("activate", func() { startGrading() })
+	addAction(gradeAction)
+
+	gradeNextAction = glib.SimpleActionNew("grade-next", nil)
+	gradeNextAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.saveGraded()
+		}
+	})
+	addAction(gradeNextAction)
+
+	rubricAction = glib.SimpleActionNew("rubric", nil)
+	rubricAction.Connect("activate", func() { showRubric() })
+	addAction(rubricAction)
+
+	scoreAction = glib.SimpleActionNew("stamp-score", nil)
+	scoreAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.stampScore(nil)
+		}
+	})
+	addAction(scoreAction)
+
+	infoAction = glib.SimpleActionNew("session-info", nil)
+	infoAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			d.showInfo()
+		}
+	})
+	addAction(infoAction)
+
+	openAction := glib.SimpleActionNew("open", nil)
+	openAction.Connect("activate", func() { open("") })
+	addAction(openAction)
+
+	openDAVAction := glib.SimpleActionNew("open-webdav", nil)
+	openDAVAction.Connect("activate", func() { openDAV() })
+	addAction(openDAVAction)
+
+	closeAction = glib.SimpleActionNew("close", nil)
+	closeAction.Connect("activate", func() {
+		if d := curDoc(); d != nil {
+			closeDoc(d)
+		}
+	})
+	addAction(closeAction)
+
+	filterAction = glib.SimpleActionNewStateful("annotated-only", nil, glib.VariantFromBoolean(false))
+	filterAction.Connect("activate", func() {
+		annotatedOnly = !annotatedOnly
+		filterAction.SetState(glib.VariantFromBoolean(annotatedOnly))
+		for _, d := range docs {
+			d.applyFilter()
+		}
+	})
+	addAction(filterAction)
+
+	prefsAction := glib.SimpleActionNew("preferences", nil)
+	prefsAction.Connect("activate", func() { showPrefs() })
+	addAction(prefsAction)
+
+	stripAction = glib.SimpleActionNewStateful("strip-view", nil, glib.VariantFromBoolean(false))
+	stripAction.Connect("activate", func() {
+		stripView = !stripView
+		stripAction.SetState(glib.VariantFromBoolean(stripView))
+		for _, d := range docs {
+			d.applyView()
+		}
+		updateStatus()
+	})
+	addAction(stripAction)
+
+	notesAction = glib.SimpleActionNewStateful("notes", nil, glib.VariantFromBoolean(false))
+	notesAction.Connect("activate", func() {
+		showNotes = !showNotes
+		notesAction.SetState(glib.VariantFromBoolean(showNotes))
+		for _, d := range docs {
+			d.notesPanel.SetVisible(showNotes)
+		}
+	})
+	addAction(notesAction)
+
+	shortcutsAction := glib.SimpleActionNew("shortcuts", nil)
+	shortcutsAction.Connect("activate", func() { showShortcuts() })
+	addAction(shortcutsAction)
+
+	diagnosticsAction := glib.SimpleActionNew("diagnostics", nil)
+	diagnosticsAction.Connect("activate", func() { showDiagnostics() })
+	addAction(diagnosticsAction)
+
+	openLogAction := glib.SimpleActionNew("open-log", nil)
+	openLogAction.Connect("activate", func() { openLogFile() })
+	addAction(openLogAction)
+
+	aboutAction := glib.SimpleActionNew("about", nil)
+	aboutAction.Connect("activate", func() { showAbout() })
+	addAction(aboutAction)
+
+	quitAction := glib.SimpleActionNew("quit", nil)
+	quitAction.Connect("activate", func() { mainWin.Close() })
+	addAction(quitAction)
+
+	registerAccels()
+
+	pageTarget, err = gtk.TargetEntryNew("application/x-pdfrankenstein-page", gtk.TARGET_SAME_APP, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create page drag target: %s", err)
+	}
+	pageAtom = gdk.GdkAtomIntern("application/x-pdfrankenstein-page", false)
 
 	// Main buttons
 
@@ -508,83 +1150,242 @@ func initUI() error {
 	if err != nil {
 		return fmt.Errorf("failed to create save button: %s", err)
 	}
-	saveBut.Connect("clicked", func() { save() })
+	saveBut.SetActionName("app.save")
+	saveAsBut, err = gtk.ButtonNewWithLabel("Save As…")
+	if err != nil {
+		return fmt.Errorf("failed to create save as button: %s", err)
+	}
+	saveAsBut.SetActionName("app.save-as")
 	closeBut, err = gtk.ButtonNewWithLabel("Close")
 	if err != nil {
 		return fmt.Errorf("failed to create close button: %s", err)
 	}
-	closeBut.Connect("clicked", func() { closeFile() })
+	closeBut.SetActionName("app.close")
+	printBut, err = gtk.ButtonNewFromIconName("document-print-symbolic", gtk.ICON_SIZE_BUTTON)
+	if err != nil {
+		return fmt.Errorf("failed to create print button: %s", err)
+	}
+	printBut.SetTooltipText("Print (Ctrl+P)")
+	printBut.SetActionName("app.print")
 	openBut, err = gtk.ButtonNewWithLabel("Open PDF File")
 	if err != nil {
 		return fmt.Errorf("failed to create open button: %s", err)
 	}
-	openBut.Connect("clicked", func() { open("") })
+	openBut.SetActionName("app.open")
 
-	hdrBar.Add(openBut)
-	hdrBar.Add(saveBut)
-	hdrBar.PackEnd(closeBut)
+	undoBut, err = gtk.ButtonNewFromIconName("edit-undo-symbolic", gtk.ICON_SIZE_BUTTON)
+	if err != nil {
+		return fmt.Errorf("failed to create undo button: %s", err)
+	}
+	undoBut.SetTooltipText("Undo (Ctrl+Z)")
+	undoBut.SetActionName("app.undo")
 
-	// Add splash
+	filterBut, err = gtk.ToggleButtonNew()
+	if err != nil {
+		return fmt.Errorf("failed to create filter button: %s", err)
+	}
+	filterImg, err := gtk.ImageNewFromIconName("document-edit-symbolic", gtk.ICON_SIZE_BUTTON)
+	if err != nil {
+		return fmt.Errorf("failed to create filter button image: %s", err)
+	}
+	filterBut.SetImage(filterImg)
+	filterBut.SetTooltipText("Show Annotated Pages Only (Ctrl+Shift+A)")
+	filterBut.SetActionName("app.annotated-only")
 
-	splashPix, err := gdk.PixbufNewFromBytesOnly(splash)
+	stripBut, err = gtk.ToggleButtonNew()
 	if err != nil {
-		return fmt.Errorf("failed to create pixbuf for splash: %s", err)
+		return fmt.Errorf("failed to create strip view button: %s", err)
 	}
-	splashImg, err := gtk.ImageNewFromPixbuf(splashPix)
+	stripImg, err := gtk.ImageNewFromIconName("view-continuous-symbolic", gtk.ICON_SIZE_BUTTON)
 	if err != nil {
-		return fmt.Errorf("failed to create image for splash: %s", err)
+		return fmt.Errorf("failed to create strip view button image: %s", err)
 	}
-	mainStack.AddNamed(splashImg, "splash")
-	mainStack.SetVisibleChildName("splash")
+	stripBut.SetImage(stripImg)
+	stripBut.SetTooltipText("Continuous View (Ctrl+Shift+C)")
+	stripBut.SetActionName("app.strip-view")
 
-	// Add continue in inkscape message
+	notesBut, err = gtk.ToggleButtonNew()
+	if err != nil {
+		return fmt.Errorf("failed to create notes button: %s", err)
+	}
+	notesImg, err := gtk.ImageNewFromIconName("accessories-text-editor-symbolic", gtk.ICON_SIZE_BUTTON)
+	if err != nil {
+		return fmt.Errorf("failed to create notes button image: %s", err)
+	}
+	notesBut.SetImage(notesImg)
+	notesBut.SetTooltipText("Page Notes (F9)")
+	notesBut.SetActionName("app.notes")
 
-	l, err := gtk.LabelNew("Continue in Inkscape.\nOnce done, save, close and return here.")
+	hdrBar.Add(openBut)
+	hdrBar.Add(saveBut)
+	hdrBar.Add(saveAsBut)
+	hdrBar.Add(printBut)
+	hdrBar.Add(undoBut)
+	hdrBar.Add(filterBut)
+	hdrBar.Add(stripBut)
+	hdrBar.PackEnd(notesBut)
+	if profiles := configProfiles(); len(profiles) > 0 {
+		profileCombo, err := newProfileCombo(profiles)
+		if err != nil {
+			return fmt.Errorf("failed to create profile chooser: %s", err)
+		}
+		hdrBar.PackEnd(profileCombo)
+	}
+	menuBut, err := gtk.MenuButtonNew()
+	if err != nil {
+		return fmt.Errorf("failed to create menu button: %s", err)
+	}
+	menuImg, err := gtk.ImageNewFromIconName("open-menu-symbolic", gtk.ICON_SIZE_BUTTON)
 	if err != nil {
-		return fmt.Errorf("unable to create label: %s", err)
+		return fmt.Errorf("failed to create menu button image: %s", err)
 	}
-	mainStack.AddNamed(l, "continue-in-inkscape")
+	menuBut.SetImage(menuImg)
+	menuBut.SetTooltipText("Main Menu")
+	menuBut.SetMenuModel(&newPrimaryMenu().MenuModel)
+	hdrBar.PackEnd(menuBut)
+	hdrBar.PackEnd(closeBut)
 
-	// Add page flow
+	// Add start page
 
-	pageFlow, err = gtk.FlowBoxNew()
+	startPage, err := newStartPage()
 	if err != nil {
-		return fmt.Errorf("failed to create flowbox: %s", err)
+		return fmt.Errorf("failed to create start page: %s", err)
 	}
-	pageFlow.SetSelectionMode(gtk.SELECTION_NONE)
-	pageFlow.SetMarginTop(10)
-	pageFlow.SetMarginBottom(10)
-	pageFlow.SetMarginStart(10)
-	pageFlow.SetMarginEnd(10)
+	mainStack.AddNamed(startPage, "splash")
+	mainStack.SetVisibleChildName("splash")
+
+	// Add document tabs
 
-	scr, err := gtk.ScrolledWindowNew(nil, nil)
+	notebook, err = gtk.NotebookNew()
 	if err != nil {
-		return fmt.Errorf("failed to create scrolled window: %s", err)
+		return fmt.Errorf("failed to create notebook: %s", err)
 	}
-	scr.Add(pageFlow)
-	mainStack.AddNamed(scr, "pages")
+	notebook.SetScrollable(true)
+	notebook.SetShowBorder(false)
+	notebook.ConnectAfter("switch-page", func() { updateUI() })
+	mainStack.AddNamed(notebook, "docs")
 
 	mainWin.ShowAll()
 	resetUIToStart()
+	updateUI()
 
 	return nil
 }
 
-func run() error {
-	if err := initUI(); err != nil {
-		return fmt.Errorf("failed to initialize UI: %s", err)
+// gFilePaths returns the paths of the GFile array passed to the
+// application's "open" signal, or the URIs of the ones with no local path.
+func gFilePaths(files unsafe.Pointer, n int) []string {
+	paths := make([]string, 0, n)
+	for _, p := range unsafe.Slice((*unsafe.Pointer)(files), n) {
+		f := &glib.File{Object: glib.Take(p)}
+		if path := f.GetPath(); path != "" {
+			paths = append(paths, path)
+		} else {
+			paths = append(paths, gFileURI(f))
+		}
+	}
+	return paths
+}
+
+func run(args guiArgs) error {
+	var err error
+	if verbose {
+		startLogFile()
+	}
+	handleSignals()
+
+	// Only the first instance runs the UI. Files opened from subsequent
+	// invocations, such as Open With from file managers, are forwarded to it
+	// via the "open" signal so instances never share temporary state.
+
+	app, err = gtk.ApplicationNew(appID, glib.APPLICATION_HANDLES_OPEN)
+	if err != nil {
+		return fmt.Errorf("failed to create application: %s", err)
+	}
+
+	var initErr error
+	app.Connect("startup", func() {
+		loadState()
+		if initErr = initUI(); initErr != nil {
+			app.Quit()
+			return
+		}
+		warmAssets()
+		checkDeps()
+		startAutosave()
+		serveDBus()
+		checkLastCrash()
+		recoverAutosaves()
+		checkForUpdate()
+	})
+	// activate is emitted when invoked without files and open otherwise, both
+	// on the first instance and on subsequent invocations. The last document
+	// is only reopened if the first instance is started without files.
+	launched := false
+	app.Connect("activate", func() {
+		mainWin.Present()
+		if !launched {
+			launched = true
+			reopenLast()
+		}
+	})
+	app.Connect("open", func(_ *gtk.Application, files unsafe.Pointer, n int, hint string) {
+		launched = true
+		mainWin.Present()
+		paths := gFilePaths(files, n)
+		page := hintPage(hint)
+		glib.IdleAdd(func() {
+			if page > 0 && len(paths) > 0 {
+				annotatePath, annotatePage = paths[0], page-1
+			}
+			openFiles(paths)
+		})
+	})
+
+	// Files are opened before running so the page to annotate goes along.
+	// Running then only activates the instance which opened them.
+
+	if len(args.files) > 0 {
+		hint := ""
+		if args.page > 0 {
+			hint = pageHint + strconv.Itoa(args.page)
+		}
+		if err := openWithHint(args.files, hint); err != nil {
+			return fmt.Errorf("failed to register application: %s", err)
+		}
 	}
-	if len(os.Args) > 1 {
-		glib.IdleAdd(func() { open(os.Args[1]) })
+	if status := app.Run(os.Args[:1]); status != 0 {
+		return fmt.Errorf("application exited with status %d", status)
+	}
+	if initErr != nil {
+		return fmt.Errorf("failed to initialize UI: %s", initErr)
 	}
-	gtk.Main()
 	return nil
 }
 
 func main() {
+	defer crashGuard(true)
 	log.SetFlags(0)
 	log.SetPrefix(strings.ToLower(progName) + ": ")
-	if err := run(); err != nil {
+	log.SetOutput(logTee{})
+	cliArgs, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if handled, err := runCLI(cliArgs); handled {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	args, err := parseGUIArgs(cliArgs)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(args); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// An archival manifest can be written beside each saved PDF for records
+// retention, telling which PDF it was made from, with which tools, along with
+// checksums to verify both by.
+
+// manifestSuffix is appended to the path of the saved PDF to name its
+// manifest.
+const manifestSuffix = ".manifest.json"
+
+// manifest is the archival manifest of a saved PDF.
+type manifest struct {
+	Created   time.Time      `json:"created"`
+	Generator string         `json:"generator"`
+	Input     manifestFile   `json:"input"`
+	Output    manifestFile   `json:"output"`
+	Tools     []manifestTool `json:"tools"`
+}
+
+// manifestFile is a PDF described by a manifest.
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestTool is an external program the PDF was made with.
+type manifestTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// describeFile returns the size and checksum of the file at local, recorded
+// under path.
+func describeFile(path, local string) (manifestFile, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return manifestFile{}, fmt.Errorf("failed to read '%s': %s", local, err)
+	}
+	return manifestFile{Path: path, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// writeManifest writes the manifest of the PDF saved to out from the one at
+// in, whose content is at local, e.g. the session's copy of it.
+func writeManifest(in, local, out string) error {
+	m := manifest{
+		Created:   time.Now().UTC(),
+		Generator: progName + " " + version,
+		Tools:     []manifestTool{},
+	}
+	var err error
+	if m.Input, err = describeFile(in, local); err != nil {
+		return err
+	}
+	if m.Output, err = describeFile(out, out); err != nil {
+		return err
+	}
+	for _, t := range session.Tools() {
+		if t.Err == nil {
+			m.Tools = append(m.Tools, manifestTool{t.Name, t.Version})
+		}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(out+manifestSuffix, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %s", err)
+	}
+	return nil
+}
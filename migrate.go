@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// minSureScore is the least similarity of matched pages for their carried
+// over annotations not to need checking.
+const minSureScore = 0.9
+
+// migrateAnnotations asks for a revision of the document's PDF, opens it and
+// carries the annotations over to the pages matching the annotated ones.
+// Pages which couldn't be matched, or only loosely, are reported.
+func (d *document) migrateAnnotations() {
+	if d.busy() {
+		return
+	}
+	d.sessMu.Lock()
+	annotated := d.sess.HasAnnotations()
+	d.sessMu.Unlock()
+	if !annotated {
+		showErrMsg("Cannot carry over annotations", baseName(d.path)+" has no annotations.")
+		return
+	}
+
+	ofd, err := gtk.FileChooserNativeDialogNew(
+		"Open Revised PDF",
+		mainWin,
+		gtk.FILE_CHOOSER_ACTION_OPEN,
+		"_Open",
+		"_Cancel",
+	)
+	if err != nil {
+		log.Fatalf("failed to open file chooser: %s", err)
+	}
+	defer ofd.Destroy()
+	filter, err := gtk.FileFilterNew()
+	if err != nil {
+		log.Fatalf("failed to create file filter: %s", err)
+	}
+	filter.AddMimeType("application/pdf")
+	filter.SetName("PDF Document")
+	ofd.AddFilter(filter)
+	if !isRemote(d.path) {
+		ofd.SetCurrentFolder(dirName(d.path))
+	}
+	if ofd.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	path := ofd.GetFilename()
+	if path == "" {
+		showErrMsg("Cannot open revision", noLocalPathMsg(ofd.GetURI()))
+		return
+	}
+	for _, o := range docs {
+		if o.path == path {
+			showErrMsg("Cannot open revision", baseName(path)+" is already open. Close it first.")
+			return
+		}
+	}
+	unlock, holder := lockDoc(path)
+	if holder != nil {
+		showErrMsg("Cannot open revision", fmt.Sprintf("%s is open by %s on %s.", baseName(path),
+			holder.User, holder.Host))
+		return
+	}
+
+	mainWin.SetSensitive(false)
+	opening++
+
+	sidecar := state.SidecarAnnotations
+	var sess *session.Session
+	var matches []session.PageMatch
+	fileQueue.submit(func() {
+		if sess, err = session.New(path); err != nil {
+			return
+		}
+		if sidecar {
+			if _, err = sess.UseSidecar(session.SidecarDir(path)); err != nil {
+				sess.Close()
+				return
+			}
+		}
+		if matches, err = sess.MigrateAnnotations(context.Background(), d.sess); err != nil {
+			sess.Close()
+		}
+	}, func() {
+		if opening--; opening == 0 {
+			mainWin.SetSensitive(true)
+		}
+		if err != nil {
+			unlock()
+			showErrMsg("Cannot carry over annotations", err.Error())
+			return
+		}
+		nd := newDocument(path, sess)
+		nd.unlock = unlock
+		addDoc(nd)
+		nd.setModified(true)
+		emitStatus(path, "annotations carried over from "+d.path)
+		reportMigration(d.path, path, matches)
+	})
+}
+
+// reportMigration tells how the annotations of the PDF at from were carried
+// over to its revision at to.
+func reportMigration(from, to string, matches []session.PageMatch) {
+	var carried int
+	var lost, unsure []string
+	for _, m := range matches {
+		switch {
+		case m.New < 0:
+			lost = append(lost, strconv.Itoa(m.Old+1))
+		case m.ByPosition || m.Score < minSureScore:
+			unsure = append(unsure, fmt.Sprintf("%d (now %d)", m.Old+1, m.New+1))
+			carried++
+		default:
+			carried++
+		}
+	}
+	msgType := gtk.MESSAGE_INFO
+	if len(lost) > 0 || len(unsure) > 0 {
+		msgType = gtk.MESSAGE_WARNING
+	}
+	dlg := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, msgType, gtk.BUTTONS_CLOSE,
+		"%s", fmt.Sprintf("Annotations of %d of %d pages carried over to %s", carried, len(matches), baseName(to)))
+	defer dlg.Destroy()
+	var msg []string
+	if len(lost) > 0 {
+		msg = append(msg, fmt.Sprintf("No match was found in the revision for page %s of %s, "+
+			"so its annotations were left out.", strings.Join(lost, ", "), baseName(from)))
+	}
+	if len(unsure) > 0 {
+		msg = append(msg, fmt.Sprintf("Page %s changed noticeably. Check that the annotations "+
+			"still line up.", strings.Join(unsure, ", ")))
+	}
+	if len(msg) == 0 {
+		msg = append(msg, "All annotated pages were found unchanged in the revision.")
+	}
+	dlg.FormatSecondaryText("%s", strings.Join(msg, "\n\n"))
+	_ = dlg.Run()
+}
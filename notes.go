@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// showNotes is whether the notes sidebar is shown.
+var showNotes bool
+
+// newNotesPanel creates the sidebar for typing free-form notes on the
+// focused page.
+func (d *document) newNotesPanel() *gtk.Box {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 6)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	box.SetMarginTop(10)
+	box.SetMarginBottom(10)
+	box.SetMarginStart(10)
+	box.SetMarginEnd(10)
+	box.SetSizeRequest(220, -1)
+
+	d.notesLbl, err = gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	d.notesLbl.SetHAlign(gtk.ALIGN_START)
+	box.PackStart(d.notesLbl, false, false, 0)
+
+	tv, err := gtk.TextViewNew()
+	if err != nil {
+		log.Fatalf("unable to create text view: %s", err)
+	}
+	tv.SetWrapMode(gtk.WRAP_WORD_CHAR)
+	d.notesBuf, err = tv.GetBuffer()
+	if err != nil {
+		log.Fatalf("unable to get text buffer: %s", err)
+	}
+	d.notesBuf.Connect("changed", func() {
+		if d.notesCell == nil || d.loadingNote {
+			return
+		}
+		start, end := d.notesBuf.GetBounds()
+		text, err := d.notesBuf.GetText(start, end, false)
+		if err != nil {
+			return
+		}
+		d.sessMu.Lock()
+		d.sess.SetNote(d.notesCell.GetIndex(), text)
+		d.sessMu.Unlock()
+	})
+	scr, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatalf("unable to create scrolled window: %s", err)
+	}
+	scr.SetShadowType(gtk.SHADOW_IN)
+	scr.Add(tv)
+	box.PackStart(scr, true, true, 0)
+
+	hint, err := gtk.LabelNew("Notes are not saved into the PDF.")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	hint.SetLineWrap(true)
+	hint.SetXAlign(0)
+	if ctx, err := hint.GetStyleContext(); err == nil {
+		ctx.AddClass("dim-label")
+	}
+	box.PackStart(hint, false, false, 0)
+
+	exportBut, err := gtk.ButtonNewWithLabel("Export Notes…")
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	exportBut.Connect("clicked", func() { d.exportNotes() })
+	box.PackStart(exportBut, false, false, 0)
+
+	d.showNote(nil)
+	return box
+}
+
+// showNote shows the notes of the page in the given cell, or disables
+// editing if nil.
+func (d *document) showNote(c *gtk.FlowBoxChild) {
+	d.notesCell = c
+	d.loadingNote = true
+	defer func() { d.loadingNote = false }()
+	if c == nil {
+		d.notesLbl.SetMarkup("<b>Notes</b>")
+		d.notesBuf.SetText("")
+		return
+	}
+	page := c.GetIndex()
+	d.notesLbl.SetMarkup(fmt.Sprintf("<b>Notes on page %d</b>", page+1))
+	d.sessMu.Lock()
+	note := d.sess.Note(page)
+	d.sessMu.Unlock()
+	d.notesBuf.SetText(note)
+}
+
+// notesReport returns the notes of all pages as a Markdown document.
+func (d *document) notesReport() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Notes on %s\n", baseName(d.path))
+	d.sessMu.Lock()
+	defer d.sessMu.Unlock()
+	for p := 0; p < d.sess.PageCount(); p++ {
+		note := strings.TrimSpace(d.sess.Note(p))
+		if note == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## Page %d", p+1)
+		if d.sess.IsAnnotated(p) {
+			b.WriteString(" (annotated)")
+		}
+		b.WriteString("\n\n" + note + "\n")
+	}
+	return b.String()
+}
+
+// exportNotes asks for a path and writes the notes report to it.
+func (d *document) exportNotes() {
+	ofd, err := gtk.FileChooserNativeDialogNew(
+		"Export Notes",
+		mainWin,
+		gtk.FILE_CHOOSER_ACTION_SAVE,
+		"_Export",
+		"_Cancel",
+	)
+	if err != nil {
+		log.Fatalf("failed to open file chooser: %s", err)
+	}
+	defer ofd.Destroy()
+	ofd.SetDoOverwriteConfirmation(true)
+	name := baseName(d.path)
+	if !isRemote(d.path) {
+		ofd.SetCurrentFolder(filepath.Dir(d.path))
+	}
+	ofd.SetCurrentName(strings.TrimSuffix(name, filepath.Ext(name)) + "-notes.md")
+	if ofd.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return
+	}
+	path := ofd.GetFilename()
+	if path == "" {
+		showErrMsg("Cannot export notes", noLocalPathMsg(ofd.GetURI()))
+		return
+	}
+	if err := ioutil.WriteFile(path, []byte(d.notesReport()), 0644); err != nil {
+		showErrMsg("Cannot export notes", err.Error())
+	}
+}
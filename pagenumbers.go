@@ -0,0 +1,256 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Page numbers are drawn by the session over the pages on saving, like
+// watermarks, so they stay right as pages are moved. The status bar tells
+// when a document has them.
+
+// pageNumberSettings are the settings of page numbers as asked for. Skipped
+// pages are particular to each document and so aren't kept.
+type pageNumberSettings struct {
+	Format   string `json:"format"`
+	Position string `json:"position"`
+	Start    int    `json:"start"`
+}
+
+// defaultPageNumbers are the page number settings first suggested.
+var defaultPageNumbers = pageNumberSettings{
+	Format:   "{n}",
+	Position: "bottom",
+	Start:    1,
+}
+
+// pageNumberFormats are the formats offered for page numbers, besides any
+// typed in.
+var pageNumberFormats = []string{
+	"{n}",
+	"Page {n}",
+	"Page {n} of {total}",
+	"{n} / {total}",
+	"- {n} -",
+}
+
+// pageNumberSize is the font size of page numbers as a fraction of the page
+// width.
+const pageNumberSize = 0.018
+
+// Responses of the page numbers dialog other than the standard ones.
+const responseRemovePageNumbers gtk.ResponseType = 1
+
+// askPageNumbers asks for the settings of page numbers, starting from the
+// current ones of the document if any or else those last used, and the pages
+// to skip. It returns false along with whether to remove the current page
+// numbers, if any, when none are to be applied.
+func askPageNumbers(cur *session.PageNumbering, count int) (ps pageNumberSettings, skip []int, remove, ok bool) {
+	ps = defaultPageNumbers
+	if state.PageNumbers != nil {
+		ps = *state.PageNumbers
+	}
+	var skipSpec string
+	if cur != nil {
+		ps.Format = cur.Format
+		ps.Start = cur.Start
+		for _, p := range imagePositions {
+			if p.x == cur.Placement.AnchorX && p.y == cur.Placement.AnchorY {
+				ps.Position = p.id
+			}
+		}
+		skip = append(skip, cur.Skip...)
+		sort.Ints(skip)
+		skipSpec = formatPages(skip)
+	}
+
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Page Numbers")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if cur != nil {
+		if _, err := dlg.AddButton("Remove", responseRemovePageNumbers); err != nil {
+			log.Fatalf("unable to create dialog button: %s", err)
+		}
+	}
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	okBut, err := dlg.AddButton("Apply", gtk.RESPONSE_OK)
+	if err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+
+	formatCombo, err := gtk.ComboBoxTextNewWithEntry()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	for _, f := range pageNumberFormats {
+		formatCombo.Append(f, f)
+	}
+	formatEntry, err := formatCombo.GetEntry()
+	if err != nil {
+		log.Fatalf("unable to get combo box entry: %s", err)
+	}
+	formatEntry.SetText(ps.Format)
+	formatEntry.SetActivatesDefault(true)
+	formatCombo.SetTooltipText("{n} is replaced by the page number and {total} by the last page number.")
+	addRow("Format", formatCombo)
+
+	posCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	for _, p := range imagePositions {
+		posCombo.Append(p.id, p.label)
+	}
+	if !posCombo.SetActiveID(ps.Position) {
+		posCombo.SetActiveID(defaultPageNumbers.Position)
+	}
+	addRow("Position", posCombo)
+
+	startSpin, err := gtk.SpinButtonNewWithRange(0, 99999, 1)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	startSpin.SetValue(float64(ps.Start))
+	startSpin.SetActivatesDefault(true)
+	startSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("First number", startSpin)
+
+	skipEntry, err := gtk.EntryNew()
+	if err != nil {
+		log.Fatalf("unable to create entry: %s", err)
+	}
+	skipEntry.SetText(skipSpec)
+	skipEntry.SetPlaceholderText("e.g. 1, 10-12")
+	skipEntry.SetTooltipText("Pages left unnumbered, such as a cover. They aren't counted either.")
+	skipEntry.SetActivatesDefault(true)
+	addRow("Skip pages", skipEntry)
+
+	// The format needs a place for the number and the skipped pages have
+	// to make sense before applying
+
+	validate := func() {
+		f, _ := formatEntry.GetText()
+		s, _ := skipEntry.GetText()
+		_, err := parseSkip(s, count)
+		okBut.SetSensitive(strings.Contains(f, "{n}") && err == nil)
+	}
+	formatEntry.Connect("changed", validate)
+	skipEntry.Connect("changed", validate)
+	validate()
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	switch dlg.Run() {
+	case gtk.RESPONSE_OK:
+	case responseRemovePageNumbers:
+		return ps, nil, true, false
+	default:
+		return ps, nil, false, false
+	}
+
+	startSpin.Update()
+	ps.Format, _ = formatEntry.GetText()
+	ps.Position = posCombo.GetActiveID()
+	ps.Start = startSpin.GetValueAsInt()
+	s, _ := skipEntry.GetText()
+	if skip, err = parseSkip(s, count); err != nil {
+		return ps, nil, false, false
+	}
+	state.PageNumbers = &ps
+	saveState()
+	return ps, skip, false, true
+}
+
+// parseSkip parses the pages to leave unnumbered like parsePages, none if
+// spec is blank.
+func parseSkip(spec string, count int) ([]int, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return parsePages(spec, count)
+}
+
+// pageNumbers asks for page numbers and sets them to be drawn on saving, or
+// removes them. Undoing puts back the previous ones.
+func (d *document) pageNumbers() {
+	if !d.changeable() {
+		return
+	}
+	d.sessMu.Lock()
+	prev := d.sess.PageNumbering()
+	count := d.sess.PageCount()
+	d.sessMu.Unlock()
+	ps, skip, remove, ok := askPageNumbers(prev, count)
+	if !ok && !remove {
+		return
+	}
+
+	var n *session.PageNumbering
+	if ok {
+		n = &session.PageNumbering{
+			Format:    ps.Format,
+			Placement: session.TextPlacement{Size: pageNumberSize, Margin: pasteMargin},
+			Start:     ps.Start,
+			Skip:      skip,
+		}
+		n.Placement.AnchorX, n.Placement.AnchorY = imageAnchor(ps.Position)
+	}
+	if !d.setPageNumbering(n) {
+		return
+	}
+	d.pushUndo(func() { d.setPageNumbering(prev) })
+}
+
+// setPageNumbering sets the page numbers of the document, or removes them if
+// nil. It returns false if it failed.
+func (d *document) setPageNumbering(n *session.PageNumbering) bool {
+	d.sessMu.Lock()
+	err := d.sess.SetPageNumbering(n)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot set page numbers", err.Error())
+		return false
+	}
+	d.setModified(true)
+	updateStatus()
+	return true
+}
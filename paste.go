@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Images on the clipboard, such as screenshots or a photo of a signature,
+// can be pasted onto a page without opening the editor.
+
+// imagePositions are where on the page a pasted image can be put.
+var imagePositions = []struct {
+	id, label string
+	x, y      float64
+}{
+	{"top-left", "Top left", 0, 0},
+	{"top", "Top", 0.5, 0},
+	{"top-right", "Top right", 1, 0},
+	{"left", "Left", 0, 0.5},
+	{"center", "Center", 0.5, 0.5},
+	{"right", "Right", 1, 0.5},
+	{"bottom-left", "Bottom left", 0, 1},
+	{"bottom", "Bottom", 0.5, 1},
+	{"bottom-right", "Bottom right", 1, 1},
+}
+
+// imageAnchor returns the anchor of the image position with the given ID,
+// the bottom right corner if there's no such position.
+func imageAnchor(id string) (float64, float64) {
+	for _, pos := range imagePositions {
+		if pos.id == id {
+			return pos.x, pos.y
+		}
+	}
+	return 1, 1
+}
+
+const (
+	defaultPastePosition = "bottom-right"
+	defaultPasteWidth    = 30 // percent of the page width
+	// pasteMargin is the space kept from the page edges as a fraction of the
+	// page width.
+	pasteMargin = 0.05
+	// pastePreviewSize is the size of the image preview in the paste dialog.
+	pastePreviewSize = 160
+)
+
+// clipboardHasImage returns true if there's an image on the clipboard.
+func clipboardHasImage() bool {
+	cb, err := gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+	if err != nil {
+		log.Printf("cannot access clipboard: %s", err)
+		return false
+	}
+	return cb.WaitIsImageAvailable()
+}
+
+// clipboardImage returns the image on the clipboard, or nil if there's none.
+func clipboardImage() *gdk.Pixbuf {
+	cb, err := gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+	if err != nil {
+		log.Printf("cannot access clipboard: %s", err)
+		return nil
+	}
+	pix, err := cb.WaitForImage()
+	if err != nil {
+		return nil
+	}
+	return pix
+}
+
+// askPlacement asks where to put the image on a page, how large and whether
+// to frame it, offering frame as the default. It returns false if cancelled.
+func askPlacement(title string, pix *gdk.Pixbuf, frame bool) (session.ImagePlacement, bool) {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle(title)
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Paste", gtk.RESPONSE_OK); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+
+	// Preview, scaled down to fit
+
+	w, h := pix.GetWidth(), pix.GetHeight()
+	preview := pix
+	if w > pastePreviewSize || h > pastePreviewSize {
+		pw, ph := pastePreviewSize, pastePreviewSize
+		if w > h {
+			ph = h * pastePreviewSize / w
+		} else {
+			pw = w * pastePreviewSize / h
+		}
+		if ph < 1 {
+			ph = 1
+		}
+		if pw < 1 {
+			pw = 1
+		}
+		if p, err := pix.ScaleSimple(pw, ph, gdk.INTERP_BILINEAR); err == nil {
+			preview = p
+		}
+	}
+	img, err := gtk.ImageNewFromPixbuf(preview)
+	if err != nil {
+		log.Fatalf("unable to create image: %s", err)
+	}
+	img.SetTooltipText(fmt.Sprintf("%d × %d pixels", w, h))
+	grid.Attach(img, 0, row, 2, 1)
+	row++
+
+	posCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	for _, p := range imagePositions {
+		posCombo.Append(p.id, p.label)
+	}
+	if !posCombo.SetActiveID(state.PastePosition) {
+		posCombo.SetActiveID(defaultPastePosition)
+	}
+	addRow("Position", posCombo)
+
+	widthSpin, err := gtk.SpinButtonNewWithRange(1, 100, 1)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	width := state.PasteWidth
+	if width == 0 {
+		width = defaultPasteWidth
+	}
+	widthSpin.SetValue(float64(width))
+	widthSpin.SetActivatesDefault(true)
+	widthSpin.SetTooltipText("Width of the image in percent of the page width. It can be adjusted later in Inkscape.")
+	widthSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("Width (%)", widthSpin)
+
+	frameCheck, err := gtk.CheckButtonNewWithLabel("Draw a frame around it")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	frameCheck.SetActive(frame)
+	addRow("", frameCheck)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	if dlg.Run() != gtk.RESPONSE_OK {
+		return session.ImagePlacement{}, false
+	}
+
+	widthSpin.Update()
+	state.PastePosition = posCombo.GetActiveID()
+	state.PasteWidth = widthSpin.GetValueAsInt()
+	saveState()
+
+	p := session.ImagePlacement{
+		Width:  float64(state.PasteWidth) / 100,
+		Margin: pasteMargin,
+		Frame:  frameCheck.GetActive(),
+	}
+	p.AnchorX, p.AnchorY = imageAnchor(state.PastePosition)
+	return p, true
+}
+
+// pasteImage puts the image on the clipboard on the given page, after asking
+// where.
+func (d *document) pasteImage(page int) {
+	if !d.changeable() {
+		return
+	}
+	pix := clipboardImage()
+	if pix == nil {
+		showErrMsg("Cannot paste image", "There's no image on the clipboard.")
+		return
+	}
+	p, ok := askPlacement(fmt.Sprintf("Paste Image on Page %d", page+1), pix, false)
+	if !ok {
+		return
+	}
+	var png bytes.Buffer
+	if err := pix.WritePNG(&png, 9); err != nil {
+		showErrMsg("Cannot paste image", err.Error())
+		return
+	}
+	d.placeImage(page, png.Bytes(), p)
+}
+
+// placeImage adds a PNG image to the annotations of the given page in the
+// background, as creating the annotations may take a moment. Undoing puts
+// back the annotations the page had before.
+func (d *document) placeImage(page int, png []byte, p session.ImagePlacement) {
+	d.place(page, "Cannot paste image", func() error {
+		return d.sess.PlaceImage(context.Background(), page, png, p)
+	}, nil)
+}
+
+// place adds to the annotations of the given page with add, run in the
+// background, and calls then, if set, once done. Failures are shown titled
+// with errTitle. Undoing puts back the annotations the page had before.
+func (d *document) place(page int, errTitle string, add func() error, then func()) {
+	d.sessMu.Lock()
+	prev, err := d.sess.Annotation(page)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg(errTitle, err.Error())
+		return
+	}
+	d.placing = true
+	updateActions()
+	updateStatus()
+
+	workQueue.submit(func() {
+		err = add()
+	}, func() {
+		d.placing = false
+		updateActions()
+		updateStatus()
+		if err != nil {
+			showErrMsg(errTitle, err.Error())
+			return
+		}
+		emitStatus(d.path, fmt.Sprintf("page %d annotated", page+1))
+		d.annotationChanged(page)
+		d.pushUndo(func() { d.unplace(page, prev) })
+		if then != nil {
+			then()
+		}
+	})
+}
+
+// unplace undoes placing something on the given page by putting back its
+// previous annotations, or clearing them if it had none.
+func (d *document) unplace(page int, prev []byte) {
+	var err error
+	d.sessMu.Lock()
+	if prev == nil {
+		err = d.sess.Clear(page)
+	} else {
+		err = d.sess.PutAnnotation(page, prev)
+	}
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot undo", err.Error())
+		return
+	}
+	d.annotationChanged(page)
+}
+
+// annotationChanged refreshes the given page after its annotations changed.
+func (d *document) annotationChanged(page int) {
+	d.updatePage(page)
+	if d.pageAfter[page] {
+		d.reloadThumb(page)
+	}
+	d.setModified(true)
+	updateStatus()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// currentEditor returns the editor settings from the preferences. Monitor
+// positions are looked up at the time of the call as monitors may have been
+// rearranged.
+func currentEditor() session.Editor {
+	e := session.Editor{Command: editorCommand()}
+	switch p := state.EditorPlacement; {
+	case p == "maximized":
+		e.Placement = session.PlaceMaximized
+	case p == "fullscreen":
+		e.Placement = session.PlaceFullscreen
+	case strings.HasPrefix(p, "monitor:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(p, "monitor:"))
+		if err != nil {
+			break
+		}
+		disp, err := gdk.DisplayGetDefault()
+		if err != nil {
+			break
+		}
+		m, err := disp.GetMonitor(n)
+		if err != nil || m == nil {
+			log.Printf("monitor %d for the editor is not connected", n+1)
+			break
+		}
+		geom := m.GetGeometry()
+		e.Placement = session.PlaceMonitor
+		e.X, e.Y = geom.GetX(), geom.GetY()
+	}
+	e.Env = editorEnv()
+	return e
+}
+
+// showPrefs shows the preferences dialog and saves the changes once closed.
+func showPrefs() {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Preferences")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Close", gtk.RESPONSE_CLOSE); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_CLOSE)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+
+	// Editor
+
+	cmdEntry, err := gtk.EntryNew()
+	if err != nil {
+		log.Fatalf("unable to create entry: %s", err)
+	}
+	cmdEntry.SetPlaceholderText(session.DefaultEditorCommand)
+	cmdEntry.SetText(state.EditorCommand)
+	cmdEntry.SetHExpand(true)
+	cmdEntry.SetWidthChars(30)
+	cmdEntry.SetActivatesDefault(true)
+	cmdEntry.SetTooltipText("{file} is replaced by the path of the page SVG to edit.")
+	if config.editor != "" {
+		cmdEntry.SetSensitive(false)
+		cmdEntry.SetTooltipText("Set to " + config.editor + " by editor.command in the config.")
+	}
+	addRow("Editor command", cmdEntry)
+
+	placeCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	placeCombo.Append("", "Where the window manager puts it")
+	placeCombo.Append("maximized", "Maximized")
+	placeCombo.Append("fullscreen", "Fullscreen")
+	if disp, err := gdk.DisplayGetDefault(); err == nil {
+		for i := 0; i < disp.GetNMonitors(); i++ {
+			name := fmt.Sprintf("Monitor %d", i+1)
+			if m, err := disp.GetMonitor(i); err == nil && m != nil && m.GetModel() != "" {
+				name += " (" + m.GetModel() + ")"
+			}
+			placeCombo.Append(fmt.Sprintf("monitor:%d", i), "Maximized on "+name)
+		}
+	}
+	if !placeCombo.SetActiveID(state.EditorPlacement) {
+		placeCombo.SetActiveID("")
+	}
+	placeCombo.SetTooltipText("Placing the window requires wmctrl and an X11 session.")
+	addRow("Editor window", placeCombo)
+
+	// Startup
+
+	reopenCheck, err := gtk.CheckButtonNewWithLabel("Reopen the last document")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	reopenCheck.SetActive(state.ReopenLast)
+	reopenCheck.SetTooltipText("When started without files, open the document which was open when last quit.")
+	addRow("On startup", reopenCheck)
+
+	updateCheck, err := gtk.CheckButtonNewWithLabel("Check for new versions")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	updateCheck.SetActive(state.CheckUpdates)
+	updateCheck.SetTooltipText("At most once a day, ask GitHub for the latest release and tell if it's newer. " +
+		"Nothing about you or your documents is sent.")
+	addRow("", updateCheck)
+
+	autosaveSpin, err := gtk.SpinButtonNewWithRange(0, 60, 1)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	autosaveSpin.SetValue(float64(state.AutosaveMinutes))
+	autosaveSpin.SetTooltipText("Minutes between saving unsaved changes for recovery after a crash. 0 turns it off.")
+	autosaveSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("Auto-save every", autosaveSpin)
+
+	tempCapSpin, err := gtk.SpinButtonNewWithRange(0, 100000, 100)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	tempCapSpin.SetValue(float64(state.TempCapMB))
+	tempCapSpin.SetTooltipText("MiB of temporary files kept for each document. Renders and exports of pages " +
+		"are removed beyond it, least recently used first, and made again when needed. 0 keeps them all.")
+	tempCapSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("Temporary files up to", tempCapSpin)
+
+	inPlaceCheck, err := gtk.CheckButtonNewWithLabel("Open documents without copying them")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	inPlaceCheck.SetActive(state.OpenInPlace)
+	inPlaceCheck.SetTooltipText("Opening large PDFs is quicker, but they must not change while open and can't " +
+		"be saved over. PDFs on read-only media are never copied.")
+	addRow("On opening", inPlaceCheck)
+
+	// Annotations
+
+	sidecarCheck, err := gtk.CheckButtonNewWithLabel("Keep beside the PDF")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	sidecarCheck.SetActive(state.SidecarAnnotations)
+	sidecarCheck.SetTooltipText("Keep the annotations of NAME.pdf in NAME.pdfrann so they survive restarts. " +
+		"Applies to documents opened afterwards.")
+	addRow("Annotations", sidecarCheck)
+
+	// Saving
+
+	manifestCheck, err := gtk.CheckButtonNewWithLabel("Write an archival manifest")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	manifestCheck.SetActive(state.ArchivalManifest)
+	manifestCheck.SetTooltipText("Write NAME.pdf" + manifestSuffix + " beside each saved PDF with the SHA-256 " +
+		"checksums of the opened and saved PDFs and the versions of the tools used.")
+	addRow("On saving", manifestCheck)
+
+	// Signing
+
+	addRow("Initials", newSignImageRow("Choose Initials Image", initialsFile))
+	addRow("Signature", newSignImageRow("Choose Signature Image", signatureFile))
+	dateRow, saveDateStamp := newDateStampRow()
+	addRow("Date stamps", dateRow)
+
+	// File managers
+
+	fmBut, err := gtk.ButtonNewWithLabel("Add to Context Menus")
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	fmBut.SetTooltipText("Add \"" + contextMenuLabel + "\" to the context menu of PDFs in Nautilus, Dolphin and Thunar.")
+	fmBut.SetHAlign(gtk.ALIGN_START)
+	fmBut.Connect("clicked", func() { addToContextMenus() })
+	addRow("File managers", fmBut)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	_ = dlg.Run()
+
+	cmd, err := cmdEntry.GetText()
+	if err == nil {
+		state.EditorCommand = strings.TrimSpace(cmd)
+	}
+	state.EditorPlacement = placeCombo.GetActiveID()
+	state.ReopenLast = reopenCheck.GetActive()
+	state.CheckUpdates = updateCheck.GetActive()
+	state.OpenInPlace = inPlaceCheck.GetActive()
+	state.SidecarAnnotations = sidecarCheck.GetActive()
+	state.ArchivalManifest = manifestCheck.GetActive()
+	saveDateStamp()
+	autosaveSpin.Update()
+	if m := autosaveSpin.GetValueAsInt(); m != state.AutosaveMinutes {
+		state.AutosaveMinutes = m
+		startAutosave()
+	}
+	tempCapSpin.Update()
+	if c := tempCapSpin.GetValueAsInt(); c != state.TempCapMB {
+		state.TempCapMB = c
+		for _, d := range docs {
+			d.sess.SetDiskCap(tempCap())
+		}
+	}
+	saveState()
+}
+
+// tempCap returns the size in bytes the temporary files of each document are
+// kept under, or 0 if unlimited.
+func tempCap() int64 {
+	return int64(state.TempCapMB) << 20
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// printDPI is the resolution pages are rasterized at for printing, unless
+// set in the config or in low-resource mode.
+const printDPI = 300
+
+// print prints the document in its current annotated state. Pages are
+// rendered through the save pipeline into images which are then scaled to fit
+// the printed page.
+func (d *document) print() {
+	if d.busy() {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "pdfrankenstein-print-*")
+	if err != nil {
+		showErrMsg("Cannot print", fmt.Sprintf("failed to create temp directory: %s", err))
+		return
+	}
+
+	mainWin.SetSensitive(false)
+	d.saving = true
+	updateStatus()
+
+	dpi := defaultDPI(printDPI)
+	var pages []string
+	workQueue.submit(func() {
+		pages, err = d.sess.ExportImages(dir, "png", dpi)
+	}, func() {
+		defer os.RemoveAll(dir)
+		mainWin.SetSensitive(true)
+		d.saving = false
+		updateStatus()
+		if err != nil {
+			showErrMsg("Cannot print", err.Error())
+			return
+		}
+		if err := runPrint(suggestedSaveName(d.path), pages); err != nil {
+			showErrMsg("Cannot print", err.Error())
+		}
+	})
+}
+
+// runPrint shows the print dialog and prints the given page images.
+func runPrint(job string, pages []string) error {
+	op, err := gtk.PrintOperationNew()
+	if err != nil {
+		return fmt.Errorf("failed to create print operation: %s", err)
+	}
+	op.SetJobName(job)
+	op.SetNPages(len(pages))
+	op.Connect("draw-page", func(_ *gtk.PrintOperation, ctx *gtk.PrintContext, page int) {
+		img, err := cairo.NewSurfaceFromPNG(pages[page])
+		if err != nil {
+			log.Printf("failed to load page %d for printing: %s", page+1, err)
+			return
+		}
+		w, h := float64(img.GetWidth()), float64(img.GetHeight())
+		scale := ctx.GetWidth() / w
+		if s := ctx.GetHeight() / h; s < scale {
+			scale = s
+		}
+		cr := ctx.GetCairoContext()
+		cr.Translate((ctx.GetWidth()-w*scale)/2, (ctx.GetHeight()-h*scale)/2)
+		cr.Scale(scale, scale)
+		cr.SetSourceSurface(img, 0, 0)
+		cr.Paint()
+	})
+	_, err = op.Run(gtk.PRINT_OPERATION_ACTION_PRINT_DIALOG, mainWin)
+	return err
+}
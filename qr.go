@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// QR codes link printed documents back to where they're found online. They
+// go into the annotations like pasted images, so they can be moved in the
+// editor later.
+const (
+	defaultQRPosition = "bottom-left"
+	defaultQRWidth    = 12 // percent of the page width
+)
+
+// askQRCode asks for the text of a QR code for the given page, where to put
+// it and how large. It returns false if cancelled.
+func askQRCode(page int) (string, session.ImagePlacement, bool) {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle(fmt.Sprintf("QR Code on Page %d", page+1))
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	okBut, err := dlg.AddButton("Place", gtk.RESPONSE_OK)
+	if err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+
+	textEntry, err := gtk.EntryNew()
+	if err != nil {
+		log.Fatalf("unable to create entry: %s", err)
+	}
+	textEntry.SetText(state.QRText)
+	textEntry.SetPlaceholderText("https://")
+	textEntry.SetWidthChars(40)
+	textEntry.SetActivatesDefault(true)
+	textEntry.Connect("changed", func() {
+		text, _ := textEntry.GetText()
+		okBut.SetSensitive(strings.TrimSpace(text) != "")
+	})
+	okBut.SetSensitive(strings.TrimSpace(state.QRText) != "")
+	addRow("Text or URL", textEntry)
+
+	posCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	for _, p := range imagePositions {
+		posCombo.Append(p.id, p.label)
+	}
+	if !posCombo.SetActiveID(state.QRPosition) {
+		posCombo.SetActiveID(defaultQRPosition)
+	}
+	addRow("Position", posCombo)
+
+	widthSpin, err := gtk.SpinButtonNewWithRange(1, 100, 1)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	width := state.QRWidth
+	if width == 0 {
+		width = defaultQRWidth
+	}
+	widthSpin.SetValue(float64(width))
+	widthSpin.SetActivatesDefault(true)
+	widthSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("Width (%)", widthSpin)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	if dlg.Run() != gtk.RESPONSE_OK {
+		return "", session.ImagePlacement{}, false
+	}
+
+	widthSpin.Update()
+	text, _ := textEntry.GetText()
+	state.QRText = strings.TrimSpace(text)
+	state.QRPosition = posCombo.GetActiveID()
+	state.QRWidth = widthSpin.GetValueAsInt()
+	saveState()
+
+	p := session.ImagePlacement{Width: float64(state.QRWidth) / 100, Margin: pasteMargin}
+	p.AnchorX, p.AnchorY = imageAnchor(state.QRPosition)
+	return state.QRText, p, true
+}
+
+// placeQRCode asks for the text of a QR code and puts it on the given page.
+func (d *document) placeQRCode(page int) {
+	if !d.changeable() {
+		return
+	}
+	text, p, ok := askQRCode(page)
+	if !ok {
+		return
+	}
+	d.place(page, "Cannot place QR code", func() error {
+		return d.sess.PlaceQRCode(context.Background(), page, text, p)
+	}, nil)
+}
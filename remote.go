@@ -0,0 +1,114 @@
+package main
+
+// #cgo pkg-config: gio-2.0
+// #include <gio/gio.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+// Documents can be opened from and saved to locations which are only
+// reachable through GIO, such as sftp://, smb:// or Google Drive mounts
+// without a FUSE path, or on WebDAV servers. They're copied to a local
+// temporary directory, with the gio tool or over HTTP for WebDAV, and worked
+// on there, and saving copies the result back.
+
+// gFileURI returns the URI of the given GFile.
+func gFileURI(f *glib.File) string {
+	c := C.g_file_get_uri((*C.GFile)(unsafe.Pointer(f.Native())))
+	defer C.g_free(C.gpointer(c))
+	return C.GoString(c)
+}
+
+// isRemote returns true if path is the URI of a location other than a local
+// file.
+func isRemote(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && u.Scheme != "" && u.Scheme != "file" && strings.Contains(path, "://")
+}
+
+// baseName returns the file name of a local path or remote URI, unescaped.
+func baseName(p string) string {
+	if !isRemote(p) {
+		return filepath.Base(p)
+	}
+	u, _ := url.Parse(p)
+	return path.Base(u.Path)
+}
+
+// dirName returns the directory of a local path or remote URI.
+func dirName(p string) string {
+	if !isRemote(p) {
+		return filepath.Dir(p)
+	}
+	u, _ := url.Parse(p)
+	u.Path = path.Dir(u.Path)
+	u.RawPath = ""
+	return u.String()
+}
+
+// gioCopy copies a file between local paths and URIs, replacing dst.
+func gioCopy(src, dst string) error {
+	_, err := exec.Command("gio", "copy", "--no-target-directory", src, dst).Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("gio was not found. Install the GLib tools (libglib2.0-bin or glib2) to use remote locations.")
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("failed to copy '%s' to '%s': %s", src, dst, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy '%s' to '%s': %s", src, dst, err)
+	}
+	return nil
+}
+
+// fetchRemote copies the remote document at uri to a new temporary directory
+// and returns its local path. The directory is for the caller to remove.
+func fetchRemote(uri string) (string, error) {
+	dir, err := ioutil.TempDir("", "pdfrankenstein-remote-*")
+	if err != nil {
+		return "", err
+	}
+	local := filepath.Join(dir, baseName(uri))
+	if isWebDAV(uri) {
+		err = davGet(uri, local)
+	} else {
+		err = gioCopy(uri, local)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	return local, nil
+}
+
+// saveRemote saves a document to the remote location at uri by writing it
+// locally with save first.
+func saveRemote(uri string, save func(path string) error) error {
+	dir, err := ioutil.TempDir("", "pdfrankenstein-remote-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, baseName(uri))
+	if err := save(local); err != nil {
+		return err
+	}
+	if isWebDAV(uri) {
+		return davPut(local, uri)
+	}
+	return gioCopy(local, uri)
+}
@@ -0,0 +1,208 @@
+// Package rpc serves the session package over gRPC, as defined in
+// session.proto.
+//
+// The service has no authentication of its own. Annotations clients put can
+// refer to any file the server's user can read, which then ends up in what's
+// saved, so it must only be served to trusted clients, e.g. on a Unix socket
+// only that user can connect to.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative session.proto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// MaxMessageSize is the largest message sent or received. Open and Save carry
+// whole PDFs, which are often beyond gRPC's default limit of 4 MiB.
+const MaxMessageSize = 1 << 30
+
+// ServerOptions are the options gRPC servers of the service are to be
+// created with so they take PDFs up to MaxMessageSize.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(MaxMessageSize),
+		grpc.MaxSendMsgSize(MaxMessageSize),
+	}
+}
+
+// DialOptions are the options clients of the service are to be created with
+// so they take saved PDFs up to MaxMessageSize.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithDefaultCallOptions(
+		grpc.MaxCallRecvMsgSize(MaxMessageSize),
+		grpc.MaxCallSendMsgSize(MaxMessageSize),
+	)}
+}
+
+// openSession is a session opened by a client, along with the directory
+// holding the PDF it was opened on.
+type openSession struct {
+	// mu serializes calls on the session since saving and rendering share its
+	// intermediate files.
+	mu   sync.Mutex
+	sess *session.Session
+	dir  string
+}
+
+// Server implements the Session service. Sessions live until clients close
+// them or the server is shut down.
+type Server struct {
+	UnimplementedSessionServer
+
+	mu       sync.Mutex
+	sessions map[string]*openSession
+}
+
+// NewServer creates a server with no sessions.
+func NewServer() *Server {
+	return &Server{sessions: map[string]*openSession{}}
+}
+
+// Shutdown closes all the sessions.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, o := range s.sessions {
+		o.close()
+		delete(s.sessions, id)
+	}
+}
+
+func (o *openSession) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sess.Close()
+	_ = os.RemoveAll(o.dir)
+}
+
+// get returns the session with the given ID, locked.
+func (s *Server) get(id string) (*openSession, error) {
+	s.mu.Lock()
+	o, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no session with ID '%s'", id)
+	}
+	o.mu.Lock()
+	return o, nil
+}
+
+// getPage returns the session with the given ID, locked, after checking the
+// page is in it.
+func (s *Server) getPage(id string, page int32) (*openSession, error) {
+	o, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if page < 0 || int(page) >= o.sess.PageCount() {
+		o.mu.Unlock()
+		return nil, status.Errorf(codes.OutOfRange, "page %d is outside of 0-%d", page, o.sess.PageCount()-1)
+	}
+	return o, nil
+}
+
+func (s *Server) Open(ctx context.Context, req *OpenRequest) (*OpenResponse, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create session ID: %s", err)
+	}
+	id := hex.EncodeToString(b)
+
+	dir, err := ioutil.TempDir("", "pdfrankenstein-rpc-*")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create session directory: %s", err)
+	}
+	path := filepath.Join(dir, "src.pdf")
+	if err := ioutil.WriteFile(path, req.Pdf, 0644); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, status.Errorf(codes.Internal, "cannot write PDF: %s", err)
+	}
+	sess, err := session.New(path)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, status.Errorf(codes.InvalidArgument, "cannot open PDF: %s", err)
+	}
+
+	s.mu.Lock()
+	s.sessions[id] = &openSession{sess: sess, dir: dir}
+	s.mu.Unlock()
+	return &OpenResponse{SessionId: id, PageCount: int32(sess.PageCount())}, nil
+}
+
+func (s *Server) Thumbnail(ctx context.Context, req *ThumbnailRequest) (*ThumbnailResponse, error) {
+	size := int(req.Size)
+	if size == 0 {
+		size = session.ThumbnailSize
+	}
+	if size < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid size %d", size)
+	}
+	o, err := s.getPage(req.SessionId, req.Page)
+	if err != nil {
+		return nil, err
+	}
+	defer o.mu.Unlock()
+	path, err := o.sess.Render(int(req.Page), size)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%s", err)
+	}
+	png, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot read thumbnail: %s", err)
+	}
+	return &ThumbnailResponse{Png: png}, nil
+}
+
+func (s *Server) PutAnnotation(ctx context.Context, req *PutAnnotationRequest) (*PutAnnotationResponse, error) {
+	o, err := s.getPage(req.SessionId, req.Page)
+	if err != nil {
+		return nil, err
+	}
+	defer o.mu.Unlock()
+	if err := o.sess.PutAnnotation(int(req.Page), req.Svg); err != nil {
+		return nil, status.Errorf(codes.Internal, "%s", err)
+	}
+	return &PutAnnotationResponse{}, nil
+}
+
+func (s *Server) Save(ctx context.Context, req *SaveRequest) (*SaveResponse, error) {
+	o, err := s.get(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer o.mu.Unlock()
+	path := filepath.Join(o.dir, "out.pdf")
+	if err := o.sess.SaveContext(ctx, path); err != nil {
+		return nil, status.Errorf(codes.Internal, "%s", err)
+	}
+	pdf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot read saved PDF: %s", err)
+	}
+	return &SaveResponse{Pdf: pdf}, nil
+}
+
+func (s *Server) Close(ctx context.Context, req *CloseRequest) (*CloseResponse, error) {
+	s.mu.Lock()
+	o, ok := s.sessions[req.SessionId]
+	delete(s.sessions, req.SessionId)
+	s.mu.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no session with ID '%s'", req.SessionId)
+	}
+	o.close()
+	return &CloseResponse{}, nil
+}
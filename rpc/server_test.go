@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/oxplot/pdfrankenstein/session"
+	"github.com/oxplot/pdfrankenstein/session/sessiontest"
+)
+
+func TestMain(m *testing.M) {
+	sessiontest.Main(m, session.SetToolPath)
+}
+
+// dial serves a new server in memory and returns a client of it.
+func dial(t *testing.T) SessionClient {
+	l := bufconn.Listen(1 << 20)
+	srv := NewServer()
+	gs := grpc.NewServer(ServerOptions()...)
+	RegisterSessionServer(gs, srv)
+	go func() { _ = gs.Serve(l) }()
+	t.Cleanup(func() {
+		gs.Stop()
+		srv.Shutdown()
+	})
+
+	opts := append(DialOptions(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}))
+	conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return NewSessionClient(conn)
+}
+
+func TestLargeDocument(t *testing.T) {
+	c := dial(t)
+	ctx := context.Background()
+
+	// Well over gRPC's default limit of 4 MiB, both ways
+
+	path := filepath.Join(t.TempDir(), "large.pdf")
+	page := sessiontest.Page{Width: "595", Height: "842", Content: []string{strings.Repeat("x", 6<<20)}}
+	if err := sessiontest.WritePDF(path, []sessiontest.Page{page}); err != nil {
+		t.Fatal(err)
+	}
+	pdf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := c.Open(ctx, &OpenRequest{Pdf: pdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.PageCount != 1 {
+		t.Errorf("got %d pages, want 1", opened.PageCount)
+	}
+	saved, err := c.Save(ctx, &SaveRequest{SessionId: opened.SessionId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Pdf, pdf) {
+		t.Errorf("saved %d bytes, want the %d opened", len(saved.Pdf), len(pdf))
+	}
+	if _, err := c.Close(ctx, &CloseRequest{SessionId: opened.SessionId}); err != nil {
+		t.Fatal(err)
+	}
+}
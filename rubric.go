@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Grading with a rubric places stamps worth points on pages. The points of
+// all stamps in a document are tallied into its score, which can be stamped
+// on its first page and, while grading a folder, is recorded in a CSV file
+// beside the graded submissions.
+const scoresFileName = "scores.csv"
+
+// rubricItem is a stamp of the rubric.
+type rubricItem struct {
+	Label  string  `json:"label"`
+	Points float64 `json:"points"`
+}
+
+// String returns the item as shown in menus and the rubric editor.
+func (r rubricItem) String() string {
+	if r.Label == "" {
+		return session.FormatPoints(r.Points)
+	}
+	return session.FormatPoints(r.Points) + " " + r.Label
+}
+
+// parseRubric parses a rubric with one item per line, the points first and
+// the label after, e.g. "+2 Clear thesis". Blank lines are skipped.
+func parseRubric(text string) ([]rubricItem, error) {
+	var items []rubricItem
+	for i, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		points, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d doesn't start with points: %s", i+1, strings.TrimSpace(line))
+		}
+		items = append(items, rubricItem{Label: strings.Join(fields[1:], " "), Points: points})
+	}
+	return items, nil
+}
+
+// formatScore formats a score, out of the rubric's total if set.
+func formatScore(score float64) string {
+	s := strconv.FormatFloat(score, 'f', -1, 64)
+	if state.RubricOutOf > 0 {
+		s += " / " + strconv.FormatFloat(state.RubricOutOf, 'f', -1, 64)
+	}
+	return s
+}
+
+// showRubric shows the rubric editor and saves the rubric once accepted.
+func showRubric() {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Rubric")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Save", gtk.RESPONSE_OK); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		l.SetVAlign(gtk.ALIGN_START)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+
+	hint, err := gtk.LabelNew("One stamp per line, the points first and the label after, " +
+		"e.g. \"+2 Clear thesis\" or \"-1 Missing citation\".")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	hint.SetLineWrap(true)
+	hint.SetMaxWidthChars(50)
+	hint.SetHAlign(gtk.ALIGN_START)
+	grid.Attach(hint, 0, row, 2, 1)
+	row++
+
+	tv, err := gtk.TextViewNew()
+	if err != nil {
+		log.Fatalf("unable to create text view: %s", err)
+	}
+	tv.SetLeftMargin(5)
+	tv.SetRightMargin(5)
+	buf, err := tv.GetBuffer()
+	if err != nil {
+		log.Fatalf("unable to get text buffer: %s", err)
+	}
+	var lines []string
+	for _, r := range state.Rubric {
+		lines = append(lines, r.String())
+	}
+	buf.SetText(strings.Join(lines, "\n"))
+	sw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatalf("unable to create scrolled window: %s", err)
+	}
+	sw.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	sw.SetShadowType(gtk.SHADOW_IN)
+	sw.SetSizeRequest(400, 200)
+	sw.SetHExpand(true)
+	sw.SetVExpand(true)
+	sw.Add(tv)
+	addRow("Stamps", sw)
+
+	outOfSpin, err := gtk.SpinButtonNewWithRange(0, 1000, 1)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	outOfSpin.SetDigits(1)
+	outOfSpin.SetValue(state.RubricOutOf)
+	outOfSpin.SetTooltipText("The score is given out of this. 0 leaves it out.")
+	outOfSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("Out of", outOfSpin)
+
+	stampCheck, err := gtk.CheckButtonNewWithLabel("Stamp the score on the first page of graded submissions")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	stampCheck.SetActive(state.StampScore)
+	addRow("", stampCheck)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+
+	for dlg.Run() == gtk.RESPONSE_OK {
+		text, err := buf.GetText(buf.GetStartIter(), buf.GetEndIter(), false)
+		if err != nil {
+			log.Fatalf("unable to get text: %s", err)
+		}
+		items, err := parseRubric(text)
+		if err != nil {
+			showErrMsg("Invalid rubric", err.Error())
+			continue
+		}
+		state.Rubric = items
+		outOfSpin.Update()
+		state.RubricOutOf = outOfSpin.GetValue()
+		state.StampScore = stampCheck.GetActive()
+		saveState()
+		updateStatus()
+		return
+	}
+}
+
+// newStampMenu creates the submenu of the page menu for placing rubric
+// stamps on the given page.
+func (d *document) newStampMenu(page int) *gtk.Menu {
+	m, err := gtk.MenuNew()
+	if err != nil {
+		log.Fatalf("unable to create menu: %s", err)
+	}
+	for _, r := range state.Rubric {
+		r := r
+		item, err := gtk.MenuItemNewWithLabel(r.String())
+		if err != nil {
+			log.Fatalf("unable to create menu item: %s", err)
+		}
+		item.Connect("activate", func() { d.placeStamp(page, r) })
+		item.SetSensitive(d.changeable())
+		m.Append(item)
+	}
+	if len(state.Rubric) > 0 {
+		sep, err := gtk.SeparatorMenuItemNew()
+		if err != nil {
+			log.Fatalf("unable to create menu separator: %s", err)
+		}
+		m.Append(sep)
+	}
+	editItem, err := gtk.MenuItemNewWithLabel("Edit Rubric…")
+	if err != nil {
+		log.Fatalf("unable to create menu item: %s", err)
+	}
+	editItem.Connect("activate", func() { showRubric() })
+	m.Append(editItem)
+	return m
+}
+
+// placeStamp places a stamp of the rubric on the given page.
+func (d *document) placeStamp(page int, r rubricItem) {
+	if !d.changeable() {
+		return
+	}
+	d.place(page, "Cannot place stamp", func() error {
+		return d.sess.PlaceStamp(context.Background(), page, r.Label, r.Points)
+	}, nil)
+}
+
+// score returns the total points of the stamps in the document and the
+// number of stamps.
+func (d *document) score() (float64, int, error) {
+	d.sessMu.Lock()
+	stamps, err := d.sess.Stamps()
+	d.sessMu.Unlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	var score float64
+	for _, s := range stamps {
+		score += s.Points
+	}
+	return score, len(stamps), nil
+}
+
+// stampScore puts the score on the first page, replacing the one put there
+// before, and calls then, if set, once done.
+func (d *document) stampScore(then func()) {
+	if !d.changeable() {
+		return
+	}
+	score, n, err := d.score()
+	if err != nil {
+		showErrMsg("Cannot stamp score", err.Error())
+		return
+	}
+	if n == 0 {
+		showErrMsg("Cannot stamp score", baseName(d.path)+" has no rubric stamps.")
+		return
+	}
+	d.place(0, "Cannot stamp score", func() error {
+		return d.sess.PlaceScore(context.Background(), "Score: "+formatScore(score))
+	}, then)
+}
+
+// recordScore records the score of the graded submission at path in the
+// scores file of the folder, replacing its previous score if any.
+func recordScore(path string, score float64, stamps int) error {
+	csvPath := filepath.Join(filepath.Dir(path), gradedDirName, scoresFileName)
+	var rows [][]string
+	if f, err := os.Open(csvPath); err == nil {
+		rows, err = csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %s", csvPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(rows) == 0 {
+		rows = [][]string{{"file", "score", "out_of", "stamps"}}
+	}
+	row := []string{
+		filepath.Base(path),
+		strconv.FormatFloat(score, 'f', -1, 64),
+		strconv.FormatFloat(state.RubricOutOf, 'f', -1, 64),
+		strconv.Itoa(stamps),
+	}
+	found := false
+	for i, r := range rows[1:] {
+		if len(r) > 0 && r[0] == row[0] {
+			rows[i+1] = row
+			found = true
+		}
+	}
+	if !found {
+		rows = append(rows, row)
+	}
+
+	f, err := os.Create(csvPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write '%s': %s", csvPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(csvPath+".tmp", csvPath)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// Recent documents show up when searching in the GNOME Shell overview. The
+// search provider runs as its own process, started through D-Bus activation
+// by the shell, so that searching doesn't bring up the app. Activating a
+// result runs the app with the document, which hands it over to the running
+// instance if there's one. The shell and D-Bus learn of the provider from
+// pdfrankenstein-search-provider.ini and the .service file next to it.
+const (
+	searchName  = "org.oxplot.PDFrankenstein.SearchProvider"
+	searchPath  = dbus.ObjectPath("/org/oxplot/PDFrankenstein/SearchProvider")
+	searchIface = "org.gnome.Shell.SearchProvider2"
+	// searchIdle is how long the provider waits for the next search before
+	// exiting.
+	searchIdle = time.Minute
+)
+
+const searchIntro = `<interface name="` + searchIface + `">
+	<method name="GetInitialResultSet">
+		<arg name="terms" type="as" direction="in"/>
+		<arg name="results" type="as" direction="out"/>
+	</method>
+	<method name="GetSubsearchResultSet">
+		<arg name="previous_results" type="as" direction="in"/>
+		<arg name="terms" type="as" direction="in"/>
+		<arg name="results" type="as" direction="out"/>
+	</method>
+	<method name="GetResultMetas">
+		<arg name="identifiers" type="as" direction="in"/>
+		<arg name="metas" type="aa{sv}" direction="out"/>
+	</method>
+	<method name="ActivateResult">
+		<arg name="identifier" type="s" direction="in"/>
+		<arg name="terms" type="as" direction="in"/>
+		<arg name="timestamp" type="u" direction="in"/>
+	</method>
+	<method name="LaunchSearch">
+		<arg name="terms" type="as" direction="in"/>
+		<arg name="timestamp" type="u" direction="in"/>
+	</method>
+</interface>`
+
+// searchProvider implements the GNOME Shell search provider interface.
+// Result identifiers are the paths of recent documents.
+type searchProvider struct {
+	// active is signalled on each call to keep the provider running.
+	active chan struct{}
+	// mu guards loading the state, which is read afresh for each search
+	// since the app changes it meanwhile.
+	mu sync.Mutex
+}
+
+// touch postpones exiting for being idle.
+func (p *searchProvider) touch() {
+	select {
+	case p.active <- struct{}{}:
+	default:
+	}
+}
+
+// matchesTerms returns those of paths whose file name contains all of the
+// terms, ignoring case.
+func matchesTerms(paths, terms []string) []string {
+	var res []string
+next:
+	for _, path := range paths {
+		name := strings.ToLower(baseName(path))
+		for _, t := range terms {
+			if !strings.Contains(name, strings.ToLower(t)) {
+				continue next
+			}
+		}
+		res = append(res, path)
+	}
+	return res
+}
+
+// recentFiles returns the recent documents, leaving out the local ones which
+// no longer exist.
+func (p *searchProvider) recentFiles() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state = appState{}
+	loadState()
+	var paths []string
+	for _, path := range state.RecentFiles {
+		if _, err := os.Stat(path); err != nil && !isRemote(path) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (p *searchProvider) GetInitialResultSet(terms []string) ([]string, *dbus.Error) {
+	p.touch()
+	return matchesTerms(p.recentFiles(), terms), nil
+}
+
+func (p *searchProvider) GetSubsearchResultSet(prev, terms []string) ([]string, *dbus.Error) {
+	p.touch()
+	return matchesTerms(prev, terms), nil
+}
+
+func (p *searchProvider) GetResultMetas(ids []string) ([]map[string]dbus.Variant, *dbus.Error) {
+	p.touch()
+	metas := make([]map[string]dbus.Variant, len(ids))
+	for i, id := range ids {
+		metas[i] = map[string]dbus.Variant{
+			"id":          dbus.MakeVariant(id),
+			"name":        dbus.MakeVariant(baseName(id)),
+			"description": dbus.MakeVariant(shrinkHome(dirName(id))),
+			"gicon":       dbus.MakeVariant("pdfrankenstein"),
+		}
+	}
+	return metas, nil
+}
+
+func (p *searchProvider) ActivateResult(id string, terms []string, timestamp uint32) *dbus.Error {
+	p.touch()
+	if err := launchApp(id); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// LaunchSearch opens the app since it has no search of its own.
+func (p *searchProvider) LaunchSearch(terms []string, timestamp uint32) *dbus.Error {
+	p.touch()
+	if err := launchApp(); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// launchApp runs the app with the given arguments without waiting for it.
+func launchApp(args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }()
+	return nil
+}
+
+func searchProviderCmd(fs *flag.FlagSet) func(pos []string) error {
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pdfrankenstein __search-provider")
+		fmt.Fprintln(fs.Output(), "\nServes the GNOME Shell search provider. It's started by D-Bus activation.")
+	}
+	return func(pos []string) error {
+		if len(pos) != 0 {
+			fs.Usage()
+			return errors.New("unexpected arguments")
+		}
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		p := &searchProvider{active: make(chan struct{}, 1)}
+		if err := conn.Export(p, searchPath, searchIface); err != nil {
+			return err
+		}
+		intro := introspect.Introspectable("<node>" + searchIntro + introspect.IntrospectDataString + "</node>")
+		if err := conn.Export(intro, searchPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+			return err
+		}
+		reply, err := conn.RequestName(searchName, dbus.NameFlagDoNotQueue)
+		if err != nil {
+			return err
+		}
+		if reply != dbus.RequestNameReplyPrimaryOwner {
+			return fmt.Errorf("name %s is taken", searchName)
+		}
+
+		// The shell starts the provider again when needed
+
+		idle := time.NewTimer(searchIdle)
+		for {
+			select {
+			case <-p.active:
+				if !idle.Stop() {
+					<-idle.C
+				}
+				idle.Reset(searchIdle)
+			case <-idle.C:
+				return nil
+			}
+		}
+	}
+}
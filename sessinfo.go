@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+const responsePurge gtk.ResponseType = 1
+
+// showInfo shows the intermediate files of the document's session and
+// offers to purge the ones which can be regenerated.
+func (d *document) showInfo() {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Session Info")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	dlg.SetDefaultSize(480, 400)
+
+	purgeBut, err := dlg.AddButton("Purge Cache", responsePurge)
+	if err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	purgeBut.SetTooltipText("Remove thumbnails, previews and unannotated page SVGs. Annotations are kept.")
+	if _, err := dlg.AddButton("Close", gtk.RESPONSE_CLOSE); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_CLOSE)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.SetSpacing(10)
+	con.SetMarginTop(10)
+	con.SetMarginBottom(10)
+	con.SetMarginStart(10)
+	con.SetMarginEnd(10)
+
+	d.sessMu.Lock()
+	tmpDir := d.sess.TmpDir()
+	d.sessMu.Unlock()
+	dirLbl, err := gtk.LabelNew("Temporary files are kept in " + tmpDir)
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	dirLbl.SetSelectable(true)
+	dirLbl.SetLineWrap(true)
+	dirLbl.SetHAlign(gtk.ALIGN_START)
+	con.PackStart(dirLbl, false, false, 0)
+
+	scr, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		log.Fatalf("unable to create scrolled window: %s", err)
+	}
+	con.PackStart(scr, true, true, 0)
+
+	totalLbl, err := gtk.LabelNew("")
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	totalLbl.SetHAlign(gtk.ALIGN_START)
+	con.PackStart(totalLbl, false, false, 0)
+
+	// fill lists the per page usage, replacing the previous listing.
+	fill := func() {
+		scr.GetChildren().Foreach(func(i any) {
+			if c, ok := i.(gtk.IWidget); ok {
+				scr.Remove(c)
+			}
+		})
+		grid, err := gtk.GridNew()
+		if err != nil {
+			log.Fatalf("unable to create grid: %s", err)
+		}
+		grid.SetColumnSpacing(20)
+		grid.SetRowSpacing(4)
+		cell := func(text string, col, row int) {
+			l, err := gtk.LabelNew("")
+			if err != nil {
+				log.Fatalf("unable to create label: %s", err)
+			}
+			if row == 0 {
+				l.SetMarkup("<b>" + text + "</b>")
+			} else {
+				l.SetText(text)
+			}
+			l.SetXAlign(1)
+			grid.Attach(l, col, row, 1, 1)
+		}
+		cell("Page", 0, 0)
+		cell("Thumbnail", 1, 0)
+		cell("Preview", 2, 0)
+		cell("Source SVG", 3, 0)
+		cell("Annotation", 4, 0)
+
+		d.sessMu.Lock()
+		for p := 0; p < d.sess.PageCount(); p++ {
+			u := d.sess.PageUsage(p)
+			cell(strconv.Itoa(p+1), 0, p+1)
+			cell(formatSize(u.Thumbnail), 1, p+1)
+			cell(formatSize(u.Preview), 2, p+1)
+			cell(formatSize(u.Source), 3, p+1)
+			cell(formatSize(u.Annotation), 4, p+1)
+		}
+		usage, err := d.sess.DiskUsage()
+		d.sessMu.Unlock()
+		if err != nil {
+			totalLbl.SetText(fmt.Sprintf("Cannot determine total disk usage: %s", err))
+		} else {
+			totalLbl.SetText("Total disk usage: " + formatSize(usage))
+		}
+		scr.Add(grid)
+		grid.ShowAll()
+	}
+	fill()
+
+	dlg.ShowAll()
+	for dlg.Run() == responsePurge {
+		if d.busy() {
+			showErrMsg("Cannot purge cache", "Wait for saving or annotating to finish first.")
+			continue
+		}
+
+		// Thumbnails already shown stay as they are and are regenerated as
+		// needed, but any being rendered now are started over.
+
+		loading := d.thumbsLeft > 0
+		if loading {
+			d.cancelLoad()
+		}
+		d.sessMu.Lock()
+		err := d.sess.Purge()
+		d.sessMu.Unlock()
+		if err != nil {
+			showErrMsg("Cannot purge cache", err.Error())
+		}
+		if loading {
+			d.startLoadingThumbs()
+		}
+		fill()
+	}
+}
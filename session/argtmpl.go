@@ -0,0 +1,126 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The arguments of the tools most sensitive to their versions are made from
+// templates, which can be overridden for setups the defaults don't suit.
+// A template is split on spaces, and {name} in any argument is replaced by
+// the value of the placeholder. Placeholders of lists, such as {options},
+// must make up whole arguments and expand to as many as there are items.
+
+// Templates by name, along with their placeholders.
+var argTemplates = map[string]struct {
+	def          string
+	placeholders []string
+}{
+	// Running Inkscape to convert a file, when not done in its shell.
+	// {import} are the import options and {options} the export ones.
+	"inkscape-export": {
+		"{import} {options} --export-filename={out} {in}",
+		[]string{"import", "options", "out", "in"},
+	},
+	// Overlaying the annotations of a page on saving with qpdf. {page} is
+	// the 1-based page number in the saved PDF.
+	"qpdf-overlay": {
+		"--overlay {file} --to={page} --",
+		[]string{"file", "page"},
+	},
+	// Rendering a page with pdftocairo. {out} is the path without the .png
+	// extension pdftocairo adds.
+	"pdftocairo-render": {
+		"-f {page} -png -singlefile -cropbox -scale-to {size} {in} {out}",
+		[]string{"page", "size", "in", "out"},
+	},
+}
+
+var placeholderPat = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// argTemplateOverrides are the templates set otherwise than the default, by
+// name.
+var (
+	argTemplateMu        sync.Mutex
+	argTemplateOverrides = map[string]string{}
+)
+
+// ArgTemplateNames returns the names of the templates, sorted.
+func ArgTemplateNames() []string {
+	var names []string
+	for name := range argTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetArgTemplate sets the template of the given name. An empty template
+// goes back to the default one. It fails like CheckArgTemplate.
+func SetArgTemplate(name, tmpl string) error {
+	if err := CheckArgTemplate(name, tmpl); err != nil {
+		return err
+	}
+	argTemplateMu.Lock()
+	defer argTemplateMu.Unlock()
+	if strings.TrimSpace(tmpl) == "" {
+		delete(argTemplateOverrides, name)
+	} else {
+		argTemplateOverrides[name] = tmpl
+	}
+	return nil
+}
+
+// CheckArgTemplate fails if there's no template of the given name or the
+// given template of it has unknown placeholders.
+func CheckArgTemplate(name, tmpl string) error {
+	t, ok := argTemplates[name]
+	if !ok {
+		return fmt.Errorf("no argument template '%s'", name)
+	}
+	for _, m := range placeholderPat.FindAllStringSubmatch(tmpl, -1) {
+		known := false
+		for _, p := range t.placeholders {
+			known = known || p == m[1]
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder {%s} in %s template, expected any of {%s}",
+				m[1], name, strings.Join(t.placeholders, "}, {"))
+		}
+	}
+	return nil
+}
+
+// argTemplateSet returns whether the template of the given name is set
+// otherwise than the default.
+func argTemplateSet(name string) bool {
+	argTemplateMu.Lock()
+	defer argTemplateMu.Unlock()
+	_, ok := argTemplateOverrides[name]
+	return ok
+}
+
+// expandArgs returns the arguments made from the template of the given name
+// with the given values of its placeholders.
+func expandArgs(name string, values map[string][]string) []string {
+	argTemplateMu.Lock()
+	tmpl, ok := argTemplateOverrides[name]
+	argTemplateMu.Unlock()
+	if !ok {
+		tmpl = argTemplates[name].def
+	}
+	var args []string
+	for _, f := range strings.Fields(tmpl) {
+		if m := placeholderPat.FindStringSubmatch(f); m != nil && m[0] == f {
+			args = append(args, values[m[1]]...)
+			continue
+		}
+		args = append(args, placeholderPat.ReplaceAllStringFunc(f, func(p string) string {
+			return strings.Join(values[p[1:len(p)-1]], " ")
+		}))
+	}
+	return args
+}
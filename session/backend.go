@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sessions render, convert and merge pages with poppler, Inkscape and qpdf by
+// default. Each step can be done otherwise by setting a Renderer, Converter
+// or Merger, e.g. to use a library rather than external programs. Setting nil
+// goes back to the default.
+
+// Renderer renders pages of PDFs to images.
+type Renderer interface {
+	// Render renders the page of the PDF at in, by its 0-based index, to a
+	// PNG image at out fit in a square of size pixels.
+	Render(ctx context.Context, in string, page, size int, out string) error
+}
+
+// Converter converts single page PDFs to SVG, for annotating, and SVGs back
+// to PDF, for saving. Which way is told by the extensions of in and out,
+// either ".pdf" or ".svg".
+type Converter interface {
+	Convert(ctx context.Context, in, out string) error
+}
+
+// Overlay is a single page PDF drawn over a page on merging.
+type Overlay struct {
+	// Page is the 0-based position of the page in the merged PDF.
+	Page int
+	// File is the path of the PDF drawn over it.
+	File string
+}
+
+// MergeJob describes the PDF a Merger writes.
+type MergeJob struct {
+	// Source is the PDF the pages are taken from.
+	Source string
+	// Order lists the 0-based indices of the pages of Source, in the order
+	// they're written.
+	Order []int
+	// Overlays are drawn over the pages, in the order given.
+	Overlays []Overlay
+	// FlattenForm is whether form fields are flattened into the pages.
+	FlattenForm bool
+}
+
+// Merger writes saved PDFs made of the pages of the session's PDF with
+// annotations overlaid.
+type Merger interface {
+	Merge(ctx context.Context, job MergeJob, out string) error
+}
+
+// SetRenderer sets what renders thumbnails, previews and other renders of
+// pages.
+func (s *Session) SetRenderer(r Renderer) {
+	s.mu.Lock()
+	s.renderer = r
+	s.mu.Unlock()
+}
+
+// SetConverter sets what converts pages to SVG and annotations to PDF.
+func (s *Session) SetConverter(c Converter) {
+	s.mu.Lock()
+	s.converter = c
+	s.mu.Unlock()
+}
+
+// SetMerger sets what writes saved PDFs.
+func (s *Session) SetMerger(m Merger) {
+	s.mu.Lock()
+	s.merger = m
+	s.mu.Unlock()
+}
+
+// backends returns the renderer, converter and merger set, nil for defaults.
+func (s *Session) backends() (Renderer, Converter, Merger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.renderer, s.converter, s.merger
+}
+
+// qpdfMerger merges with qpdf, in a single run.
+type qpdfMerger struct{}
+
+func (qpdfMerger) Merge(ctx context.Context, job MergeJob, out string) error {
+	args := []string{job.Source}
+	if !inOrder(job.Order) {
+		ids := make([]string, len(job.Order))
+		for i, id := range job.Order {
+			ids[i] = strconv.Itoa(id + 1)
+		}
+		args = []string{"--empty", "--pages", job.Source, strings.Join(ids, ","), "--"}
+	}
+
+	// Pages to overlay are numbered as in the output, after reordering
+
+	for _, o := range job.Overlays {
+		args = append(args, expandArgs("qpdf-overlay", map[string][]string{
+			"file": {o.File},
+			"page": {strconv.Itoa(o.Page + 1)},
+		})...)
+	}
+	// Fields without appearances, e.g. those filled in by viewers relying on
+	// NeedAppearances, get them generated first so their values aren't lost.
+	// Fields are otherwise kept as they are, since qpdf carries them over on
+	// reordering and overlaying.
+
+	if job.FlattenForm {
+		args = append(args, "--generate-appearances", "--flatten-annotations=all")
+	}
+
+	// Shortcut for when there's nothing to do
+
+	if len(args) == 1 {
+		return copyContext(ctx, job.Source, out)
+	}
+	_, err := qpdfContext(ctx, append(args, out)...)
+	return err
+}
+
+// inOrder returns whether the page indices are 0 to n-1 in order.
+func inOrder(order []int) bool {
+	for i, id := range order {
+		if id != i {
+			return false
+		}
+	}
+	return true
+}
+
+// renderWith renders the page with the given ID to path at the given size
+// with the renderer set.
+func (s *Session) renderWith(r Renderer, page, size int, path string) error {
+	tmp := path + ".tmp.png"
+	if err := r.Render(context.Background(), s.path, page, size, tmp); err != nil {
+		return fmt.Errorf("failed to render page %d of '%s': %s", page+1, s.path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// convertWith runs the given conversions with the converter set, a few at
+// a time, and returns their errors in the same order.
+func convertWith(ctx context.Context, c Converter, convs []conversion) []error {
+	return parallel(ctx, len(convs), func(i int) error {
+		return c.Convert(ctx, convs[i].in, convs[i].out)
+	})
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Decorations are drawn over pages on saving, on top of their annotations,
+// without being part of them. Each decorated page gets an SVG of its own as
+// large as the page is shown, in points.
+
+// decorTpl is the SVG decorations are drawn in, given the width and height
+// of the page and the elements drawn.
+const decorTpl = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<svg
+   width="%[1]gpt"
+   height="%[2]gpt"
+   viewBox="0 0 %[1]g %[2]g"
+   version="1.1"
+   xmlns:xlink="http://www.w3.org/1999/xlink"
+   xmlns="http://www.w3.org/2000/svg">
+%[3]s</svg>
+`
+
+// decorates returns whether anything is drawn over the page with the given
+// ID on saving.
+func (s *Session) decorates(id int) bool {
+	num, _ := s.pageNumber(id)
+	return s.watermarks(id) || num != ""
+}
+
+// writeDecoration writes the SVG of what's drawn over the page at the given
+// position on saving to path.
+func (s *Session) writeDecoration(page int, path string) error {
+	info, err := s.PageInfo(page)
+	if err != nil {
+		return err
+	}
+	pw, ph := info.DisplaySize()
+	var elems string
+	id := s.pageID(page)
+	if s.watermarks(id) {
+		s.mu.Lock()
+		w := s.watermark
+		s.mu.Unlock()
+		elems += w.svg(pw, ph)
+	}
+	if num, p := s.pageNumber(id); num != "" {
+		elems += textSVG(fmt.Sprintf("page-number-%d", page+1), num, p, pw, ph)
+	}
+	if err := ioutil.WriteFile(path+".tmp", []byte(fmt.Sprintf(decorTpl, pw, ph, elems)), 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
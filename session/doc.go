@@ -0,0 +1,32 @@
+// Package session is the annotation pipeline of PDFrankenstein, usable on
+// its own by tools embedding it. A Session is opened on a PDF, its pages
+// annotated as SVG drawn over them, and saved back to a PDF with the
+// annotations overlaid:
+//
+//	s, err := session.New("in.pdf")
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	if err := s.PutAnnotation(0, svg); err != nil {
+//		return err
+//	}
+//	return s.Save("out.pdf")
+//
+// The package makes no assumptions about a user interface: annotations are
+// put in with PutAnnotation, PlaceImage or ImportAnnotations as well as by
+// running an editor with Annotate, and progress and logs are reported to
+// the callbacks set, from the goroutine doing the work.
+//
+// Pages are rendered with poppler, converted to and from SVG with Inkscape
+// and merged with qpdf, unless a Renderer, Converter or Merger is set. Tools
+// reports which of the external programs are found.
+//
+// # Compatibility
+//
+// The exported API of the package follows semantic versioning along with
+// the module's release tags: it's only changed incompatibly in a new major
+// version. The names and layout of intermediate files in TmpDir, the
+// arguments the external programs are run with and the text of errors and
+// log messages are not part of it.
+package session
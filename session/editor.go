@@ -0,0 +1,132 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultEditorCommand is the command template used for annotating pages
+// unless set otherwise.
+const DefaultEditorCommand = "inkscape {file}"
+
+// Placement is where the editor window is placed once it opens.
+type Placement int
+
+const (
+	// PlaceDefault leaves the window where the window manager puts it.
+	PlaceDefault Placement = iota
+	// PlaceMaximized maximizes the window.
+	PlaceMaximized
+	// PlaceFullscreen makes the window fullscreen.
+	PlaceFullscreen
+	// PlaceMonitor moves the window to the monitor whose top left corner is
+	// at Editor.X and Editor.Y, and maximizes it there.
+	PlaceMonitor
+)
+
+// Editor configures how pages are opened for annotation.
+type Editor struct {
+	// Command is the command line run to edit a page. It's split on spaces
+	// and {file} in any of the arguments is replaced by the path of the SVG
+	// to edit. An empty command means DefaultEditorCommand. A program named
+	// like a tool runs the executable set for it by SetToolPath.
+	Command   string
+	Placement Placement
+	// X and Y are the position of the monitor for PlaceMonitor.
+	X, Y int
+	// Env are environment variables set for the editor as "NAME=value",
+	// besides those of this process.
+	Env []string
+}
+
+// args returns the editor command line for editing the given file.
+func (e Editor) args(file string) ([]string, error) {
+	cmd := e.Command
+	if strings.TrimSpace(cmd) == "" {
+		cmd = DefaultEditorCommand
+	}
+	args := strings.Fields(cmd)
+	hasFile := false
+	for i, a := range args {
+		if strings.Contains(a, "{file}") {
+			hasFile = true
+			args[i] = strings.ReplaceAll(a, "{file}", file)
+		}
+	}
+	if !hasFile {
+		return nil, fmt.Errorf("editor command '%s' has no {file} placeholder", cmd)
+	}
+	args[0] = toolPath(args[0])
+	return args, nil
+}
+
+// place waits for the editor window showing the given file to appear and
+// moves it according to the placement. It gives up once ctx is done. Windows
+// are found and moved with wmctrl, so this only works on X11.
+func (e Editor) place(ctx context.Context, file string) {
+	if e.Placement == PlaceDefault {
+		return
+	}
+	if err := lookTool("wmctrl"); err != nil {
+		logf(LogSession, "wmctrl is needed for placing the editor window")
+		return
+	}
+	title := filepath.Base(file)
+	timeout := time.After(30 * time.Second)
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			logf(LogSession, "editor window for '%s' not found for placing", title)
+			return
+		case <-tick.C:
+		}
+		out, err := command("wmctrl", "-l").Output()
+		if err != nil {
+			logf(LogSession, "failed to list windows: %s", cmdErr(err))
+			return
+		}
+		for _, l := range strings.Split(string(out), "\n") {
+			if f := strings.Fields(l); len(f) > 0 && strings.Contains(l, title) {
+				e.placeWindow(f[0])
+				return
+			}
+		}
+	}
+}
+
+func (e Editor) placeWindow(id string) {
+	var cmds [][]string
+	switch e.Placement {
+	case PlaceMaximized:
+		cmds = [][]string{{"-b", "add,maximized_vert,maximized_horz"}}
+	case PlaceFullscreen:
+		cmds = [][]string{{"-b", "add,fullscreen"}}
+	case PlaceMonitor:
+		cmds = [][]string{
+			{"-b", "remove,maximized_vert,maximized_horz"},
+			{"-e", fmt.Sprintf("0,%d,%d,-1,-1", e.X, e.Y)},
+			{"-b", "add,maximized_vert,maximized_horz"},
+		}
+	}
+	for _, c := range cmds {
+		args := append([]string{"-i", "-r", id}, c...)
+		if _, err := command("wmctrl", args...).Output(); err != nil {
+			logf(LogSession, "failed to place editor window: %s", cmdErr(err))
+			return
+		}
+	}
+}
+
+// SetEditor sets how pages are opened for annotation.
+func (s *Session) SetEditor(e Editor) {
+	s.mu.Lock()
+	s.editor = e
+	s.mu.Unlock()
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Intermediate files which can be regenerated, i.e. renders of pages and
+// their SVG exports, accumulate without bound as pages are viewed. With a
+// cap set, the least recently used of them are removed once the session's
+// directory grows past it. Annotation SVGs, including those in the trash or
+// kept for Revert, and the exports annotations are drawn over, are never
+// removed, so the cap may be exceeded by those alone.
+
+// evictGrace is how long files are kept after last used regardless of the
+// cap, so renders aren't removed before they're shown.
+const evictGrace = 10 * time.Second
+
+// SetDiskCap sets the size in bytes the session's directory is kept under by
+// removing intermediate files which can be regenerated. 0 removes the cap.
+func (s *Session) SetDiskCap(bytes int64) {
+	s.diskMu.Lock()
+	s.diskCap = bytes
+	s.diskMu.Unlock()
+	s.trim()
+}
+
+// used records that the intermediate file at path was just used.
+func (s *Session) used(path string) {
+	s.diskMu.Lock()
+	if s.lastUsed == nil {
+		s.lastUsed = map[string]time.Time{}
+	}
+	s.lastUsed[path] = time.Now()
+	s.diskMu.Unlock()
+}
+
+// evictable returns whether the file of the given name in the session's
+// directory can be regenerated and isn't in use.
+func (s *Session) evictable(name string) bool {
+	var id int
+	switch {
+	case strings.HasPrefix(name, "thumb-"), strings.HasPrefix(name, "preview-"),
+		strings.HasPrefix(name, "render-"):
+		return strings.HasSuffix(name, ".png") && !strings.HasSuffix(name, ".tmp.png")
+	case strings.HasSuffix(name, ".svg"):
+		if _, err := fmt.Sscanf(name, "src-%d.svg", &id); err != nil || name != fmt.Sprintf("src-%d.svg", id) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	// Exports are kept while being made or edited over, and for as long as
+	// annotations are drawn over them
+
+	s.mu.Lock()
+	_, annotated := s.annotated[id]
+	trashed := len(s.trash[id]) > 0
+	editing := s.editing[id] > 0
+	l := s.exporting[id]
+	s.mu.Unlock()
+	if annotated || trashed || editing {
+		return false
+	}
+	if _, err := os.Stat(s.savedPath(id)); err == nil {
+		return false
+	}
+	if l != nil {
+		if !l.TryLock() {
+			return false
+		}
+		l.Unlock()
+	}
+	return true
+}
+
+// trim removes the least recently used intermediate files which can be
+// regenerated until the session's directory is under its cap, if set.
+func (s *Session) trim() {
+	if !s.diskMu.TryLock() {
+		return // Already being trimmed
+	}
+	defer s.diskMu.Unlock()
+	if s.diskCap <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(s.tmpDir)
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	if total <= s.diskCap {
+		return
+	}
+
+	lastUsed := func(f os.FileInfo) time.Time {
+		if t, ok := s.lastUsed[filepath.Join(s.tmpDir, f.Name())]; ok {
+			return t
+		}
+		return f.ModTime()
+	}
+	var candidates []os.FileInfo
+	for _, f := range files {
+		if time.Since(lastUsed(f)) > evictGrace && s.evictable(f.Name()) {
+			candidates = append(candidates, f)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return lastUsed(candidates[i]).Before(lastUsed(candidates[j]))
+	})
+	for _, f := range candidates {
+		if total <= s.diskCap {
+			break
+		}
+		path := filepath.Join(s.tmpDir, f.Name())
+		if err := os.Remove(path); err != nil {
+			continue
+		}
+		delete(s.lastUsed, path)
+		logf(LogSession, "evicted '%s'", path)
+		total -= f.Size()
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// In a Flatpak sandbox, the tools are run as bundled with the app if they
+// are, and on the host with flatpak-spawn otherwise, which needs the
+// org.freedesktop.Flatpak talk permission. Tools on the host only see the
+// files shared with it, so temporary files must then be kept in such a
+// place, and documents opened through the document portal, whose paths are
+// the same on the host.
+
+// flatpakInfo is the file present at the root of Flatpak sandboxes.
+const flatpakInfo = "/.flatpak-info"
+
+// Sandboxed returns whether running in a Flatpak sandbox.
+func Sandboxed() bool {
+	_, err := os.Stat(flatpakInfo)
+	return err == nil
+}
+
+// onHost are whether executables by name are run on the host, once
+// looked for.
+var (
+	onHostMu sync.Mutex
+	onHost   = map[string]bool{}
+)
+
+// runOnHost returns whether the executable of the given name or path must
+// be run on the host, being sandboxed without it bundled.
+func runOnHost(name string) bool {
+	if !Sandboxed() {
+		return false
+	}
+	onHostMu.Lock()
+	defer onHostMu.Unlock()
+	host, ok := onHost[name]
+	if !ok {
+		_, err := exec.LookPath(name)
+		host = err != nil
+		onHost[name] = host
+	}
+	return host
+}
+
+// hostArgs returns the arguments of flatpak-spawn to run a command on the
+// host, with the given environment variables set as "NAME=value". The
+// command is killed along with flatpak-spawn.
+func hostArgs(env []string) []string {
+	args := []string{"flatpak-spawn", "--host", "--watch-bus"}
+	for _, e := range env {
+		args = append(args, "--env="+e)
+	}
+	return args
+}
+
+// lookTool fails if the executable of the given name or path can't be found,
+// on the host if it must be run there.
+func lookTool(name string) error {
+	if !runOnHost(name) {
+		_, err := exec.LookPath(name)
+		return err
+	}
+	args := append(hostArgs(nil), "sh", "-c", `command -v "$1"`, "sh", name)
+	if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+		return &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	return nil
+}
+
+// setEnv sets environment variables of a command made with command or
+// commandContext, as "NAME=value", on top of those inherited.
+func setEnv(cmd *exec.Cmd, env []string) {
+	if len(env) == 0 {
+		return
+	}
+	if len(cmd.Args) > 2 && cmd.Args[0] == "flatpak-spawn" && cmd.Args[1] == "--host" {
+		cmd.Args = append(hostArgs(env), cmd.Args[len(hostArgs(nil)):]...)
+		return
+	}
+	cmd.Env = append(os.Environ(), env...)
+}
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"regexp"
+)
+
+// Form is the kind of interactive form a document has, as reported by
+// pdfinfo.
+type Form string
+
+const (
+	FormNone     Form = "none"
+	FormAcroForm Form = "AcroForm"
+	FormXFA      Form = "XFA"
+)
+
+var formPat = regexp.MustCompile(`(?m)^Form:\s+(\S+)`)
+
+// parseForm returns the kind of form in the given pdfinfo output.
+func parseForm(out []byte) Form {
+	m := formPat.FindSubmatch(out)
+	if m == nil {
+		return FormNone
+	}
+	switch f := Form(m[1]); f {
+	case FormAcroForm, FormXFA:
+		return f
+	}
+	return FormNone
+}
+
+// Form returns the kind of form fields the document has.
+func (s *Session) Form() (Form, error) {
+	if _, err := s.loadGeometry(); err != nil {
+		return FormNone, err
+	}
+	s.geomMu.Lock()
+	defer s.geomMu.Unlock()
+	return s.form, nil
+}
+
+// SetFlattenForm sets whether form fields are flattened into the pages on
+// saving, leaving their current values as plain content, rather than kept
+// fillable. Flattening also flattens any other PDF annotations, such as
+// comments, since qpdf can't tell them apart from fields.
+func (s *Session) SetFlattenForm(flatten bool) {
+	s.mu.Lock()
+	s.flattenForm = flatten
+	s.mu.Unlock()
+}
+
+// FlattensForm returns whether form fields are flattened on saving.
+func (s *Session) FlattensForm() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flattenForm
+}
+
+// flattens returns whether form fields are flattened on saving, being
+// asked to and there being any.
+func (s *Session) flattens() (bool, error) {
+	form, err := s.Form()
+	if err != nil {
+		return false, err
+	}
+	return form != FormNone && s.FlattensForm(), nil
+}
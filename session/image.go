@@ -0,0 +1,175 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/png" // for image.DecodeConfig
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImagePlacement positions an image on a page.
+type ImagePlacement struct {
+	// AnchorX and AnchorY are the point of the page the image is aligned with
+	// as fractions of its width and height: 0, 0 is the top left corner, 1, 1
+	// the bottom right and 0.5, 0.5 the center.
+	AnchorX, AnchorY float64
+	// Width is the width of the image as a fraction of the page width. Its
+	// height follows from its aspect ratio.
+	Width float64
+	// Margin is the space kept from the page edges as a fraction of the page
+	// width.
+	Margin float64
+	// Frame draws a thin line around the image, setting it apart from the
+	// page like a stamp.
+	Frame bool
+}
+
+// Annotation returns the annotation SVG of the given page, or nil if it has
+// no annotations.
+func (s *Session) Annotation(page int) ([]byte, error) {
+	if !s.IsAnnotated(page) {
+		return nil, nil
+	}
+	path := s.annotPath(s.pageID(page))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %s", path, err)
+	}
+	return b, nil
+}
+
+// viewBox returns the width and height of the view box of an SVG, falling
+// back to its width and height attributes.
+func viewBox(svg []byte) (float64, float64, error) {
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, fmt.Errorf("no svg element found: %s", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var vb, w, h string
+		for _, a := range start.Attr {
+			switch a.Name.Local {
+			case "viewBox":
+				vb = a.Value
+			case "width":
+				w = a.Value
+			case "height":
+				h = a.Value
+			}
+		}
+		if f := strings.Fields(strings.ReplaceAll(vb, ",", " ")); len(f) == 4 {
+			w, h = f[2], f[3]
+		}
+		width, werr := strconv.ParseFloat(strings.TrimRight(w, "x%npiemtc"), 64)
+		height, herr := strconv.ParseFloat(strings.TrimRight(h, "x%npiemtc"), 64)
+		if werr != nil || herr != nil || width <= 0 || height <= 0 {
+			return 0, 0, fmt.Errorf("invalid svg size '%s' x '%s'", w, h)
+		}
+		return width, height, nil
+	}
+}
+
+// PlaceImage adds a PNG image to the annotations of the given page, e.g. a
+// pasted screenshot or a scanned signature, creating them if needed. The
+// image is embedded in the annotation SVG, grouped with its frame if any, so
+// it can still be moved and resized in the editor later.
+func (s *Session) PlaceImage(ctx context.Context, page int, png []byte, p ImagePlacement) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(png))
+	if err != nil {
+		return fmt.Errorf("failed to read image: %s", err)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return errors.New("image is empty")
+	}
+
+	return s.editAnnotation(ctx, page, func(svg []byte, pw, ph float64) ([]byte, error) {
+		margin := p.Margin * pw
+		w := p.Width * pw
+		h := w * float64(cfg.Height) / float64(cfg.Width)
+		x := margin + p.AnchorX*(pw-2*margin-w)
+		y := margin + p.AnchorY*(ph-2*margin-h)
+		id := time.Now().UnixNano()
+		img := fmt.Sprintf(`    <image
+       id="placed-image-%d"
+       preserveAspectRatio="none"
+       x="%g"
+       y="%g"
+       width="%g"
+       height="%g"
+       xlink:href="data:image/png;base64,%s" />
+`, id, x, y, w, h, base64.StdEncoding.EncodeToString(png))
+		if p.Frame {
+			img += fmt.Sprintf(`    <rect
+       id="placed-frame-%d"
+       style="fill:none;stroke:#000000;stroke-width:%g"
+       x="%g"
+       y="%g"
+       width="%g"
+       height="%g" />
+`, id, pw/500, x, y, w, h)
+		}
+		return appendToSVG(svg, fmt.Sprintf("  <g\n     id=\"placed-%d\">\n%s  </g>\n", id, img))
+	})
+}
+
+// editAnnotation replaces the annotation SVG of the given page with what
+// edit makes of it, creating the annotations first if needed. edit is also
+// given the size of the page in SVG units.
+func (s *Session) editAnnotation(ctx context.Context, page int, edit func(svg []byte, pw, ph float64) ([]byte, error)) error {
+	page = s.pageID(page)
+	if err := s.prepare(ctx, page); err != nil {
+		return err
+	}
+	path := s.annotPath(page)
+	svg, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %s", path, err)
+	}
+	pw, ph, err := viewBox(svg)
+	if err != nil {
+		return fmt.Errorf("failed to parse svg at '%s': %s", path, err)
+	}
+	if svg, err = edit(svg, pw, ph); err != nil {
+		return fmt.Errorf("failed to edit svg at '%s': %s", path, err)
+	}
+	if err := ioutil.WriteFile(path+".tmp", svg, 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
+	}
+	s.mu.Lock()
+	s.annotated[page] = struct{}{}
+	delete(s.reverted, page)
+	s.mu.Unlock()
+	s.dropRenders(page)
+	return s.syncSidecar(page, true)
+}
+
+// appendToSVG adds the given elements to the end of an SVG, on top of
+// everything else.
+func appendToSVG(svg []byte, elems string) ([]byte, error) {
+	end := bytes.LastIndex(svg, []byte("</svg>"))
+	if end < 0 {
+		return nil, errors.New("no closing svg tag")
+	}
+	var b bytes.Buffer
+	b.Write(svg[:end])
+	b.WriteString(elems)
+	b.Write(svg[end:])
+	return b.Bytes(), nil
+}
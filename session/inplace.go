@@ -0,0 +1,102 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Copying a large PDF when opening it takes long, and is needless if it
+// can't change under the session anyway, e.g. on read-only media. Such PDFs
+// can be opened in place instead. Since nothing stops them from changing
+// nonetheless, they're checked before saving to be as they were when opened:
+// their size and modification time right away, and their SHA-256 checksum,
+// which is computed in the background when opened so as not to delay it.
+
+// NewInPlace is like New but the session works on the file itself rather
+// than a copy. The file must not change while the session is open, and
+// can't be saved over.
+func NewInPlace(path string) (*Session, error) {
+	return open(path, true)
+}
+
+// OnReadOnlyMedium returns whether the file at path is on a file system
+// mounted read-only, making it safe to open in place.
+func OnReadOnlyMedium(path string) bool {
+	return syscall.Access(path, 2 /* W_OK */) == syscall.EROFS
+}
+
+// sourceCheck tells whether a PDF opened in place changed since.
+type sourceCheck struct {
+	size    int64
+	modTime time.Time
+	// sum is the checksum of the PDF when opened, or err the failure to
+	// compute it, once done is closed.
+	done chan struct{}
+	sum  []byte
+	err  error
+}
+
+func newSourceCheck(path string) (*sourceCheck, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &sourceCheck{size: fi.Size(), modTime: fi.ModTime(), done: make(chan struct{})}
+	go func() {
+		c.sum, c.err = fileSum(path)
+		close(c.done)
+	}()
+	return c, nil
+}
+
+func fileSum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// checkSource fails if the session was opened in place and its PDF changed
+// since, or would be replaced by saving to path.
+func (s *Session) checkSource(path string) error {
+	c := s.inPlace
+	if c == nil {
+		return nil
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if src, err := os.Stat(s.path); err == nil && os.SameFile(fi, src) {
+			return fmt.Errorf("'%s' is opened in place, so it can't be saved over; save to another file", s.origin)
+		}
+	}
+	changed := fmt.Errorf("'%s' changed since it was opened; reopen it to annotate the current version", s.origin)
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to check '%s': %s", s.origin, err)
+	}
+	if fi.Size() != c.size || !fi.ModTime().Equal(c.modTime) {
+		return changed
+	}
+	<-c.done
+	if c.err != nil {
+		return fmt.Errorf("failed to check '%s': %s", s.origin, c.err)
+	}
+	sum, err := fileSum(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to check '%s': %s", s.origin, err)
+	}
+	if !bytes.Equal(sum, c.sum) {
+		return changed
+	}
+	return nil
+}
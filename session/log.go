@@ -0,0 +1,79 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// What sessions do and the commands they run can be logged by setting a
+// Logger, which is how problems with the external tools are tracked down.
+
+// Log kinds of what's logged.
+const (
+	// LogSession is the kind of operations on sessions.
+	LogSession = "session"
+	// LogCommand is the kind of external commands run.
+	LogCommand = "command"
+)
+
+// Logger is told what's being done, of the given kind. It's called from the
+// goroutine doing it.
+type Logger func(kind, msg string)
+
+var (
+	loggerMu sync.Mutex
+	logger   Logger
+)
+
+// SetLogger sets what's told about what sessions do. It's nil by default,
+// logging nothing.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+// logf logs what's being done, formatted like fmt.Sprintf.
+func logf(kind, format string, v ...any) {
+	loggerMu.Lock()
+	l := logger
+	loggerMu.Unlock()
+	if l != nil {
+		l(kind, fmt.Sprintf(format, v...))
+	}
+}
+
+// command is like exec.Command but logs the command run.
+func command(name string, args ...string) *exec.Cmd {
+	return commandContext(context.Background(), name, args...)
+}
+
+// commandContext is like exec.CommandContext but logs the command run. The
+// command is run on the host if it must be, see runOnHost.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if runOnHost(name) {
+		host := hostArgs(nil)
+		args = append(append(host[1:], name), args...)
+		name = host[0]
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	logf(LogCommand, "%s", quoteArgs(cmd.Args))
+	return cmd
+}
+
+// quoteArgs returns the arguments of a command separated by spaces, quoted
+// where needed to be told apart.
+func quoteArgs(args []string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\") {
+			a = strconv.Quote(a)
+		}
+		q[i] = a
+	}
+	return strings.Join(q, " ")
+}
@@ -0,0 +1,231 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// minMatchScore is the least similarity for two pages to be matched by text.
+const minMatchScore = 0.5
+
+// PageMatch is where a page of one PDF is found in a revision of it.
+type PageMatch struct {
+	// Old is the page in the old PDF and New the page in the revision, or -1
+	// if no page matched.
+	Old, New int
+	// Score is how similar the text of the two pages is, from 0 to 1 for
+	// identical text.
+	Score float64
+	// ByPosition is set for pages without text, which are matched by being
+	// between matched pages rather than by similarity.
+	ByPosition bool
+}
+
+// pageWords returns the set of words on each page of the PDF at path.
+func pageWords(path string) ([]map[string]struct{}, error) {
+	out, err := command(toolPath("pdftotext"), "-enc", "UTF-8", path, "-").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract text of '%s': %s", path, cmdErr(err))
+	}
+
+	// Pages end with a form feed
+
+	texts := strings.Split(string(out), "\f")
+	texts = texts[:len(texts)-1]
+	pages := make([]map[string]struct{}, len(texts))
+	for i, t := range texts {
+		pages[i] = map[string]struct{}{}
+		for _, w := range strings.FieldsFunc(strings.ToLower(t), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			pages[i][w] = struct{}{}
+		}
+	}
+	return pages, nil
+}
+
+// similarity returns the Jaccard index of two word sets.
+func similarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	common := 0
+	for w := range a {
+		if _, ok := b[w]; ok {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// MatchPages finds each page of the PDF at oldPath in its revision at
+// newPath, by the similarity of their text. Each page of the revision is
+// matched at most once, most similar pairs first. Pages without text, such
+// as scans, are matched by position if the pages around them are matched
+// likewise. Matches are returned in page order of the old PDF.
+func MatchPages(oldPath, newPath string) ([]PageMatch, error) {
+	oldWords, err := pageWords(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newWords, err := pageWords(newPath)
+	if err != nil {
+		return nil, err
+	}
+	return matchPages(oldWords, newWords), nil
+}
+
+// matchPages matches pages by their words as described in MatchPages.
+func matchPages(oldWords, newWords []map[string]struct{}) []PageMatch {
+	var pairs []PageMatch
+	for o, ow := range oldWords {
+		for n, nw := range newWords {
+			if s := similarity(ow, nw); s >= minMatchScore {
+				pairs = append(pairs, PageMatch{Old: o, New: n, Score: s})
+			}
+		}
+	}
+
+	// Ties go to the pages closest in position
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return abs(pairs[i].Old-pairs[i].New) < abs(pairs[j].Old-pairs[j].New)
+	})
+	matches := make([]PageMatch, len(oldWords))
+	for i := range matches {
+		matches[i] = PageMatch{Old: i, New: -1}
+	}
+	taken := make([]bool, len(newWords))
+	for _, p := range pairs {
+		if matches[p.Old].New < 0 && !taken[p.New] {
+			matches[p.Old] = p
+			taken[p.New] = true
+		}
+	}
+
+	// Runs of pages without text follow the page before them, or start at
+	// the beginning
+
+	for o := range matches {
+		if matches[o].New >= 0 || len(oldWords[o]) > 0 {
+			continue
+		}
+		n := 0
+		if o > 0 {
+			if matches[o-1].New < 0 {
+				continue
+			}
+			n = matches[o-1].New + 1
+		}
+		if n < len(newWords) && !taken[n] && len(newWords[n]) == 0 {
+			matches[o] = PageMatch{Old: o, New: n, ByPosition: true}
+			taken[n] = true
+		}
+	}
+	return matches
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// MigrateAnnotations carries the annotations of the old session over to the
+// pages of this one they match, as found by MatchPages on the two sessions'
+// PDFs. Annotations are laid over the matching page as they were, so content
+// which moved within the page needs checking. It returns the matches of the
+// annotated pages of the old session, by position in either session.
+func (s *Session) MigrateAnnotations(ctx context.Context, old *Session) ([]PageMatch, error) {
+	oldWords, err := pageWords(old.path)
+	if err != nil {
+		return nil, err
+	}
+	newWords, err := pageWords(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(oldWords) != old.pageCount || len(newWords) != s.pageCount {
+		return nil, errors.New("page counts of the extracted text don't match the PDFs")
+	}
+	matches := matchPages(oldWords, newWords)
+	newPos := make([]int, s.pageCount)
+	for pos := range newPos {
+		newPos[s.pageID(pos)] = pos
+	}
+
+	var migrated []PageMatch
+	for pos := 0; pos < old.pageCount; pos++ {
+		if !old.IsAnnotated(pos) {
+			continue
+		}
+		m := matches[old.pageID(pos)]
+		m.Old = pos
+		if m.New < 0 {
+			migrated = append(migrated, m)
+			continue
+		}
+		svg, err := old.Annotation(pos)
+		if err != nil {
+			return migrated, err
+		}
+		if err := s.prepare(ctx, m.New); err != nil {
+			return migrated, err
+		}
+		m.New = newPos[m.New]
+		if err := s.PutAnnotation(m.New, setBackground(svg, s.srcPath(s.pageID(m.New)))); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, m)
+	}
+	return migrated, nil
+}
+
+// Extract writes the pages at the given positions, in the order given, to a
+// new PDF at path and opens a session on it with their annotations and
+// notes carried over. Annotations stay editable rather than being merged
+// into the pages.
+func (s *Session) Extract(ctx context.Context, pages []int, path string) (*Session, error) {
+	ids := make([]string, len(pages))
+	for i, p := range pages {
+		ids[i] = strconv.Itoa(s.pageID(p) + 1)
+	}
+	if _, err := qpdfContext(ctx, "--empty", "--pages", s.path, strings.Join(ids, ","), "--", path); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to extract pages to '%s': %s", path, err)
+	}
+	x, err := New(path)
+	if err != nil {
+		return nil, err
+	}
+	x.SetFlattenForm(s.FlattensForm())
+	for i, p := range pages {
+		x.SetNote(i, s.Note(p))
+		svg, err := s.Annotation(p)
+		if err == nil && svg != nil {
+			if err = x.prepare(ctx, i); err == nil {
+				err = x.PutAnnotation(i, setBackground(svg, x.srcPath(i)))
+			}
+		}
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+	}
+	logf(LogSession, "extracted %d pages of '%s' to '%s'", len(pages), s.origin, path)
+	return x, nil
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Page numbers, like watermarks, are only drawn over the pages on saving, so
+// they follow the pages as they're moved, deleted or added.
+
+// PageNumbering is the page numbers drawn on pages on saving, for documents
+// which lack printed ones.
+type PageNumbering struct {
+	// Format is the text drawn, in which "{n}" is replaced by the number of
+	// the page and "{total}" by that of the last numbered page.
+	Format string
+	// Placement is where and how large numbers are drawn.
+	Placement TextPlacement
+	// Start is the number of the first numbered page.
+	Start int
+	// Skip are the positions of the pages left unnumbered, e.g. a cover.
+	// They're not counted either, so the page after a skipped cover is
+	// numbered Start.
+	Skip []int
+}
+
+// SetPageNumbering sets the page numbers drawn on saving, or removes them if
+// nil.
+func (s *Session) SetPageNumbering(n *PageNumbering) error {
+	if n == nil {
+		s.mu.Lock()
+		s.numbering = nil
+		s.mu.Unlock()
+		return nil
+	}
+	if !strings.Contains(n.Format, "{n}") {
+		return errors.New("page number format lacks {n}")
+	}
+	if n.Placement.Size <= 0 {
+		return errors.New("page number size must be positive")
+	}
+
+	// Skipped pages are kept by ID so they stay skipped as they move
+
+	pn := *n
+	pn.Skip = make([]int, len(n.Skip))
+	for i, p := range n.Skip {
+		pn.Skip[i] = s.pageID(p)
+	}
+	s.mu.Lock()
+	s.numbering = &pn
+	s.mu.Unlock()
+	logf(LogSession, "set page numbering of '%s'", s.origin)
+	return nil
+}
+
+// PageNumbering returns the page numbers drawn on saving, or nil if there
+// are none.
+func (s *Session) PageNumbering() *PageNumbering {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.numbering == nil {
+		return nil
+	}
+	n := *s.numbering
+	pos := make(map[int]int, len(s.order))
+	for p, id := range s.order {
+		pos[id] = p
+	}
+	n.Skip = make([]int, 0, len(s.numbering.Skip))
+	for _, id := range s.numbering.Skip {
+		if p, ok := pos[id]; ok {
+			n.Skip = append(n.Skip, p)
+		}
+	}
+	return &n
+}
+
+// pageNumber returns the page number text drawn on the page with the given
+// ID on saving and where, or "" if it isn't numbered.
+func (s *Session) pageNumber(id int) (string, TextPlacement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.numbering
+	if n == nil {
+		return "", TextPlacement{}
+	}
+	skipped := make(map[int]bool, len(n.Skip))
+	for _, p := range n.Skip {
+		skipped[p] = true
+	}
+	if skipped[id] {
+		return "", TextPlacement{}
+	}
+	num, last := 0, n.Start-1
+	for _, p := range s.order {
+		if skipped[p] {
+			continue
+		}
+		last++
+		if p == id {
+			num = last
+		}
+	}
+	text := strings.NewReplacer(
+		"{n}", strconv.Itoa(num),
+		"{total}", strconv.Itoa(last),
+	).Replace(n.Format)
+	return text, n.Placement
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// concurrency is how many external programs parallel runs at a time.
+var concurrency = runtime.NumCPU()
+
+// SetConcurrency sets how many external programs are run at a time for a
+// single operation, such as converting pages on saving. It defaults to the
+// number of CPUs and must be set before any session is used.
+func SetConcurrency(n int) {
+	if n > 0 {
+		concurrency = n
+	}
+}
+
+// parallel calls do for 0 to n-1, as many at a time as set by
+// SetConcurrency, and returns their errors in the same order. Once ctx is
+// cancelled, the calls not started yet fail with its error.
+func parallel(ctx context.Context, n int, do func(i int) error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if errs[i] = ctx.Err(); errs[i] == nil {
+				errs[i] = do(i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,74 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// qpdf runs qpdf with the given arguments and returns its output. Warnings
+// about damaged but recoverable files don't fail it.
+func qpdf(args ...string) ([]byte, error) {
+	return qpdfContext(context.Background(), args...)
+}
+
+// qpdfContext is like qpdf but kills qpdf once ctx is cancelled.
+func qpdfContext(ctx context.Context, args ...string) ([]byte, error) {
+	args = append([]string{"--warning-exit-0"}, args...)
+	out, err := commandContext(ctx, toolPath("qpdf"), args...).Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+	return out, nil
+}
+
+// CountPages returns the number of pages in the given PDF file.
+func CountPages(path string) (int, error) {
+	out, err := qpdf("--show-npages", path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("cannot convert page count: %s", err)
+	}
+	return n, nil
+}
+
+// PageRange is a range of pages by their 1-based numbers, inclusive.
+type PageRange struct {
+	From, To int
+}
+
+func (r PageRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// Merge concatenates the pages of the given PDF files into out.
+func Merge(out string, inputs ...string) error {
+	args := append([]string{"--empty", "--pages"}, inputs...)
+	args = append(args, "--", out)
+	if _, err := qpdf(args...); err != nil {
+		return fmt.Errorf("failed to merge into '%s': %s", out, err)
+	}
+	return nil
+}
+
+// Split writes each of the given page ranges of the PDF file to its own file
+// named after prefix and the 1-based index of the range, and returns their
+// paths.
+func Split(in string, ranges []PageRange, prefix string) ([]string, error) {
+	digits := len(strconv.Itoa(len(ranges)))
+	paths := make([]string, len(ranges))
+	for i, r := range ranges {
+		paths[i] = fmt.Sprintf("%s-%0*d.pdf", prefix, digits, i+1)
+		if _, err := qpdf("--empty", "--pages", in, r.String(), "--", paths[i]); err != nil {
+			return nil, fmt.Errorf("failed to extract pages %s to '%s': %s", r, paths[i], err)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,74 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// PlaceQRCode adds a QR code of the given text, e.g. the URL a document is
+// found at, to the annotations of the given page, placed like an image. The
+// QR code is drawn with vector shapes on a white square including the quiet
+// zone around it, so it prints sharply and scans on any background.
+func (s *Session) PlaceQRCode(ctx context.Context, page int, text string, p ImagePlacement) error {
+	q, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("failed to make QR code: %s", err)
+	}
+	bits := q.Bitmap()
+	n := len(bits)
+
+	// Dark modules are drawn in units of modules, a path segment for each
+	// run of them in a row so there are no seams between them
+
+	var d strings.Builder
+	for y, row := range bits {
+		for x := 0; x < n; x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < n && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&d, "M%d %dh%dv1h-%dz", x, y, run, run)
+			x += run
+		}
+	}
+
+	return s.editAnnotation(ctx, page, func(svg []byte, pw, ph float64) ([]byte, error) {
+		margin := p.Margin * pw
+		w := p.Width * pw
+		x := margin + p.AnchorX*(pw-2*margin-w)
+		y := margin + p.AnchorY*(ph-2*margin-w)
+		id := time.Now().UnixNano()
+		frame := ""
+		if p.Frame {
+			frame = fmt.Sprintf(`
+    <rect
+       style="fill:none;stroke:#000000;stroke-width:%g"
+       x="0"
+       y="0"
+       width="%d"
+       height="%d" />`, pw/500/(w/float64(n)), n, n)
+		}
+		return appendToSVG(svg, fmt.Sprintf(`  <g
+     id="qr-%d"
+     transform="translate(%g,%g) scale(%g)">
+    <title>%s</title>
+    <rect
+       style="fill:#ffffff"
+       x="0"
+       y="0"
+       width="%d"
+       height="%d" />
+    <path
+       style="fill:#000000"
+       d="%s" />%s
+  </g>
+`, id, x, y, w/float64(n), xmlText(text), n, n, d.String(), frame))
+	})
+}
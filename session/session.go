@@ -1,6 +1,8 @@
 package session
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -10,17 +12,21 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 )
 
 var (
-	srcBGPat = regexp.MustCompile(`<image[^>]*id="src-bg"[^>]*>`)
-	annotTpl = template.Must(template.New("").Funcs(map[string]any{
+	srcBGPat    = regexp.MustCompile(`<image[^>]*id="src-bg"[^>]*>`)
+	pageSizePat = regexp.MustCompile(`(?m)^Page\s+(\d+)\s+size:\s+([\d.]+)\s+x\s+([\d.]+)\s+pts`)
+	pageRotPat  = regexp.MustCompile(`(?m)^Page\s+(\d+)\s+rot:\s+(-?\d+)`)
+	annotTpl    = template.Must(template.New("").Funcs(map[string]any{
 		"stripunit": func(v string) string {
 			return strings.TrimRight(v, "x%npiemtc")
 		},
@@ -78,27 +84,129 @@ func fileCopy(src, dst string) error {
 	return err
 }
 
+// PageInfo holds the details of a page.
+type PageInfo struct {
+	// Width and Height are the dimensions of the page in points, before
+	// rotation.
+	Width, Height float64
+	// Rotation is the clockwise rotation of the page in degrees.
+	Rotation int
+	// Annotated is true if the page has annotations.
+	Annotated bool
+	// LastEdited is when the annotations were last changed. It's zero for
+	// pages without annotations.
+	LastEdited time.Time
+}
+
+// DisplaySize returns the dimensions of the page in points as shown, that
+// is after rotation.
+func (i PageInfo) DisplaySize() (width, height float64) {
+	if i.Rotation%180 != 0 {
+		return i.Height, i.Width
+	}
+	return i.Width, i.Height
+}
+
+// Landscape returns true if the page is wider than it's tall as shown.
+func (i PageInfo) Landscape() bool {
+	w, h := i.DisplaySize()
+	return w > h
+}
+
+type pageGeometry struct {
+	width, height float64
+	rotation      int
+}
+
 // Session represents an annotation session.
+//
+// Pages are addressed by their current position in the document, which
+// changes as pages are moved around. Intermediate files are named after the
+// page's index in the source PDF (its ID) so they stay valid across moves.
 type Session struct {
+	// path is the session's own copy of origin
 	path      string
+	origin    string
 	pageCount int
 	tmpDir    string
+	// live is read locked by renders, which can take long and so are run
+	// without the caller holding any lock of its own, and write locked by
+	// Close so it waits for them rather than pulling the session from under
+	// them.
+	live      sync.RWMutex
 	mu        sync.Mutex
 	annotated map[int]struct{}
+	order     []int
+	// trash holds the paths of cleared annotations by page ID, most recent
+	// last, so they can be restored.
+	trash  map[int][]string
+	editor Editor
+	// compositor draws annotations over renders of pages, if set.
+	compositor Compositor
+	// watermark is drawn over pages on saving, if set, with its pages by ID.
+	watermark *Watermark
+	// numbering is the page numbers drawn on saving, if set, with its
+	// skipped pages by ID.
+	numbering *PageNumbering
+	// renderer, converter and merger replace the default tools, if set.
+	renderer  Renderer
+	converter Converter
+	merger    Merger
+	// saveProgress is told about progress of writing saved PDFs, if set.
+	saveProgress SaveProgress
+	// notes are free-form notes by page ID
+	notes map[int]string
+	// reverted holds the IDs of pages whose annotations were put back by
+	// Revert and haven't been changed since.
+	reverted map[int]struct{}
+	// sidecar is the directory annotations are kept in beside the PDF, if set
+	// by UseSidecar.
+	sidecar string
+
+	// inPlace is set if the session works on the PDF it was opened from
+	// rather than its own copy, to tell whether it has changed since.
+	inPlace *sourceCheck
+
+	// flattenForm is whether form fields are flattened on saving.
+	flattenForm bool
+
+	// exporting holds the locks of exporting pages to SVG by page ID.
+	exporting map[int]*sync.Mutex
+	// editing counts the editors open on pages by page ID.
+	editing map[int]int
+	// shell runs Inkscape conversions.
+	shell inkscapeShell
+
+	geomMu   sync.Mutex
+	geometry []pageGeometry
+	form     Form
+	// specs caches the dimensions of SVG exports by page ID.
+	specs map[int]svgSpecs
+
+	// diskCap is the size the session's directory is kept under, if set,
+	// by removing the least recently used intermediate files by lastUsed.
+	diskMu   sync.Mutex
+	diskCap  int64
+	lastUsed map[string]time.Time
+
+	// stamps caches the rubric stamps of annotation SVGs by path.
+	stampsMu sync.Mutex
+	stamps   map[string]stampCacheEntry
 }
 
-// New opens the given PDF file by path and returns a new session.
+// New opens the given PDF file by path and returns a new session. The
+// session works on its own copy of the file.
 func New(path string) (*Session, error) {
+	return open(path, false)
+}
+
+func open(path string, inPlace bool) (*Session, error) {
 
 	// Get page count
 
-	out, err := exec.Command("qpdf", "--warning-exit-0", "--show-npages", path).Output()
-	if err != nil {
-		return nil, cmdErr(err)
-	}
-	p, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	p, err := CountPages(path)
 	if err != nil {
-		return nil, fmt.Errorf("cannot convert page count: %s", err)
+		return nil, err
 	}
 
 	// Create temp dir
@@ -108,18 +216,37 @@ func New(path string) (*Session, error) {
 		return nil, fmt.Errorf("failed to create temp directory: %s", err)
 	}
 
-	// Make our own copy
+	// Make our own copy (unless opened in place)
 
 	copyPath := filepath.Join(tmpDir, "src.pdf")
-	if err := fileCopy(path, copyPath); err != nil {
+	var check *sourceCheck
+	if inPlace {
+		copyPath = path
+		if check, err = newSourceCheck(path); err != nil {
+			_ = os.Remove(tmpDir)
+			return nil, err
+		}
+	} else if err := fileCopy(path, copyPath); err != nil {
 		return nil, err
 	}
 
+	order := make([]int, p)
+	for i := range order {
+		order[i] = i
+	}
+
+	logf(LogSession, "opened '%s' with %d pages in '%s'", path, p, tmpDir)
 	return &Session{
 		path:      copyPath,
+		origin:    path,
 		pageCount: p,
 		tmpDir:    tmpDir,
 		annotated: map[int]struct{}{},
+		order:     order,
+		trash:     map[int][]string{},
+		notes:     map[int]string{},
+		reverted:  map[int]struct{}{},
+		inPlace:   check,
 	}, nil
 }
 
@@ -128,36 +255,364 @@ func (s *Session) PageCount() int {
 	return s.pageCount
 }
 
+// pageID returns the index of the page at the given position in the source
+// PDF.
+func (s *Session) pageID(page int) int {
+	if page < 0 || page >= s.pageCount {
+		panic("invalid page number")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order[page]
+}
+
+// Note returns the free-form notes of the given page.
+func (s *Session) Note(page int) string {
+	id := s.pageID(page)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notes[id]
+}
+
+// SetNote sets the free-form notes of the given page. Notes are kept with the
+// session only and are not rendered into the saved PDF.
+func (s *Session) SetNote(page int, note string) {
+	id := s.pageID(page)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if note == "" {
+		delete(s.notes, id)
+	} else {
+		s.notes[id] = note
+	}
+}
+
+// Move moves the page at position from to position to, shifting the pages in
+// between.
+func (s *Session) Move(from, to int) {
+	if from < 0 || from >= s.pageCount || to < 0 || to >= s.pageCount {
+		panic("invalid page number")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.order[from]
+	copy(s.order[from:], s.order[from+1:])
+	copy(s.order[to+1:], s.order[to:s.pageCount-1])
+	s.order[to] = id
+	logf(LogSession, "moved page %d to %d in '%s'", from+1, to+1, s.origin)
+}
+
+// IsReordered returns true if the pages are no longer in their original
+// order.
+func (s *Session) IsReordered() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, id := range s.order {
+		if i != id {
+			return true
+		}
+	}
+	return false
+}
+
+// ThumbnailSize and PreviewSize are the sizes in pixels of the squares
+// thumbnails and previews fit in. The longer side of the page, as shown after
+// rotation, is scaled to them. They may only be changed before any session
+// is opened.
+var (
+	ThumbnailSize = 200
+	PreviewSize   = 800
+)
+
+// ErrClosed is returned for renders asked of a closed session.
+var ErrClosed = errors.New("session is closed")
+
+// use read locks live for a render, returning false if the session is
+// closed already. It's unlocked with s.live.RUnlock.
+func (s *Session) use() bool {
+	s.live.RLock()
+	if s.pageCount == -1 {
+		s.live.RUnlock()
+		return false
+	}
+	return true
+}
+
 // Thumbnail returns the path to temporary thumbnail image of the given page.
 func (s *Session) Thumbnail(page int) (string, error) {
+	if !s.use() {
+		return "", ErrClosed
+	}
+	defer s.live.RUnlock()
+	return s.renderPage(page, ThumbnailSize)
+}
 
-	if page < 0 || page >= s.pageCount {
-		panic("invalid page number")
+// Preview returns the path to temporary preview image of the given page,
+// which is a larger rendering than the thumbnail.
+func (s *Session) Preview(page int) (string, error) {
+	if !s.use() {
+		return "", ErrClosed
 	}
+	defer s.live.RUnlock()
+	return s.renderPage(page, PreviewSize)
+}
 
-	thumbPath := s.thumbPath(page)
+// render renders the page with the given ID to path at the given size,
+// unless it's already been rendered.
+func (s *Session) render(page, size int, path string) (string, error) {
 
 	// Serve from cache if available
 
-	if _, err := os.Stat(thumbPath); err == nil {
-		return thumbPath, nil
+	if _, err := os.Stat(path); err == nil {
+		s.used(path)
+		return path, nil
+	}
+
+	// Otherwise, render with the renderer set or run pdftocairo
+
+	if r, _, _ := s.backends(); r != nil {
+		if err := s.renderWith(r, page, size, path); err != nil {
+			return "", err
+		}
+		s.used(path)
+		s.trim()
+		return path, nil
+	}
+	cmd := command(toolPath("pdftocairo"), expandArgs("pdftocairo-render", map[string][]string{
+		"page": {strconv.Itoa(page + 1)},
+		"size": {strconv.Itoa(size)},
+		"in":   {s.path},
+		"out":  {path + ".tmp"},
+	})...)
+	if _, err := cmd.Output(); err != nil {
+		return "", fmt.Errorf("failed to render page %d of '%s': %s", page, s.path, cmdErr(err))
+	}
+	_ = os.Rename(path+".tmp.png", path)
+	s.used(path)
+	s.trim()
+
+	return path, nil
+}
+
+// RenderPages is like Render for many pages at once, returning the paths in
+// the same order. Pages not rendered yet are rendered by as few runs of
+// pdftoppm as there are stretches of consecutive pages in their original
+// order, rather than a run for each.
+func (s *Session) RenderPages(pages []int, size int) ([]string, error) {
+	if !s.use() {
+		return nil, ErrClosed
+	}
+	defer s.live.RUnlock()
+	return s.renderOriginal(s.OriginalPages(pages), size)
+}
+
+// OriginalPages returns the positions the pages at the given positions had
+// in the PDF as opened, which stay the same as pages are moved.
+func (s *Session) OriginalPages(pages []int) []int {
+	orig := make([]int, len(pages))
+	for i, p := range pages {
+		orig[i] = s.pageID(p)
+	}
+	return orig
+}
+
+// RenderOriginalPages is like RenderPages for pages given by their positions
+// in the PDF as opened, as returned by OriginalPages. It lets pages be looked
+// up while the order is known not to change, e.g. under a lock, and rendered
+// later without holding it.
+func (s *Session) RenderOriginalPages(orig []int, size int) ([]string, error) {
+	if !s.use() {
+		return nil, ErrClosed
 	}
+	defer s.live.RUnlock()
+	return s.renderOriginal(orig, size)
+}
+
+// renderOriginal is RenderOriginalPages with live read locked.
+func (s *Session) renderOriginal(orig []int, size int) ([]string, error) {
+	for _, id := range orig {
+		if id < 0 || id >= s.pageCount {
+			return nil, fmt.Errorf("invalid original page %d", id+1)
+		}
+	}
+	pathOf := func(id int) string {
+		return filepath.Join(s.tmpDir, fmt.Sprintf("render-%d-%d.png", id, size))
+	}
+	switch size {
+	case ThumbnailSize:
+		pathOf = s.thumbPath
+	case PreviewSize:
+		pathOf = s.previewPath
+	}
+	return s.renderAll(orig, size, pathOf)
+}
 
-	// Otherwise, run pdftocairo to generate image
+// renderAll renders the pages with the given IDs at the given size to the
+// paths pathOf returns for them, unless they've already been rendered.
+func (s *Session) renderAll(ids []int, size int, pathOf func(id int) string) ([]string, error) {
+	paths := make([]string, len(ids))
+	var todo []int
+	for i, id := range ids {
+		paths[i] = pathOf(id)
+		if _, err := os.Stat(paths[i]); err != nil {
+			todo = append(todo, id)
+		}
+		s.used(paths[i])
+	}
+	sort.Ints(todo)
+	if r, _, _ := s.backends(); r != nil {
+		for _, id := range todo {
+			if err := s.renderWith(r, id, size, pathOf(id)); err != nil {
+				return nil, err
+			}
+		}
+		todo = nil
+	}
+	for len(todo) > 0 {
+		n := 1
+		for n < len(todo) && todo[n] == todo[n-1]+1 {
+			n++
+		}
+		if err := s.renderRun(todo[0], todo[n-1], size, pathOf); err != nil {
+			return nil, err
+		}
+		todo = todo[n:]
+	}
+	s.trim()
+	return paths, nil
+}
 
-	cmd := exec.Command("pdftocairo", "-f", strconv.Itoa(page+1), "-png",
-		"-singlefile", "-cropbox", "-scale-to", "200", s.path, thumbPath+".tmp")
+// renderRun renders the pages with IDs first to last with a single run of
+// pdftoppm.
+func (s *Session) renderRun(first, last, size int, pathOf func(id int) string) error {
+	prefix := filepath.Join(s.tmpDir, fmt.Sprintf("batch-%d", size))
+	cmd := command(toolPath("pdftoppm"), "-png", "-cropbox", "-scale-to", strconv.Itoa(size),
+		"-f", strconv.Itoa(first+1), "-l", strconv.Itoa(last+1), s.path, prefix)
 	if _, err := cmd.Output(); err != nil {
-		return "", fmt.Errorf("failed to generate thumb for page %d of '%s': %s", page, s.path, cmdErr(err))
+		return fmt.Errorf("failed to render pages %d-%d of '%s': %s", first+1, last+1, s.path, cmdErr(err))
+	}
+
+	// pdftoppm pads page numbers to the width of the last page
+
+	digits := len(strconv.Itoa(s.pageCount))
+	for id := first; id <= last; id++ {
+		out := fmt.Sprintf("%s-%0*d.png", prefix, digits, id+1)
+		if err := os.Rename(out, pathOf(id)); err != nil {
+			return fmt.Errorf("failed to render page %d of '%s': %s", id+1, s.path, err)
+		}
+	}
+	return nil
+}
+
+// Render returns the path to a temporary image of the given page rendered
+// like thumbnails but fit in a square of the given size in pixels.
+func (s *Session) Render(page, size int) (string, error) {
+	if !s.use() {
+		return "", ErrClosed
 	}
-	_ = os.Rename(thumbPath+".tmp.png", thumbPath)
+	defer s.live.RUnlock()
+	return s.renderPage(page, size)
+}
 
-	return thumbPath, nil
+// renderPage is Render with live read locked.
+func (s *Session) renderPage(page, size int) (string, error) {
+	id := s.pageID(page)
+	switch size {
+	case ThumbnailSize:
+		return s.render(id, size, s.thumbPath(id))
+	case PreviewSize:
+		return s.render(id, size, s.previewPath(id))
+	}
+	return s.render(id, size, filepath.Join(s.tmpDir, fmt.Sprintf("render-%d-%d.png", id, size)))
+}
+
+// AnnotatedThumbnail and AnnotatedPreview are like Thumbnail and Preview but
+// show the page with its annotations drawn over it. They fail for pages
+// without annotations.
+func (s *Session) AnnotatedThumbnail(page int) (string, error) {
+	if !s.use() {
+		return "", ErrClosed
+	}
+	defer s.live.RUnlock()
+	return s.renderAnnotated(page, ThumbnailSize, withAnnotations(s.thumbPath(s.pageID(page))))
+}
+
+func (s *Session) AnnotatedPreview(page int) (string, error) {
+	if !s.use() {
+		return "", ErrClosed
+	}
+	defer s.live.RUnlock()
+	return s.renderAnnotated(page, PreviewSize, withAnnotations(s.previewPath(s.pageID(page))))
+}
+
+// Compositor draws the annotations in the SVG at overlay over the page
+// image at base, writing the result to out as PNG.
+type Compositor func(base, overlay, out string) error
+
+// SetCompositor sets what draws annotations over renders of pages for
+// AnnotatedThumbnail and AnnotatedPreview, which is far quicker than
+// Inkscape rendering both. Inkscape is still used without one, or if it
+// fails.
+func (s *Session) SetCompositor(c Compositor) {
+	s.mu.Lock()
+	s.compositor = c
+	s.mu.Unlock()
+}
+
+// renderAnnotated renders the given page with its annotations to path at the
+// given size, unless it's already been rendered. The annotations are drawn
+// over the render of the page by the compositor if set, or else Inkscape
+// renders the annotation SVG, which includes the page as background.
+func (s *Session) renderAnnotated(page, size int, path string) (string, error) {
+	if !s.IsAnnotated(page) {
+		return "", fmt.Errorf("page %d has no annotations", page+1)
+	}
+	if _, err := os.Stat(path); err == nil {
+		s.used(path)
+		return path, nil
+	}
+	defer s.trim()
+	defer s.used(path)
+
+	s.mu.Lock()
+	composite := s.compositor
+	s.mu.Unlock()
+	if composite != nil {
+		base, err := s.renderPage(page, size)
+		overlay := s.annotPath(s.pageID(page)) + ".overlay.svg"
+		if err == nil {
+			err = s.writeCleaned(s.pageID(page), overlay)
+		}
+		if err == nil {
+			err = composite(base, overlay, path+".tmp.png")
+		}
+		_ = os.Remove(overlay)
+		if err == nil {
+			_ = os.Rename(path+".tmp.png", path)
+			return path, nil
+		}
+	}
+
+	info, err := s.PageInfo(page)
+	if err != nil {
+		return "", err
+	}
+	sizeFlag := "--export-height="
+	if info.Landscape() {
+		sizeFlag = "--export-width="
+	}
+	cmd := command(toolPath("inkscape"), "--export-type=png", sizeFlag+strconv.Itoa(size),
+		"--export-filename="+path+".tmp.png", s.annotPath(s.pageID(page)))
+	if _, err := cmd.Output(); err != nil {
+		return "", fmt.Errorf("failed to render annotations of page %d: %s", page+1, cmdErr(err))
+	}
+	_ = os.Rename(path+".tmp.png", path)
+	return path, nil
 }
 
 func getInkscapeVersion() (*semver.Version, error) {
 
-	cmd := exec.Command("inkscape", "--version")
+	cmd := command(toolPath("inkscape"), "--version")
 	verBytes, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get inkscape version: %s", cmdErr(err))
@@ -176,99 +631,241 @@ func getInkscapeVersion() (*semver.Version, error) {
 
 // Annotate blocks and launches Inkscape to annotate the page.
 // It returns true if the page was annotated by the user this time around.
-func (s *Session) Annotate(page int) (bool, error) {
+// Cancelling ctx kills Inkscape, in which case ctx's error is returned along
+// with whether any changes were saved before then.
+func (s *Session) Annotate(ctx context.Context, page int) (bool, error) {
 
-	if page < 0 || page >= s.pageCount {
-		panic("invalid page number")
+	logf(LogSession, "annotating page %d of '%s'", page+1, s.origin)
+	page = s.pageID(page)
+	s.mu.Lock()
+	if s.editing == nil {
+		s.editing = map[int]int{}
+	}
+	s.editing[page]++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.editing[page]--
+		s.mu.Unlock()
+	}()
+	if err := s.prepare(ctx, page); err != nil {
+		return false, err
+	}
+	annotPath := s.annotPath(page)
+
+	// Run the editor (Inkscape in GUI mode) to edit the annotation file
+
+	beforeEditStat, err := os.Stat(annotPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat '%s': %s", annotPath, err)
 	}
 
-	// Export PDF page to SVG (if needed)
+	s.mu.Lock()
+	editor := s.editor
+	s.mu.Unlock()
+	args, err := editor.args(annotPath)
+	if err != nil {
+		return false, err
+	}
+
+	placeCtx, placed := context.WithCancel(ctx)
+	go editor.place(placeCtx, annotPath)
+	var cancelErr error
+	cmd := commandContext(ctx, args[0], args[1:]...)
+	setEnv(cmd, editor.Env)
+	_, err = cmd.Output()
+	placed()
+	if err != nil {
+		if ctx.Err() == nil {
+			return false, fmt.Errorf("%s exited with error while editing '%s': %s", args[0], annotPath, err)
+		}
+		cancelErr = ctx.Err()
+	}
+
+	afterEditStat, err := os.Stat(annotPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat '%s': %s", annotPath, err)
+	}
+
+	modified := afterEditStat.ModTime() != beforeEditStat.ModTime()
+	if modified {
+		s.dropRenders(page)
+		s.mu.Lock()
+		s.annotated[page] = struct{}{}
+		delete(s.reverted, page)
+		s.mu.Unlock()
+		if err := s.syncSidecar(page, true); err != nil {
+			return modified, err
+		}
+	}
+	return modified, cancelErr
+}
+
+// Prefetch exports the given page to SVG ahead of annotating it, so the
+// editor opens without waiting for the export. It does nothing if the page
+// was exported already, and fails with ErrClosed if the session is closed.
+// Closing the session waits for it.
+func (s *Session) Prefetch(ctx context.Context, page int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !s.use() {
+		return ErrClosed
+	}
+	defer s.live.RUnlock()
+	return s.export(ctx, s.pageID(page))
+}
+
+// exportLock returns the lock held while exporting the page with the given
+// ID, so a prefetch and annotating the page don't both export it.
+func (s *Session) exportLock(page int) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exporting == nil {
+		s.exporting = map[int]*sync.Mutex{}
+	}
+	l, ok := s.exporting[page]
+	if !ok {
+		l = &sync.Mutex{}
+		s.exporting[page] = l
+	}
+	return l
+}
+
+// export exports the page with the given ID from the PDF to SVG, unless it
+// was already.
+func (s *Session) export(ctx context.Context, page int) error {
+	l := s.exportLock(page)
+	l.Lock()
+	defer l.Unlock()
 
 	srcPath := s.srcPath(page)
-	if _, err := os.Stat(srcPath); err != nil {
+	defer s.used(srcPath)
+	if _, err := os.Stat(srcPath); err == nil {
+		return nil
+	}
+	defer s.trim()
+
+	// The page is split off first since the page Inkscape imports can't be
+	// chosen in its shell.
+
+	pagePath := srcPath + ".pdf"
+	if _, err := qpdf("--empty", "--pages", s.path, strconv.Itoa(page+1), "--", pagePath); err != nil {
+		return fmt.Errorf("failed to split page %d off '%s': %s", page+1, s.path, err)
+	}
+	defer os.Remove(pagePath)
+	err := s.convert(ctx, conversion{
+		in:         pagePath,
+		out:        srcPath + ".svg",
+		importArgs: []string{"--pdf-poppler"},
+		opts:       []exportOpt{{"type", "svg"}},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to convert page %d of '%s' to svg: %s", page+1, s.path, err)
+	}
+	_ = os.Rename(srcPath+".svg", srcPath)
+	return nil
+}
+
+// svgSpecs are the dimensions given on the root element of an SVG.
+type svgSpecs struct {
+	Width, Height, ViewBox string
+}
 
-		// Page selection flag has changed between Inkscape versions. Check the
-		// inkscape version first.
+// pageSpecs returns the dimensions of the SVG export of the page with the
+// given ID. Exports don't change, so they're only read from it once, and
+// only up to the root element.
+func (s *Session) pageSpecs(page int) (svgSpecs, error) {
+	s.geomMu.Lock()
+	specs, ok := s.specs[page]
+	s.geomMu.Unlock()
+	if ok {
+		return specs, nil
+	}
 
-		sv, err := getInkscapeVersion()
+	srcPath := s.srcPath(page)
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return svgSpecs{}, fmt.Errorf("failed to open '%s': %s", srcPath, err)
+	}
+	defer f.Close()
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
 		if err != nil {
-			return false, err
+			return svgSpecs{}, fmt.Errorf("failed to parse svg at '%s': %s", srcPath, err)
 		}
-		var pagesFlag string
-		if sv.LessThan(semver.MustParse("1.3.0")) {
-			pagesFlag = "--pdf-page="
-		} else {
-			pagesFlag = "--pages="
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
 		}
-
-		cmd := exec.Command("inkscape", pagesFlag+strconv.Itoa(page+1), "--export-type=svg",
-			"--pdf-poppler", "--export-filename="+srcPath+".svg", s.path)
-		if _, err := cmd.Output(); err != nil {
-			return false, fmt.Errorf("failed to convert page %d of '%s' to svg: %s", page+1, s.path, cmdErr(err))
+		for _, a := range start.Attr {
+			switch a.Name.Local {
+			case "width":
+				specs.Width = a.Value
+			case "height":
+				specs.Height = a.Value
+			case "viewBox":
+				specs.ViewBox = a.Value
+			}
 		}
-		_ = os.Rename(srcPath+".svg", srcPath)
+		break
+	}
+
+	s.geomMu.Lock()
+	if s.specs == nil {
+		s.specs = map[int]svgSpecs{}
+	}
+	s.specs[page] = specs
+	s.geomMu.Unlock()
+	return specs, nil
+}
+
+// prepare creates the annotation SVG of the page with the given ID with the
+// page as its background, unless it exists.
+func (s *Session) prepare(ctx context.Context, page int) error {
+
+	// Export PDF page to SVG (if needed)
+
+	if err := s.export(ctx, page); err != nil {
+		return err
 	}
+	srcPath := s.srcPath(page)
 
 	// Create a new SVG with above as background (if needed)
 
 	annotPath := s.annotPath(page)
 	if _, err := os.Stat(annotPath); err != nil {
-		pageSpecs := struct {
-			Width   string `xml:"width,attr"`
-			Height  string `xml:"height,attr"`
-			ViewBox string `xml:"viewBox,attr"`
-			Href    string `xml:"-"`
-		}{}
-		f, err := os.Open(srcPath)
+		specs, err := s.pageSpecs(page)
 		if err != nil {
-			return false, fmt.Errorf("failed to open '%s': %s", srcPath, err)
+			return err
 		}
-		if err := xml.NewDecoder(f).Decode(&pageSpecs); err != nil {
-			f.Close()
-			return false, fmt.Errorf("failed to parse svg at '%s': %s", srcPath, err)
-		}
-		f.Close()
 
-		f, err = os.Create(annotPath + ".tmp")
+		f, err := os.Create(annotPath + ".tmp")
 		if err != nil {
-			return false, fmt.Errorf("failed to create '%s': %s", annotPath, err)
+			return fmt.Errorf("failed to create '%s': %s", annotPath, err)
 		}
 
-		pageSpecs.Href = srcPath
-		if err := annotTpl.Execute(f, pageSpecs); err != nil {
+		data := struct {
+			svgSpecs
+			Href string
+		}{specs, srcPath}
+		if err := annotTpl.Execute(f, data); err != nil {
 			f.Close()
-			return false, fmt.Errorf("failed to write to '%s': %s", annotPath, err)
+			return fmt.Errorf("failed to write to '%s': %s", annotPath, err)
 		}
 		f.Close()
 		_ = os.Rename(annotPath+".tmp", annotPath)
 	}
-
-	// Run Inkscape in GUI mode to edit the annotation file
-
-	beforeEditStat, err := os.Stat(annotPath)
-	if err != nil {
-		return false, fmt.Errorf("failed to stat '%s': %s", annotPath, err)
-	}
-
-	if _, err := exec.Command("inkscape", annotPath).Output(); err != nil {
-		return false, fmt.Errorf("inkscape exited with error while editing '%s': %s", annotPath, err)
-	}
-
-	afterEditStat, err := os.Stat(annotPath)
-	if err != nil {
-		return false, fmt.Errorf("failed to stat '%s': %s", annotPath, err)
-	}
-
-	modified := afterEditStat.ModTime() != beforeEditStat.ModTime()
-	if modified {
-		_ = os.Remove(s.thumbPath(page))
-		s.mu.Lock()
-		s.annotated[page] = struct{}{}
-		s.mu.Unlock()
-	}
-	return modified, nil
+	return nil
 }
 
+// annotPath, srcPath, thumbPath, previewPath and decorPath take page IDs
+// rather than positions.
+
 func (s *Session) annotPath(page int) string {
 	return filepath.Join(s.tmpDir, fmt.Sprintf("annot-%d.svg", page))
 }
@@ -281,11 +878,47 @@ func (s *Session) thumbPath(page int) string {
 	return filepath.Join(s.tmpDir, fmt.Sprintf("thumb-%d.png", page))
 }
 
+func (s *Session) previewPath(page int) string {
+	return filepath.Join(s.tmpDir, fmt.Sprintf("preview-%d.png", page))
+}
+
+func (s *Session) decorPath(page int) string {
+	return filepath.Join(s.tmpDir, fmt.Sprintf("decor-%d.svg", page))
+}
+
+// withAnnotations returns the path of the rendering with annotations
+// corresponding to the given thumbnail or preview path.
+func withAnnotations(path string) string {
+	return strings.TrimSuffix(path, ".png") + "-annotated.png"
+}
+
+// dropRenders removes the thumbnail and preview of the page with the given ID
+// with annotations, so they're rendered afresh. Those without annotations
+// are kept since the page itself doesn't change.
+func (s *Session) dropRenders(page int) {
+	for _, p := range []string{s.thumbPath(page), s.previewPath(page)} {
+		_ = os.Remove(withAnnotations(p))
+	}
+}
+
+// writeCleaned writes the annotation SVG of the page with the given ID to
+// path without the page as its background, leaving only the annotations.
+func (s *Session) writeCleaned(page int, path string) error {
+	annotPath := s.annotPath(page)
+	b, err := ioutil.ReadFile(annotPath)
+	if err != nil {
+		return fmt.Errorf("failed to read back '%s': %s", annotPath, err)
+	}
+	b = srcBGPat.ReplaceAll(b, nil)
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return fmt.Errorf("failed to write back '%s': %s", annotPath, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
 // IsAnnotated returns true if the given page has any annotations.
 func (s *Session) IsAnnotated(page int) bool {
-	if page < 0 || page >= s.pageCount {
-		panic("invalid page number")
-	}
+	page = s.pageID(page)
 	s.mu.Lock()
 	_, ok := s.annotated[page]
 	s.mu.Unlock()
@@ -299,97 +932,539 @@ func (s *Session) HasAnnotations() bool {
 	return len(s.annotated) > 0
 }
 
-// Clear clears the annotations for the given page.
-func (s *Session) Clear(page int) {
-	if page < 0 || page >= s.pageCount {
-		panic("invalid page number")
+// loadGeometry returns the geometry of all pages by their ID, as reported by
+// pdfinfo. The result is cached for the lifetime of the session.
+func (s *Session) loadGeometry() ([]pageGeometry, error) {
+	s.geomMu.Lock()
+	defer s.geomMu.Unlock()
+	if s.geometry != nil {
+		return s.geometry, nil
+	}
+
+	out, err := command(toolPath("pdfinfo"), "-f", "1", "-l", strconv.Itoa(s.pageCount), s.path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page info of '%s': %s", s.path, cmdErr(err))
+	}
+	geom := make([]pageGeometry, s.pageCount)
+	for _, m := range pageSizePat.FindAllStringSubmatch(string(out), -1) {
+		p, _ := strconv.Atoi(m[1])
+		if p < 1 || p > s.pageCount {
+			continue
+		}
+		geom[p-1].width, _ = strconv.ParseFloat(m[2], 64)
+		geom[p-1].height, _ = strconv.ParseFloat(m[3], 64)
+	}
+	for _, m := range pageRotPat.FindAllStringSubmatch(string(out), -1) {
+		p, _ := strconv.Atoi(m[1])
+		if p < 1 || p > s.pageCount {
+			continue
+		}
+		geom[p-1].rotation, _ = strconv.Atoi(m[2])
+	}
+	s.geometry = geom
+	s.form = parseForm(out)
+	return geom, nil
+}
+
+// PageInfo returns the details of the given page.
+func (s *Session) PageInfo(page int) (PageInfo, error) {
+	id := s.pageID(page)
+	geom, err := s.loadGeometry()
+	if err != nil {
+		return PageInfo{}, err
+	}
+	info := PageInfo{
+		Width:     geom[id].width,
+		Height:    geom[id].height,
+		Rotation:  geom[id].rotation,
+		Annotated: s.IsAnnotated(page),
+	}
+	if info.Annotated {
+		info.LastEdited = s.LastEdited(page)
+	}
+	return info, nil
+}
+
+// LastEdited returns when the annotations of the given page were last saved
+// or the zero time if it has none.
+func (s *Session) LastEdited(page int) time.Time {
+	if !s.IsAnnotated(page) {
+		return time.Time{}
+	}
+	st, err := os.Stat(s.annotPath(s.pageID(page)))
+	if err != nil {
+		return time.Time{}
+	}
+	return st.ModTime()
+}
+
+// nonObjects are the SVG elements which aren't drawn, by local name.
+var nonObjects = map[string]bool{
+	"defs": true, "metadata": true, "namedview": true, "title": true,
+	"desc": true, "style": true, "script": true,
+}
+
+// ObjectCount returns the number of objects, such as strokes, shapes and
+// text, drawn on the given page. Objects inside layers are counted
+// individually while groups count as one. It's 0 for pages without
+// annotations.
+func (s *Session) ObjectCount(page int) (int, error) {
+	if !s.IsAnnotated(page) {
+		return 0, nil
+	}
+	path := s.annotPath(s.pageID(page))
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// containers tracks for each open element whether its children are
+	// counted, i.e. whether it's the root or a layer.
+	var containers []bool
+	count := 0
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse '%s': %s", path, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			isLayer := false
+			id := ""
+			for _, a := range t.Attr {
+				switch a.Name.Local {
+				case "groupmode":
+					isLayer = a.Value == "layer"
+				case "id":
+					id = a.Value
+				}
+			}
+			counted := len(containers) > 0 && containers[len(containers)-1]
+			if counted && !isLayer && id != "src-bg" && !nonObjects[t.Name.Local] {
+				count++
+			}
+			containers = append(containers, len(containers) == 0 || (isLayer && t.Name.Local == "g"))
+		case xml.EndElement:
+			if len(containers) > 0 {
+				containers = containers[:len(containers)-1]
+			}
+		}
+	}
+}
+
+// ImportAnnotations takes the annotation SVGs in the given directory, named
+// as the session names them, i.e. annot-<ID>.svg where ID is the 0-based
+// index of the page in the source PDF. It returns the number of pages
+// imported.
+func (s *Session) ImportAnnotations(dir string) (int, error) {
+	n := 0
+	for id := 0; id < s.pageCount; id++ {
+		path := filepath.Join(dir, filepath.Base(s.annotPath(id)))
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := fileCopy(path, s.annotPath(id)); err != nil {
+			return n, fmt.Errorf("failed to import '%s': %s", path, err)
+		}
+		s.mu.Lock()
+		s.annotated[id] = struct{}{}
+		s.mu.Unlock()
+		s.dropRenders(id)
+		if err := s.syncSidecar(id, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// PutAnnotation sets the annotation SVG of the given page to one made
+// elsewhere rather than in the editor. It's overlaid on the page as is when
+// saving.
+func (s *Session) PutAnnotation(page int, svg []byte) error {
+	id := s.pageID(page)
+	path := s.annotPath(id)
+	if err := ioutil.WriteFile(path+".tmp", svg, 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
 	}
-	_ = os.Remove(s.annotPath(page))
-	_ = os.Remove(s.thumbPath(page))
 	s.mu.Lock()
-	delete(s.annotated, page)
+	s.annotated[id] = struct{}{}
+	delete(s.reverted, id)
 	s.mu.Unlock()
+	logf(LogSession, "put annotations of page %d of '%s'", page+1, s.origin)
+	s.dropRenders(id)
+	return s.syncSidecar(id, true)
 }
 
-// Save saves the annotated PDF to the given path.
-func (s *Session) Save(path string) error {
+// AnnotatedCount returns the number of pages with annotations.
+func (s *Session) AnnotatedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.annotated)
+}
+
+// DiskUsage returns the total size in bytes of the session's temporary files.
+func (s *Session) DiskUsage() (int64, error) {
+	files, err := ioutil.ReadDir(s.tmpDir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	return total, nil
+}
 
-	// Shortcut for when no page is annotated
+// Source returns the path of the session's own copy of the PDF it was
+// opened from, as it was when opened, or of the PDF itself if opened in
+// place.
+func (s *Session) Source() string {
+	return s.path
+}
 
-	s.mu.Lock()
-	if len(s.annotated) == 0 {
+// TmpDir returns the directory where the session keeps its intermediate
+// files.
+func (s *Session) TmpDir() string {
+	return s.tmpDir
+}
+
+// PageUsage is the disk usage in bytes of the intermediate files of a page.
+type PageUsage struct {
+	Thumbnail  int64
+	Preview    int64
+	Source     int64
+	Annotation int64
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// PageUsage returns the disk usage of the intermediate files of the given
+// page.
+func (s *Session) PageUsage(page int) PageUsage {
+	page = s.pageID(page)
+	return PageUsage{
+		Thumbnail:  fileSize(s.thumbPath(page)) + fileSize(withAnnotations(s.thumbPath(page))),
+		Preview:    fileSize(s.previewPath(page)) + fileSize(withAnnotations(s.previewPath(page))),
+		Source:     fileSize(s.srcPath(page)),
+		Annotation: fileSize(s.annotPath(page)),
+	}
+}
+
+// Purge removes the intermediate files which can be regenerated, i.e. all
+// thumbnails and previews along with the SVGs of pages without annotations.
+// The source SVGs of annotated pages (including cleared ones in the trash)
+// are kept since annotations are drawn over them.
+func (s *Session) Purge() error {
+	var paths []string
+	for id := 0; id < s.pageCount; id++ {
+		for _, p := range []string{s.thumbPath(id), s.previewPath(id)} {
+			paths = append(paths, p, withAnnotations(p))
+		}
+		s.mu.Lock()
+		_, annotated := s.annotated[id]
+		trashed := len(s.trash[id]) > 0
 		s.mu.Unlock()
-		return fileCopy(s.path, path)
+		if !annotated && !trashed {
+			paths = append(paths, s.srcPath(id), s.annotPath(id))
+		}
+	}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove '%s': %s", p, err)
+		}
+	}
+	return nil
+}
+
+// Clear clears the annotations for the given page. The annotations are moved
+// to the session's trash from where Restore can bring them back.
+func (s *Session) Clear(page int) error {
+	id := s.pageID(page)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trashPath := filepath.Join(s.tmpDir, fmt.Sprintf("trash-%d-%d.svg", id, len(s.trash[id])))
+	if err := os.Rename(s.annotPath(id), trashPath); err != nil {
+		return fmt.Errorf("failed to move annotations of page %d to trash: %s", page+1, err)
+	}
+	s.trash[id] = append(s.trash[id], trashPath)
+	s.dropRenders(id)
+	delete(s.annotated, id)
+	delete(s.reverted, id)
+	logf(LogSession, "cleared annotations of page %d of '%s'", page+1, s.origin)
+	return s.syncSidecar(id, false)
+}
+
+// Restore brings back the most recently cleared annotations of the given
+// page. It fails if the page has been annotated since, other than by Revert.
+func (s *Session) Restore(page int) error {
+	id := s.pageID(page)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trash := s.trash[id]
+	if len(trash) == 0 {
+		return fmt.Errorf("page %d has no cleared annotations", page+1)
+	}
+	_, annotated := s.annotated[id]
+	_, reverted := s.reverted[id]
+	if annotated && !reverted {
+		return fmt.Errorf("page %d has been annotated since it was cleared", page+1)
+	}
+	if err := os.Rename(trash[len(trash)-1], s.annotPath(id)); err != nil {
+		return fmt.Errorf("failed to restore annotations of page %d: %s", page+1, err)
+	}
+	s.trash[id] = trash[:len(trash)-1]
+	s.dropRenders(id)
+	s.annotated[id] = struct{}{}
+	delete(s.reverted, id)
+	return s.syncSidecar(id, true)
+}
+
+func (s *Session) savedPath(page int) string {
+	return filepath.Join(s.tmpDir, fmt.Sprintf("saved-%d.svg", page))
+}
+
+// IsChangedSinceSave returns true if the annotations of the given page
+// differ from when the document was last saved. Before the first save, any
+// annotations count as changes.
+func (s *Session) IsChangedSinceSave(page int) bool {
+	id := s.pageID(page)
+	s.mu.Lock()
+	_, annotated := s.annotated[id]
+	s.mu.Unlock()
+	saved, err := ioutil.ReadFile(s.savedPath(id))
+	if err != nil {
+		return annotated
+	}
+	if !annotated {
+		return true
 	}
+	cur, err := ioutil.ReadFile(s.annotPath(id))
+	return err != nil || !bytes.Equal(cur, saved)
+}
+
+// Revert puts the annotations of the given page back to how they were when
+// the document was last saved, removing them if it had none then. The
+// current annotations are moved to the trash from where Restore can bring
+// them back.
+func (s *Session) Revert(page int) error {
+	id := s.pageID(page)
+	if s.IsAnnotated(page) {
+		if err := s.Clear(page); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(s.savedPath(id)); err != nil {
+		return nil
+	}
+	if err := fileCopy(s.savedPath(id), s.annotPath(id)); err != nil {
+		return fmt.Errorf("failed to revert annotations of page %d: %s", page+1, err)
+	}
+	s.mu.Lock()
+	s.annotated[id] = struct{}{}
+	s.reverted[id] = struct{}{}
 	s.mu.Unlock()
+	return s.syncSidecar(id, true)
+}
 
-	// Covert all annotated pages to PDF
+// Save saves the annotated PDF to the given path and remembers the
+// annotations of each page as saved, for Revert.
+func (s *Session) Save(path string) error {
+	return s.SaveContext(context.Background(), path)
+}
 
-	annotated := []int{}
-	for i := 0; i < s.pageCount; i++ {
-		if !s.IsAnnotated(i) {
+// SaveContext is like Save but stops once ctx is cancelled, returning its
+// error.
+func (s *Session) SaveContext(ctx context.Context, path string) error {
+	logf(LogSession, "saving '%s' to '%s'", s.origin, path)
+	if err := s.save(ctx, path); err != nil {
+		logf(LogSession, "failed to save '%s': %s", s.origin, err)
+		return err
+	}
+	for id := 0; id < s.pageCount; id++ {
+		s.mu.Lock()
+		_, annotated := s.annotated[id]
+		s.mu.Unlock()
+		if !annotated {
+			_ = os.Remove(s.savedPath(id))
 			continue
 		}
-		annotated = append(annotated, i)
+		if err := fileCopy(s.annotPath(id), s.savedPath(id)); err != nil {
+			return fmt.Errorf("failed to keep saved annotations: %s", err)
+		}
+	}
+	return nil
+}
 
-		annotPath := s.annotPath(i)
+func (s *Session) save(ctx context.Context, path string) error {
+	if err := s.checkSource(path); err != nil {
+		return err
+	}
 
-		// Remove the backgrounds
+	// Everything is written by the merger in one go from the session's copy,
+	// with the pages in their current order
 
-		b, err := ioutil.ReadFile(annotPath)
-		if err != nil {
-			return fmt.Errorf("failed to read back '%s': %s", annotPath, err)
+	s.mu.Lock()
+	job := MergeJob{Source: s.path, Order: append([]int(nil), s.order...)}
+	s.mu.Unlock()
+
+	// Annotated pages go through a pipeline: their annotation SVGs are
+	// cleaned up a few at a time, converted to PDF all at once and finally
+	// overlaid. Decorations are written afresh and go through it too, on top
+	// of annotations. The first page failing fails saving.
+
+	type overlay struct {
+		page  int
+		conv  conversion
+		write func(svg string) error
+	}
+	var overlays []overlay
+	pdf := []exportOpt{{"type", "pdf"}}
+	for i := 0; i < s.pageCount; i++ {
+		id := s.pageID(i)
+		if s.IsAnnotated(i) {
+			annotPath := s.annotPath(id)
+			overlays = append(overlays, overlay{
+				page:  i,
+				conv:  conversion{in: annotPath + ".cleaned.svg", out: annotPath + ".pdf", opts: pdf},
+				write: func(svg string) error { return s.writeCleaned(id, svg) },
+			})
+		}
+		if s.decorates(id) {
+			page, decorPath := i, s.decorPath(id)
+			overlays = append(overlays, overlay{
+				page:  i,
+				conv:  conversion{in: decorPath, out: decorPath + ".pdf", opts: pdf},
+				write: func(svg string) error { return s.writeDecoration(page, svg) },
+			})
+		}
+	}
+	convs := make([]conversion, len(overlays))
+	for i, o := range overlays {
+		convs[i] = o.conv
+	}
+	defer func() {
+		for _, c := range convs {
+			_ = os.Remove(c.in)
+			_ = os.Remove(c.out)
 		}
-		b = srcBGPat.ReplaceAll(b, nil)
-		if err := ioutil.WriteFile(annotPath+".cleaned.svg", b, 0644); err != nil {
-			return fmt.Errorf("failed to write back '%s': %s", annotPath, err)
+	}()
+	pageErr := func(errs []error) error {
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to save page %d: %s", overlays[i].page+1, err)
 		}
+		return nil
+	}
 
-		// Convert to PDF
+	// Remove the backgrounds and draw the decorations
 
-		cmd := exec.Command("inkscape", "--export-type=pdf",
-			"--export-filename="+annotPath+".pdf", annotPath+".cleaned.svg")
-		if _, err := cmd.Output(); err != nil {
-			return fmt.Errorf("failed to convert annotation SVG ('%s') to PDF: %s", annotPath, cmdErr(err))
-		}
+	err := pageErr(parallel(ctx, len(overlays), func(i int) error {
+		return overlays[i].write(convs[i].in)
+	}))
+	if err != nil {
+		return err
 	}
 
-	// Append all annotated PDFs into a single PDF
+	// Convert to PDF
+
+	if err := pageErr(s.convertAll(ctx, convs)); err != nil {
+		return err
+	}
 
-	overlayPath := filepath.Join(s.tmpDir, "overlay.pdf")
+	// Overlay each page with its own PDFs. Pages to overlay are numbered as
+	// in the output, after reordering.
 
-	args := []string{"--warning-exit-0", "--empty", "--pages"}
-	for _, p := range annotated {
-		args = append(args, s.annotPath(p)+".pdf")
+	for i, o := range overlays {
+		job.Overlays = append(job.Overlays, Overlay{Page: o.page, File: convs[i].out})
 	}
-	args = append(args, "--", overlayPath)
 
-	cmd := exec.Command("qpdf", args...)
-	if _, err := cmd.Output(); err != nil {
-		return fmt.Errorf("failed to merge annotated pages to '%s': %s", overlayPath, cmdErr(err))
+	if job.FlattenForm, err = s.flattens(); err != nil {
+		return err
 	}
 
-	// Overlay and create the final file
+	_, _, merger := s.backends()
+	if merger == nil {
+		merger = qpdfMerger{}
+	}
+	return s.writeBeside(path, func(tmp string) error {
+		if err := merger.Merge(ctx, job, tmp); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to write '%s': %s", path, err)
+		}
+		return nil
+	})
+}
 
-	finalPath := filepath.Join(s.tmpDir, "final.pdf")
+// ExportImages renders the annotated document to one image per page in the
+// given directory at the given resolution, and returns their paths in page
+// order. Format is either "png" or "jpeg".
+func (s *Session) ExportImages(dir, format string, dpi int) ([]string, error) {
+	var ext string
+	switch format {
+	case "png":
+		ext = ".png"
+	case "jpeg":
+		ext = ".jpg"
+	default:
+		return nil, fmt.Errorf("unsupported image format '%s'", format)
+	}
 
-	annotedStr := make([]string, len(annotated))
-	for i, p := range annotated {
-		annotedStr[i] = strconv.Itoa(p + 1)
+	pdfPath := filepath.Join(s.tmpDir, "export.pdf")
+	if err := s.save(context.Background(), pdfPath); err != nil {
+		return nil, err
 	}
-	pageRange := strings.Join(annotedStr, ",")
+	defer os.Remove(pdfPath)
 
-	cmd = exec.Command("qpdf", "--warning-exit-0", s.path, "--overlay", overlayPath, "--to="+pageRange, "--", finalPath)
+	prefix := filepath.Join(dir, "page")
+	cmd := command(toolPath("pdftocairo"), "-"+format, "-r", strconv.Itoa(dpi), "-cropbox", pdfPath, prefix)
 	if _, err := cmd.Output(); err != nil {
-		return fmt.Errorf("failed to overlay annotated pages to '%s': %s", finalPath, cmdErr(err))
+		return nil, fmt.Errorf("failed to render pages to '%s': %s", dir, cmdErr(err))
 	}
 
-	return fileCopy(finalPath, path)
+	// pdftocairo pads page numbers to the width of the last one
+
+	digits := len(strconv.Itoa(s.pageCount))
+	paths := make([]string, s.pageCount)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s-%0*d%s", prefix, digits, i+1, ext)
+		if _, err := os.Stat(paths[i]); err != nil {
+			return nil, fmt.Errorf("failed to render page %d: %s", i+1, err)
+		}
+	}
+	return paths, nil
 }
 
-// Close closes the annotation session and releases all resources.
-// This instance cannot be used after a call to Close().
+// Close closes the annotation session and releases all resources, once
+// renders and prefetches underway are done. This instance cannot be used
+// after a call to Close(), except for renders and prefetches which fail
+// with ErrClosed.
 func (s *Session) Close() {
+	s.live.Lock()
+	defer s.live.Unlock()
+	logf(LogSession, "closing '%s'", s.origin)
+	s.shell.close()
 	files, _ := ioutil.ReadDir(s.tmpDir)
 	for _, f := range files {
 		_ = os.Remove(filepath.Join(s.tmpDir, f.Name()))
@@ -0,0 +1,483 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oxplot/pdfrankenstein/session/sessiontest"
+)
+
+func TestMain(m *testing.M) {
+	sessiontest.Main(m, SetToolPath)
+}
+
+// openFixture opens a session on a copy of the given PDF in testdata,
+// closed once the test is done.
+func openFixture(t *testing.T, name string) *Session {
+	t.Helper()
+	s, err := New(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("opening %s: %s", name, err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// annotate annotates the given page with the fake editor, failing unless it
+// changed.
+func annotate(t *testing.T, s *Session, page int) {
+	t.Helper()
+	changed, err := s.Annotate(context.Background(), page)
+	if err != nil {
+		t.Fatalf("annotating page %d: %s", page+1, err)
+	}
+	if !changed {
+		t.Fatalf("annotating page %d didn't change it", page+1)
+	}
+}
+
+// save saves the session to a new file and returns its pages.
+func save(t *testing.T, s *Session) []sessiontest.Page {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out.pdf")
+	if err := s.Save(out); err != nil {
+		t.Fatalf("saving: %s", err)
+	}
+	pages, err := sessiontest.ReadPDF(out)
+	if err != nil {
+		t.Fatalf("reading saved PDF: %s", err)
+	}
+	return pages
+}
+
+// edited returns whether the page was saved with what the fake editor
+// draws and without the page as background.
+func edited(p sessiontest.Page) bool {
+	for _, c := range p.Content {
+		if strings.Contains(c, sessiontest.Edit) && !strings.Contains(c, "src-bg") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOpen(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	if n := s.PageCount(); n != 3 {
+		t.Fatalf("got %d pages, want 3", n)
+	}
+	info, err := s.PageInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 842 || info.Height != 595 || !info.Landscape() {
+		t.Errorf("got page 2 of %gx%g, want landscape 842x595", info.Width, info.Height)
+	}
+	if s.HasAnnotations() {
+		t.Error("freshly opened session has annotations")
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	thumb, err := s.Thumbnail(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(thumb); err != nil {
+		t.Errorf("thumbnail not rendered: %s", err)
+	}
+	paths, err := s.RenderPages([]int{0, 1, 2}, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("page %d not rendered: %s", i+1, err)
+		}
+	}
+
+	s.Move(2, 0)
+	orig := s.OriginalPages([]int{0})
+	if len(orig) != 1 || orig[0] != 2 {
+		t.Fatalf("got original pages %v of the moved page, want [2]", orig)
+	}
+	moved, err := s.RenderOriginalPages(orig, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved[0] != paths[2] {
+		t.Errorf("moved page rendered to %s, want %s", moved[0], paths[2])
+	}
+	if _, err := s.RenderOriginalPages([]int{3}, 64); err == nil {
+		t.Error("page outside of the PDF rendered")
+	}
+}
+
+func TestRenderClosed(t *testing.T) {
+	s, err := New(filepath.Join("testdata", "one-page.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := s.Thumbnail(0); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v rendering a closed session, want ErrClosed", err)
+	}
+	if _, err := s.RenderPages([]int{0}, 64); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v rendering pages of a closed session, want ErrClosed", err)
+	}
+	if err := s.Prefetch(context.Background(), 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v prefetching from a closed session, want ErrClosed", err)
+	}
+}
+
+func TestSaveUnannotated(t *testing.T) {
+	s := openFixture(t, "one-page.pdf")
+	out := filepath.Join(t.TempDir(), "out.pdf")
+	if err := s.Save(out); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := ioutil.ReadFile(filepath.Join("testdata", "one-page.pdf"))
+	got, _ := ioutil.ReadFile(out)
+	if !bytes.Equal(got, want) {
+		t.Error("saving without annotations changed the PDF")
+	}
+}
+
+func TestAnnotateSave(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	annotate(t, s, 1)
+	if !s.IsAnnotated(1) || s.IsAnnotated(0) || s.AnnotatedCount() != 1 {
+		t.Fatal("only page 2 should be annotated")
+	}
+	n, err := s.ObjectCount(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d objects on page 2, want 1", n)
+	}
+
+	pages := save(t, s)
+	if len(pages) != 3 {
+		t.Fatalf("saved %d pages, want 3", len(pages))
+	}
+	for i, p := range pages {
+		if want := i == 1; edited(p) != want {
+			t.Errorf("page %d saved edited: %v, want %v", i+1, edited(p), want)
+		}
+	}
+	if s.IsChangedSinceSave(1) {
+		t.Error("page 2 changed since saved")
+	}
+}
+
+func TestAnnotateUnchanged(t *testing.T) {
+	s := openFixture(t, "one-page.pdf")
+	s.SetEditor(Editor{Env: []string{"FAKE_INKSCAPE_EDIT=none"}})
+	changed, err := s.Annotate(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || s.IsAnnotated(0) {
+		t.Error("page annotated without being edited")
+	}
+}
+
+func TestClearRestore(t *testing.T) {
+	s := openFixture(t, "one-page.pdf")
+	annotate(t, s, 0)
+	if err := s.Clear(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsAnnotated(0) {
+		t.Fatal("page still annotated once cleared")
+	}
+	if pages := save(t, s); edited(pages[0]) {
+		t.Error("cleared annotations saved")
+	}
+
+	if err := s.Restore(0); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsAnnotated(0) {
+		t.Fatal("page not annotated once restored")
+	}
+	if pages := save(t, s); !edited(pages[0]) {
+		t.Error("restored annotations not saved")
+	}
+}
+
+func TestSaveReordered(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	annotate(t, s, 0)
+	s.Move(0, 2)
+	if !s.IsReordered() || !s.IsAnnotated(2) {
+		t.Fatal("annotated page not moved to the end")
+	}
+
+	pages := save(t, s)
+	if len(pages) != 3 {
+		t.Fatalf("saved %d pages, want 3", len(pages))
+	}
+	if pages[0].Width != "842" {
+		t.Errorf("got page 1 %s wide, want the landscape page", pages[0].Width)
+	}
+	for i, p := range pages {
+		if want := i == 2; edited(p) != want {
+			t.Errorf("page %d saved edited: %v, want %v", i+1, edited(p), want)
+		}
+	}
+}
+
+// countConverter counts the SVGs it converts to PDF, which are written with
+// a single blank page.
+type countConverter struct{ n int }
+
+func (c *countConverter) Convert(ctx context.Context, in, out string) error {
+	c.n++
+	return sessiontest.WritePDF(out, []sessiontest.Page{{Width: "595", Height: "842"}})
+}
+
+// recordMerger records the job it's given and merges with qpdf.
+type recordMerger struct{ job MergeJob }
+
+func (m *recordMerger) Merge(ctx context.Context, job MergeJob, out string) error {
+	m.job = job
+	return qpdfMerger{}.Merge(ctx, job, out)
+}
+
+func TestBackends(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	annotate(t, s, 0)
+	s.Move(0, 2)
+	c, m := &countConverter{}, &recordMerger{}
+	s.SetConverter(c)
+	s.SetMerger(m)
+
+	save(t, s)
+	if c.n != 1 {
+		t.Errorf("converter ran %d times, want once", c.n)
+	}
+	if got := fmt.Sprint(m.job.Order); got != "[1 2 0]" {
+		t.Errorf("merged pages in order %s, want [1 2 0]", got)
+	}
+	if len(m.job.Overlays) != 1 || m.job.Overlays[0].Page != 2 {
+		t.Errorf("got overlays %+v, want one on page 3", m.job.Overlays)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	annotate(t, s, 1)
+	s.SetNote(2, "appendix")
+	x, err := s.Extract(context.Background(), []int{2, 1}, filepath.Join(t.TempDir(), "x.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(x.Close)
+
+	if n := x.PageCount(); n != 2 {
+		t.Fatalf("extracted %d pages, want 2", n)
+	}
+	if x.IsAnnotated(0) || !x.IsAnnotated(1) {
+		t.Error("only the second extracted page should be annotated")
+	}
+	if note := x.Note(0); note != "appendix" {
+		t.Errorf("got note %q on the first extracted page, want \"appendix\"", note)
+	}
+	pages := save(t, x)
+	if edited(pages[0]) || !edited(pages[1]) {
+		t.Error("annotations not saved on the second extracted page only")
+	}
+}
+
+// hasText returns whether the page was saved with the given text drawn on
+// it.
+func hasText(p sessiontest.Page, text string) bool {
+	for _, c := range p.Content {
+		if strings.Contains(c, ">"+text+"<") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWatermark(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	annotate(t, s, 1)
+	err := s.SetWatermark(&Watermark{Text: "DRAFT", Opacity: 0.3, Rotation: 45, Width: 0.6, Pages: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Move(1, 0)
+	if w := s.Watermark(); len(w.Pages) != 1 || w.Pages[0] != 0 {
+		t.Fatalf("got watermark on pages %v once moved, want [0]", w.Pages)
+	}
+
+	pages := save(t, s)
+	for i, p := range pages {
+		if want := i == 0; hasText(p, "DRAFT") != want {
+			t.Errorf("page %d saved watermarked: %v, want %v", i+1, !want, want)
+		}
+	}
+	if !edited(pages[0]) {
+		t.Error("annotations of the watermarked page not saved")
+	}
+
+	if err := s.SetWatermark(nil); err != nil {
+		t.Fatal(err)
+	}
+	if pages := save(t, s); hasText(pages[0], "DRAFT") {
+		t.Error("watermark saved once removed")
+	}
+}
+
+func TestPlaceQRCode(t *testing.T) {
+	s := openFixture(t, "one-page.pdf")
+	url := "https://example.com/doc?a=1&b=2"
+	err := s.PlaceQRCode(context.Background(), 0, url, ImagePlacement{AnchorX: 1, AnchorY: 1, Width: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg, err := s.Annotation(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(svg, []byte("<title>https://example.com/doc?a=1&amp;b=2</title>")) {
+		t.Error("QR code not placed with its text as title")
+	}
+	if !bytes.Contains(svg, []byte(`d="M`)) {
+		t.Error("QR code placed without modules")
+	}
+	if _, _, err := viewBox(svg); err != nil {
+		t.Errorf("annotations no longer parse: %s", err)
+	}
+}
+
+func TestPlaceText(t *testing.T) {
+	s := openFixture(t, "one-page.pdf")
+	err := s.PlaceText(context.Background(), 0, "16 Oct 2026", TextPlacement{AnchorX: 1, AnchorY: 1, Size: 0.02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg, err := s.Annotation(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(svg, []byte("text-anchor:end")) {
+		t.Error("text in the right corner not aligned by its end")
+	}
+	if pages := save(t, s); !hasText(pages[0], "16 Oct 2026") {
+		t.Error("placed text not saved")
+	}
+	if err := s.PlaceText(context.Background(), 0, " ", TextPlacement{Size: 0.02}); err == nil {
+		t.Error("blank text placed")
+	}
+}
+
+func TestPageNumbering(t *testing.T) {
+	s := openFixture(t, "three-pages.pdf")
+	err := s.SetPageNumbering(&PageNumbering{
+		Format:    "{n} of {total}",
+		Placement: TextPlacement{AnchorX: 0.5, AnchorY: 1, Size: 0.02},
+		Start:     5,
+		Skip:      []int{0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Move(0, 2)
+	if n := s.PageNumbering(); len(n.Skip) != 1 || n.Skip[0] != 2 {
+		t.Fatalf("got pages %v skipped once moved, want [2]", n.Skip)
+	}
+
+	pages := save(t, s)
+	for i, want := range []string{"5 of 6", "6 of 6"} {
+		if !hasText(pages[i], want) {
+			t.Errorf("page %d not saved numbered %q", i+1, want)
+		}
+	}
+	if hasText(pages[2], "7 of 7") || hasText(pages[2], "7 of 6") {
+		t.Error("skipped page saved numbered")
+	}
+
+	if err := s.SetPageNumbering(&PageNumbering{Format: "Page", Placement: TextPlacement{Size: 0.02}}); err == nil {
+		t.Error("page numbering without {n} set")
+	}
+	if err := s.SetPageNumbering(nil); err != nil {
+		t.Fatal(err)
+	}
+	if pages := save(t, s); hasText(pages[0], "5 of 6") {
+		t.Error("page numbers saved once removed")
+	}
+}
+
+func TestPlaceWhiteouts(t *testing.T) {
+	s := openFixture(t, "one-page.pdf")
+	err := s.PlaceWhiteouts(context.Background(), 0, []Whiteout{
+		{X: 0.1, Y: 0.2, Width: 0.3, Height: 0.05},
+		{X: 0.5, Y: 0.5, Width: 0.1, Height: 0.1, Color: "#f5f0e1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg, err := s.Annotation(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(svg, []byte("fill:#ffffff;stroke:none")) || !bytes.Contains(svg, []byte("fill:#f5f0e1;stroke:none")) {
+		t.Error("whiteouts not placed in their colors")
+	}
+	saved := false
+	for _, c := range save(t, s)[0].Content {
+		saved = saved || strings.Contains(c, `id="whiteout-`)
+	}
+	if !saved {
+		t.Error("whiteouts not saved")
+	}
+	if err := s.PlaceWhiteouts(context.Background(), 0, []Whiteout{{Width: 0.1}}); err == nil {
+		t.Error("empty whiteout placed")
+	}
+}
+
+func TestEditorPlaceLogs(t *testing.T) {
+	var logged []string
+	SetLogger(func(kind, msg string) { logged = append(logged, kind+": "+msg) })
+	t.Cleanup(func() { SetLogger(nil) })
+	t.Setenv("PATH", t.TempDir())
+
+	Editor{Placement: PlaceMaximized}.place(context.Background(), "page.svg")
+	want := LogSession + ": wmctrl is needed for placing the editor window"
+	if len(logged) != 1 || logged[0] != want {
+		t.Errorf("got logged %q, want [%q]", logged, want)
+	}
+}
+
+func TestConvertShellCrash(t *testing.T) {
+	t.Setenv("FAKE_INKSCAPE_SHELL", "crash")
+	s := openFixture(t, "one-page.pdf")
+	out := filepath.Join(t.TempDir(), "page.svg")
+	err := s.convert(context.Background(), conversion{
+		in:   filepath.Join("testdata", "one-page.pdf"),
+		out:  out,
+		opts: []exportOpt{{"type", "svg"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(svg, []byte("</svg>")) {
+		t.Errorf("kept what the crashed shell exported: %s", svg)
+	}
+}
@@ -0,0 +1,430 @@
+// Package sessiontest runs tests of packages using sessions against fakes
+// of the external tools rather than the real ones, so they're hermetic and
+// quick. The fakes are the test binary itself, run through symlinks named
+// after the tools and set with session.SetToolPath. They keep just enough
+// of PDFs to check what sessions do with them: the pages with their sizes,
+// in order, and what's drawn on each. Fake PDFs have a page dictionary per
+// line, followed by a "% content" comment line with the quoted content of
+// each thing drawn on it, like the fixtures in session/testdata have
+// besides being real PDFs.
+//
+// The fake Inkscape reports a version too old for its shell, so it's run
+// for each conversion, unless FAKE_INKSCAPE_SHELL=crash is set: its shell
+// then dies halfway through the first export asked of it. Run as the
+// editor, it adds a rectangle with the id Edit to the file, unless
+// FAKE_INKSCAPE_EDIT=none is set.
+package sessiontest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Edit is the id of what the fake editor adds to the annotation SVG.
+const Edit = "fake-edit"
+
+var fakeTools = map[string]func(args []string) error{
+	"inkscape":   fakeInkscape,
+	"qpdf":       fakeQPDF,
+	"pdftocairo": fakePdftocairo,
+	"pdftoppm":   fakePdftoppm,
+	"pdfinfo":    fakePdfinfo,
+	"pdftotext":  fakePdftotext,
+}
+
+// Main is called by TestMain to run the tests with the fakes, given
+// session.SetToolPath to set them with. It's passed in so tests of package
+// session itself can use the fakes, which they couldn't if this package
+// imported it. When the test binary is run as one of the fakes, Main runs
+// the fake instead of the tests.
+func Main(m *testing.M, setToolPath func(name, path string)) {
+	if run, ok := fakeTools[filepath.Base(os.Args[0])]; ok {
+		if err := run(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	dir, err := ioutil.TempDir("", "pdfrankenstein-fake-tools-*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for name := range fakeTools {
+		link := filepath.Join(dir, name)
+		if err := os.Symlink(self, link); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		setToolPath(name, link)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Page is a page of a PDF as the fakes see it.
+type Page struct {
+	Width, Height string
+	// Content is what's drawn on the page, such as the SVGs of annotations
+	// overlaid on it.
+	Content []string
+}
+
+var (
+	fakePagePat    = regexp.MustCompile(`/Type /Page\b.*/MediaBox \[0 0 (\S+) (\S+)\]`)
+	fakeContentPat = regexp.MustCompile(`^% content (".*")$`)
+	svgSizePat     = regexp.MustCompile(`<svg[^>]*?\swidth="([\d.]+)[a-z]*"[^>]*?\sheight="([\d.]+)[a-z]*"`)
+)
+
+// ReadPDF returns the pages of the fake PDF at path.
+func ReadPDF(path string) ([]Page, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pages []Page
+	for _, line := range strings.Split(string(b), "\n") {
+		if m := fakePagePat.FindStringSubmatch(line); m != nil {
+			pages = append(pages, Page{Width: m[1], Height: m[2]})
+			continue
+		}
+		if m := fakeContentPat.FindStringSubmatch(line); m != nil && len(pages) > 0 {
+			c, err := strconv.Unquote(m[1])
+			if err != nil {
+				return nil, err
+			}
+			p := &pages[len(pages)-1]
+			p.Content = append(p.Content, c)
+		}
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("'%s' has no pages", path)
+	}
+	return pages, nil
+}
+
+// WritePDF writes a fake PDF with the given pages to path.
+func WritePDF(path string, pages []Page) error {
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	for i, p := range pages {
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Type /Page /MediaBox [0 0 %s %s] >>\nendobj\n", i+1, p.Width, p.Height)
+		for _, c := range p.Content {
+			fmt.Fprintf(&b, "%% content %s\n", strconv.Quote(c))
+		}
+	}
+	b.WriteString("%%EOF\n")
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// parseRange returns the 0-based indexes of the pages in a qpdf page range
+// such as "1-2,5".
+func parseRange(r string, count int) ([]int, error) {
+	var pages []int
+	for _, part := range strings.Split(r, ",") {
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			to = from
+		}
+		f, err1 := strconv.Atoi(from)
+		t, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || f < 1 || t > count || f > t {
+			return nil, fmt.Errorf("invalid page range '%s'", r)
+		}
+		for p := f; p <= t; p++ {
+			pages = append(pages, p-1)
+		}
+	}
+	return pages, nil
+}
+
+var pageRangePat = regexp.MustCompile(`^[\d,-]+$`)
+
+// fakeQPDF supports counting pages, selecting pages with --empty --pages
+// and overlaying with --overlay FILE --to=PAGE --. Other options are
+// ignored.
+func fakeQPDF(args []string) error {
+	if len(args) > 0 && args[0] == "--warning-exit-0" {
+		args = args[1:]
+	}
+	if len(args) == 2 && args[0] == "--show-npages" {
+		pages, err := ReadPDF(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(len(pages))
+		return nil
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("qpdf: too few arguments %q", args)
+	}
+
+	var pages []Page
+	if args[0] == "--empty" {
+		if args[1] != "--pages" {
+			return fmt.Errorf("qpdf: expected --pages in %q", args)
+		}
+		args = args[2:]
+		for len(args) > 0 && args[0] != "--" {
+			in, err := ReadPDF(args[0])
+			if err != nil {
+				return err
+			}
+			sel := make([]int, len(in))
+			for i := range sel {
+				sel[i] = i
+			}
+			args = args[1:]
+			if len(args) > 0 && pageRangePat.MatchString(args[0]) {
+				if sel, err = parseRange(args[0], len(in)); err != nil {
+					return err
+				}
+				args = args[1:]
+			}
+			for _, i := range sel {
+				pages = append(pages, in[i])
+			}
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("qpdf: --pages not ended with --")
+		}
+		args = args[1:]
+	} else {
+		var err error
+		if pages, err = ReadPDF(args[0]); err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+
+	for len(args) > 1 {
+		if args[0] != "--overlay" {
+			args = args[1:]
+			continue
+		}
+		if len(args) < 4 || !strings.HasPrefix(args[2], "--to=") || args[3] != "--" {
+			return fmt.Errorf("qpdf: expected --overlay FILE --to=PAGE -- in %q", args)
+		}
+		over, err := ReadPDF(args[1])
+		if err != nil {
+			return err
+		}
+		to, err := parseRange(strings.TrimPrefix(args[2], "--to="), len(pages))
+		if err != nil {
+			return err
+		}
+		for _, p := range to {
+			pages[p].Content = append(pages[p].Content, over[0].Content...)
+		}
+		args = args[4:]
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("qpdf: no output file")
+	}
+	return WritePDF(args[0], pages)
+}
+
+// fakeInkscape converts PDFs to SVG, and SVGs to PDF and PNG, given
+// --export-filename. Otherwise it edits the file given as the editor.
+func fakeInkscape(args []string) error {
+	crash := os.Getenv("FAKE_INKSCAPE_SHELL") == "crash"
+	if len(args) == 1 && args[0] == "--version" {
+		if crash {
+			fmt.Println("Inkscape 1.2.2 (fake)")
+		} else {
+			fmt.Println("Inkscape 1.1.2 (fake)")
+		}
+		return nil
+	}
+	var in, out, typ string
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "--export-filename="):
+			out = strings.TrimPrefix(a, "--export-filename=")
+		case strings.HasPrefix(a, "--export-type="):
+			typ = strings.TrimPrefix(a, "--export-type=")
+		case a == "--shell" && crash:
+			return fakeInkscapeCrash()
+		case a == "--shell":
+			return fmt.Errorf("inkscape: no shell in the fake")
+		case !strings.HasPrefix(a, "-"):
+			in = a
+		}
+	}
+	if in == "" {
+		return fmt.Errorf("inkscape: no input file in %q", args)
+	}
+
+	if out == "" {
+		if os.Getenv("FAKE_INKSCAPE_EDIT") == "none" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		edit := fmt.Sprintf(`<rect id="%s" x="10" y="10" width="20" height="20"/></svg>`, Edit)
+		b = bytes.Replace(b, []byte("</svg>"), []byte(edit), 1)
+		return ioutil.WriteFile(in, b, 0644)
+	}
+
+	switch typ {
+	case "svg":
+		pages, err := ReadPDF(in)
+		if err != nil {
+			return err
+		}
+		p := pages[0]
+		svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %[1]s %[2]s"><!-- %q --></svg>`,
+			p.Width, p.Height, p.Content)
+		return ioutil.WriteFile(out, []byte(svg), 0644)
+	case "pdf":
+		b, err := ioutil.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		m := svgSizePat.FindSubmatch(b)
+		if m == nil {
+			return fmt.Errorf("inkscape: no size in '%s'", in)
+		}
+		return WritePDF(out, []Page{{Width: string(m[1]), Height: string(m[2]), Content: []string{string(b)}}})
+	case "png":
+		return writeFakePNG(out)
+	}
+	return fmt.Errorf("inkscape: unsupported export type '%s'", typ)
+}
+
+// fakeInkscapeCrash is the shell of the fake Inkscape, which writes half an
+// SVG to the first export-filename given and dies.
+func fakeInkscapeCrash() error {
+	fmt.Print("> ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	for _, a := range strings.Split(strings.TrimSpace(line), ";") {
+		if out := strings.TrimPrefix(a, "export-filename:"); out != a {
+			if err := ioutil.WriteFile(out, []byte(`<svg xmlns="http://www.w3.org/2000/svg"`), 0644); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return fmt.Errorf("inkscape: crashed")
+}
+
+func writeFakePNG(path string) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// fakePdftocairo renders a single page with -singlefile to OUT.png, or else
+// all pages to PREFIX-N.png or .jpg.
+func fakePdftocairo(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("pdftocairo: too few arguments %q", args)
+	}
+	in, out := args[len(args)-2], args[len(args)-1]
+	pages, err := ReadPDF(in)
+	if err != nil {
+		return err
+	}
+	ext := ".png"
+	for _, a := range args {
+		switch a {
+		case "-singlefile":
+			return writeFakePNG(out + ".png")
+		case "-jpeg":
+			ext = ".jpg"
+		}
+	}
+	digits := len(strconv.Itoa(len(pages)))
+	for i := range pages {
+		if err := writeFakePNG(fmt.Sprintf("%s-%0*d%s", out, digits, i+1, ext)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakePdftoppm renders pages -f to -l to PREFIX-N.png.
+func fakePdftoppm(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("pdftoppm: too few arguments %q", args)
+	}
+	in, prefix := args[len(args)-2], args[len(args)-1]
+	pages, err := ReadPDF(in)
+	if err != nil {
+		return err
+	}
+	first, last := 1, len(pages)
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-f":
+			first, _ = strconv.Atoi(args[i+1])
+		case "-l":
+			last, _ = strconv.Atoi(args[i+1])
+		}
+	}
+	digits := len(strconv.Itoa(len(pages)))
+	for p := first; p <= last; p++ {
+		if err := writeFakePNG(fmt.Sprintf("%s-%0*d.png", prefix, digits, p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakePdfinfo prints the sizes and rotations of all pages.
+func fakePdfinfo(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("pdfinfo: no input file")
+	}
+	pages, err := ReadPDF(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pages:          %d\n", len(pages))
+	fmt.Println("Form:           none")
+	for i, p := range pages {
+		fmt.Printf("Page %4d size: %s x %s pts\n", i+1, p.Width, p.Height)
+		fmt.Printf("Page %4d rot:  0\n", i+1)
+	}
+	return nil
+}
+
+// fakePdftotext prints "Page N" as the text of each page, ending pages with
+// form feeds.
+func fakePdftotext(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("pdftotext: too few arguments %q", args)
+	}
+	pages, err := ReadPDF(args[len(args)-2])
+	if err != nil {
+		return err
+	}
+	for i := range pages {
+		fmt.Printf("Page %d\n\f", i+1)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Starting Inkscape takes seconds, more than most conversions themselves.
+// Conversions are therefore fed to a long-lived "inkscape --shell" process
+// of the session, all those at hand in one go. Where the shell can't be
+// used, e.g. with Inkscape before 1.2 which can't close documents in it, or
+// it fails on a conversion, Inkscape is run for each conversion instead.
+
+// errNoShell is returned by the shell when it can't be used.
+var errNoShell = errors.New("inkscape shell is not available")
+
+// inkscapeShell is a long-lived "inkscape --shell" process.
+type inkscapeShell struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	// failed is set once the shell failed to start, so it's not tried again.
+	failed bool
+}
+
+// exportOpt is an Inkscape export option, e.g. {"type", "pdf"}, given as
+// --export-type=pdf on the command line and export-type:pdf in the shell.
+type exportOpt struct {
+	name, value string
+}
+
+// start starts the shell and waits for its first prompt.
+func (sh *inkscapeShell) start() error {
+	sv, err := getInkscapeVersion()
+	if err != nil {
+		return err
+	}
+	if sv.LessThan(semver.MustParse("1.2.0")) {
+		return fmt.Errorf("inkscape %s can't close documents in its shell", sv)
+	}
+	// Import options given at startup apply to all documents opened
+	cmd := command(toolPath("inkscape"), "--shell", "--pdf-poppler")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sh.cmd, sh.stdin, sh.stdout = cmd, stdin, bufio.NewReader(stdout)
+	if err := sh.prompt(); err != nil {
+		sh.stop()
+		return fmt.Errorf("inkscape shell didn't start: %s", err)
+	}
+	return nil
+}
+
+// prompt reads the output of the shell up to its next prompt, which is a
+// line starting with "> ".
+func (sh *inkscapeShell) prompt() error {
+	lineStart := true
+	for {
+		b, err := sh.stdout.ReadByte()
+		if err != nil {
+			return err
+		}
+		if lineStart && b == '>' {
+			if next, err := sh.stdout.Peek(1); err == nil && next[0] == ' ' {
+				_, _ = sh.stdout.ReadByte()
+				return nil
+			}
+		}
+		lineStart = b == '\n'
+	}
+}
+
+// stop kills the shell. It's started again for the next conversion.
+func (sh *inkscapeShell) stop() {
+	if sh.cmd == nil {
+		return
+	}
+	_ = sh.stdin.Close()
+	_ = sh.cmd.Process.Kill()
+	_ = sh.cmd.Wait()
+	sh.cmd = nil
+}
+
+// close stops the shell for good.
+func (sh *inkscapeShell) close() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.stop()
+	sh.failed = true
+}
+
+// run runs the given conversions in the shell, all at once. Cancelling ctx
+// kills the shell.
+func (sh *inkscapeShell) run(ctx context.Context, convs []conversion) error {
+	// Actions are separated by semicolons, one line at a time
+
+	var actions []string
+	for _, c := range convs {
+		actions = append(actions, c.actions()...)
+	}
+	for _, a := range actions {
+		if strings.ContainsAny(a, ";\n") {
+			return errNoShell
+		}
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.failed {
+		return errNoShell
+	}
+	if sh.cmd == nil {
+		if err := sh.start(); err != nil {
+			sh.failed = true
+			return fmt.Errorf("%w: %s", errNoShell, err)
+		}
+	}
+
+	line := strings.Join(actions, ";") + "\n"
+	logf(LogCommand, "inkscape shell: %s", strings.TrimSuffix(line, "\n"))
+	done := make(chan error, 1)
+	go func() {
+		if _, err := io.WriteString(sh.stdin, line); err != nil {
+			done <- err
+			return
+		}
+		done <- sh.prompt()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			sh.stop()
+			return fmt.Errorf("inkscape shell failed: %s", err)
+		}
+		return nil
+	case <-ctx.Done():
+		_ = sh.cmd.Process.Kill()
+		<-done
+		sh.stop()
+		return ctx.Err()
+	}
+}
+
+// conversion is a file for Inkscape to open and export.
+type conversion struct {
+	in, out string
+	// importArgs are the import options for when Inkscape is run for the
+	// conversion alone.
+	importArgs []string
+	opts       []exportOpt
+}
+
+// actions returns the shell actions opening the file, exporting and closing
+// it.
+func (c conversion) actions() []string {
+	actions := []string{"file-open:" + c.in}
+	for _, o := range c.opts {
+		actions = append(actions, "export-"+o.name+":"+o.value)
+	}
+	return append(actions, "export-filename:"+c.out, "export-do", "file-close")
+}
+
+// args returns the arguments for running Inkscape for the conversion alone,
+// made from the inkscape-export template.
+func (c conversion) args() []string {
+	var opts []string
+	for _, o := range c.opts {
+		opts = append(opts, "--export-"+o.name+"="+o.value)
+	}
+	return expandArgs("inkscape-export", map[string][]string{
+		"import":  c.importArgs,
+		"options": opts,
+		"out":     {c.out},
+		"in":      {c.in},
+	})
+}
+
+// convert runs the given conversion.
+func (s *Session) convert(ctx context.Context, c conversion) error {
+	return s.convertAll(ctx, []conversion{c})[0]
+}
+
+// convertAll runs the given conversions with the converter set, or else
+// through the session's shell in one go where possible, and returns their
+// errors in the same order. Those the shell didn't export are run with
+// Inkscape for each, a few at a time.
+func (s *Session) convertAll(ctx context.Context, convs []conversion) []error {
+	for _, c := range convs {
+		_ = os.Remove(c.out)
+	}
+	if _, c, _ := s.backends(); c != nil {
+		return convertWith(ctx, c, convs)
+	}
+	// The arguments of the shell can't be changed like those of Inkscape
+	// run alone, so it's not used once they are
+	if !argTemplateSet("inkscape-export") {
+		if err := s.shell.run(ctx, convs); err != nil && !errors.Is(err, errNoShell) {
+			// A shell failing partway can leave the export it was on
+			// truncated, which can't be told from those it finished
+			for _, c := range convs {
+				_ = os.Remove(c.out)
+			}
+		}
+	}
+
+	// Running Inkscape for the conversion alone reports what went wrong, if
+	// it fails too
+
+	return parallel(ctx, len(convs), func(i int) error {
+		c := convs[i]
+		if _, err := os.Stat(c.out); err == nil {
+			return nil
+		}
+		if _, err := commandContext(ctx, toolPath("inkscape"), c.args()...).Output(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return cmdErr(err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// hrefPat matches the link to the background in the src-bg image element.
+var hrefPat = regexp.MustCompile(`(\s(?:xlink:)?href=")[^"]*(")`)
+
+// SidecarDir returns the directory beside the PDF file at path where its
+// annotations are kept in sidecar mode, e.g. report.pdfrann for report.pdf.
+func SidecarDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".pdfrann"
+}
+
+// setBackground returns the annotation SVG with its background linked to
+// href.
+func setBackground(svg []byte, href string) []byte {
+	return srcBGPat.ReplaceAllFunc(svg, func(img []byte) []byte {
+		return hrefPat.ReplaceAll(img, []byte("${1}"+href+"${2}"))
+	})
+}
+
+// UseSidecar keeps the annotations of the session in the given directory
+// from now on, so that they outlive the session. Annotations already there
+// are loaded, replacing the session's own, and their number returned. It
+// must be called before the session is otherwise used.
+//
+// Files are named like ImportAnnotations expects, so the directory can be
+// flattened onto the PDF without the app. Backgrounds are linked by relative
+// path so the files don't change with the session's temporary directory.
+func (s *Session) UseSidecar(dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create sidecar directory: %s", err)
+	}
+	n := 0
+	for id := 0; id < s.pageCount; id++ {
+		path := filepath.Join(dir, filepath.Base(s.annotPath(id)))
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to load '%s': %s", path, err)
+		}
+		if err := ioutil.WriteFile(s.annotPath(id), setBackground(b, s.srcPath(id)), 0644); err != nil {
+			return n, fmt.Errorf("failed to load '%s': %s", path, err)
+		}
+		s.mu.Lock()
+		s.annotated[id] = struct{}{}
+		s.mu.Unlock()
+		s.dropRenders(id)
+		n++
+	}
+	s.sidecar = dir
+	return n, nil
+}
+
+// Sidecar returns the directory the annotations are kept in, or "" if not in
+// sidecar mode.
+func (s *Session) Sidecar() string {
+	return s.sidecar
+}
+
+// syncSidecar writes the annotations of the page with the given ID to the
+// sidecar directory, or removes them from there if it has none.
+func (s *Session) syncSidecar(id int, annotated bool) error {
+	if s.sidecar == "" {
+		return nil
+	}
+	path := filepath.Join(s.sidecar, filepath.Base(s.annotPath(id)))
+	if !annotated {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove '%s': %s", path, err)
+		}
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.annotPath(id))
+	if err != nil {
+		return fmt.Errorf("failed to read back '%s': %s", s.annotPath(id), err)
+	}
+	b = setBackground(b, filepath.Base(s.srcPath(id)))
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write '%s': %s", path, err)
+	}
+	return nil
+}
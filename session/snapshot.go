@@ -0,0 +1,170 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// manifest describes the state of a session in a snapshot. The snapshot
+// directory holds it along with the source PDF and the SVGs of the annotated
+// pages.
+type manifest struct {
+	// Origin is the path of the PDF the session was opened from.
+	Origin    string         `json:"origin"`
+	Order     []int          `json:"order"`
+	Annotated []int          `json:"annotated"`
+	Notes     map[int]string `json:"notes,omitempty"`
+	// TmpDir is the temporary directory of the session, which annotation
+	// SVGs refer to for their backgrounds.
+	TmpDir string `json:"tmp_dir"`
+}
+
+const manifestName = "manifest.json"
+
+// Origin returns the path of the PDF file the session was opened from.
+func (s *Session) Origin() string {
+	return s.origin
+}
+
+// linkOrCopy hard links src to dst, falling back to copying, unless dst
+// already exists.
+func linkOrCopy(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return fileCopy(src, dst)
+}
+
+// Snapshot writes the state of the session to the given directory, from
+// where Resume can pick it up, e.g. after a crash. Later snapshots to the
+// same directory replace earlier ones. Files which never change, like the
+// source PDF, are only written once.
+func (s *Session) Snapshot(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %s", err)
+	}
+	if err := linkOrCopy(s.path, filepath.Join(dir, "src.pdf")); err != nil {
+		return fmt.Errorf("failed to snapshot source PDF: %s", err)
+	}
+
+	s.mu.Lock()
+	m := manifest{
+		Origin: s.origin,
+		Order:  append([]int(nil), s.order...),
+		Notes:  map[int]string{},
+		TmpDir: s.tmpDir,
+	}
+	for id := range s.annotated {
+		m.Annotated = append(m.Annotated, id)
+	}
+	for id, n := range s.notes {
+		m.Notes[id] = n
+	}
+	s.mu.Unlock()
+	sort.Ints(m.Annotated)
+
+	for _, id := range m.Annotated {
+		src := filepath.Base(s.srcPath(id))
+		if err := linkOrCopy(s.srcPath(id), filepath.Join(dir, src)); err != nil {
+			return fmt.Errorf("failed to snapshot page %d: %s", id+1, err)
+		}
+		annot := filepath.Join(dir, filepath.Base(s.annotPath(id)))
+		if err := fileCopy(s.annotPath(id), annot+".tmp"); err != nil {
+			return fmt.Errorf("failed to snapshot page %d: %s", id+1, err)
+		}
+		if err := os.Rename(annot+".tmp", annot); err != nil {
+			return fmt.Errorf("failed to snapshot page %d: %s", id+1, err)
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot manifest: %s", err)
+	}
+	path := filepath.Join(dir, manifestName)
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest: %s", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest: %s", err)
+	}
+	return nil
+}
+
+// SnapshotOrigin returns the path of the PDF the session in the given
+// snapshot directory was opened from.
+func SnapshotOrigin(dir string) (string, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return "", err
+	}
+	return m.Origin, nil
+}
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return m, fmt.Errorf("failed to read snapshot manifest: %s", err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("failed to parse snapshot manifest: %s", err)
+	}
+	return m, nil
+}
+
+// Resume creates a new session with the state written to the given
+// directory by Snapshot.
+func Resume(dir string) (*Session, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(filepath.Join(dir, "src.pdf"))
+	if err != nil {
+		return nil, err
+	}
+	s.origin = m.Origin
+	if len(m.Order) != s.pageCount {
+		s.Close()
+		return nil, fmt.Errorf("snapshot has %d pages rather than %d", len(m.Order), s.pageCount)
+	}
+	s.order = m.Order
+	for id, n := range m.Notes {
+		s.notes[id] = n
+	}
+
+	// Annotation SVGs refer to the page SVG backgrounds in the temporary
+	// directory of the snapshotted session, which is replaced with ours.
+
+	for _, id := range m.Annotated {
+		if id < 0 || id >= s.pageCount {
+			continue
+		}
+		src := filepath.Base(s.srcPath(id))
+		if err := fileCopy(filepath.Join(dir, src), s.srcPath(id)); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to resume page %d: %s", id+1, err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.Base(s.annotPath(id))))
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to resume page %d: %s", id+1, err)
+		}
+		b = bytes.ReplaceAll(b, []byte(m.TmpDir+string(filepath.Separator)), []byte(s.tmpDir+string(filepath.Separator)))
+		if err := ioutil.WriteFile(s.annotPath(id), b, 0644); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to resume page %d: %s", id+1, err)
+		}
+		s.annotated[id] = struct{}{}
+	}
+	return s, nil
+}
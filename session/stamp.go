@@ -0,0 +1,210 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rubric stamps are text placed on pages which is worth points. The points
+// are kept in an attribute of the stamp's group, in a namespace of our own,
+// so they survive editing the stamp in Inkscape and can be tallied.
+const stampNS = "https://github.com/oxplot/pdfrankenstein/stamp"
+
+// Stamp is a rubric stamp found on a page.
+type Stamp struct {
+	// Page is the position of the page.
+	Page   int
+	Label  string
+	Points float64
+}
+
+// stampCacheEntry holds the stamps of a page as of the given modification
+// of its annotation SVG.
+type stampCacheEntry struct {
+	mod    time.Time
+	size   int64
+	stamps []Stamp
+}
+
+// scoreGroup matches the score placed by PlaceScore.
+var scoreGroup = regexp.MustCompile(`(?s)  <g\s[^>]*id="stamp-score".*?</g>\n?`)
+
+// FormatPoints formats points with an explicit sign, e.g. "+2" or "-0.5".
+func FormatPoints(points float64) string {
+	s := strconv.FormatFloat(points, 'f', -1, 64)
+	if points >= 0 {
+		s = "+" + s
+	}
+	return s
+}
+
+// stampStyle returns the style of stamp text of the given size.
+func stampStyle(size float64, anchor string) string {
+	return fmt.Sprintf("font-family:sans-serif;font-size:%gpx;font-weight:bold;fill:#c00000;text-anchor:%s",
+		size, anchor)
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// PlaceStamp adds a rubric stamp worth the given points to the given page,
+// labelled with the points and label. Stamps are stacked in the top right
+// corner, below those already on the page, and can be moved in the editor.
+func (s *Session) PlaceStamp(ctx context.Context, page int, label string, points float64) error {
+	n, err := s.pageStamps(page)
+	if err != nil {
+		return err
+	}
+	return s.editAnnotation(ctx, page, func(svg []byte, pw, ph float64) ([]byte, error) {
+		size := pw / 45
+		margin := pw / 30
+		text := FormatPoints(points)
+		if label != "" {
+			text += " " + label
+		}
+		return appendToSVG(svg, fmt.Sprintf(`  <g
+     id="stamp-%d"
+     xmlns:pdfrankenstein="%s"
+     pdfrankenstein:points="%g">
+    <text
+       style="%s"
+       x="%g"
+       y="%g">%s</text>
+  </g>
+`, time.Now().UnixNano(), stampNS, points, stampStyle(size, "end"),
+			pw-margin, margin+size*1.4*float64(len(n)+1), xmlText(text)))
+	})
+}
+
+// PlaceScore puts the given score in the top left corner of the first page,
+// replacing the one put there before if any. It's not a stamp itself and
+// isn't tallied.
+func (s *Session) PlaceScore(ctx context.Context, score string) error {
+	return s.editAnnotation(ctx, 0, func(svg []byte, pw, ph float64) ([]byte, error) {
+		size := pw / 25
+		margin := pw / 30
+		svg = scoreGroup.ReplaceAll(svg, nil)
+		return appendToSVG(svg, fmt.Sprintf(`  <g
+     id="stamp-score">
+    <text
+       style="%s"
+       x="%g"
+       y="%g">%s</text>
+  </g>
+`, stampStyle(size, "start"), margin, margin+size, xmlText(score)))
+	})
+}
+
+// Stamps returns the rubric stamps on all pages, in page order.
+func (s *Session) Stamps() ([]Stamp, error) {
+	var all []Stamp
+	for page := 0; page < s.PageCount(); page++ {
+		stamps, err := s.pageStamps(page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stamps...)
+	}
+	return all, nil
+}
+
+// pageStamps returns the rubric stamps on the given page. They're only
+// parsed again once the page's annotations change.
+func (s *Session) pageStamps(page int) ([]Stamp, error) {
+	if !s.IsAnnotated(page) {
+		return nil, nil
+	}
+	path := s.annotPath(s.pageID(page))
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s.stampsMu.Lock()
+	e, ok := s.stamps[path]
+	s.stampsMu.Unlock()
+	if !ok || !e.mod.Equal(fi.ModTime()) || e.size != fi.Size() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps, err := parseStamps(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %s", path, err)
+		}
+		e = stampCacheEntry{mod: fi.ModTime(), size: fi.Size(), stamps: stamps}
+		s.stampsMu.Lock()
+		if s.stamps == nil {
+			s.stamps = map[string]stampCacheEntry{}
+		}
+		s.stamps[path] = e
+		s.stampsMu.Unlock()
+	}
+	stamps := make([]Stamp, len(e.stamps))
+	for i, st := range e.stamps {
+		st.Page = page
+		stamps[i] = st
+	}
+	return stamps, nil
+}
+
+// parseStamps returns the stamps in an annotation SVG, labelled with their
+// text less the points.
+func parseStamps(r io.Reader) ([]Stamp, error) {
+	var stamps []Stamp
+	// depth is the nesting within the current stamp, 0 outside of any.
+	depth := 0
+	var text strings.Builder
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return stamps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth > 0 {
+				depth++
+				continue
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == stampNS && a.Name.Local == "points" {
+					p, err := strconv.ParseFloat(a.Value, 64)
+					if err != nil {
+						return nil, fmt.Errorf("invalid stamp points '%s'", a.Value)
+					}
+					stamps = append(stamps, Stamp{Points: p})
+					depth = 1
+					text.Reset()
+				}
+			}
+		case xml.CharData:
+			if depth > 0 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth == 0 {
+				label := strings.TrimSpace(text.String())
+				label = strings.TrimSpace(strings.TrimPrefix(label, FormatPoints(stamps[len(stamps)-1].Points)))
+				stamps[len(stamps)-1].Label = label
+			}
+		}
+	}
+}
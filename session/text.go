@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TextPlacement positions a line of text on a page.
+type TextPlacement struct {
+	// AnchorX and AnchorY are the point of the page the text is aligned with
+	// as fractions of its width and height, as for ImagePlacement.
+	AnchorX, AnchorY float64
+	// Size is the font size as a fraction of the page width.
+	Size float64
+	// Margin is the space kept from the page edges as a fraction of the page
+	// width.
+	Margin float64
+	// Color is the CSS color of the text, black if empty.
+	Color string
+}
+
+// textSVG returns the SVG text element drawing text as placed on a page of
+// the given size. Its width isn't known ahead of rendering, so it's aligned
+// by its start, middle or end depending on the anchor.
+func textSVG(id, text string, p TextPlacement, pw, ph float64) string {
+	size := p.Size * pw
+	margin := p.Margin * pw
+	anchor := "middle"
+	switch {
+	case p.AnchorX < 0.5:
+		anchor = "start"
+	case p.AnchorX > 0.5:
+		anchor = "end"
+	}
+	color := p.Color
+	if color == "" {
+		color = "#000000"
+	}
+
+	// The baseline is placed so the text's capitals are within the margins
+
+	x := margin + p.AnchorX*(pw-2*margin)
+	y := margin + size*0.7 + p.AnchorY*(ph-2*margin-size*0.7)
+	return fmt.Sprintf(`  <text
+     id="%s"
+     style="font-family:sans-serif;font-size:%gpx;fill:%s;text-anchor:%s"
+     x="%g"
+     y="%g">%s</text>
+`, id, size, xmlText(color), anchor, x, y, xmlText(text))
+}
+
+// PlaceText adds a line of text, e.g. today's date, to the annotations of
+// the given page, creating them if needed. It can be edited in the editor
+// later like any other text.
+func (s *Session) PlaceText(ctx context.Context, page int, text string, p TextPlacement) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("no text to place")
+	}
+	if p.Size <= 0 {
+		return errors.New("text size must be positive")
+	}
+	return s.editAnnotation(ctx, page, func(svg []byte, pw, ph float64) ([]byte, error) {
+		id := fmt.Sprintf("placed-text-%d", time.Now().UnixNano())
+		return appendToSVG(svg, textSVG(id, text, p, pw, ph))
+	})
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+var toolVersionPat = regexp.MustCompile(`(?i)version\s+([0-9][0-9.]*)|inkscape\s+([0-9][0-9.]*)`)
+
+// Tool describes an external program sessions depend on.
+type Tool struct {
+	// Name is the name of the executable.
+	Name string
+	// Version is the detected version of the tool or empty if it's missing.
+	Version string
+	// Err is the reason the tool or its version couldn't be found.
+	Err error
+	// Output is what the tool printed when asked for its version.
+	Output string
+}
+
+// toolPaths are the executables run for tools by name, where set otherwise
+// than found in PATH.
+var (
+	toolPathsMu sync.Mutex
+	toolPaths   = map[string]string{}
+)
+
+// SetToolPath sets the executable run for the tool of the given name, e.g.
+// "inkscape", rather than finding it in PATH. An empty path finds it in PATH
+// again.
+func SetToolPath(name, path string) {
+	toolPathsMu.Lock()
+	defer toolPathsMu.Unlock()
+	if path == "" {
+		delete(toolPaths, name)
+	} else {
+		toolPaths[name] = path
+	}
+}
+
+// toolPath returns the executable run for the tool of the given name.
+func toolPath(name string) string {
+	toolPathsMu.Lock()
+	defer toolPathsMu.Unlock()
+	if p, ok := toolPaths[name]; ok {
+		return p
+	}
+	return name
+}
+
+// ToolNames returns the names of the required tools.
+func ToolNames() []string {
+	names := make([]string, len(toolVersionArgs))
+	for i, t := range toolVersionArgs {
+		names[i] = t.name
+	}
+	return names
+}
+
+// toolVersionArgs lists the required tools along with the flags which make
+// them print their version.
+var toolVersionArgs = []struct {
+	name string
+	args []string
+}{
+	{"inkscape", []string{"--version"}},
+	{"qpdf", []string{"--version"}},
+	{"pdftocairo", []string{"-v"}},
+	{"pdftoppm", []string{"-v"}},
+	{"pdfinfo", []string{"-v"}},
+	{"pdftotext", []string{"-v"}},
+}
+
+// Tools probes the external programs used by sessions and returns their
+// versions.
+func Tools() []Tool {
+	tools := make([]Tool, len(toolVersionArgs))
+	for i, t := range toolVersionArgs {
+		tools[i] = Tool{Name: t.name}
+		if err := lookTool(toolPath(t.name)); err != nil {
+			tools[i].Err = fmt.Errorf("%s not found", t.name)
+			continue
+		}
+
+		// Poppler tools print their version to stderr.
+
+		out, err := command(toolPath(t.name), t.args...).CombinedOutput()
+		tools[i].Output = string(out)
+		if err != nil {
+			tools[i].Err = fmt.Errorf("failed to get %s version: %s", t.name, err)
+			continue
+		}
+		m := toolVersionPat.FindStringSubmatch(string(out))
+		if m == nil {
+			tools[i].Err = fmt.Errorf("failed to parse %s version: %s", t.name, out)
+			continue
+		}
+		tools[i].Version = m[1] + m[2]
+	}
+	return tools
+}
@@ -0,0 +1,172 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Watermarks aren't part of the annotations: they're only drawn over the
+// pages on saving, on top of annotations, so they don't get in the way of
+// annotating and can be changed or removed at any time.
+
+// Watermark is text or an image drawn across pages on saving, e.g. "DRAFT"
+// on review copies.
+type Watermark struct {
+	// Text is drawn unless Image is set.
+	Text string
+	// Image is a PNG image drawn instead of text.
+	Image []byte
+	// Opacity ranges from 0, invisible, to 1, opaque.
+	Opacity float64
+	// Rotation is the angle in degrees the watermark is turned
+	// counterclockwise by.
+	Rotation float64
+	// Width is the width of the watermark as a fraction of the page width.
+	Width float64
+	// Tile repeats the watermark across the whole page rather than drawing
+	// it once in the middle.
+	Tile bool
+	// Pages are the positions of the pages watermarked, or nil for all.
+	Pages []int
+}
+
+// watermarkColor is the color of watermark text.
+const watermarkColor = "#808080"
+
+// SetWatermark sets the watermark drawn on saving, or removes it if nil.
+func (s *Session) SetWatermark(w *Watermark) error {
+	if w == nil {
+		s.mu.Lock()
+		s.watermark = nil
+		s.mu.Unlock()
+		return nil
+	}
+	if w.Width <= 0 {
+		return errors.New("watermark width must be positive")
+	}
+	if len(w.Image) == 0 && strings.TrimSpace(w.Text) == "" {
+		return errors.New("watermark has neither text nor image")
+	}
+	if len(w.Image) > 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(w.Image))
+		if err != nil {
+			return fmt.Errorf("failed to read watermark image: %s", err)
+		}
+		if cfg.Width == 0 || cfg.Height == 0 {
+			return errors.New("watermark image is empty")
+		}
+	}
+
+	// Pages are kept by ID so the watermark stays with them as they move
+
+	wm := *w
+	if w.Pages != nil {
+		wm.Pages = make([]int, len(w.Pages))
+		for i, p := range w.Pages {
+			wm.Pages[i] = s.pageID(p)
+		}
+	}
+	s.mu.Lock()
+	s.watermark = &wm
+	s.mu.Unlock()
+	logf(LogSession, "set watermark of '%s'", s.origin)
+	return nil
+}
+
+// Watermark returns the watermark drawn on saving, or nil if there's none.
+func (s *Session) Watermark() *Watermark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watermark == nil {
+		return nil
+	}
+	w := *s.watermark
+	if w.Pages != nil {
+		pos := make(map[int]int, len(s.order))
+		for p, id := range s.order {
+			pos[id] = p
+		}
+		w.Pages = make([]int, len(s.watermark.Pages))
+		for i, id := range s.watermark.Pages {
+			w.Pages[i] = pos[id]
+		}
+	}
+	return &w
+}
+
+// watermarks returns whether the watermark, if any, is drawn on the page
+// with the given ID.
+func (s *Session) watermarks(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watermark == nil {
+		return false
+	}
+	if s.watermark.Pages == nil {
+		return true
+	}
+	for _, p := range s.watermark.Pages {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// svg returns the SVG elements drawing the watermark on a page of the given
+// size.
+func (w *Watermark) svg(pw, ph float64) string {
+
+	// The watermark is drawn centered on the origin, then moved and turned
+	// into place
+
+	var elem string
+	width := w.Width * pw
+	var height float64
+	if len(w.Image) > 0 {
+		cfg, _, _ := image.DecodeConfig(bytes.NewReader(w.Image))
+		height = width * float64(cfg.Height) / float64(cfg.Width)
+		elem = fmt.Sprintf(`<image preserveAspectRatio="none" x="%g" y="%g" width="%g" height="%g" xlink:href="data:image/png;base64,%s" />`,
+			-width/2, -height/2, width, height, base64.StdEncoding.EncodeToString(w.Image))
+	} else {
+		// Bold sans-serif glyphs are about 0.62 em wide on average
+
+		height = width / (0.62 * float64(len([]rune(w.Text))))
+		elem = fmt.Sprintf(`<text style="font-family:sans-serif;font-size:%gpx;font-weight:bold;fill:%s;text-anchor:middle" x="0" y="%g">%s</text>`,
+			height, watermarkColor, height*0.35, xmlText(w.Text))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "  <g opacity=\"%g\">\n", w.Opacity)
+	place := func(x, y float64) {
+		fmt.Fprintf(&b, "    <g transform=\"translate(%g,%g) rotate(%g)\">%s</g>\n", x, y, -w.Rotation, elem)
+	}
+	if !w.Tile {
+		place(pw/2, ph/2)
+	} else {
+		// Tiles are as far apart as the turned watermark is wide and high,
+		// plus a gap, every other row shifted by half
+
+		rad := w.Rotation * math.Pi / 180
+		sin, cos := math.Abs(math.Sin(rad)), math.Abs(math.Cos(rad))
+		gap := pw / 20
+		stepX := width*cos + height*sin + gap
+		stepY := width*sin + height*cos + gap
+		for row, y := 0, ph/2-stepY*math.Ceil(ph/2/stepY); y < ph+stepY; row, y = row+1, y+stepY {
+			x := pw/2 - stepX*math.Ceil(pw/2/stepX)
+			if row%2 == 1 {
+				x -= stepX / 2
+			}
+			for ; x < pw+stepX; x += stepX {
+				place(x, y)
+			}
+		}
+	}
+	b.WriteString("  </g>\n")
+	return b.String()
+}
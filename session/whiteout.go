@@ -0,0 +1,55 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Whiteout is a rectangle painted over part of a page to cover it, e.g.
+// obsolete text. Its position and size are fractions of the page width and
+// height, from the top left corner.
+type Whiteout struct {
+	X, Y, Width, Height float64
+	// Color is the CSS color painted, white if empty, e.g. to match a page
+	// which isn't white.
+	Color string
+}
+
+// PlaceWhiteouts adds opaque rectangles covering parts of the given page to
+// its annotations, creating them if needed.
+//
+// Covering is only cosmetic and is NOT redaction: what's covered is still in
+// the saved PDF, where it can be selected, copied, searched for or uncovered
+// by removing the rectangles.
+func (s *Session) PlaceWhiteouts(ctx context.Context, page int, rects []Whiteout) error {
+	if len(rects) == 0 {
+		return errors.New("nothing to cover")
+	}
+	for _, r := range rects {
+		if r.Width <= 0 || r.Height <= 0 {
+			return errors.New("covered area must not be empty")
+		}
+	}
+	return s.editAnnotation(ctx, page, func(svg []byte, pw, ph float64) ([]byte, error) {
+		id := time.Now().UnixNano()
+		var b strings.Builder
+		for i, r := range rects {
+			color := r.Color
+			if color == "" {
+				color = "#ffffff"
+			}
+			fmt.Fprintf(&b, `  <rect
+     id="whiteout-%d-%d"
+     style="fill:%s;stroke:none"
+     x="%g"
+     y="%g"
+     width="%g"
+     height="%g" />
+`, id, i, xmlText(color), r.X*pw, r.Y*ph, r.Width*pw, r.Height*ph)
+		}
+		return appendToSVG(svg, b.String())
+	})
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Saved PDFs are written to a temporary file beside their destination and
+// renamed over it once complete, so the destination is never left half
+// written and nothing is copied after the fact, which is slow on network
+// mounts.
+
+// progressEvery is how often progress of writing is reported.
+const progressEvery = 250 * time.Millisecond
+
+// SaveProgress is called while saving with the number of bytes written to
+// the destination so far. It's called from the goroutine saving.
+type SaveProgress func(written int64)
+
+// SetSaveProgress sets what's told about progress of writing the saved PDF.
+func (s *Session) SetSaveProgress(p SaveProgress) {
+	s.mu.Lock()
+	s.saveProgress = p
+	s.mu.Unlock()
+}
+
+// writeBeside calls write with a temporary path in the directory of path and
+// renames it to path once written. The written file keeps the permissions of
+// the one it replaces, if any. Progress is reported by polling the size of
+// the temporary file.
+func (s *Session) writeBeside(path string, write func(tmp string) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+
+	s.mu.Lock()
+	progress := s.saveProgress
+	s.mu.Unlock()
+	if progress != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			t := time.NewTicker(progressEvery)
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C:
+					if fi, err := os.Stat(tmp); err == nil {
+						progress(fi.Size())
+					}
+				}
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// copyContext copies src to dst, stopping once ctx is cancelled.
+func copyContext(ctx context.Context, src, dst string) error {
+	fin, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	fout, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fout, ctxReader{ctx, fin}); err != nil {
+		fout.Close()
+		return err
+	}
+	return fout.Close()
+}
+
+// ctxReader is a reader failing with the error of ctx once it's cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
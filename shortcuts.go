@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// shortcut is a keyboard binding documented in the shortcuts window.
+type shortcut struct {
+	group string
+	title string
+	// action is the application action the accelerators are registered
+	// for. It's empty for bindings handled by widgets themselves.
+	action string
+	accels []string
+}
+
+// shortcuts is the table of all keyboard bindings, grouped by area in the
+// order they're shown.
+var shortcuts = []shortcut{
+	{"File", "Open a PDF file", "app.open", []string{"<Primary>o"}},
+	{"File", "Save", "app.save", []string{"<Primary>s"}},
+	{"File", "Save as", "app.save-as", []string{"<Primary><Shift>s"}},
+	{"File", "Print", "app.print", []string{"<Primary>p"}},
+	{"File", "Close the current file", "app.close", []string{"<Primary>w"}},
+	{"File", "Save the graded submission and open the next", "app.grade-next", []string{"<Primary>Return"}},
+	{"File", "Switch to the previous or next file", "", []string{"<Primary>Page_Up", "<Primary>Page_Down"}},
+	{"Pages", "Move between pages", "", []string{"Left", "Right", "Up", "Down"}},
+	{"Pages", "Show the page menu", "", []string{"Menu", "<Shift>F10"}},
+	{"Pages", "Select or unselect the focused page", "", []string{"<Primary>space"}},
+	{"Pages", "Unselect all pages", "", []string{"Escape"}},
+	{"Pages", "Open the selected pages as a new document", "app.extract", []string{"<Primary><Shift>n"}},
+	{"Pages", "Undo", "app.undo", []string{"<Primary>z"}},
+	{"Pages", "Show annotated pages only", "app.annotated-only", []string{"<Primary><Shift>a"}},
+	{"Pages", "Show pages as a continuous strip", "app.strip-view", []string{"<Primary><Shift>c"}},
+	{"Pages", "Show page notes", "app.notes", []string{"F9"}},
+	{"Annotation", "Annotate the focused page in Inkscape", "", []string{"Return"}},
+	{"Annotation", "Clear the annotations of the focused page", "", []string{"Delete"}},
+	{"Annotation", "Show the focused page before or after annotating", "", []string{"b"}},
+	{"Annotation", "Paste an image onto the focused page", "", []string{"<Primary>v"}},
+	{"Annotation", "Capture a screen region onto the focused page", "app.capture", []string{"<Primary><Shift>r"}},
+	{"Annotation", "Stamp today's date on the focused page", "app.stamp-date", []string{"<Primary><Shift>d"}},
+	{"Annotation", "Cover parts of the focused page with whiteout", "app.whiteout", []string{"<Primary><Shift>w"}},
+	{"General", "Preferences", "app.preferences", []string{"<Primary>comma"}},
+	{"General", "Keyboard shortcuts", "app.shortcuts", []string{"<Primary>question", "<Primary>F1"}},
+	{"General", "Quit", "app.quit", []string{"<Primary>q"}},
+}
+
+// registerAccels registers the accelerators of the app actions in the
+// shortcuts table.
+func registerAccels() {
+	for _, s := range shortcuts {
+		if s.action != "" {
+			app.SetAccelsForAction(s.action, s.accels)
+		}
+	}
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// shortcutsUI returns the GtkBuilder definition of the shortcuts window
+// generated from the shortcuts table.
+func shortcutsUI() string {
+	var b strings.Builder
+	b.WriteString(`<interface><object class="GtkShortcutsWindow" id="shortcuts">` +
+		`<property name="modal">1</property>` +
+		`<child><object class="GtkShortcutsSection"><property name="visible">1</property>` +
+		`<property name="section-name">shortcuts</property>`)
+	group := ""
+	for _, s := range shortcuts {
+		if s.group != group {
+			if group != "" {
+				b.WriteString(`</object></child>`)
+			}
+			group = s.group
+			fmt.Fprintf(&b, `<child><object class="GtkShortcutsGroup"><property name="visible">1</property>`+
+				`<property name="title">%s</property>`, xmlEscape(group))
+		}
+		fmt.Fprintf(&b, `<child><object class="GtkShortcutsShortcut"><property name="visible">1</property>`+
+			`<property name="title">%s</property><property name="accelerator">%s</property>`+
+			`</object></child>`, xmlEscape(s.title), xmlEscape(strings.Join(s.accels, " ")))
+	}
+	if group != "" {
+		b.WriteString(`</object></child>`)
+	}
+	b.WriteString(`</object></child></object></interface>`)
+	return b.String()
+}
+
+// showShortcuts shows the window listing all keyboard shortcuts.
+func showShortcuts() {
+	builder, err := gtk.BuilderNewFromString(shortcutsUI())
+	if err != nil {
+		showErrMsg("Cannot show shortcuts", err.Error())
+		return
+	}
+	obj, err := builder.GetObject("shortcuts")
+	if err != nil {
+		showErrMsg("Cannot show shortcuts", err.Error())
+		return
+	}
+	w, ok := obj.(*gtk.ShortcutsWindow)
+	if !ok {
+		showErrMsg("Cannot show shortcuts", "unexpected shortcuts window type")
+		return
+	}
+	w.SetTransientFor(mainWin)
+	w.Show()
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Contracts often need initialling every page and signing one. The initials
+// and signature images are kept as PNG in the state directory, chosen once
+// in the preferences, so the whole document is initialled and signed at
+// once rather than page by page in the editor.
+const (
+	initialsFile  = "initials.png"
+	signatureFile = "signature.png"
+
+	defaultInitialsPosition  = "bottom-right"
+	defaultInitialsWidth     = 8 // percent of the page width
+	defaultSignaturePosition = "bottom-right"
+	defaultSignatureWidth    = 30
+	// signPreviewHeight is the height of the previews in the preferences.
+	signPreviewHeight = 40
+)
+
+// signImagePath returns where the stored image of the given name is kept.
+func signImagePath(name string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// loadSignImage returns the stored PNG image of the given name, or nil if
+// none was chosen. Images set in the config are used instead.
+func loadSignImage(name string) ([]byte, error) {
+	override := config.initials
+	if name == signatureFile {
+		override = config.signature
+	}
+	if override != "" {
+		return ioutil.ReadFile(override)
+	}
+	path, err := signImagePath(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// chooseSignImage asks for an image file and stores it as PNG under the
+// given name. It returns false if cancelled or failed.
+func chooseSignImage(title, name string) bool {
+	ofd, err := gtk.FileChooserNativeDialogNew(title, mainWin, gtk.FILE_CHOOSER_ACTION_OPEN, "_Choose", "_Cancel")
+	if err != nil {
+		log.Fatalf("failed to open file chooser: %s", err)
+	}
+	defer ofd.Destroy()
+	filter, err := gtk.FileFilterNew()
+	if err != nil {
+		log.Fatalf("failed to create file filter: %s", err)
+	}
+	filter.AddPixbufFormats()
+	filter.SetName("Images")
+	ofd.AddFilter(filter)
+	if ofd.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return false
+	}
+	pix, err := gdk.PixbufNewFromFile(ofd.GetFilename())
+	if err != nil {
+		showErrMsg("Cannot use image", err.Error())
+		return false
+	}
+	var png bytes.Buffer
+	if err := pix.WritePNG(&png, 9); err != nil {
+		showErrMsg("Cannot use image", err.Error())
+		return false
+	}
+	path, err := signImagePath(name)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path, png.Bytes(), 0600)
+	}
+	if err != nil {
+		showErrMsg("Cannot store image", err.Error())
+		return false
+	}
+	return true
+}
+
+// newSignImageRow creates the preferences row showing the stored image of
+// the given name, with buttons to choose another one or remove it.
+func newSignImageRow(title, name string) *gtk.Box {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 10)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	img, err := gtk.ImageNew()
+	if err != nil {
+		log.Fatalf("unable to create image: %s", err)
+	}
+	removeBut, err := gtk.ButtonNewWithLabel("Remove")
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	refresh := func() {
+		img.Clear()
+		removeBut.SetSensitive(false)
+		path, err := signImagePath(name)
+		if err != nil {
+			return
+		}
+		pix, err := gdk.PixbufNewFromFileAtScale(path, -1, signPreviewHeight, true)
+		if err != nil {
+			img.SetFromIconName("image-missing", gtk.ICON_SIZE_DND)
+			return
+		}
+		img.SetFromPixbuf(pix)
+		removeBut.SetSensitive(true)
+	}
+	chooseBut, err := gtk.ButtonNewWithLabel("Choose…")
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	chooseBut.Connect("clicked", func() {
+		if chooseSignImage(title, name) {
+			refresh()
+		}
+	})
+	removeBut.Connect("clicked", func() {
+		if path, err := signImagePath(name); err == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				showErrMsg("Cannot remove image", err.Error())
+			}
+		}
+		refresh()
+	})
+	refresh()
+	box.PackStart(img, false, false, 0)
+	box.PackStart(chooseBut, false, false, 0)
+	box.PackStart(removeBut, false, false, 0)
+	return box
+}
+
+// signing is where initials and the signature go.
+type signing struct {
+	initials, signature session.ImagePlacement
+	// page is the position of the page signed.
+	page int
+}
+
+// askSigning asks where to put the initials and signature in a document of
+// the given number of pages. It returns false if cancelled.
+func askSigning(pages int) (signing, bool) {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Initial Every Page and Sign")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Sign and Save", gtk.RESPONSE_OK); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+	newPosCombo := func(pos, def string) *gtk.ComboBoxText {
+		c, err := gtk.ComboBoxTextNew()
+		if err != nil {
+			log.Fatalf("unable to create combo box: %s", err)
+		}
+		for _, p := range imagePositions {
+			c.Append(p.id, p.label)
+		}
+		if !c.SetActiveID(pos) {
+			c.SetActiveID(def)
+		}
+		return c
+	}
+	newWidthSpin := func(width, def int) *gtk.SpinButton {
+		s, err := gtk.SpinButtonNewWithRange(1, 100, 1)
+		if err != nil {
+			log.Fatalf("unable to create spin button: %s", err)
+		}
+		if width == 0 {
+			width = def
+		}
+		s.SetValue(float64(width))
+		s.SetActivatesDefault(true)
+		s.SetHAlign(gtk.ALIGN_START)
+		return s
+	}
+
+	initPos := newPosCombo(state.InitialsPosition, defaultInitialsPosition)
+	addRow("Initials on every page", initPos)
+	initWidth := newWidthSpin(state.InitialsWidth, defaultInitialsWidth)
+	addRow("Initials width (%)", initWidth)
+
+	pageSpin, err := gtk.SpinButtonNewWithRange(1, float64(pages), 1)
+	if err != nil {
+		log.Fatalf("unable to create spin button: %s", err)
+	}
+	pageSpin.SetValue(float64(pages))
+	pageSpin.SetActivatesDefault(true)
+	pageSpin.SetHAlign(gtk.ALIGN_START)
+	addRow("Sign page", pageSpin)
+	sigPos := newPosCombo(state.SignaturePosition, defaultSignaturePosition)
+	addRow("Signature position", sigPos)
+	sigWidth := newWidthSpin(state.SignatureWidth, defaultSignatureWidth)
+	addRow("Signature width (%)", sigWidth)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	if dlg.Run() != gtk.RESPONSE_OK {
+		return signing{}, false
+	}
+
+	initWidth.Update()
+	sigWidth.Update()
+	pageSpin.Update()
+	state.InitialsPosition = initPos.GetActiveID()
+	state.InitialsWidth = initWidth.GetValueAsInt()
+	state.SignaturePosition = sigPos.GetActiveID()
+	state.SignatureWidth = sigWidth.GetValueAsInt()
+	saveState()
+
+	s := signing{
+		initials:  session.ImagePlacement{Width: float64(state.InitialsWidth) / 100, Margin: pasteMargin},
+		signature: session.ImagePlacement{Width: float64(state.SignatureWidth) / 100, Margin: pasteMargin},
+		page:      pageSpin.GetValueAsInt() - 1,
+	}
+	s.initials.AnchorX, s.initials.AnchorY = imageAnchor(state.InitialsPosition)
+	s.signature.AnchorX, s.signature.AnchorY = imageAnchor(state.SignaturePosition)
+	return s, true
+}
+
+// initialAndSign puts the stored initials on every page and the signature on
+// the chosen one, then saves. Undoing removes them all.
+func (d *document) initialAndSign() {
+	if !d.changeable() {
+		return
+	}
+	initials, err := loadSignImage(initialsFile)
+	if err != nil {
+		showErrMsg("Cannot sign", err.Error())
+		return
+	}
+	signature, err := loadSignImage(signatureFile)
+	if err != nil {
+		showErrMsg("Cannot sign", err.Error())
+		return
+	}
+	if initials == nil || signature == nil {
+		showErrMsg("Cannot sign", "Choose images of your initials and signature in Preferences first.")
+		return
+	}
+	s, ok := askSigning(len(d.pageCells))
+	if !ok {
+		return
+	}
+
+	prevs := make([][]byte, len(d.pageCells))
+	d.sessMu.Lock()
+	for p := range prevs {
+		if prevs[p], err = d.sess.Annotation(p); err != nil {
+			break
+		}
+	}
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot sign", err.Error())
+		return
+	}
+	d.placing = true
+	updateActions()
+	updateStatus()
+
+	workQueue.submit(func() {
+		ctx := context.Background()
+		for p := range prevs {
+			if err = d.sess.PlaceImage(ctx, p, initials, s.initials); err != nil {
+				err = fmt.Errorf("failed to initial page %d: %s", p+1, err)
+				return
+			}
+		}
+		err = d.sess.PlaceImage(ctx, s.page, signature, s.signature)
+	}, func() {
+		d.placing = false
+		updateActions()
+		updateStatus()
+
+		// Pages initialled before a failure are kept, and can be undone
+
+		for p := range prevs {
+			d.annotationChanged(p)
+		}
+		d.pushUndo(func() {
+			for p, prev := range prevs {
+				d.unplace(p, prev)
+			}
+		})
+		if err != nil {
+			showErrMsg("Cannot sign", err.Error())
+			return
+		}
+		emitStatus(d.path, "initialled and signed")
+		d.save()
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const maxRecentFiles = 10
+
+// appState is remembered across runs.
+type appState struct {
+	RecentFiles []string `json:"recent_files,omitempty"`
+	// DepsFound is set once all external tools have been found.
+	DepsFound bool `json:"deps_found,omitempty"`
+	// Last directories files were opened from and saved to
+	OpenDir string `json:"open_dir,omitempty"`
+	SaveDir string `json:"save_dir,omitempty"`
+	// EditorCommand is the command template for annotating pages.
+	EditorCommand string `json:"editor_command,omitempty"`
+	// EditorPlacement is where the editor window is placed: "" (anywhere),
+	// "maximized", "fullscreen" or "monitor:N" for the Nth monitor.
+	EditorPlacement string `json:"editor_placement,omitempty"`
+	// ReopenLast is whether the document open when the app was last quit is
+	// reopened on startup, at LastPage.
+	ReopenLast   bool   `json:"reopen_last,omitempty"`
+	LastDocument string `json:"last_document,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	// AutosaveMinutes is the interval of auto-saving sessions with unsaved
+	// changes, or 0 if disabled.
+	AutosaveMinutes int `json:"autosave_minutes,omitempty"`
+	// TempCapMB is the size in MiB the temporary files of each document are
+	// kept under by removing renders and exports of pages, or 0 if unlimited.
+	TempCapMB int `json:"temp_cap_mb,omitempty"`
+	// OpenInPlace is whether PDFs are opened without copying them first,
+	// which those on read-only media always are.
+	OpenInPlace bool `json:"open_in_place,omitempty"`
+	// SidecarAnnotations is whether annotations are kept in a .pdfrann
+	// directory beside each PDF rather than only for the session.
+	SidecarAnnotations bool `json:"sidecar_annotations,omitempty"`
+	// PastePosition and PasteWidth are where and how large images were last
+	// pasted onto pages, the width in percent of the page width.
+	PastePosition string `json:"paste_position,omitempty"`
+	PasteWidth    int    `json:"paste_width,omitempty"`
+	// WebDAVLocation is the URL last entered for opening from or saving to a
+	// WebDAV server. Credentials are never saved.
+	WebDAVLocation string `json:"webdav_location,omitempty"`
+	// CheckUpdates is whether GitHub is asked for newer releases on startup,
+	// last at LastUpdateCheck (Unix time). SkippedVersion is a release not to
+	// be told of again.
+	CheckUpdates    bool   `json:"check_updates,omitempty"`
+	LastUpdateCheck int64  `json:"last_update_check,omitempty"`
+	SkippedVersion  string `json:"skipped_version,omitempty"`
+	// Rubric is the stamps worth points which can be placed on pages.
+	// RubricOutOf is what scores are out of, or 0 if not shown. StampScore
+	// is whether the score is stamped on submissions graded in a batch.
+	Rubric      []rubricItem `json:"rubric,omitempty"`
+	RubricOutOf float64      `json:"rubric_out_of,omitempty"`
+	StampScore  bool         `json:"stamp_score,omitempty"`
+	// InitialsPosition and SignaturePosition are where initials and the
+	// signature were last put when signing, and InitialsWidth and
+	// SignatureWidth how large, in percent of the page width.
+	InitialsPosition  string `json:"initials_position,omitempty"`
+	InitialsWidth     int    `json:"initials_width,omitempty"`
+	SignaturePosition string `json:"signature_position,omitempty"`
+	SignatureWidth    int    `json:"signature_width,omitempty"`
+	// Profile is the profile of the config last chosen in the header bar.
+	Profile string `json:"profile,omitempty"`
+	// ArchivalManifest is whether a manifest with checksums and tool
+	// versions is written beside each locally saved PDF.
+	ArchivalManifest bool `json:"archival_manifest,omitempty"`
+	// LastCrashSeen is the name of the newest crash report told about.
+	LastCrashSeen string `json:"last_crash_seen,omitempty"`
+	// QRText is the text of the QR code last placed, and QRPosition and
+	// QRWidth where and how large, in percent of the page width.
+	QRText     string `json:"qr_text,omitempty"`
+	QRPosition string `json:"qr_position,omitempty"`
+	QRWidth    int    `json:"qr_width,omitempty"`
+	// DateFormat is the strftime-like format of date stamps, empty for the
+	// default, and DatePosition where they're put.
+	DateFormat   string `json:"date_format,omitempty"`
+	DatePosition string `json:"date_position,omitempty"`
+	// WhiteoutMatchPage is whether whiteouts were last painted in the color
+	// of the page around them rather than white.
+	WhiteoutMatchPage bool `json:"whiteout_match_page,omitempty"`
+	// Watermark is the watermark last applied, or nil for the defaults.
+	Watermark *watermarkSettings `json:"watermark,omitempty"`
+	// PageNumbers are the page numbers last applied, or nil for the
+	// defaults.
+	PageNumbers *pageNumberSettings `json:"page_numbers,omitempty"`
+}
+
+var state appState
+
+// stateDir returns the directory where the app state is kept, following the
+// XDG base directory spec.
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "pdfrankenstein"), nil
+}
+
+func statePath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// loadState reads the app state from disk. A missing or broken state file
+// results in a blank state.
+func loadState() {
+	path, err := statePath()
+	if err != nil {
+		log.Printf("cannot locate state file: %s", err)
+		return
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("cannot read state file: %s", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		log.Printf("cannot parse state file '%s': %s", path, err)
+	}
+}
+
+// saveState writes the app state to disk.
+func saveState() {
+	path, err := statePath()
+	if err != nil {
+		log.Printf("cannot locate state file: %s", err)
+		return
+	}
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Printf("cannot encode state: %s", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("cannot create state directory: %s", err)
+		return
+	}
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		log.Printf("cannot write state file: %s", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("cannot write state file: %s", err)
+	}
+}
+
+// addRecentFile moves the given path to the top of the recent files.
+func addRecentFile(path string) {
+	recent := []string{path}
+	for _, p := range state.RecentFiles {
+		if p != path && len(recent) < maxRecentFiles {
+			recent = append(recent, p)
+		}
+	}
+	state.RecentFiles = recent
+	saveState()
+}
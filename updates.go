@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Checking for a newer version is opt-in. Only the GitHub API is asked for
+// the latest release, at most once a day, without anything identifying the
+// user beyond what any HTTP request reveals.
+const (
+	releasesURL      = "https://api.github.com/repos/oxplot/pdfrankenstein/releases/latest"
+	updateCheckEvery = 24 * time.Hour
+)
+
+// responseSkipVersion is the response of the update dialog to not be told of
+// the release again.
+const responseSkipVersion gtk.ResponseType = 1
+
+// release is the part of a GitHub release needed to tell about it.
+type release struct {
+	Tag   string `json:"tag_name"`
+	Name  string `json:"name"`
+	Notes string `json:"body"`
+	URL   string `json:"html_url"`
+}
+
+// latestRelease returns the latest release on GitHub.
+func latestRelease() (release, error) {
+	var r release
+	req, err := http.NewRequest(http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return r, err
+	}
+	req.Header.Set("User-Agent", progName)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return r, fmt.Errorf("checking for new versions failed: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("cannot parse latest release: %s", err)
+	}
+	return r, nil
+}
+
+// checkForUpdate tells about a newer release than the running version, if
+// enabled in the preferences and not checked recently. Development builds
+// are never checked. It's done in the background so as not to hold up
+// opening documents, and failures are only logged.
+func checkForUpdate() {
+	if !state.CheckUpdates {
+		return
+	}
+	current, err := semver.NewVersion(version)
+	if err != nil {
+		return
+	}
+	if time.Since(time.Unix(state.LastUpdateCheck, 0)) < updateCheckEvery {
+		return
+	}
+	state.LastUpdateCheck = time.Now().Unix()
+	saveState()
+	go func() {
+		defer crashGuard(false)
+		r, err := latestRelease()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		latest, err := semver.NewVersion(r.Tag)
+		if err != nil {
+			log.Printf("cannot parse version of latest release '%s': %s", r.Tag, err)
+			return
+		}
+		if !latest.GreaterThan(current) {
+			return
+		}
+		glib.IdleAdd(func() {
+			if state.SkippedVersion == latest.String() {
+				return
+			}
+			showUpdate(r, latest.String())
+		})
+	}()
+}
+
+// showUpdate tells about the given newer release along with its changelog.
+func showUpdate(r release, ver string) {
+	d := gtk.MessageDialogNew(mainWin, gtk.DIALOG_MODAL, gtk.MESSAGE_INFO, gtk.BUTTONS_NONE,
+		"%s", fmt.Sprintf("%s %s is available", progName, ver))
+	defer d.Destroy()
+	d.FormatSecondaryText("%s", fmt.Sprintf("You're running version %s.", strings.TrimPrefix(version, "v")))
+
+	if notes := strings.TrimSpace(r.Notes); notes != "" {
+		tv, err := gtk.TextViewNew()
+		if err != nil {
+			log.Fatalf("unable to create text view: %s", err)
+		}
+		tv.SetEditable(false)
+		tv.SetCursorVisible(false)
+		tv.SetWrapMode(gtk.WRAP_WORD)
+		tv.SetLeftMargin(5)
+		tv.SetRightMargin(5)
+		buf, err := tv.GetBuffer()
+		if err != nil {
+			log.Fatalf("unable to get text buffer: %s", err)
+		}
+		buf.SetText(strings.ReplaceAll(notes, "\r\n", "\n"))
+		sw, err := gtk.ScrolledWindowNew(nil, nil)
+		if err != nil {
+			log.Fatalf("unable to create scrolled window: %s", err)
+		}
+		sw.SetPolicy(gtk.POLICY_NEVER, gtk.POLICY_AUTOMATIC)
+		sw.SetShadowType(gtk.SHADOW_IN)
+		sw.SetSizeRequest(450, 200)
+		sw.Add(tv)
+		area, err := d.GetMessageArea()
+		if err != nil {
+			log.Fatalf("unable to get dialog message area: %s", err)
+		}
+		area.PackStart(sw, true, true, 0)
+		sw.ShowAll()
+	}
+
+	if _, err := d.AddButton("Skip This Version", responseSkipVersion); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := d.AddButton("Later", gtk.RESPONSE_CLOSE); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := d.AddButton("Download", gtk.RESPONSE_ACCEPT); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	d.SetDefaultResponse(gtk.RESPONSE_ACCEPT)
+
+	switch d.Run() {
+	case gtk.RESPONSE_ACCEPT:
+		xdgOpen(r.URL)
+	case responseSkipVersion:
+		state.SkippedVersion = ver
+		saveState()
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Watermarks such as "DRAFT" are drawn by the session over the pages on
+// saving, so they're not shown on the pages while annotating. The status bar
+// tells when a document has one.
+
+// watermarkSettings are the settings of a watermark as asked for.
+type watermarkSettings struct {
+	Text string `json:"text,omitempty"`
+	// Image is the path of the image used instead of text, if any.
+	Image string `json:"image,omitempty"`
+	// Opacity and Width are in percent, Rotation in degrees
+	// counterclockwise.
+	Opacity  int  `json:"opacity"`
+	Rotation int  `json:"rotation"`
+	Width    int  `json:"width"`
+	Tile     bool `json:"tile,omitempty"`
+}
+
+// defaultWatermark is the watermark first suggested.
+var defaultWatermark = watermarkSettings{
+	Text:     "DRAFT",
+	Opacity:  25,
+	Rotation: 45,
+	Width:    70,
+}
+
+// Responses of the watermark dialog other than the standard ones.
+const responseRemoveWatermark gtk.ResponseType = 1
+
+// askWatermark asks for the settings of a watermark, starting from those
+// last used, and whether it goes on the selected pages only if there are
+// any. It returns false along with whether to remove the current watermark,
+// if any, when none is to be applied.
+func askWatermark(selected int, hasWatermark bool) (ws watermarkSettings, selectedOnly, remove, ok bool) {
+	ws = defaultWatermark
+	if state.Watermark != nil {
+		ws = *state.Watermark
+	}
+
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle("Watermark")
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if hasWatermark {
+		if _, err := dlg.AddButton("Remove", responseRemoveWatermark); err != nil {
+			log.Fatalf("unable to create dialog button: %s", err)
+		}
+	}
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Apply", gtk.RESPONSE_OK); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+	newSpin := func(min, max float64, value int) *gtk.SpinButton {
+		s, err := gtk.SpinButtonNewWithRange(min, max, 1)
+		if err != nil {
+			log.Fatalf("unable to create spin button: %s", err)
+		}
+		s.SetValue(float64(value))
+		s.SetActivatesDefault(true)
+		s.SetHAlign(gtk.ALIGN_START)
+		return s
+	}
+
+	textEntry, err := gtk.EntryNew()
+	if err != nil {
+		log.Fatalf("unable to create entry: %s", err)
+	}
+	textEntry.SetText(ws.Text)
+	textEntry.SetActivatesDefault(true)
+	addRow("Text", textEntry)
+
+	// An image, if chosen, is used instead of the text
+
+	imageBox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	imageBut, err := gtk.FileChooserButtonNew("Choose Watermark Image", gtk.FILE_CHOOSER_ACTION_OPEN)
+	if err != nil {
+		log.Fatalf("unable to create file chooser button: %s", err)
+	}
+	filter, err := gtk.FileFilterNew()
+	if err != nil {
+		log.Fatalf("failed to create file filter: %s", err)
+	}
+	filter.AddPixbufFormats()
+	filter.SetName("Images")
+	imageBut.AddFilter(filter)
+	if ws.Image != "" {
+		imageBut.SetFilename(ws.Image)
+	}
+	clearBut, err := gtk.ButtonNewWithLabel("Use Text")
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	useImage := func() {
+		hasImage := imageBut.GetFilename() != ""
+		textEntry.SetSensitive(!hasImage)
+		clearBut.SetSensitive(hasImage)
+	}
+	imageBut.Connect("file-set", useImage)
+	clearBut.Connect("clicked", func() {
+		imageBut.UnselectAll()
+		useImage()
+	})
+	useImage()
+	imageBox.PackStart(imageBut, true, true, 0)
+	imageBox.PackStart(clearBut, false, false, 0)
+	addRow("Image", imageBox)
+
+	opacitySpin := newSpin(1, 100, ws.Opacity)
+	addRow("Opacity (%)", opacitySpin)
+	rotationSpin := newSpin(-180, 180, ws.Rotation)
+	addRow("Rotation (°)", rotationSpin)
+	widthSpin := newSpin(1, 100, ws.Width)
+	addRow("Width (%)", widthSpin)
+	tileCheck, err := gtk.CheckButtonNewWithLabel("Repeat across the page")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	tileCheck.SetActive(ws.Tile)
+	addRow("", tileCheck)
+
+	pagesCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		log.Fatalf("unable to create combo box: %s", err)
+	}
+	pagesCombo.Append("all", "All pages")
+	if selected > 0 {
+		pagesCombo.Append("selected", "Selected pages")
+	}
+	pagesCombo.SetActiveID("all")
+	addRow("Pages", pagesCombo)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	switch dlg.Run() {
+	case gtk.RESPONSE_OK:
+	case responseRemoveWatermark:
+		return ws, false, true, false
+	default:
+		return ws, false, false, false
+	}
+
+	opacitySpin.Update()
+	rotationSpin.Update()
+	widthSpin.Update()
+	ws.Text, _ = textEntry.GetText()
+	ws.Image = imageBut.GetFilename()
+	ws.Opacity = opacitySpin.GetValueAsInt()
+	ws.Rotation = rotationSpin.GetValueAsInt()
+	ws.Width = widthSpin.GetValueAsInt()
+	ws.Tile = tileCheck.GetActive()
+	state.Watermark = &ws
+	saveState()
+	return ws, pagesCombo.GetActiveID() == "selected", false, true
+}
+
+// watermark asks for a watermark and sets it to be drawn on saving, or
+// removes it. Undoing puts back the previous one.
+func (d *document) watermark() {
+	if !d.changeable() {
+		return
+	}
+	d.sessMu.Lock()
+	prev := d.sess.Watermark()
+	d.sessMu.Unlock()
+	selected := d.selectedPages()
+	ws, selectedOnly, remove, ok := askWatermark(len(selected), prev != nil)
+	if !ok && !remove {
+		return
+	}
+
+	var w *session.Watermark
+	if ok {
+		w = &session.Watermark{
+			Text:     ws.Text,
+			Opacity:  float64(ws.Opacity) / 100,
+			Rotation: float64(ws.Rotation),
+			Width:    float64(ws.Width) / 100,
+			Tile:     ws.Tile,
+		}
+		if ws.Image != "" {
+			pix, err := gdk.PixbufNewFromFile(ws.Image)
+			if err != nil {
+				showErrMsg("Cannot use watermark image", err.Error())
+				return
+			}
+			var png bytes.Buffer
+			if err := pix.WritePNG(&png, 9); err != nil {
+				showErrMsg("Cannot use watermark image", err.Error())
+				return
+			}
+			w.Image = png.Bytes()
+		}
+		if selectedOnly {
+			w.Pages = selected
+		}
+	}
+	if !d.setWatermark(w) {
+		return
+	}
+	d.pushUndo(func() { d.setWatermark(prev) })
+}
+
+// setWatermark sets the watermark of the document, or removes it if nil. It
+// returns false if it failed.
+func (d *document) setWatermark(w *session.Watermark) bool {
+	d.sessMu.Lock()
+	err := d.sess.SetWatermark(w)
+	d.sessMu.Unlock()
+	if err != nil {
+		showErrMsg("Cannot set watermark", err.Error())
+		return false
+	}
+	d.setModified(true)
+	updateStatus()
+	return true
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Documents on WebDAV servers are opened and saved over HTTP directly, with
+// no need for them to be mounted. Like other remote documents, they're worked
+// on as a local copy.
+
+// davCred is a user and password for a WebDAV server.
+type davCred struct {
+	user, password string
+}
+
+var (
+	davClient = &http.Client{Timeout: 5 * time.Minute}
+	// davCreds are the credentials entered, by server. They're only kept in
+	// memory.
+	davMu    sync.Mutex
+	davCreds = map[string]davCred{}
+)
+
+// errDAVUnauthorized is returned when the server rejects the credentials, or
+// needs some and there are none.
+var errDAVUnauthorized = errors.New("the WebDAV server requires a user and password")
+
+// isWebDAV returns true if path is the URL of a document on a WebDAV server.
+func isWebDAV(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// davServer returns the key of the server of the given URL in davCreds.
+func davServer(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// davRequest sends a request to a WebDAV server, authenticating with the
+// credentials of the server if any. Failure statuses are returned as errors.
+func davRequest(method, uri string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("User-Agent", progName)
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/pdf")
+	}
+	davMu.Lock()
+	cred, ok := davCreds[davServer(req.URL)]
+	davMu.Unlock()
+	if u := req.URL.User; u != nil {
+		pass, _ := u.Password()
+		cred, ok = davCred{u.Username(), pass}, true
+		req.URL.User = nil
+	}
+	if ok {
+		req.SetBasicAuth(cred.user, cred.password)
+	}
+	resp, err := davClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, errDAVUnauthorized
+	case resp.StatusCode/100 != 2:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s of '%s' failed: %s", method, uri, resp.Status)
+	}
+	return resp, nil
+}
+
+// davGet downloads the document at uri to the local path.
+func davGet(uri, path string) error {
+	resp, err := davRequest(http.MethodGet, uri, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to download '%s': %s", uri, err)
+	}
+	return f.Close()
+}
+
+// davPut uploads the local file at path to uri, replacing what's there.
+func davPut(path, uri string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	resp, err := davRequest(http.MethodPut, uri, f, fi.Size())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// askDAVLocation asks for the URL of a document on a WebDAV server and the
+// credentials for it, offering uri as the default. why explains why it's
+// asked again, if it is. It returns false if cancelled.
+func askDAVLocation(title, uri, why string) (string, bool) {
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle(title)
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	if _, err := dlg.AddButton("Connect", gtk.RESPONSE_OK); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	grid, err := gtk.GridNew()
+	if err != nil {
+		log.Fatalf("unable to create grid: %s", err)
+	}
+	grid.SetColumnSpacing(10)
+	grid.SetRowSpacing(10)
+	grid.SetMarginTop(10)
+	grid.SetMarginBottom(10)
+	grid.SetMarginStart(10)
+	grid.SetMarginEnd(10)
+	row := 0
+	addRow := func(label string, w gtk.IWidget) {
+		l, err := gtk.LabelNew(label)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetHAlign(gtk.ALIGN_END)
+		grid.Attach(l, 0, row, 1, 1)
+		grid.Attach(w, 1, row, 1, 1)
+		row++
+	}
+	newEntry := func(text string) *gtk.Entry {
+		e, err := gtk.EntryNew()
+		if err != nil {
+			log.Fatalf("unable to create entry: %s", err)
+		}
+		e.SetText(text)
+		e.SetHExpand(true)
+		e.SetActivatesDefault(true)
+		return e
+	}
+
+	if why != "" {
+		l, err := gtk.LabelNew(why)
+		if err != nil {
+			log.Fatalf("unable to create label: %s", err)
+		}
+		l.SetLineWrap(true)
+		l.SetHAlign(gtk.ALIGN_START)
+		grid.Attach(l, 0, row, 2, 1)
+		row++
+	}
+
+	if uri == "" {
+		uri = state.WebDAVLocation
+	}
+	urlEntry := newEntry(uri)
+	urlEntry.SetWidthChars(50)
+	urlEntry.SetPlaceholderText("https://dav.example.com/documents/report.pdf")
+	addRow("URL", urlEntry)
+
+	var cred davCred
+	if u, err := url.Parse(uri); err == nil {
+		davMu.Lock()
+		cred = davCreds[davServer(u)]
+		davMu.Unlock()
+	}
+	userEntry := newEntry(cred.user)
+	addRow("User", userEntry)
+	passEntry := newEntry(cred.password)
+	passEntry.SetVisibility(false)
+	passEntry.SetTooltipText("The password is kept until " + progName + " is closed.")
+	addRow("Password", passEntry)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(grid)
+	dlg.ShowAll()
+	if uri != "" {
+		userEntry.GrabFocus()
+	}
+
+	for dlg.Run() == gtk.RESPONSE_OK {
+		text, _ := urlEntry.GetText()
+		text = strings.TrimSpace(text)
+		if !strings.Contains(text, "://") {
+			text = "https://" + text
+		}
+		u, err := url.Parse(text)
+		if err != nil || !isWebDAV(text) || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+			showErrMsg("Invalid WebDAV location", "Enter the full URL of a PDF, starting with https:// or http://.")
+			continue
+		}
+		user, _ := userEntry.GetText()
+		pass, _ := passEntry.GetText()
+
+		// Credentials in the URL would end up in the recent files
+
+		if u.User != nil {
+			if user == "" {
+				user = u.User.Username()
+				pass, _ = u.User.Password()
+			}
+			u.User = nil
+			text = u.String()
+		}
+		davMu.Lock()
+		if user != "" {
+			davCreds[davServer(u)] = davCred{user, pass}
+		} else {
+			delete(davCreds, davServer(u))
+		}
+		davMu.Unlock()
+		state.WebDAVLocation = text
+		saveState()
+		return text, true
+	}
+	return "", false
+}
+
+// openDAV asks for a document on a WebDAV server and opens it.
+func openDAV() {
+	if uri, ok := askDAVLocation("Open WebDAV Location", "", ""); ok {
+		open(uri)
+	}
+}
+
+// saveDAV asks for a location on a WebDAV server and saves the document
+// there, suggesting the location it came from or was last saved to.
+func (d *document) saveDAV() {
+	if !d.changeable() {
+		return
+	}
+	uri := ""
+	switch {
+	case isWebDAV(d.savePath):
+		uri = d.savePath
+	case isWebDAV(d.path):
+		uri = dirName(d.path) + "/" + url.PathEscape(suggestedSaveName(d.path))
+	}
+	uri, ok := askDAVLocation("Save to WebDAV Location", uri, "")
+	if !ok {
+		return
+	}
+	if !strings.HasSuffix(strings.ToLower(uri), ".pdf") {
+		uri += ".pdf"
+	}
+	d.saveTo(uri)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+
+	"github.com/oxplot/pdfrankenstein/session"
+)
+
+// Whiteouts cover parts of a page, e.g. obsolete text, with opaque
+// rectangles dragged over its preview, without a trip to the editor. They're
+// cosmetic only and the dialog says so: what's covered is still in the PDF.
+
+// whiteoutNote tells what whiteouts are and aren't good for.
+const whiteoutNote = "Drag over what to cover. Covering is cosmetic, not redaction: " +
+	"what's covered stays in the PDF and can still be selected, copied or uncovered."
+
+// whiteoutMinSize is the size in pixels under which drags are taken as
+// clicks rather than areas to cover.
+const whiteoutMinSize = 3
+
+// whiteoutRect is an area of the preview to cover, in pixels.
+type whiteoutRect struct {
+	x0, y0, x1, y1 float64
+}
+
+// normalized returns the rectangle with its first corner top left and
+// clamped to the given size.
+func (r whiteoutRect) normalized(w, h float64) whiteoutRect {
+	clamp := func(v, max float64) float64 { return math.Max(0, math.Min(v, max)) }
+	return whiteoutRect{
+		clamp(math.Min(r.x0, r.x1), w), clamp(math.Min(r.y0, r.y1), h),
+		clamp(math.Max(r.x0, r.x1), w), clamp(math.Max(r.y0, r.y1), h),
+	}
+}
+
+// backgroundColor returns the color of the pixels around the given area of
+// pix, the median of each channel so stray bits of text don't count, as
+// RGB components from 0 to 255.
+func backgroundColor(pix *gdk.Pixbuf, r whiteoutRect) [3]int {
+	w, h := pix.GetWidth(), pix.GetHeight()
+	n, stride := pix.GetNChannels(), pix.GetRowstride()
+	pixels := pix.GetPixels()
+	var chans [3][]int
+	sample := func(x, y int) {
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return
+		}
+		for c := 0; c < 3; c++ {
+			chans[c] = append(chans[c], int(pixels[y*stride+x*n+c]))
+		}
+	}
+	x0, y0 := int(r.x0)-1, int(r.y0)-1
+	x1, y1 := int(math.Ceil(r.x1)), int(math.Ceil(r.y1))
+	for x := x0; x <= x1; x++ {
+		sample(x, y0)
+		sample(x, y1)
+	}
+	for y := y0 + 1; y < y1; y++ {
+		sample(x0, y)
+		sample(x1, y)
+	}
+	color := [3]int{255, 255, 255}
+	for c := range chans {
+		if len(chans[c]) > 0 {
+			sort.Ints(chans[c])
+			color[c] = chans[c][len(chans[c])/2]
+		}
+	}
+	return color
+}
+
+// askWhiteouts shows the preview of the given page at path and lets the user
+// drag rectangles over it to cover. It returns false if cancelled.
+func askWhiteouts(page int, path string) ([]session.Whiteout, bool) {
+	img, err := cairo.NewSurfaceFromPNG(path)
+	if err != nil {
+		showErrMsg("Cannot cover page", err.Error())
+		return nil, false
+	}
+	pix, err := gdk.PixbufNewFromFile(path)
+	if err != nil {
+		showErrMsg("Cannot cover page", err.Error())
+		return nil, false
+	}
+	w, h := float64(img.GetWidth()), float64(img.GetHeight())
+
+	dlg, err := gtk.DialogNew()
+	if err != nil {
+		log.Fatalf("unable to create dialog: %s", err)
+	}
+	defer dlg.Destroy()
+	dlg.SetTitle(fmt.Sprintf("Cover Parts of Page %d", page+1))
+	dlg.SetModal(true)
+	dlg.SetTransientFor(mainWin)
+	if _, err := dlg.AddButton("Cancel", gtk.RESPONSE_CANCEL); err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	okBut, err := dlg.AddButton("Cover", gtk.RESPONSE_OK)
+	if err != nil {
+		log.Fatalf("unable to create dialog button: %s", err)
+	}
+	okBut.SetSensitive(false)
+	dlg.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 10)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	box.SetMarginTop(10)
+	box.SetMarginBottom(10)
+	box.SetMarginStart(10)
+	box.SetMarginEnd(10)
+
+	note, err := gtk.LabelNew(whiteoutNote)
+	if err != nil {
+		log.Fatalf("unable to create label: %s", err)
+	}
+	note.SetLineWrap(true)
+	note.SetMaxWidthChars(60)
+	note.SetXAlign(0)
+	box.PackStart(note, false, false, 0)
+
+	// Areas are painted as they'll be covered, and outlined so they show on
+	// white pages
+
+	var rects []whiteoutRect
+	var drag *whiteoutRect
+	matchPage := state.WhiteoutMatchPage
+	colorOf := func(r whiteoutRect) [3]int {
+		if !matchPage {
+			return [3]int{255, 255, 255}
+		}
+		return backgroundColor(pix, r)
+	}
+	area, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatalf("unable to create drawing area: %s", err)
+	}
+	area.SetSizeRequest(int(w), int(h))
+	area.SetHAlign(gtk.ALIGN_CENTER)
+	area.AddEvents(int(gdk.BUTTON_PRESS_MASK | gdk.BUTTON_RELEASE_MASK | gdk.BUTTON1_MOTION_MASK))
+	area.Connect("draw", func(_ *gtk.DrawingArea, cr *cairo.Context) bool {
+		cr.SetSourceSurface(img, 0, 0)
+		cr.Paint()
+		cr.SetLineWidth(1)
+		cr.SetDash([]float64{4, 4}, 0)
+		for _, r := range rects {
+			c := colorOf(r)
+			cr.SetSourceRGB(float64(c[0])/255, float64(c[1])/255, float64(c[2])/255)
+			cr.Rectangle(r.x0, r.y0, r.x1-r.x0, r.y1-r.y0)
+			cr.FillPreserve()
+			cr.SetSourceRGB(0.5, 0.5, 0.5)
+			cr.Stroke()
+		}
+		if drag != nil {
+			r := drag.normalized(w, h)
+			cr.SetSourceRGB(0.2, 0.4, 0.8)
+			cr.Rectangle(r.x0, r.y0, r.x1-r.x0, r.y1-r.y0)
+			cr.Stroke()
+		}
+		return true
+	})
+	area.Connect("button-press-event", func(_ *gtk.DrawingArea, ev *gdk.Event) bool {
+		btn := gdk.EventButtonNewFromEvent(ev)
+		if btn.Type() != gdk.EVENT_BUTTON_PRESS || btn.Button() != gdk.BUTTON_PRIMARY {
+			return false
+		}
+		drag = &whiteoutRect{btn.X(), btn.Y(), btn.X(), btn.Y()}
+		return true
+	})
+	area.Connect("motion-notify-event", func(_ *gtk.DrawingArea, ev *gdk.Event) bool {
+		if drag == nil {
+			return false
+		}
+		drag.x1, drag.y1 = gdk.EventMotionNewFromEvent(ev).MotionVal()
+		area.QueueDraw()
+		return true
+	})
+	area.Connect("button-release-event", func(_ *gtk.DrawingArea, ev *gdk.Event) bool {
+		if drag == nil {
+			return false
+		}
+		r := drag.normalized(w, h)
+		drag = nil
+		if r.x1-r.x0 >= whiteoutMinSize && r.y1-r.y0 >= whiteoutMinSize {
+			rects = append(rects, r)
+			okBut.SetSensitive(true)
+		}
+		area.QueueDraw()
+		return true
+	})
+	box.PackStart(area, false, false, 0)
+
+	opts, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 10)
+	if err != nil {
+		log.Fatalf("unable to create box: %s", err)
+	}
+	matchCheck, err := gtk.CheckButtonNewWithLabel("Match the page color instead of white")
+	if err != nil {
+		log.Fatalf("unable to create check button: %s", err)
+	}
+	matchCheck.SetActive(matchPage)
+	matchCheck.Connect("toggled", func() {
+		matchPage = matchCheck.GetActive()
+		area.QueueDraw()
+	})
+	opts.PackStart(matchCheck, true, true, 0)
+	undoBut, err := gtk.ButtonNewWithLabel("Uncover Last")
+	if err != nil {
+		log.Fatalf("unable to create button: %s", err)
+	}
+	undoBut.Connect("clicked", func() {
+		if len(rects) > 0 {
+			rects = rects[:len(rects)-1]
+		}
+		okBut.SetSensitive(len(rects) > 0)
+		area.QueueDraw()
+	})
+	opts.PackStart(undoBut, false, false, 0)
+	box.PackStart(opts, false, false, 0)
+
+	con, err := dlg.GetContentArea()
+	if err != nil {
+		log.Fatalf("unable to get dialog content area: %s", err)
+	}
+	con.Add(box)
+	dlg.ShowAll()
+	if dlg.Run() != gtk.RESPONSE_OK || len(rects) == 0 {
+		return nil, false
+	}
+	state.WhiteoutMatchPage = matchPage
+	saveState()
+
+	covers := make([]session.Whiteout, len(rects))
+	for i, r := range rects {
+		covers[i] = session.Whiteout{X: r.x0 / w, Y: r.y0 / h, Width: (r.x1 - r.x0) / w, Height: (r.y1 - r.y0) / h}
+		if matchPage {
+			c := colorOf(r)
+			covers[i].Color = fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+		}
+	}
+	return covers, true
+}
+
+// whiteout renders a preview of the given page, as shown with annotations if
+// it has any, and covers the parts of it the user drags over.
+func (d *document) whiteout(page int) {
+	if !d.changeable() {
+		return
+	}
+	after := d.isAnnotated(page)
+	var path string
+	var err error
+	workQueue.submit(func() {
+		path, err = d.loadThumb(page, true, after)
+	}, func() {
+		if err != nil {
+			showErrMsg("Cannot cover page", err.Error())
+			return
+		}
+		if page >= len(d.pageCells) || !d.changeable() {
+			return
+		}
+		covers, ok := askWhiteouts(page, path)
+		if !ok {
+			return
+		}
+		d.place(page, "Cannot cover page", func() error {
+			return d.sess.PlaceWhiteouts(context.Background(), page, covers)
+		}, nil)
+	})
+}